@@ -0,0 +1,267 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultAuditMaxMessages is the default number of messages an AuditRecord
+// keeps in each direction.
+const defaultAuditMaxMessages = 100
+
+// An AuditSink receives a record of each RPC matched by an audit interceptor.
+// Sinks must be safe to call concurrently, and they should return quickly:
+// they're called synchronously when the RPC finishes.
+type AuditSink interface {
+	Audit(context.Context, *AuditRecord)
+}
+
+// AuditSinkFunc adapts a function to the [AuditSink] interface.
+type AuditSinkFunc func(context.Context, *AuditRecord)
+
+// Audit implements [AuditSink].
+func (f AuditSinkFunc) Audit(ctx context.Context, record *AuditRecord) {
+	f(ctx, record)
+}
+
+// AuditRecord describes a single RPC, as captured by
+// [NewAuditInterceptor]. Protobuf messages are copies, so sinks may retain and
+// inspect them after the RPC finishes; if the interceptor has a redactor,
+// they've already been redacted. Other messages can't be copied, so they're
+// handled as described in NewAuditInterceptor.
+type AuditRecord struct {
+	Spec      Spec
+	Requests  []any
+	Responses []any
+	// Err is the error that ended the RPC, or nil if it succeeded. For
+	// streaming RPCs, it's the first error other than [io.EOF] returned by
+	// Send or Receive.
+	Err      error
+	Start    time.Time
+	Duration time.Duration
+	// Truncated reports whether the RPC sent or received more messages than the
+	// interceptor's limit, so some were omitted from the record.
+	Truncated bool
+}
+
+// An AuditOption configures [NewAuditInterceptor].
+type AuditOption interface {
+	applyToAudit(*auditInterceptor)
+}
+
+// WithAuditRedactor scrubs sensitive fields from each message before it's
+// added to an [AuditRecord]. The redactor receives a copy of the message, so
+// it should modify the message in place; the original message sent or
+// received by the caller is never touched. Only Protobuf messages can be
+// copied, so the redactor is never called with other messages: with a
+// redactor, they're recorded as nil rather than risk recording sensitive
+// fields.
+func WithAuditRedactor(redact func(Spec, any)) AuditOption {
+	return &auditRedactorOption{redact: redact}
+}
+
+// WithAuditMaxMessages limits the number of messages an [AuditRecord] keeps
+// in each direction, which bounds memory use for long streams. Messages past
+// the limit are omitted, and the record is marked as truncated. The default
+// limit is 100; a non-positive limit keeps every message.
+func WithAuditMaxMessages(max int) AuditOption {
+	return &auditMaxMessagesOption{max: max}
+}
+
+// NewAuditInterceptor returns a client interceptor that records the payloads
+// of RPCs for auditing. For each RPC whose [Spec] matches, the sink receives
+// the procedure, the request and response messages or error, and timing once
+// the call finishes. A nil match function matches every RPC.
+//
+// Each message is copied when it's sent or received, so later changes by the
+// caller don't race with the sink. Copies are made with [proto.Clone];
+// messages that aren't Protobuf messages are recorded as-is, or as nil if the
+// interceptor has a redactor (see [WithAuditRedactor]). For streaming
+// calls, every message in each direction is recorded (up to the limit set with
+// [WithAuditMaxMessages]), and the record is delivered when the caller closes
+// the response side of the stream. The interceptor has no effect on handlers.
+func NewAuditInterceptor(sink AuditSink, match func(Spec) bool, options ...AuditOption) Interceptor {
+	interceptor := &auditInterceptor{
+		sink:        sink,
+		match:       match,
+		maxMessages: defaultAuditMaxMessages,
+	}
+	for _, opt := range options {
+		opt.applyToAudit(interceptor)
+	}
+	return interceptor
+}
+
+type auditInterceptor struct {
+	sink        AuditSink
+	match       func(Spec) bool
+	redact      func(Spec, any)
+	maxMessages int
+}
+
+func (i *auditInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		spec := request.Spec()
+		if !spec.IsClient || !i.matches(spec) {
+			return next(ctx, request)
+		}
+		record := i.newRecord(spec)
+		i.addMessage(record, &record.Requests, request.Any())
+		response, err := next(ctx, request)
+		if err != nil {
+			record.Err = err
+		} else if response != nil {
+			i.addMessage(record, &record.Responses, response.Any())
+		}
+		i.finish(ctx, record)
+		return response, err
+	}
+}
+
+func (i *auditInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return func(ctx context.Context, spec Spec) StreamingClientConn {
+		conn := next(ctx, spec)
+		if !i.matches(spec) {
+			return conn
+		}
+		return &auditStreamingClientConn{
+			StreamingClientConn: conn,
+			ctx:                 ctx,
+			interceptor:         i,
+			record:              i.newRecord(spec),
+		}
+	}
+}
+
+func (i *auditInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return next
+}
+
+func (i *auditInterceptor) matches(spec Spec) bool {
+	return i.match == nil || i.match(spec)
+}
+
+func (i *auditInterceptor) newRecord(spec Spec) *AuditRecord {
+	return &AuditRecord{Spec: spec, Start: time.Now()}
+}
+
+// addMessage appends a redacted copy of msg to messages, unless the record
+// already holds the maximum number of messages. Messages that can't be copied
+// are never passed to the redactor, since it would modify the caller's
+// message; if there's a redactor, they're recorded as nil.
+func (i *auditInterceptor) addMessage(record *AuditRecord, messages *[]any, msg any) {
+	if i.maxMessages > 0 && len(*messages) >= i.maxMessages {
+		record.Truncated = true
+		return
+	}
+	protoMsg, ok := msg.(proto.Message)
+	switch {
+	case ok:
+		msg = proto.Clone(protoMsg)
+		if i.redact != nil {
+			i.redact(record.Spec, msg)
+		}
+	case i.redact != nil:
+		msg = nil
+	}
+	*messages = append(*messages, msg)
+}
+
+func (i *auditInterceptor) finish(ctx context.Context, record *AuditRecord) {
+	record.Duration = time.Since(record.Start)
+	i.sink.Audit(ctx, record)
+}
+
+// auditStreamingClientConn records the messages of a streaming call. Send and
+// Receive may be called concurrently, so the record is guarded by a mutex.
+type auditStreamingClientConn struct {
+	StreamingClientConn
+
+	ctx         context.Context //nolint:containedctx
+	interceptor *auditInterceptor
+	once        sync.Once
+
+	mu     sync.Mutex
+	record *AuditRecord
+}
+
+func (c *auditStreamingClientConn) getResponseCompression() string {
+	return responseCompressionOf(c.StreamingClientConn)
+}
+
+func (c *auditStreamingClientConn) Send(msg any) error {
+	c.mu.Lock()
+	c.interceptor.addMessage(c.record, &c.record.Requests, msg)
+	c.mu.Unlock()
+	err := c.StreamingClientConn.Send(msg)
+	c.setErr(err)
+	return err
+}
+
+func (c *auditStreamingClientConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	if err != nil {
+		c.setErr(err)
+		return err
+	}
+	c.mu.Lock()
+	c.interceptor.addMessage(c.record, &c.record.Responses, msg)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *auditStreamingClientConn) CloseResponse() error {
+	err := c.StreamingClientConn.CloseResponse()
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.interceptor.finish(c.ctx, c.record)
+	})
+	return err
+}
+
+func (c *auditStreamingClientConn) setErr(err error) {
+	if err == nil || errors.Is(err, io.EOF) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.record.Err == nil {
+		c.record.Err = err
+	}
+}
+
+type auditRedactorOption struct {
+	redact func(Spec, any)
+}
+
+func (o *auditRedactorOption) applyToAudit(interceptor *auditInterceptor) {
+	interceptor.redact = o.redact
+}
+
+type auditMaxMessagesOption struct {
+	max int
+}
+
+func (o *auditMaxMessagesOption) applyToAudit(interceptor *auditInterceptor) {
+	interceptor.maxMessages = o.max
+}
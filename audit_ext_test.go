@@ -0,0 +1,207 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAuditInterceptor(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, mux)
+
+	var mu sync.Mutex
+	var records []*connect.AuditRecord
+	sink := connect.AuditSinkFunc(func(_ context.Context, record *connect.AuditRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, record)
+	})
+	takeRecords := func() []*connect.AuditRecord {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := records
+		records = nil
+		return taken
+	}
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithInterceptors(connect.NewAuditInterceptor(
+			sink,
+			func(spec connect.Spec) bool {
+				return spec.Procedure != pingv1connect.PingServiceSumProcedure
+			},
+			connect.WithAuditRedactor(func(_ connect.Spec, msg any) {
+				if ping, ok := msg.(*pingv1.PingRequest); ok {
+					ping.Text = "REDACTED"
+				}
+			}),
+			connect.WithAuditMaxMessages(2),
+		)),
+	)
+	ctx := context.Background()
+
+	t.Run("unary", func(t *testing.T) {
+		request := &pingv1.PingRequest{Number: 42, Text: "secret"}
+		_, err := client.Ping(ctx, connect.NewRequest(request))
+		assert.Nil(t, err)
+		assert.Equal(t, request.GetText(), "secret") // caller's message is untouched
+		recorded := takeRecords()
+		assert.Equal(t, len(recorded), 1)
+		record := recorded[0]
+		assert.Equal(t, record.Spec.Procedure, pingv1connect.PingServicePingProcedure)
+		assert.Equal(t, len(record.Requests), 1)
+		assert.True(t, proto.Equal(record.Requests[0].(*pingv1.PingRequest), &pingv1.PingRequest{Number: 42, Text: "REDACTED"}))
+		assert.Equal(t, len(record.Responses), 1)
+		assert.True(t, proto.Equal(record.Responses[0].(*pingv1.PingResponse), &pingv1.PingResponse{Number: 42, Text: "secret"}))
+		assert.Nil(t, record.Err)
+		assert.False(t, record.Truncated)
+		assert.False(t, record.Start.IsZero())
+		assert.True(t, record.Duration > 0)
+	})
+	t.Run("error", func(t *testing.T) {
+		_, err := client.Fail(ctx, connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)}))
+		assert.NotNil(t, err)
+		recorded := takeRecords()
+		assert.Equal(t, len(recorded), 1)
+		assert.Equal(t, connect.CodeOf(recorded[0].Err), connect.CodeResourceExhausted)
+		assert.Equal(t, len(recorded[0].Responses), 0)
+	})
+	t.Run("stream", func(t *testing.T) {
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 5}))
+		assert.Nil(t, err)
+		var received int
+		for stream.Receive() {
+			received++
+		}
+		assert.Nil(t, stream.Err())
+		assert.Equal(t, len(takeRecords()), 0) // delivered on close
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, received, 5)
+		recorded := takeRecords()
+		assert.Equal(t, len(recorded), 1)
+		record := recorded[0]
+		assert.Equal(t, len(record.Requests), 1)
+		assert.Equal(t, len(record.Responses), 2)
+		assert.True(t, record.Truncated)
+		assert.Nil(t, record.Err)
+		for i, msg := range record.Responses {
+			assert.Equal(t, msg.(*pingv1.CountUpResponse).GetNumber(), int64(i+1))
+		}
+
+		stream, err = client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: -1}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Nil(t, stream.Close())
+		recorded = takeRecords()
+		assert.Equal(t, len(recorded), 1)
+		assert.Equal(t, connect.CodeOf(recorded[0].Err), connect.CodeInvalidArgument)
+	})
+	t.Run("unmatched", func(t *testing.T) {
+		stream := client.Sum(ctx)
+		assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+		_, err := stream.CloseAndReceive()
+		assert.Nil(t, err)
+		assert.Equal(t, len(takeRecords()), 0)
+	})
+}
+
+func TestAuditInterceptorNonProtoMessages(t *testing.T) {
+	t.Parallel()
+	type auditMessage struct {
+		Text string `json:"text"`
+	}
+	const procedure = "/connect.ping.v1.PingService/Ping"
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewUnaryHandler(
+		procedure,
+		func(_ context.Context, request *connect.Request[auditMessage]) (*connect.Response[auditMessage], error) {
+			return connect.NewResponse(&auditMessage{Text: request.Msg.Text}), nil
+		},
+		connect.WithCodec(stdJSONCodec{}),
+	))
+	server := memhttptest.NewServer(t, mux)
+	newClient := func(options ...connect.AuditOption) (*connect.Client[auditMessage, auditMessage], func() *connect.AuditRecord) {
+		records := make(chan *connect.AuditRecord, 1)
+		sink := connect.AuditSinkFunc(func(_ context.Context, record *connect.AuditRecord) {
+			records <- record
+		})
+		client := connect.NewClient[auditMessage, auditMessage](
+			server.Client(),
+			server.URL()+procedure,
+			connect.WithCodec(stdJSONCodec{}),
+			connect.WithInterceptors(connect.NewAuditInterceptor(sink, nil, options...)),
+		)
+		return client, func() *connect.AuditRecord { return <-records }
+	}
+
+	t.Run("without_redactor", func(t *testing.T) {
+		t.Parallel()
+		client, record := newClient()
+		_, err := client.CallUnary(context.Background(), connect.NewRequest(&auditMessage{Text: "hello"}))
+		assert.Nil(t, err)
+		recorded := record()
+		assert.Equal(t, recorded.Requests, []any{&auditMessage{Text: "hello"}})
+		assert.Equal(t, recorded.Responses, []any{&auditMessage{Text: "hello"}})
+	})
+	t.Run("with_redactor", func(t *testing.T) {
+		t.Parallel()
+		var redacted int
+		client, record := newClient(connect.WithAuditRedactor(func(_ connect.Spec, msg any) {
+			redacted++
+			if message, ok := msg.(*auditMessage); ok {
+				message.Text = "REDACTED"
+			}
+		}))
+		request := &auditMessage{Text: "secret"}
+		response, err := client.CallUnary(context.Background(), connect.NewRequest(request))
+		assert.Nil(t, err)
+		// Messages that can't be copied are neither redacted nor recorded.
+		assert.Equal(t, request.Text, "secret")
+		assert.Equal(t, response.Msg.Text, "secret")
+		assert.Equal(t, redacted, 0)
+		recorded := record()
+		assert.Equal(t, recorded.Requests, []any{nil})
+		assert.Equal(t, recorded.Responses, []any{nil})
+	})
+}
+
+// stdJSONCodec marshals any value with encoding/json, so it works with
+// messages that aren't Protobuf messages.
+type stdJSONCodec struct{}
+
+func (stdJSONCodec) Name() string { return "json" }
+
+func (stdJSONCodec) Marshal(message any) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (stdJSONCodec) Unmarshal(data []byte, message any) error {
+	return json.Unmarshal(data, message)
+}
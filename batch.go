@@ -0,0 +1,118 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"sync"
+)
+
+// A BatchOption configures [CallUnaryBatch].
+type BatchOption interface {
+	applyToBatch(*batchConfig)
+}
+
+// WithBatchConcurrency limits the number of calls [CallUnaryBatch] has in
+// flight at once. By default, or if the limit isn't positive, all calls are
+// issued at once.
+func WithBatchConcurrency(limit int) BatchOption {
+	return &batchConcurrencyOption{limit: limit}
+}
+
+// WithBatchFailFast configures [CallUnaryBatch] to stop after the first failed
+// call. In-flight calls are canceled, and calls that haven't started yet
+// aren't issued; both report [CodeCanceled] errors.
+func WithBatchFailFast() BatchOption {
+	return &batchFailFastOption{}
+}
+
+// CallUnaryBatch calls a unary procedure once for each request message,
+// issuing the calls concurrently. It returns the response messages and errors
+// in the same order as the requests: for each index, exactly one of the
+// response and the error is non-nil.
+//
+// If the context is canceled or its deadline passes, CallUnaryBatch stops
+// issuing new calls and cancels in-flight calls. Calls that never started
+// report an error with the context's code, so callers can always tell which
+// requests were processed.
+func CallUnaryBatch[Req, Res any](
+	ctx context.Context,
+	client *Client[Req, Res],
+	requests []*Req,
+	options ...BatchOption,
+) ([]*Res, []error) {
+	var config batchConfig
+	for _, opt := range options {
+		opt.applyToBatch(&config)
+	}
+	limit := config.Concurrency
+	if limit <= 0 || limit > len(requests) {
+		limit = len(requests)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	responses := make([]*Res, len(requests))
+	errs := make([]error, len(requests))
+	semaphore := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, msg := range requests {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			// Don't issue any further calls, even if we won the race for a slot.
+			for j := i; j < len(requests); j++ {
+				errs[j] = wrapIfContextError(err)
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int, msg *Req) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			response, err := client.CallUnary(ctx, NewRequest(msg))
+			if err != nil {
+				errs[i] = err
+				if config.FailFast {
+					cancel()
+				}
+				return
+			}
+			responses[i] = response.Msg
+		}(i, msg)
+	}
+	wg.Wait()
+	return responses, errs
+}
+
+type batchConfig struct {
+	Concurrency int
+	FailFast    bool
+}
+
+type batchConcurrencyOption struct {
+	limit int
+}
+
+func (o *batchConcurrencyOption) applyToBatch(config *batchConfig) {
+	config.Concurrency = o.limit
+}
+
+type batchFailFastOption struct{}
+
+func (o *batchFailFastOption) applyToBatch(config *batchConfig) {
+	config.FailFast = true
+}
@@ -0,0 +1,110 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestCallUnaryBatch(t *testing.T) {
+	t.Parallel()
+	var inFlight, maxInFlight atomic.Int64
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(ctx context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				seen := maxInFlight.Load()
+				if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			switch request.Msg.GetText() {
+			case "fail":
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New(errorMessage))
+			case "block":
+				<-ctx.Done()
+				return nil, connect.NewError(connect.CodeCanceled, ctx.Err())
+			}
+			return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+		server.Client(),
+		server.URL()+pingv1connect.PingServicePingProcedure,
+	)
+	t.Run("ordered", func(t *testing.T) {
+		maxInFlight.Store(0)
+		requests := make([]*pingv1.PingRequest, 20)
+		for i := range requests {
+			requests[i] = &pingv1.PingRequest{Number: int64(i)}
+		}
+		requests[7].Text = "fail"
+		responses, errs := connect.CallUnaryBatch(context.Background(), client, requests, connect.WithBatchConcurrency(3))
+		assert.Equal(t, len(responses), len(requests))
+		assert.Equal(t, len(errs), len(requests))
+		for i := range requests {
+			if i == 7 {
+				assert.Nil(t, responses[i])
+				assert.Equal(t, connect.CodeOf(errs[i]), connect.CodeInvalidArgument)
+				continue
+			}
+			assert.Nil(t, errs[i])
+			assert.Equal(t, responses[i].GetNumber(), int64(i))
+		}
+		assert.True(t, maxInFlight.Load() <= 3)
+	})
+	t.Run("fail_fast", func(t *testing.T) {
+		requests := []*pingv1.PingRequest{
+			{Text: "block"},
+			{Text: "fail"},
+			{Number: 2},
+		}
+		responses, errs := connect.CallUnaryBatch(
+			context.Background(),
+			client,
+			requests,
+			connect.WithBatchConcurrency(2),
+			connect.WithBatchFailFast(),
+		)
+		assert.Equal(t, connect.CodeOf(errs[0]), connect.CodeCanceled)
+		assert.Equal(t, connect.CodeOf(errs[1]), connect.CodeInvalidArgument)
+		assert.Equal(t, connect.CodeOf(errs[2]), connect.CodeCanceled)
+		for _, response := range responses {
+			assert.Nil(t, response)
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		responses, errs := connect.CallUnaryBatch(ctx, client, []*pingv1.PingRequest{{}, {}})
+		assert.Equal(t, len(responses), 2)
+		for _, err := range errs {
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+		}
+	})
+}
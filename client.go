@@ -20,8 +20,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Client is a reusable, concurrency-safe client for a single procedure.
@@ -35,6 +39,7 @@ type Client[Req, Res any] struct {
 	config         *clientConfig
 	callUnary      func(context.Context, *Request[Req]) (*Response[Res], error)
 	protocolClient protocolClient
+	httpClient     HTTPClient
 	err            error
 }
 
@@ -47,36 +52,110 @@ func NewClient[Req, Res any](httpClient HTTPClient, url string, options ...Clien
 		return client
 	}
 	client.config = config
-	protocolClient, protocolErr := client.config.Protocol.NewClient(
-		&protocolClientParams{
-			CompressionName: config.RequestCompressionName,
-			CompressionPools: newReadOnlyCompressionPools(
-				config.CompressionPools,
-				config.CompressionNames,
-			),
-			Codec:            config.Codec,
-			Protobuf:         config.protobuf(),
-			CompressMinBytes: config.CompressMinBytes,
-			HTTPClient:       httpClient,
-			URL:              config.URL,
-			BufferPool:       config.BufferPool,
-			ReadMaxBytes:     config.ReadMaxBytes,
-			SendMaxBytes:     config.SendMaxBytes,
-			EnableGet:        config.EnableGet,
-			GetURLMaxBytes:   config.GetURLMaxBytes,
-			GetUseFallback:   config.GetUseFallback,
-		},
-	)
-	if protocolErr != nil {
-		client.err = protocolErr
-		return client
+	client.httpClient = httpClient
+	if config.RequestRecorder != nil {
+		httpClient = &recordingHTTPClient{
+			HTTPClient: httpClient,
+			record:     config.RequestRecorder,
+			redact:     config.RecorderRedactHeaders,
+		}
+	}
+	params := &protocolClientParams{
+		CompressionName: config.RequestCompressionName,
+		CompressionPools: newReadOnlyCompressionPools(
+			config.CompressionPools,
+			config.CompressionNames,
+		),
+		Codec:                 config.Codec,
+		Protobuf:              config.protobuf(),
+		CompressMinBytes:      config.CompressMinBytes,
+		HTTPClient:            httpClient,
+		URL:                   config.URL,
+		BufferPool:            config.BufferPool,
+		ReadMaxBytes:          config.ReadMaxBytes,
+		MaxDecompressionRatio: config.MaxDecompressionRatio,
+		ReadMaxFrames:         config.ReadMaxFrames,
+		ReadMaxEmptyFrames:    config.ReadMaxEmptyFrames,
+		SendMaxBytes:          config.SendMaxBytes,
+		EnableGet:             config.EnableGet,
+		GetURLMaxBytes:        config.GetURLMaxBytes,
+		GetUseFallback:        config.GetUseFallback,
+		GetParamNames:         config.GetParamNames.withDefaults(),
+		TimeoutEncoder:        config.TimeoutEncoder,
+		LenientGzip:           config.LenientDecompression,
+		MaxCallAttempts:       config.MaxCallAttempts,
+		SkipDrain:             config.SkipResponseDraining,
+		RequireHTTP2:          config.RequireHTTP2,
+		DisableKeepAlives:     config.DisableKeepAlives,
+		ConnObserver:          config.ConnObserver,
+		MaxMessageAge:         config.MaxMessageAge,
+		AcceptHeartbeats:      config.AcceptHeartbeats,
+		TypeResolver:          config.TypeResolver,
 	}
-	client.protocolClient = protocolClient
+	protocolClients := make([]protocolClient, 0, 1+len(config.FallbackProtocols))
+	for _, p := range append([]protocol{config.Protocol}, config.FallbackProtocols...) {
+		protocolClient, protocolErr := p.NewClient(params)
+		if protocolErr != nil {
+			client.err = protocolErr
+			return client
+		}
+		protocolClients = append(protocolClients, protocolClient)
+	}
+	client.protocolClient = protocolClients[0]
 	// Rather than applying unary interceptors along the hot path, we can do it
-	// once at client creation.
+	// once at client creation. Each fallback protocol needs its own chain.
 	unarySpec := config.newSpec(StreamTypeUnary)
-	unaryFunc := UnaryFunc(func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
-		conn := client.protocolClient.NewConn(ctx, unarySpec, request.Header())
+	unaryFuncs := make([]UnaryFunc, 0, len(protocolClients))
+	for _, protocolClient := range protocolClients {
+		unaryFunc := newUnaryFunc[Res](protocolClient, unarySpec, config.Initializer)
+		if interceptor := config.Interceptor; interceptor != nil {
+			unaryFunc = interceptor.WrapUnary(unaryFunc)
+		}
+		unaryFuncs = append(unaryFuncs, unaryFunc)
+	}
+	client.callUnary = func(ctx context.Context, request *Request[Req]) (*Response[Res], error) {
+		// To make the specification, peer, and RPC headers visible to the full
+		// interceptor chain (as though they were supplied by the caller), we'll
+		// add them here.
+		request.spec = unarySpec
+		request.codec = config.Codec.Name()
+		setIdempotencyKey(ctx, request.Header())
+		var header http.Header
+		if len(protocolClients) > 1 {
+			// Protocols and interceptors modify the headers, so each fallback
+			// attempt starts over from the caller's headers.
+			header = request.Header().Clone()
+		}
+		var response AnyResponse
+		var err error
+		for i, protocolClient := range protocolClients {
+			if i > 0 {
+				request.header = header.Clone()
+			}
+			request.peer = protocolClient.Peer()
+			protocolClient.WriteRequestHeader(StreamTypeUnary, request.Header())
+			response, err = unaryFuncs[i](ctx, request)
+			if !isProtocolMismatch(err) {
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		typed, ok := response.(*Response[Res])
+		if !ok {
+			return nil, errorf(CodeInternal, "unexpected client response type %T", response)
+		}
+		return typed, nil
+	}
+	return client
+}
+
+// newUnaryFunc returns a UnaryFunc that calls a unary procedure using the
+// protocol client.
+func newUnaryFunc[Res any](protocolClient protocolClient, spec Spec, initializer maybeInitializer) UnaryFunc {
+	return UnaryFunc(func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		conn := protocolClient.NewConn(ctx, spec, request.Header())
 		conn.onRequestSend(func(r *http.Request) {
 			request.setRequestMethod(r.Method)
 		})
@@ -92,34 +171,13 @@ func NewClient[Req, Res any](httpClient HTTPClient, url string, options ...Clien
 			_ = conn.CloseResponse()
 			return nil, err
 		}
-		response, err := receiveUnaryResponse[Res](conn, config.Initializer)
+		response, err := receiveUnaryResponse[Res](conn, initializer)
 		if err != nil {
 			_ = conn.CloseResponse()
 			return nil, err
 		}
 		return response, conn.CloseResponse()
 	})
-	if interceptor := config.Interceptor; interceptor != nil {
-		unaryFunc = interceptor.WrapUnary(unaryFunc)
-	}
-	client.callUnary = func(ctx context.Context, request *Request[Req]) (*Response[Res], error) {
-		// To make the specification, peer, and RPC headers visible to the full
-		// interceptor chain (as though they were supplied by the caller), we'll
-		// add them here.
-		request.spec = unarySpec
-		request.peer = client.protocolClient.Peer()
-		protocolClient.WriteRequestHeader(StreamTypeUnary, request.Header())
-		response, err := unaryFunc(ctx, request)
-		if err != nil {
-			return nil, err
-		}
-		typed, ok := response.(*Response[Res])
-		if !ok {
-			return nil, errorf(CodeInternal, "unexpected client response type %T", response)
-		}
-		return typed, nil
-	}
-	return client
 }
 
 // CallUnary calls a request-response procedure.
@@ -127,6 +185,9 @@ func (c *Client[Req, Res]) CallUnary(ctx context.Context, request *Request[Req])
 	if c.err != nil {
 		return nil, c.err
 	}
+	if err := c.config.validateCallCompression(ctx); err != nil {
+		return nil, err
+	}
 	return c.callUnary(ctx, request)
 }
 
@@ -135,8 +196,15 @@ func (c *Client[Req, Res]) CallClientStream(ctx context.Context) *ClientStreamFo
 	if c.err != nil {
 		return &ClientStreamForClient[Req, Res]{err: c.err}
 	}
+	if err := c.config.validateCallCompression(ctx); err != nil {
+		return &ClientStreamForClient[Req, Res]{err: err}
+	}
+	conn := c.newConn(ctx, StreamTypeClient, nil)
+	// Headers are sent lazily, so it's safe to modify them here.
+	setIdempotencyKey(ctx, conn.RequestHeader())
 	return &ClientStreamForClient[Req, Res]{
-		conn:        c.newConn(ctx, StreamTypeClient, nil),
+		ctx:         ctx,
+		conn:        conn,
 		initializer: c.config.Initializer,
 	}
 }
@@ -146,12 +214,17 @@ func (c *Client[Req, Res]) CallServerStream(ctx context.Context, request *Reques
 	if c.err != nil {
 		return nil, c.err
 	}
+	if err := c.config.validateCallCompression(ctx); err != nil {
+		return nil, err
+	}
 	conn := c.newConn(ctx, StreamTypeServer, func(r *http.Request) {
 		request.method = r.Method
 	})
 	request.spec = conn.Spec()
 	request.peer = conn.Peer()
+	request.codec = c.config.Codec.Name()
 	mergeHeaders(conn.RequestHeader(), request.header)
+	setIdempotencyKey(ctx, conn.RequestHeader())
 	// Send always returns an io.EOF unless the error is from the client-side.
 	// We want the user to continue to call Receive in those cases to get the
 	// full error from the server-side.
@@ -161,6 +234,7 @@ func (c *Client[Req, Res]) CallServerStream(ctx context.Context, request *Reques
 		return nil, err
 	}
 	if err := conn.CloseRequest(); err != nil {
+		_ = conn.CloseResponse()
 		return nil, err
 	}
 	return &ServerStreamForClient[Res]{
@@ -174,8 +248,14 @@ func (c *Client[Req, Res]) CallBidiStream(ctx context.Context) *BidiStreamForCli
 	if c.err != nil {
 		return &BidiStreamForClient[Req, Res]{err: c.err}
 	}
+	if err := c.config.validateCallCompression(ctx); err != nil {
+		return &BidiStreamForClient[Req, Res]{err: err}
+	}
+	conn := c.newConn(ctx, StreamTypeBidi, nil)
+	// Headers are sent lazily, so it's safe to modify them here.
+	setIdempotencyKey(ctx, conn.RequestHeader())
 	return &BidiStreamForClient[Req, Res]{
-		conn:        c.newConn(ctx, StreamTypeBidi, nil),
+		conn:        conn,
 		initializer: c.config.Initializer,
 	}
 }
@@ -208,11 +288,30 @@ type clientConfig struct {
 	RequestCompressionName string
 	BufferPool             *bufferPool
 	ReadMaxBytes           int
+	MaxDecompressionRatio  float64
+	ReadMaxFrames          int
+	ReadMaxEmptyFrames     int
 	SendMaxBytes           int
 	EnableGet              bool
 	GetURLMaxBytes         int
 	GetUseFallback         bool
+	GetParamNames          ConnectGetParamNames
 	IdempotencyLevel       IdempotencyLevel
+	TimeoutEncoder         func(time.Duration, http.Header)
+	LenientDecompression   bool
+	MaxCallAttempts        int
+	SkipResponseDraining   bool
+	RequireHTTP2           bool
+	DisableKeepAlives      bool
+	ConnObserver           func(context.Context, Spec, httptrace.GotConnInfo)
+	MaxMessageAge          time.Duration
+	AcceptHeartbeats       bool
+	TypeResolver           protoregistry.MessageTypeResolver
+	JSONTimeFormat         JSONTimeFormat
+	FallbackProtocols      []protocol
+	FallbackProtocolsErr   *Error
+	RequestRecorder        func(RecordedRequest)
+	RecorderRedactHeaders  map[string]struct{}
 }
 
 func newClientConfig(rawURL string, options []ClientOption) (*clientConfig, *Error) {
@@ -233,6 +332,15 @@ func newClientConfig(rawURL string, options []ClientOption) (*clientConfig, *Err
 	for _, opt := range options {
 		opt.applyToClient(&config)
 	}
+	if jsonCodec, ok := config.Codec.(*protoJSONCodec); ok {
+		// JSON options may come before WithProtoJSON, so they're applied once
+		// the codec is known.
+		jsonCodec = jsonCodec.withTimeFormat(config.JSONTimeFormat)
+		if config.TypeResolver != nil {
+			jsonCodec = jsonCodec.withResolver(config.TypeResolver)
+		}
+		config.Codec = jsonCodec
+	}
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
@@ -240,6 +348,9 @@ func newClientConfig(rawURL string, options []ClientOption) (*clientConfig, *Err
 }
 
 func (c *clientConfig) validate() *Error {
+	if c.FallbackProtocolsErr != nil {
+		return c.FallbackProtocolsErr
+	}
 	if c.Codec == nil || c.Codec.Name() == "" {
 		return errorf(CodeUnknown, "no codec configured")
 	}
@@ -251,6 +362,28 @@ func (c *clientConfig) validate() *Error {
 	return nil
 }
 
+// validateCallCompression checks the compression override set with
+// WithCallSendCompression, if any.
+func (c *clientConfig) validateCallCompression(ctx context.Context) *Error {
+	name, ok := callSendCompression(ctx)
+	if !ok || name == "" || name == compressionIdentity {
+		return nil
+	}
+	if _, ok := c.CompressionPools[name]; !ok {
+		return errorf(CodeUnknown, "unknown compression %q", name)
+	}
+	return nil
+}
+
+// isProtocolMismatch reports whether err shows that the server doesn't support
+// the protocol used for the call. Servers reject requests with an unsupported
+// Content-Type with an HTTP 415 before reading the body or running any
+// application code, so it's always safe to retry them with another protocol.
+func isProtocolMismatch(err error) bool {
+	connectErr, ok := asError(err)
+	return ok && connectErr.HTTPStatus() == http.StatusUnsupportedMediaType
+}
+
 func (c *clientConfig) protobuf() Codec {
 	if c.Codec.Name() == codecNameProto {
 		return c.Codec
@@ -16,15 +16,20 @@ package connect_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -41,6 +46,7 @@ import (
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func TestNewClient_InitFailure(t *testing.T) {
@@ -201,6 +207,46 @@ func TestGetNotModified(t *testing.T) {
 	assert.Equal(t, http.MethodGet, unaryReq.HTTPMethod())
 }
 
+func TestGetWithETags(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithETags()))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithHTTPGet())
+	ctx := context.Background()
+
+	res, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+	assert.Nil(t, err)
+	etag := res.Header().Get("Etag")
+	assert.NotZero(t, etag)
+
+	// The same response message always has the same ETag.
+	req := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+	req.Header().Set("If-None-Match", `"stale", `+etag)
+	_, err = client.Ping(ctx, req)
+	assert.True(t, connect.IsNotModifiedError(err))
+	var connectErr *connect.Error
+	assert.True(t, errors.As(err, &connectErr))
+	assert.Equal(t, connectErr.Meta().Get("Etag"), etag)
+
+	// A different response has a different ETag.
+	req = connect.NewRequest(&pingv1.PingRequest{Number: 43})
+	req.Header().Set("If-None-Match", etag)
+	res, err = client.Ping(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, res.Msg.GetNumber(), 43)
+	assert.NotZero(t, res.Header().Get("Etag"))
+	assert.NotEqual(t, res.Header().Get("Etag"), etag)
+
+	// POST requests don't get ETags.
+	client = pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+	req = connect.NewRequest(&pingv1.PingRequest{Number: 42})
+	req.Header().Set("If-None-Match", etag)
+	res, err = client.Ping(ctx, req)
+	assert.Nil(t, err)
+	assert.Zero(t, res.Header().Get("Etag"))
+}
+
 func TestGetNoContentHeaders(t *testing.T) {
 	t.Parallel()
 
@@ -227,6 +273,127 @@ func TestGetNoContentHeaders(t *testing.T) {
 	assert.Equal(t, http.MethodGet, unaryReq.HTTPMethod())
 }
 
+func TestGetCustomQueryParams(t *testing.T) {
+	t.Parallel()
+
+	names := connect.WithConnectGetQueryParams(connect.ConnectGetParamNames{
+		Message:  "m",
+		Encoding: "e",
+		Connect:  "v",
+	})
+	var query url.Values
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pingServer{}, names))
+	server := memhttptest.NewServer(t, http.HandlerFunc(func(respWriter http.ResponseWriter, req *http.Request) {
+		query = req.URL.Query()
+		mux.ServeHTTP(respWriter, req)
+	}))
+	ctx := context.Background()
+
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithHTTPGet(),
+		names,
+	)
+	unaryReq := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+	res, err := client.Ping(ctx, unaryReq)
+	assert.Nil(t, err)
+	assert.Equal(t, res.Msg.GetNumber(), 42)
+	assert.Equal(t, http.MethodGet, unaryReq.HTTPMethod())
+	assert.Equal(t, query.Get("e"), "proto")
+	assert.Equal(t, query.Get("v"), "v1")
+	assert.Equal(t, query.Get("base64"), "1")
+	assert.True(t, query.Has("m"))
+	assert.False(t, query.Has("message"))
+	assert.False(t, query.Has("encoding"))
+
+	// Clients using the spec names can't reach the handler.
+	client = pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithHTTPGet(),
+	)
+	_, err = client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.NotNil(t, err)
+}
+
+func TestConnectUnaryErrorBody(t *testing.T) {
+	t.Parallel()
+	detailValue, err := proto.Marshal(durationpb.New(time.Second))
+	assert.Nil(t, err)
+	detailJSON := fmt.Sprintf(
+		`{"type":"google.protobuf.Duration","value":%q}`,
+		base64.RawStdEncoding.EncodeToString(detailValue),
+	)
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantCode    connect.Code
+		wantMessage string
+		wantDetail  bool
+	}{
+		{
+			name:        "status_matches_body",
+			status:      http.StatusNotFound,
+			body:        `{"code":"not_found","message":"oops"}`,
+			wantCode:    connect.CodeNotFound,
+			wantMessage: "oops",
+		},
+		{
+			name:        "status_rewritten",
+			status:      http.StatusBadGateway,
+			body:        `{"code":"permission_denied","message":"no access","details":[` + detailJSON + `]}`,
+			wantCode:    connect.CodePermissionDenied,
+			wantMessage: "no access",
+			wantDetail:  true,
+		},
+		{
+			name:        "body_without_code",
+			status:      http.StatusTooManyRequests,
+			body:        `{"message":"slow down"}`,
+			wantCode:    connect.CodeUnavailable,
+			wantMessage: "slow down",
+		},
+		{
+			name:        "body_not_json",
+			status:      http.StatusUnauthorized,
+			body:        "<html>unauthorized</html>",
+			wantCode:    connect.CodeUnauthenticated,
+			wantMessage: "401 Unauthorized",
+		},
+	}
+	for _, testCase := range tests {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			server := memhttptest.NewServer(t, http.HandlerFunc(func(respWriter http.ResponseWriter, _ *http.Request) {
+				respWriter.Header().Set("Content-Type", "application/json")
+				respWriter.WriteHeader(testCase.status)
+				_, _ = io.WriteString(respWriter, testCase.body)
+			}))
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.NotNil(t, err)
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), testCase.wantCode)
+			assert.Equal(t, connectErr.Message(), testCase.wantMessage)
+			if !testCase.wantDetail {
+				assert.Zero(t, len(connectErr.Details()))
+				return
+			}
+			assert.Equal(t, len(connectErr.Details()), 1)
+			value, err := connectErr.Details()[0].Value()
+			assert.Nil(t, err)
+			duration, ok := value.(*durationpb.Duration)
+			assert.True(t, ok)
+			assert.Equal(t, duration.AsDuration(), time.Second)
+		})
+	}
+}
+
 func TestSpecSchema(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
@@ -432,6 +599,827 @@ func TestDynamicClient(t *testing.T) {
 	})
 }
 
+func TestClientContextErrors(t *testing.T) {
+	t.Parallel()
+	// The handler ignores its context and never responds, so the client's
+	// context always fires first.
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				<-release
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+			countUp: func(context.Context, *connect.Request[pingv1.CountUpRequest], *connect.ServerStream[pingv1.CountUpResponse]) error {
+				<-release
+				return nil
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	t.Cleanup(func() { close(release) })
+
+	assertContextErrors := func(
+		t *testing.T,
+		client pingv1connect.PingServiceClient,
+		newContext func() (context.Context, context.CancelFunc),
+		code connect.Code,
+		sentinel error,
+		pattern string,
+	) {
+		t.Helper()
+		ctx, cancel := newContext()
+		defer cancel()
+		_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), code)
+		assert.ErrorIs(t, err, sentinel)
+		assert.Match(t, err.Error(), pattern)
+
+		ctx, cancel = newContext()
+		defer cancel()
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+		if err == nil {
+			// Depending on timing, the error may surface when sending the
+			// request or when receiving the response.
+			assert.False(t, stream.Receive())
+			err = stream.Err()
+			assert.Nil(t, stream.Close())
+		}
+		assert.Equal(t, connect.CodeOf(err), code)
+		assert.ErrorIs(t, err, sentinel)
+		assert.Match(t, err.Error(), pattern)
+	}
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+		{name: "grpcweb", opts: []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		protocol := protocol
+		t.Run(protocol.name, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opts...)
+			t.Run("deadline", func(t *testing.T) {
+				t.Parallel()
+				assertContextErrors(
+					t,
+					client,
+					func() (context.Context, context.CancelFunc) {
+						return context.WithTimeout(context.Background(), 50*time.Millisecond)
+					},
+					connect.CodeDeadlineExceeded,
+					context.DeadlineExceeded,
+					`^deadline_exceeded: .*context deadline exceeded \(timeout \d+ms, elapsed \d+ms\)$`,
+				)
+			})
+			t.Run("canceled", func(t *testing.T) {
+				t.Parallel()
+				assertContextErrors(
+					t,
+					client,
+					func() (context.Context, context.CancelFunc) {
+						ctx, cancel := context.WithCancel(context.Background())
+						timer := time.AfterFunc(50*time.Millisecond, cancel)
+						return ctx, func() {
+							timer.Stop()
+							cancel()
+						}
+					},
+					connect.CodeCanceled,
+					context.Canceled,
+					`^canceled: .*context canceled \(elapsed \d+ms\)$`,
+				)
+			})
+		})
+	}
+}
+
+func TestClientStreamCloseAfterCancel(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int64
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		mux.ServeHTTP(w, r)
+	}))
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+		{name: "grpcweb", opts: []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opts...)
+		t.Run(protocol.name+"/client_stream", func(t *testing.T) {
+			before := requests.Load()
+			ctx, cancel := context.WithCancel(context.Background())
+			stream := client.Sum(ctx)
+			cancel()
+			res, err := stream.CloseAndReceive()
+			assert.Nil(t, res)
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+			assert.ErrorIs(t, err, context.Canceled)
+			// The final round trip is skipped entirely.
+			assert.Equal(t, requests.Load(), before)
+		})
+		t.Run(protocol.name+"/bidi_stream", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			stream := client.CumSum(ctx)
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 1}))
+			cancel()
+			for i := 0; i < 2; i++ {
+				assert.Nil(t, stream.CloseRequest())
+				assert.Nil(t, stream.CloseResponse())
+			}
+			_, err := stream.Receive()
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+		})
+	}
+}
+
+func TestWithTimeoutEncoder(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				response := connect.NewResponse(&pingv1.PingResponse{})
+				for _, key := range []string{"X-Request-Timeout-Ms", "Connect-Timeout-Ms", "Grpc-Timeout"} {
+					if value := request.Header().Get(key); value != "" {
+						response.Header().Set("Echo-"+key, value)
+					}
+				}
+				return response, nil
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	var (
+		mu       sync.Mutex
+		timeouts []time.Duration
+	)
+	encoder := connect.WithTimeoutEncoder(func(timeout time.Duration, header http.Header) {
+		mu.Lock()
+		timeouts = append(timeouts, timeout)
+		mu.Unlock()
+		header.Set("X-Request-Timeout-Ms", strconv.FormatInt(timeout.Milliseconds(), 10))
+	})
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt, encoder)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		response, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		cancel()
+		assert.Nil(t, err)
+		millis, err := strconv.ParseInt(response.Header().Get("Echo-X-Request-Timeout-Ms"), 10, 64)
+		assert.Nil(t, err)
+		assert.True(t, millis > 0 && millis <= time.Minute.Milliseconds())
+		// The custom encoder replaces the standard headers.
+		assert.Zero(t, response.Header().Get("Echo-Connect-Timeout-Ms"))
+		assert.Zero(t, response.Header().Get("Echo-Grpc-Timeout"))
+
+		// Without a deadline, the encoder isn't called.
+		response, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Zero(t, response.Header().Get("Echo-X-Request-Timeout-Ms"))
+	}
+	t.Run("expired", func(t *testing.T) {
+		t.Parallel()
+		var got []time.Duration
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithTimeoutEncoder(func(timeout time.Duration, _ http.Header) {
+				got = append(got, timeout)
+			}),
+		)
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+		assert.Equal(t, got, []time.Duration{0})
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, len(timeouts), 3)
+}
+
+func TestWithLenientDecompression(t *testing.T) {
+	t.Parallel()
+	gzipped := func(t *testing.T, msg proto.Message) []byte {
+		t.Helper()
+		data, err := proto.Marshal(msg)
+		assert.Nil(t, err)
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		_, err = writer.Write(data)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	envelope := func(flags byte, data []byte) []byte {
+		prefix := []byte{flags, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+		return append(prefix, data...)
+	}
+	response := &pingv1.PingResponse{Number: 42, Text: strings.Repeat("a", 1024)}
+	// A misbehaving proxy gzips response bodies, but labels them as identity.
+	mux := http.NewServeMux()
+	mux.HandleFunc(pingv1connect.PingServicePingProcedure, func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "application/proto")
+		responseWriter.Header().Set("Content-Encoding", "identity")
+		_, _ = responseWriter.Write(gzipped(t, response))
+	})
+	mux.HandleFunc(pingv1connect.PingServiceCountUpProcedure, func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "application/connect+proto")
+		responseWriter.Header().Set("Connect-Content-Encoding", "identity")
+		_, _ = responseWriter.Write(envelope(0, gzipped(t, &pingv1.CountUpResponse{Number: 1})))
+		_, _ = responseWriter.Write(envelope(2, []byte("{}")))
+	})
+	server := memhttptest.NewServer(t, mux)
+
+	t.Run("lenient", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithLenientDecompression())
+		got, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, got.Msg, response)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Equal(t, stream.Msg().GetNumber(), 1)
+		assert.False(t, stream.Receive())
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("read_max_bytes", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithLenientDecompression(),
+			connect.WithReadMaxBytes(512),
+		)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	})
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeInvalidArgument)
+		assert.Nil(t, stream.Close())
+	})
+}
+
+func TestWithoutResponseDraining(t *testing.T) {
+	t.Parallel()
+	first, err := proto.Marshal(&pingv1.CountUpResponse{Number: 1})
+	assert.Nil(t, err)
+	tail := bytes.Repeat([]byte{0}, 1024*1024)
+	mux := http.NewServeMux()
+	mux.HandleFunc(pingv1connect.PingServiceCountUpProcedure, func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "application/connect+proto")
+		prefix := []byte{0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(first)))
+		_, _ = responseWriter.Write(append(prefix, first...))
+		// The client stops reading before this large, unwanted tail.
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(tail)))
+		_, _ = responseWriter.Write(append(prefix, tail...))
+		_, _ = responseWriter.Write([]byte{2, 0, 0, 0, 2, '{', '}'})
+	})
+	server := memhttptest.NewServer(t, mux)
+
+	countRead := func(t *testing.T, opts ...connect.ClientOption) int64 {
+		t.Helper()
+		transport := &countingRoundTripper{transport: server.Transport()}
+		client := pingv1connect.NewPingServiceClient(&http.Client{Transport: transport}, server.URL(), opts...)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Equal(t, stream.Msg().GetNumber(), 1)
+		_ = stream.Close()
+		return transport.read.Load()
+	}
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		assert.True(t, countRead(t) > int64(len(tail)))
+	})
+	t.Run("without_draining", func(t *testing.T) {
+		t.Parallel()
+		assert.True(t, countRead(t, connect.WithoutResponseDraining()) < int64(len(tail)))
+	})
+}
+
+func TestCallSendCompression(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var encodings []string
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		encoding := r.Header.Get("Content-Encoding")
+		for _, key := range []string{"Connect-Content-Encoding", "Grpc-Encoding"} {
+			if value := r.Header.Get(key); value != "" {
+				encoding = value
+			}
+		}
+		encodings = append(encodings, encoding)
+		mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	takeEncodings := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := encodings
+		encodings = nil
+		return taken
+	}
+	gzipCtx := connect.WithCallSendCompression(context.Background(), "gzip")
+	identityCtx := connect.WithCallSendCompression(context.Background(), "identity")
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+	} {
+		t.Run(protocol.name, func(t *testing.T) {
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opts...)
+			// Two calls from the same client, only one of them compressed.
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+			assert.Nil(t, err)
+			res, err := client.Ping(gzipCtx, connect.NewRequest(&pingv1.PingRequest{Number: 2}))
+			assert.Nil(t, err)
+			assert.Equal(t, res.Msg.GetNumber(), 2)
+			stream, err := client.CountUp(gzipCtx, connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+			assert.Nil(t, err)
+			var received int
+			for stream.Receive() {
+				received++
+			}
+			assert.Nil(t, stream.Err())
+			assert.Nil(t, stream.Close())
+			assert.Equal(t, received, 2)
+			assert.Equal(t, takeEncodings(), []string{"", "gzip", "gzip"})
+
+			// A client that compresses by default can opt out for one call.
+			gzipClient := pingv1connect.NewPingServiceClient(
+				server.Client(),
+				server.URL(),
+				append(protocol.opts, connect.WithSendGzip())...,
+			)
+			_, err = gzipClient.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+			_, err = gzipClient.Ping(identityCtx, connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+			assert.Equal(t, takeEncodings(), []string{"gzip", ""})
+
+			// Unknown algorithms fail before anything is sent.
+			unknownCtx := connect.WithCallSendCompression(context.Background(), "zstd")
+			_, err = client.Ping(unknownCtx, connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Equal(t, connect.CodeOf(err), connect.CodeUnknown)
+			assert.Equal(t, err.Error(), `unknown: unknown compression "zstd"`)
+			_, err = client.CumSum(unknownCtx).Receive()
+			assert.Equal(t, connect.CodeOf(err), connect.CodeUnknown)
+			assert.Equal(t, len(takeEncodings()), 0)
+		})
+	}
+}
+
+func TestWithMaxMessageAge(t *testing.T) {
+	t.Parallel()
+	const procedure = "/connect.ping.v1.PingService/Ping"
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewServerStreamHandler(
+		procedure,
+		func(ctx context.Context, _ *connect.Request[pingv1.PingRequest], stream *connect.ServerStream[pingv1.PingResponse]) error {
+			for i := int64(1); i <= 3; i++ {
+				if err := stream.Send(&pingv1.PingResponse{Number: i}); err != nil {
+					return err
+				}
+			}
+			// By now, the client has fallen behind.
+			select {
+			case <-time.After(300 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return stream.Send(&pingv1.PingResponse{Number: 4})
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	receive := func(t *testing.T, opts ...connect.ClientOption) []int64 {
+		t.Helper()
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](server.Client(), server.URL()+procedure, opts...)
+		stream, err := client.CallServerStream(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		// A slow client, so the first messages are stale by the time they're read.
+		time.Sleep(200 * time.Millisecond)
+		var numbers []int64
+		for stream.Receive() {
+			numbers = append(numbers, stream.Msg().GetNumber())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		return numbers
+	}
+	t.Run("drops_stale", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, receive(t, connect.WithMaxMessageAge(100*time.Millisecond)), []int64{4})
+	})
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, receive(t), []int64{1, 2, 3, 4})
+	})
+	t.Run("grpc", func(t *testing.T) {
+		t.Parallel()
+		// The extension is Connect-only, so gRPC clients get every message.
+		assert.Equal(t, receive(t, connect.WithGRPC(), connect.WithMaxMessageAge(100*time.Millisecond)), []int64{1, 2, 3, 4})
+	})
+}
+
+func TestRequestReplyStream(t *testing.T) {
+	t.Parallel()
+	const slowNumber = 100
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		cumSum: func(ctx context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			var sum int64
+			for {
+				msg, err := stream.Receive()
+				if errors.Is(err, io.EOF) {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if msg.GetNumber() < 0 {
+					return connect.NewError(connect.CodeInvalidArgument, errors.New("negative number"))
+				}
+				if msg.GetNumber() == slowNumber {
+					select {
+					case <-time.After(200 * time.Millisecond):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				sum += msg.GetNumber()
+				if err := stream.Send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+					return err
+				}
+			}
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt)
+		stream := connect.NewRequestReplyStream(client.CumSum(context.Background()))
+		res, err := stream.SendAndAwait(&pingv1.CumSumRequest{Number: 1}, time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, res.GetSum(), 1)
+		// The slow reply times out, but the stream stays open.
+		_, err = stream.SendAndAwait(&pingv1.CumSumRequest{Number: slowNumber}, 10*time.Millisecond)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+		// The late reply is discarded rather than mistaken for this one.
+		res, err = stream.SendAndAwait(&pingv1.CumSumRequest{Number: 2}, time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, res.GetSum(), 1+slowNumber+2)
+		res, err = stream.SendAndAwait(&pingv1.CumSumRequest{Number: 3}, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, res.GetSum(), 1+slowNumber+2+3)
+		// Errors that end the stream are sticky.
+		_, err = stream.SendAndAwait(&pingv1.CumSumRequest{Number: -1}, time.Second)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+		_, err = stream.SendAndAwait(&pingv1.CumSumRequest{Number: 4}, time.Second)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+		assert.Nil(t, stream.Close())
+
+		// Closing the stream unblocks an exchange that's waiting for a reply.
+		stream = connect.NewRequestReplyStream(client.CumSum(context.Background()))
+		errs := make(chan error, 1)
+		go func() {
+			_, err := stream.SendAndAwait(&pingv1.CumSumRequest{Number: slowNumber}, 0)
+			errs <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, connect.CodeOf(<-errs), connect.CodeCanceled)
+		_, err = stream.SendAndAwait(&pingv1.CumSumRequest{Number: 5}, time.Second)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+	}
+}
+
+func TestWithMaxCallAttempts(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int64
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			calls.Add(1)
+			return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	// The transport loses the first response after the server has handled the
+	// request, then replays the request, much like net/http does when a
+	// connection is closed underneath it.
+	httpClient := &http.Client{Transport: replayingRoundTripper{server.Transport()}}
+	newClient := func(opts ...connect.ClientOption) *connect.Client[pingv1.PingRequest, pingv1.PingResponse] {
+		return connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+			httpClient,
+			server.URL()+pingv1connect.PingServicePingProcedure,
+			opts...,
+		)
+	}
+	for _, msg := range []*pingv1.PingRequest{{Number: 42}, {}} {
+		t.Run(fmt.Sprintf("unlimited_%d", msg.GetNumber()), func(t *testing.T) {
+			calls.Store(0)
+			response, err := newClient().CallUnary(context.Background(), connect.NewRequest(msg))
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.GetNumber(), msg.GetNumber())
+			assert.Equal(t, calls.Load(), 2)
+		})
+		t.Run(fmt.Sprintf("single_attempt_%d", msg.GetNumber()), func(t *testing.T) {
+			calls.Store(0)
+			_, err := newClient(connect.WithMaxCallAttempts(1)).CallUnary(context.Background(), connect.NewRequest(msg))
+			assert.NotNil(t, err)
+			assert.Equal(t, calls.Load(), 1)
+		})
+		t.Run(fmt.Sprintf("idempotent_%d", msg.GetNumber()), func(t *testing.T) {
+			calls.Store(0)
+			_, err := newClient(
+				connect.WithMaxCallAttempts(1),
+				connect.WithIdempotency(connect.IdempotencyIdempotent),
+			).CallUnary(context.Background(), connect.NewRequest(msg))
+			assert.Nil(t, err)
+			assert.Equal(t, calls.Load(), 2)
+		})
+	}
+}
+
+func TestClientProbe(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int64
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			calls.Add(1)
+			return connect.NewResponse(&pingv1.PingResponse{}), nil
+		},
+		countUp: func(_ context.Context, _ *connect.Request[pingv1.CountUpRequest], _ *connect.ServerStream[pingv1.CountUpResponse]) error {
+			calls.Add(1)
+			return nil
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+			server.Client(),
+			server.URL()+pingv1connect.PingServicePingProcedure,
+		)
+		capabilities, err := client.Probe(context.Background())
+		assert.Nil(t, err)
+		assert.True(t, capabilities.SupportsCodec("proto"))
+		assert.True(t, capabilities.SupportsCodec("json"))
+		assert.Equal(t, len(capabilities.ContentTypes), 15)
+		assert.False(t, capabilities.SupportsCodec("msgpack"))
+		assert.Equal(t, capabilities.Compressions, []string{"gzip"})
+		assert.True(t, capabilities.SupportsCompression("identity"))
+		assert.False(t, capabilities.SupportsCompression("br"))
+		assert.Equal(t, calls.Load(), 0)
+	})
+	t.Run("streaming", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[pingv1.CountUpRequest, pingv1.CountUpResponse](
+			server.Client(),
+			server.URL()+pingv1connect.PingServiceCountUpProcedure,
+			connect.WithGRPC(),
+		)
+		capabilities, err := client.Probe(context.Background())
+		assert.Nil(t, err)
+		assert.True(t, capabilities.SupportsCodec("proto"))
+		assert.Equal(t, capabilities.Compressions, []string{"gzip"})
+		assert.Equal(t, calls.Load(), 0)
+	})
+	t.Run("unknown_procedure", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+			server.Client(),
+			server.URL()+"/connect.ping.v1.PingService/Unknown",
+		)
+		_, err := client.Probe(context.Background())
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+	})
+}
+
+func TestHTTPStatus(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			if request.Msg.GetText() == "fail" {
+				return nil, connect.NewError(connect.CodeResourceExhausted, errors.New(errorMessage))
+			}
+			return connect.NewResponse(&pingv1.PingResponse{}), nil
+		},
+	}))
+	mux.HandleFunc(pingv1connect.PingServiceSumProcedure, func(responseWriter http.ResponseWriter, _ *http.Request) {
+		// A misbehaving proxy replaces the response.
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.WriteHeader(http.StatusBadGateway)
+		_, _ = responseWriter.Write([]byte("<html>bad gateway</html>"))
+	})
+	server := memhttptest.NewServer(t, mux)
+	assert.Zero(t, connect.NewResponse(&pingv1.PingResponse{}).HTTPStatus())
+	assert.Zero(t, connect.NewError(connect.CodeUnknown, errors.New(errorMessage)).HTTPStatus())
+	for _, test := range []struct {
+		name       string
+		opt        connect.ClientOption
+		failStatus int
+	}{
+		{name: "connect", opt: connect.WithProtoJSON(), failStatus: http.StatusTooManyRequests},
+		{name: "grpc", opt: connect.WithGRPC(), failStatus: http.StatusOK},
+		{name: "grpcweb", opt: connect.WithGRPCWeb(), failStatus: http.StatusOK},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), test.opt)
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+			assert.Equal(t, response.HTTPStatus(), http.StatusOK)
+			_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "fail"}))
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeResourceExhausted)
+			assert.Equal(t, connectErr.HTTPStatus(), test.failStatus)
+			stream := client.Sum(context.Background())
+			_, err = stream.CloseAndReceive()
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.HTTPStatus(), http.StatusBadGateway)
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+	retryDate := time.Now().Add(time.Hour).UTC()
+	mux := http.NewServeMux()
+	mux.HandleFunc(pingv1connect.PingServicePingProcedure, func(responseWriter http.ResponseWriter, _ *http.Request) {
+		// A rate-limiting proxy rejects the request.
+		responseWriter.Header().Set("Retry-After", "5")
+		responseWriter.WriteHeader(http.StatusTooManyRequests)
+	})
+	mux.HandleFunc(pingv1connect.PingServiceSumProcedure, func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Retry-After", retryDate.Format(http.TimeFormat))
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc(pingv1connect.PingServiceCountUpProcedure, func(responseWriter http.ResponseWriter, _ *http.Request) {
+		// Retry-After is only meaningful on 429s and 503s.
+		responseWriter.Header().Set("Retry-After", "5")
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	})
+	server := memhttptest.NewServer(t, mux)
+	assertRetryAfter := func(t *testing.T, err error, check func(time.Duration)) {
+		t.Helper()
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		delay, ok := connectErr.RetryAfter()
+		if check == nil {
+			assert.False(t, ok)
+			return
+		}
+		assert.True(t, ok)
+		assert.NotZero(t, connectErr.Meta().Get("Retry-After"))
+		check(delay)
+	}
+	for _, test := range []struct {
+		name string
+		opt  connect.ClientOption
+	}{
+		{name: "connect", opt: connect.WithProtoJSON()},
+		{name: "grpc", opt: connect.WithGRPC()},
+		{name: "grpcweb", opt: connect.WithGRPCWeb()},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), test.opt)
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assertRetryAfter(t, err, func(delay time.Duration) {
+				assert.Equal(t, delay, 5*time.Second)
+			})
+			_, err = client.Sum(context.Background()).CloseAndReceive()
+			assertRetryAfter(t, err, func(delay time.Duration) {
+				// HTTP dates have a resolution of one second.
+				assert.True(t, delay > 58*time.Minute && delay <= time.Hour, assert.Sprintf("delay %v", delay))
+			})
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+			if err == nil {
+				assert.False(t, stream.Receive())
+				err = stream.Err()
+			}
+			assertRetryAfter(t, err, nil)
+		})
+	}
+}
+
+func TestWithProtocolFallback(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int64
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			calls.Add(1)
+			if request.Msg.GetText() == "fail" {
+				return nil, connect.NewError(connect.CodeUnimplemented, errors.New("oops"))
+			}
+			return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+		},
+	}))
+	// The server only speaks the Connect protocol.
+	server := memhttptest.NewServer(t, http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if strings.HasPrefix(request.Header.Get("Content-Type"), "application/grpc") {
+			responseWriter.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		mux.ServeHTTP(responseWriter, request)
+	}))
+	var mu sync.Mutex
+	var protocols []string
+	recordProtocol := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+			mu.Lock()
+			protocols = append(protocols, request.Peer().Protocol)
+			mu.Unlock()
+			return next(ctx, request)
+		}
+	})
+	takeProtocols := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := protocols
+		protocols = nil
+		return taken
+	}
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithProtocolFallback(connect.ProtocolGRPC, connect.ProtocolGRPCWeb, connect.ProtocolConnect),
+		connect.WithInterceptors(recordProtocol),
+	)
+
+	response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.GetNumber(), 42)
+	assert.Equal(t, calls.Load(), 1)
+	assert.Equal(t, takeProtocols(), []string{connect.ProtocolGRPC, connect.ProtocolGRPCWeb, connect.ProtocolConnect})
+
+	// Application errors never trigger a fallback.
+	_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "fail"}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+	assert.Equal(t, calls.Load(), 2)
+	assert.Equal(t, len(takeProtocols()), 3)
+
+	connectFirst := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithProtocolFallback(connect.ProtocolConnect, connect.ProtocolGRPC),
+		connect.WithInterceptors(recordProtocol),
+	)
+	_, err = connectFirst.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "fail"}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+	assert.Equal(t, takeProtocols(), []string{connect.ProtocolConnect})
+
+	// Streaming calls only use the first protocol.
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+	if err == nil {
+		assert.False(t, stream.Receive())
+		err = stream.Err()
+	}
+	var connectErr *connect.Error
+	assert.True(t, errors.As(err, &connectErr))
+	assert.Equal(t, connectErr.HTTPStatus(), http.StatusUnsupportedMediaType)
+
+	invalid := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithProtocolFallback(connect.ProtocolGRPC, connect.ProtocolSSE),
+	)
+	_, err = invalid.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnknown)
+}
+
 func TestClientDeadlineHandling(t *testing.T) {
 	t.Parallel()
 	if testing.Short() {
@@ -656,6 +1644,57 @@ func testClientDeadlineBruteForceLoop(
 	t.Logf("Issued %d RPCs.", rpcCount.Load())
 }
 
+// replayingRoundTripper sends each request twice, discarding the first
+// response. Like net/http, it uses GetBody to rewind request bodies.
+type replayingRoundTripper struct {
+	transport http.RoundTripper
+}
+
+func (r replayingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	replay := request.Clone(request.Context())
+	response, err := r.transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	_ = response.Body.Close()
+	if request.GetBody != nil && request.Body != nil && request.Body != http.NoBody {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		replay.Body = body
+	}
+	return r.transport.RoundTrip(replay)
+}
+
+// countingRoundTripper counts the response body bytes read by the client.
+type countingRoundTripper struct {
+	transport http.RoundTripper
+	read      atomic.Int64
+}
+
+func (c *countingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := c.transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = &countingReadCloser{ReadCloser: response.Body, read: &c.read}
+	return response, nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+
+	read *atomic.Int64
+}
+
+func (c *countingReadCloser) Read(data []byte) (int, error) {
+	n, err := c.ReadCloser.Read(data)
+	c.read.Add(int64(n))
+	return n, err
+}
+
 type notModifiedPingServer struct {
 	pingv1connect.UnimplementedPingServiceHandler
 
@@ -15,9 +15,12 @@
 package connect
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
 // ClientStreamForClient is the client's view of a client streaming RPC.
@@ -25,6 +28,7 @@ import (
 // It's returned from [Client].CallClientStream, but doesn't currently have an
 // exported constructor function.
 type ClientStreamForClient[Req, Res any] struct {
+	ctx         context.Context //nolint:containedctx
 	conn        StreamingClientConn
 	initializer maybeInitializer
 	// Error from client construction. If non-nil, return for all calls.
@@ -69,12 +73,36 @@ func (c *ClientStreamForClient[Req, Res]) Send(request *Req) error {
 	return c.conn.Send(request)
 }
 
+// SendWithFlags is like Send, but it also sets custom envelope flags on the
+// message, which handlers can read with [ClientStream.ReceivedFlags]. The
+// flags may only use the bits in [CustomEnvelopeFlags]. SendWithFlags returns
+// an error if the client doesn't use the Connect protocol, or if the stream is
+// wrapped by an interceptor.
+func (c *ClientStreamForClient[Req, Res]) SendWithFlags(request *Req, flags uint8) error {
+	if c.err != nil {
+		return c.err
+	}
+	if request == nil {
+		return sendWithCustomFlags(c.conn, nil, flags)
+	}
+	return sendWithCustomFlags(c.conn, request, flags)
+}
+
 // CloseAndReceive closes the send side of the stream and waits for the
-// response.
+// response. If the stream's context is already canceled or past its deadline,
+// CloseAndReceive releases the stream's resources and returns an error with
+// [CodeCanceled] or [CodeDeadlineExceeded] without waiting for the server.
 func (c *ClientStreamForClient[Req, Res]) CloseAndReceive() (*Response[Res], error) {
 	if c.err != nil {
 		return nil, c.err
 	}
+	if err := c.ctx.Err(); err != nil {
+		// Don't bother finishing the request: the server's response can't be
+		// delivered to a canceled context anyway.
+		_ = c.conn.CloseRequest()
+		_ = c.conn.CloseResponse()
+		return nil, wrapIfContextError(err)
+	}
 	if err := c.conn.CloseRequest(); err != nil {
 		_ = c.conn.CloseResponse()
 		return nil, err
@@ -133,6 +161,17 @@ func (s *ServerStreamForClient[Res]) Msg() *Res {
 	return s.msg
 }
 
+// ReceivedFlags returns the custom envelope flags (see [CustomEnvelopeFlags])
+// of the most recent message unmarshaled by a call to Receive. It returns zero
+// if the message didn't have any custom flags, if the client doesn't use the
+// Connect protocol, or if the stream is wrapped by an interceptor.
+func (s *ServerStreamForClient[Res]) ReceivedFlags() uint8 {
+	if s.constructErr != nil {
+		return 0
+	}
+	return receivedFlagsOf(s.conn) & CustomEnvelopeFlags
+}
+
 // Err returns the first non-EOF error that was encountered by Receive.
 func (s *ServerStreamForClient[Res]) Err() error {
 	if s.constructErr != nil {
@@ -162,6 +201,18 @@ func (s *ServerStreamForClient[Res]) ResponseTrailer() http.Header {
 	return s.conn.ResponseTrailer()
 }
 
+// ResponseCompression returns the name of the compression algorithm used to
+// decode response messages, or "identity" if the server didn't compress them.
+// It blocks until the response headers arrive. Interceptors defined outside
+// this package hide the negotiated algorithm, so if one wraps the stream's
+// conn, ResponseCompression always reports "identity".
+func (s *ServerStreamForClient[Res]) ResponseCompression() string {
+	if s.constructErr != nil {
+		return compressionIdentity
+	}
+	return responseCompressionOf(s.conn)
+}
+
 // Close the receive side of the stream.
 func (s *ServerStreamForClient[Res]) Close() error {
 	if s.constructErr != nil {
@@ -226,6 +277,21 @@ func (b *BidiStreamForClient[Req, Res]) Send(msg *Req) error {
 	return b.conn.Send(msg)
 }
 
+// SendWithFlags is like Send, but it also sets custom envelope flags on the
+// message, which handlers can read with [BidiStream.ReceivedFlags]. The flags
+// may only use the bits in [CustomEnvelopeFlags]. SendWithFlags returns an
+// error if the client doesn't use the Connect protocol, or if the stream is
+// wrapped by an interceptor.
+func (b *BidiStreamForClient[Req, Res]) SendWithFlags(msg *Req, flags uint8) error {
+	if b.err != nil {
+		return b.err
+	}
+	if msg == nil {
+		return sendWithCustomFlags(b.conn, nil, flags)
+	}
+	return sendWithCustomFlags(b.conn, msg, flags)
+}
+
 // CloseRequest closes the send side of the stream.
 func (b *BidiStreamForClient[Req, Res]) CloseRequest() error {
 	if b.err != nil {
@@ -250,6 +316,17 @@ func (b *BidiStreamForClient[Req, Res]) Receive() (*Res, error) {
 	return &msg, nil
 }
 
+// ReceivedFlags returns the custom envelope flags (see [CustomEnvelopeFlags])
+// of the message returned by the most recent call to Receive. It returns zero
+// if the message didn't have any custom flags, if the client doesn't use the
+// Connect protocol, or if the stream is wrapped by an interceptor.
+func (b *BidiStreamForClient[Req, Res]) ReceivedFlags() uint8 {
+	if b.err != nil {
+		return 0
+	}
+	return receivedFlagsOf(b.conn) & CustomEnvelopeFlags
+}
+
 // CloseResponse closes the receive side of the stream.
 func (b *BidiStreamForClient[Req, Res]) CloseResponse() error {
 	if b.err != nil {
@@ -276,8 +353,165 @@ func (b *BidiStreamForClient[Req, Res]) ResponseTrailer() http.Header {
 	return b.conn.ResponseTrailer()
 }
 
+// ResponseCompression returns the name of the compression algorithm used to
+// decode response messages, or "identity" if the server didn't compress them.
+// It blocks until the response headers arrive. Interceptors defined outside
+// this package hide the negotiated algorithm, so if one wraps the stream's
+// conn, ResponseCompression always reports "identity".
+func (b *BidiStreamForClient[Req, Res]) ResponseCompression() string {
+	if b.err != nil {
+		return compressionIdentity
+	}
+	return responseCompressionOf(b.conn)
+}
+
 // Conn exposes the underlying StreamingClientConn. This may be useful if
 // you'd prefer to wrap the connection in a different high-level API.
 func (b *BidiStreamForClient[Req, Res]) Conn() (StreamingClientConn, error) {
 	return b.conn, b.err
 }
+
+// RequestReplyStream layers request-response semantics on a bidirectional
+// stream, for protocols that correlate each request with a reply. Unlike
+// separate unary calls, all the exchanges share one stream, and unlike the
+// stream's own deadline, each exchange has its own timeout.
+//
+// Correlation is strictly first-in, first-out: the server must send exactly one
+// reply for each request, in the order it received them, and mustn't send any
+// other messages. When an exchange times out, its reply is discarded whenever
+// it eventually arrives, so later exchanges still receive the right replies.
+//
+// Create a RequestReplyStream with [NewRequestReplyStream]. Once it's created,
+// don't call Send or Receive on the underlying stream directly.
+type RequestReplyStream[Req, Res any] struct {
+	stream  *BidiStreamForClient[Req, Res]
+	replies chan requestReplyResult[Res]
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	mu      sync.Mutex // serializes exchanges
+	discard int        // replies still owed to exchanges that timed out
+	err     error      // sticky: the error that ended the stream
+}
+
+type requestReplyResult[Res any] struct {
+	msg *Res
+	err error
+}
+
+// NewRequestReplyStream wraps a bidirectional stream. It starts a goroutine
+// that receives replies from the stream; call Close to stop it.
+func NewRequestReplyStream[Req, Res any](stream *BidiStreamForClient[Req, Res]) *RequestReplyStream[Req, Res] {
+	s := &RequestReplyStream[Req, Res]{
+		stream:  stream,
+		replies: make(chan requestReplyResult[Res]),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.receive()
+	return s
+}
+
+// SendAndAwait sends a request and waits for the next reply. If no reply
+// arrives within the timeout, it returns an error with [CodeDeadlineExceeded]
+// but leaves the stream open for further exchanges. A timeout that isn't
+// positive waits indefinitely, or until the stream's context is done.
+//
+// If the stream ends, SendAndAwait returns the error that ended it (which
+// wraps [io.EOF] if the server closed the stream cleanly), and so do all
+// subsequent calls. Once Close is called, SendAndAwait returns an error with
+// [CodeCanceled], including in calls that are already waiting for a reply.
+// Concurrent calls are safe, but they're serialized: each exchange completes
+// or times out before the next request is sent.
+func (s *RequestReplyStream[Req, Res]) SendAndAwait(request *Req, timeout time.Duration) (*Res, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil && s.closed() {
+		s.err = errorf(CodeCanceled, "request-reply stream closed")
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if err := s.stream.Send(request); err != nil {
+		if !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		// The server ended the stream, and Receive returns the reason.
+		timeout = 0
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case result := <-s.replies:
+			if result.err != nil && s.closed() {
+				// Closing the stream made Receive fail.
+				result.err = errorf(CodeCanceled, "request-reply stream closed")
+			}
+			if result.err != nil {
+				s.err = result.err
+				return nil, s.err
+			}
+			if s.discard > 0 {
+				// A late reply to an exchange that already timed out.
+				s.discard--
+				continue
+			}
+			return result.msg, nil
+		case <-expired:
+			s.discard++
+			return nil, errorf(CodeDeadlineExceeded, "no reply within %v", timeout)
+		case <-s.done:
+			s.err = errorf(CodeCanceled, "request-reply stream closed")
+			return nil, s.err
+		}
+	}
+}
+
+func (s *RequestReplyStream[Req, Res]) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes both sides of the underlying stream and waits for the
+// receiving goroutine to exit. Replies that haven't been awaited are
+// discarded, and exchanges that are waiting for a reply fail with
+// [CodeCanceled].
+func (s *RequestReplyStream[Req, Res]) Close() error {
+	s.once.Do(func() { close(s.done) })
+	// Closing the request side mustn't race with an exchange's Send, so wait
+	// for the exchange in progress, which returns once done is closed.
+	s.mu.Lock()
+	requestErr := s.stream.CloseRequest()
+	s.mu.Unlock()
+	responseErr := s.stream.CloseResponse()
+	<-s.stopped
+	if requestErr != nil {
+		return requestErr
+	}
+	return responseErr
+}
+
+func (s *RequestReplyStream[Req, Res]) receive() {
+	defer close(s.stopped)
+	for {
+		msg, err := s.stream.Receive()
+		select {
+		case s.replies <- requestReplyResult[Res]{msg: msg, err: err}:
+		case <-s.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
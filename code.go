@@ -39,7 +39,9 @@ const (
 	// successes.)
 
 	// CodeCanceled indicates that the operation was canceled, typically by the
-	// caller.
+	// caller. To report a more specific code when canceling an RPC's context,
+	// cancel it with context.WithCancelCause and an [*Error] as the cause:
+	// handlers send the cause to the client, and clients return it from calls.
 	CodeCanceled Code = 1
 
 	// CodeUnknown indicates that the operation failed for an unknown reason.
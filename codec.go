@@ -22,6 +22,8 @@ import (
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/runtime/protoiface"
 )
 
@@ -145,6 +147,15 @@ func (c *protoBinaryCodec) IsBinary() bool {
 
 type protoJSONCodec struct {
 	name string
+	// disallowUnknown makes Unmarshal reject unknown fields rather than
+	// discarding them. See WithStrictJSON.
+	disallowUnknown bool
+	// timeFormat controls the representation of timestamps and durations.
+	// See WithJSONTimeFormat.
+	timeFormat JSONTimeFormat
+	// resolver looks up the message types of google.protobuf.Any fields. If
+	// nil, protojson uses the global registry. See WithTypeResolver.
+	resolver protoResolver
 }
 
 var _ Codec = (*protoJSONCodec)(nil)
@@ -156,7 +167,11 @@ func (c *protoJSONCodec) Marshal(message any) ([]byte, error) {
 	if !ok {
 		return nil, errNotProto(message)
 	}
-	return protojson.MarshalOptions{}.Marshal(protoMessage)
+	data, err := protojson.MarshalOptions{Resolver: c.resolver}.Marshal(protoMessage)
+	if err != nil || c.timeFormat != JSONTimeFormatNumeric {
+		return data, err
+	}
+	return numericJSONTimes(data, protoMessage.ProtoReflect().Descriptor())
 }
 
 func (c *protoJSONCodec) MarshalAppend(dst []byte, message any) ([]byte, error) {
@@ -164,7 +179,11 @@ func (c *protoJSONCodec) MarshalAppend(dst []byte, message any) ([]byte, error)
 	if !ok {
 		return nil, errNotProto(message)
 	}
-	return protojson.MarshalOptions{}.MarshalAppend(dst, protoMessage)
+	if c.timeFormat == JSONTimeFormatNumeric {
+		data, err := c.Marshal(message)
+		return append(dst, data...), err
+	}
+	return protojson.MarshalOptions{Resolver: c.resolver}.MarshalAppend(dst, protoMessage)
 }
 
 func (c *protoJSONCodec) Unmarshal(binary []byte, message any) error {
@@ -175,9 +194,20 @@ func (c *protoJSONCodec) Unmarshal(binary []byte, message any) error {
 	if len(binary) == 0 {
 		return errors.New("zero-length payload is not a valid JSON object")
 	}
-	// Discard unknown fields so clients and servers aren't forced to always use
-	// exactly the same version of the schema.
-	options := protojson.UnmarshalOptions{DiscardUnknown: true}
+	// Unless configured otherwise, discard unknown fields so clients and
+	// servers aren't forced to always use exactly the same version of the
+	// schema.
+	if c.timeFormat == JSONTimeFormatNumeric {
+		canonical, err := canonicalJSONTimes(binary, protoMessage.ProtoReflect().Descriptor())
+		if err != nil {
+			return fmt.Errorf("unmarshal into %T: %w", message, err)
+		}
+		binary = canonical
+	}
+	options := protojson.UnmarshalOptions{
+		DiscardUnknown: !c.disallowUnknown,
+		Resolver:       c.resolver,
+	}
 	err := options.Unmarshal(binary, protoMessage)
 	if err != nil {
 		return fmt.Errorf("unmarshal into %T: %w", message, err)
@@ -185,6 +215,18 @@ func (c *protoJSONCodec) Unmarshal(binary []byte, message any) error {
 	return nil
 }
 
+func (c *protoJSONCodec) withTimeFormat(format JSONTimeFormat) *protoJSONCodec {
+	codec := *c
+	codec.timeFormat = format
+	return &codec
+}
+
+func (c *protoJSONCodec) withResolver(resolver protoregistry.MessageTypeResolver) *protoJSONCodec {
+	codec := *c
+	codec.resolver = newProtoResolver(resolver)
+	return &codec
+}
+
 func (c *protoJSONCodec) MarshalStable(message any) ([]byte, error) {
 	// protojson does not offer a "deterministic" field ordering, but fields
 	// are still ordered consistently by their index. However, protojson can
@@ -257,3 +299,35 @@ func errNotProto(message any) error {
 	}
 	return fmt.Errorf("%T doesn't implement proto.Message", message)
 }
+
+// protoResolver is the type resolver required by protojson and by
+// proto.UnmarshalOptions.
+type protoResolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
+
+// newProtoResolver adapts a message type resolver to a protoResolver. If the
+// resolver can't also resolve extensions, extensions are looked up in the
+// global registry. It returns nil if the resolver is nil.
+func newProtoResolver(resolver protoregistry.MessageTypeResolver) protoResolver {
+	if resolver == nil {
+		return nil
+	}
+	if full, ok := resolver.(protoResolver); ok {
+		return full
+	}
+	return &globalExtensionResolver{MessageTypeResolver: resolver}
+}
+
+type globalExtensionResolver struct {
+	protoregistry.MessageTypeResolver
+}
+
+func (r *globalExtensionResolver) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByName(field)
+}
+
+func (r *globalExtensionResolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
@@ -0,0 +1,226 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	timestampFullName protoreflect.FullName = "google.protobuf.Timestamp"
+	durationFullName  protoreflect.FullName = "google.protobuf.Duration"
+)
+
+// JSONTimeFormat selects how the JSON codecs represent the
+// google.protobuf.Timestamp and google.protobuf.Duration well-known types.
+// See [WithJSONTimeFormat].
+type JSONTimeFormat int
+
+const (
+	// JSONTimeFormatCanonical uses the canonical Protobuf JSON mapping:
+	// timestamps are RFC 3339 strings like "2024-01-02T03:04:05.678Z" and
+	// durations are strings of seconds like "1.5s".
+	JSONTimeFormatCanonical JSONTimeFormat = iota
+	// JSONTimeFormatNumeric represents timestamps as JSON numbers of
+	// milliseconds since the Unix epoch and durations as JSON numbers of
+	// seconds. Sub-millisecond timestamps and sub-second durations use
+	// fractional digits, so no precision is lost.
+	JSONTimeFormatNumeric
+)
+
+// numericJSONTimes rewrites canonical Protobuf JSON for the described message
+// to use numeric timestamps and durations.
+func numericJSONTimes(data []byte, desc protoreflect.MessageDescriptor) ([]byte, error) {
+	return rewriteJSONTimes(data, desc, true)
+}
+
+// canonicalJSONTimes rewrites JSON with numeric timestamps and durations back to
+// the canonical Protobuf JSON mapping, so that protojson can unmarshal it.
+func canonicalJSONTimes(data []byte, desc protoreflect.MessageDescriptor) ([]byte, error) {
+	return rewriteJSONTimes(data, desc, false)
+}
+
+func rewriteJSONTimes(data []byte, desc protoreflect.MessageDescriptor, toNumeric bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree any
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	rewritten, err := rewriteJSONTimesValue(tree, desc, toNumeric)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rewritten)
+}
+
+func rewriteJSONTimesValue(value any, desc protoreflect.MessageDescriptor, toNumeric bool) (any, error) {
+	switch desc.FullName() {
+	case timestampFullName:
+		if toNumeric {
+			return timestampToMillis(value)
+		}
+		return millisToTimestamp(value)
+	case durationFullName:
+		if toNumeric {
+			return durationToSeconds(value)
+		}
+		return secondsToDuration(value)
+	}
+	object, ok := value.(map[string]any)
+	if !ok || desc.FullName().Parent() == "google.protobuf" {
+		// Nulls and other well-known types (including the contents of Any)
+		// are left alone.
+		return value, nil
+	}
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		key := field.JSONName()
+		fieldValue, ok := object[key]
+		if !ok {
+			key = string(field.Name())
+			if fieldValue, ok = object[key]; !ok {
+				continue
+			}
+		}
+		var err error
+		switch {
+		case field.IsMap():
+			if field.MapValue().Message() == nil {
+				continue
+			}
+			entries, ok := fieldValue.(map[string]any)
+			if !ok {
+				continue
+			}
+			for entryKey, entry := range entries {
+				if entries[entryKey], err = rewriteJSONTimesValue(entry, field.MapValue().Message(), toNumeric); err != nil {
+					return nil, err
+				}
+			}
+		case field.Message() == nil:
+			continue
+		case field.IsList():
+			elements, ok := fieldValue.([]any)
+			if !ok {
+				continue
+			}
+			for j, element := range elements {
+				if elements[j], err = rewriteJSONTimesValue(element, field.Message(), toNumeric); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			if object[key], err = rewriteJSONTimesValue(fieldValue, field.Message(), toNumeric); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return object, nil
+}
+
+func timestampToMillis(value any) (any, error) {
+	text, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", timestampFullName, text, err)
+	}
+	nanos := new(big.Int).Mul(big.NewInt(timestamp.Unix()), big.NewInt(int64(time.Second)))
+	nanos.Add(nanos, big.NewInt(int64(timestamp.Nanosecond())))
+	return json.Number(formatDecimal(nanos, 6)), nil
+}
+
+func millisToTimestamp(value any) (any, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		// Also accept the canonical representation.
+		return value, nil
+	}
+	nanos, err := parseDecimal(string(number), 6)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s: %w", timestampFullName, number, err)
+	}
+	seconds, remainder := new(big.Int).DivMod(nanos, big.NewInt(int64(time.Second)), new(big.Int))
+	if !seconds.IsInt64() {
+		return nil, fmt.Errorf("invalid %s %s: out of range", timestampFullName, number)
+	}
+	return time.Unix(seconds.Int64(), remainder.Int64()).UTC().Format(time.RFC3339Nano), nil
+}
+
+func durationToSeconds(value any) (any, error) {
+	text, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	return json.Number(strings.TrimSuffix(text, "s")), nil
+}
+
+func secondsToDuration(value any) (any, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		// Also accept the canonical representation.
+		return value, nil
+	}
+	nanos, err := parseDecimal(string(number), 9)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s: %w", durationFullName, number, err)
+	}
+	return formatDecimal(nanos, 9) + "s", nil
+}
+
+// formatDecimal formats value / 10^scale as a decimal number, without trailing
+// zeros in the fractional part.
+func formatDecimal(value *big.Int, scale int) string {
+	divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	whole, fraction := new(big.Int).QuoRem(new(big.Int).Abs(value), divisor, new(big.Int))
+	var text strings.Builder
+	if value.Sign() < 0 {
+		text.WriteByte('-')
+	}
+	text.WriteString(whole.String())
+	if fraction.Sign() != 0 {
+		digits := fraction.String()
+		text.WriteByte('.')
+		text.WriteString(strings.Repeat("0", scale-len(digits)))
+		text.WriteString(strings.TrimRight(digits, "0"))
+	}
+	return text.String()
+}
+
+// parseDecimal parses a JSON number and returns it multiplied by 10^scale,
+// which must be an integer.
+func parseDecimal(text string, scale int) (*big.Int, error) {
+	rat, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, errors.New("not a decimal number")
+	}
+	rat.Mul(rat, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if !rat.IsInt() {
+		return nil, errors.New("too many fractional digits")
+	}
+	return rat.Num(), nil
+}
@@ -16,15 +16,25 @@ package connect
 
 import (
 	"bytes"
+	"encoding/json"
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"connectrpc.com/connect/internal/assert"
 	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func convertMapToInterface(stringMap map[string]string) map[string]interface{} {
@@ -142,6 +152,14 @@ func TestJSONCodec(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("unknown fields strict", func(t *testing.T) {
+		t.Parallel()
+		strict := &protoJSONCodec{name: "json", disallowUnknown: true}
+		err := strict.Unmarshal([]byte(`{"foo": "bar"}`), &emptypb.Empty{})
+		assert.NotNil(t, err)
+		assert.True(t, strings.Contains(err.Error(), `unknown field "foo"`))
+	})
+
 	t.Run("empty string", func(t *testing.T) {
 		t.Parallel()
 		err := codec.Unmarshal([]byte{}, &emptypb.Empty{})
@@ -152,4 +170,168 @@ func TestJSONCodec(t *testing.T) {
 			assert.Sprintf(`error message should explain that "" is not a valid JSON object`),
 		)
 	})
+
+	t.Run("struct", func(t *testing.T) {
+		t.Parallel()
+		// google.protobuf.Struct and Value use their natural JSON mapping, so
+		// arbitrary JSON round-trips without a wrapper.
+		raw := `{"name":"alice","tags":["a",1,true,null],"nested":{"deeper":{"n":1.5}},"none":null}`
+		var msg structpb.Struct
+		assert.Nil(t, codec.Unmarshal([]byte(raw), &msg))
+		assert.Equal(t, msg.GetFields()["name"].GetStringValue(), "alice")
+		assert.Equal(t, len(msg.GetFields()["tags"].GetListValue().GetValues()), 4)
+		_, isNull := msg.GetFields()["none"].GetKind().(*structpb.Value_NullValue)
+		assert.True(t, isNull)
+		deeper := msg.GetFields()["nested"].GetStructValue().GetFields()["deeper"]
+		assert.Equal(t, deeper.GetStructValue().GetFields()["n"].GetNumberValue(), 1.5)
+		assertJSONEqual(t, codec, &msg, raw)
+
+		var value structpb.Value
+		assert.Nil(t, codec.Unmarshal([]byte(`[{"a":null},[],"b"]`), &value))
+		assert.Equal(t, len(value.GetListValue().GetValues()), 3)
+		assertJSONEqual(t, codec, &value, `[{"a":null},[],"b"]`)
+
+		assert.Nil(t, codec.Unmarshal([]byte(`null`), &value))
+		assertJSONEqual(t, codec, &value, `null`)
+	})
+}
+
+func TestJSONCodecTimeFormat(t *testing.T) {
+	t.Parallel()
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("connect/test/times.proto"),
+		Package:    proto.String("connect.test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/duration.proto", "google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Times"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{
+					Name:     proto.String("created_at"),
+					JsonName: proto.String("createdAt"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".google.protobuf.Timestamp"),
+				},
+				{
+					Name:     proto.String("timeouts"),
+					JsonName: proto.String("timeouts"),
+					Number:   proto.Int32(2),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".google.protobuf.Duration"),
+				},
+				{
+					Name:     proto.String("label"),
+					JsonName: proto.String("label"),
+					Number:   proto.Int32(3),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				},
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	assert.Nil(t, err)
+	desc := file.Messages().ByName("Times")
+	created := time.Date(2024, 1, 2, 3, 4, 5, 678_900_000, time.UTC)
+	msg := dynamicpb.NewMessage(desc)
+	msg.Set(desc.Fields().ByName("created_at"), protoreflect.ValueOfMessage(timestamppb.New(created).ProtoReflect()))
+	timeouts := msg.Mutable(desc.Fields().ByName("timeouts")).List()
+	timeouts.Append(protoreflect.ValueOfMessage(durationpb.New(1500 * time.Millisecond).ProtoReflect()))
+	timeouts.Append(protoreflect.ValueOfMessage(durationpb.New(-time.Nanosecond).ProtoReflect()))
+	msg.Set(desc.Fields().ByName("label"), protoreflect.ValueOfString("1s"))
+
+	codec := (&protoJSONCodec{name: codecNameJSON}).withTimeFormat(JSONTimeFormatNumeric)
+	const numeric = `{"createdAt":1704164645678.9,"timeouts":[1.5,-0.000000001],"label":"1s"}`
+	assertJSONEqual(t, codec, msg, numeric)
+	roundTripped := dynamicpb.NewMessage(desc)
+	assert.Nil(t, codec.Unmarshal([]byte(numeric), roundTripped))
+	assert.True(t, proto.Equal(roundTripped, msg))
+
+	// The canonical format is still accepted, but never produced.
+	canonical, err := (&protoJSONCodec{name: codecNameJSON}).Marshal(msg)
+	assert.Nil(t, err)
+	fromCanonical := dynamicpb.NewMessage(desc)
+	assert.Nil(t, codec.Unmarshal(canonical, fromCanonical))
+	assert.True(t, proto.Equal(fromCanonical, msg))
+
+	// Top-level well-known types and pre-epoch timestamps work too.
+	assertJSONEqual(t, codec, timestamppb.New(time.Unix(-1, 500_000_000)), `-500`)
+	var timestamp timestamppb.Timestamp
+	assert.Nil(t, codec.Unmarshal([]byte(`-500`), &timestamp))
+	assert.Equal(t, timestamp.AsTime(), time.Unix(-1, 500_000_000).UTC())
+	assert.NotNil(t, codec.Unmarshal([]byte(`1.0000001`), &timestamp))
+}
+
+func TestClientJSONTimeFormatOptionOrder(t *testing.T) {
+	t.Parallel()
+	for _, opts := range [][]ClientOption{
+		{WithProtoJSON(), WithJSONTimeFormat(JSONTimeFormatNumeric)},
+		{WithJSONTimeFormat(JSONTimeFormatNumeric), WithProtoJSON()},
+	} {
+		config, err := newClientConfig("http://localhost/foo.v1.Bar/Baz", opts)
+		assert.Nil(t, err)
+		codec, ok := config.Codec.(*protoJSONCodec)
+		assert.True(t, ok)
+		assert.Equal(t, codec.timeFormat, JSONTimeFormatNumeric)
+	}
+}
+
+func TestJSONCodecTypeResolver(t *testing.T) {
+	t.Parallel()
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("connect/test/plugin_config.proto"),
+		Package: proto.String("connect.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("PluginConfig"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("name"),
+				JsonName: proto.String("name"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+		}},
+	}, protoregistry.GlobalFiles)
+	assert.Nil(t, err)
+	desc := file.Messages().ByName("PluginConfig")
+	var types protoregistry.Types
+	assert.Nil(t, types.RegisterMessage(dynamicpb.NewMessageType(desc)))
+	config := dynamicpb.NewMessage(desc)
+	config.Set(desc.Fields().ByName("name"), protoreflect.ValueOfString("acme"))
+	msg, err := anypb.New(config)
+	assert.Nil(t, err)
+
+	// The global registry doesn't know the type.
+	_, err = (&protoJSONCodec{name: codecNameJSON}).Marshal(msg)
+	assert.NotNil(t, err)
+
+	codec := (&protoJSONCodec{name: codecNameJSON}).withResolver(&types)
+	const want = `{"@type":"type.googleapis.com/connect.test.PluginConfig","name":"acme"}`
+	assertJSONEqual(t, codec, msg, want)
+	var roundTripped anypb.Any
+	assert.Nil(t, codec.Unmarshal([]byte(want), &roundTripped))
+	assert.True(t, proto.Equal(&roundTripped, msg))
+
+	// Clients apply the resolver regardless of option order.
+	for _, opts := range [][]ClientOption{
+		{WithProtoJSON(), WithTypeResolver(&types)},
+		{WithTypeResolver(&types), WithProtoJSON()},
+	} {
+		config, err := newClientConfig("http://localhost/foo.v1.Bar/Baz", opts)
+		assert.Nil(t, err)
+		assertJSONEqual(t, config.Codec, msg, want)
+	}
+}
+
+func assertJSONEqual(tb testing.TB, codec Codec, msg proto.Message, want string) {
+	tb.Helper()
+	data, err := codec.Marshal(msg)
+	assert.Nil(tb, err)
+	var got, expected any
+	assert.Nil(tb, json.Unmarshal(data, &got))
+	assert.Nil(tb, json.Unmarshal([]byte(want), &expected))
+	assert.Equal(tb, got, expected)
 }
@@ -16,6 +16,7 @@ package connect
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"math"
@@ -28,6 +29,33 @@ const (
 	compressionIdentity = "identity"
 )
 
+// WithCallSendCompression returns a copy of ctx that overrides the client's
+// request compression for calls made with it. It's useful when a single call,
+// like a large upload, benefits from compression that the client doesn't use
+// by default, and it avoids constructing a second client:
+//
+//	ctx = connect.WithCallSendCompression(ctx, "gzip")
+//	res, err := client.Upload(ctx, req)
+//
+// The name must be "identity" (to send uncompressed requests) or the name of
+// a compression algorithm registered with the client, such as "gzip";
+// otherwise the call fails with [CodeUnknown] before anything is sent. The
+// override only changes request compression, so the algorithms the client
+// accepts in responses are unaffected. Because it's carried by the context,
+// the override also applies to calls made by generated clients.
+func WithCallSendCompression(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, callSendCompressionContextKey{}, name)
+}
+
+type callSendCompressionContextKey struct{}
+
+// callSendCompression returns the override set with WithCallSendCompression,
+// if any.
+func callSendCompression(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(callSendCompressionContextKey{}).(string)
+	return name, ok
+}
+
 // A Decompressor is a reusable wrapper that decompresses an underlying data
 // source. The standard library's [*gzip.Reader] implements Decompressor.
 type Decompressor interface {
@@ -56,6 +84,22 @@ type Compressor interface {
 	Reset(io.Writer)
 }
 
+// compressionNameOrIdentity normalizes an empty compression name, as found in
+// a missing Content-Encoding or Grpc-Encoding header, to identity.
+func compressionNameOrIdentity(name string) string {
+	if name == "" {
+		return compressionIdentity
+	}
+	return name
+}
+
+// isGzipped reports whether data starts with the gzip magic number. Neither
+// binary Protobuf nor JSON messages can start with these bytes, so it's a
+// reliable way to detect gzipped data that's been mislabeled as identity.
+func isGzipped(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
 type compressionPool struct {
 	decompressors sync.Pool
 	compressors   sync.Pool
@@ -78,14 +122,27 @@ func newCompressionPool(
 	}
 }
 
-func (c *compressionPool) Decompress(dst *bytes.Buffer, src *bytes.Buffer, readMaxBytes int64) *Error {
+// Decompress decompresses src into dst. If readMaxBytes is positive, messages
+// that decompress to more bytes fail with CodeResourceExhausted. If maxRatio
+// is positive, so do messages that decompress to more than maxRatio times
+// their compressed size; unlike oversized messages, they're abandoned without
+// decompressing the rest, since they're likely decompression bombs.
+func (c *compressionPool) Decompress(dst *bytes.Buffer, src *bytes.Buffer, readMaxBytes int64, maxRatio float64) *Error {
+	compressedBytes := src.Len()
+	ratioMaxBytes := int64(-1)
+	if maxRatio > 0 && maxRatio*float64(compressedBytes) < math.MaxInt64 {
+		ratioMaxBytes = int64(maxRatio * float64(compressedBytes))
+	}
 	decompressor, err := c.getDecompressor(src)
 	if err != nil {
 		return errorf(CodeInvalidArgument, "get decompressor: %w", err)
 	}
 	reader := io.Reader(decompressor)
 	if readMaxBytes > 0 && readMaxBytes < math.MaxInt64 {
-		reader = io.LimitReader(decompressor, readMaxBytes+1)
+		reader = io.LimitReader(reader, readMaxBytes+1)
+	}
+	if ratioMaxBytes >= 0 {
+		reader = io.LimitReader(reader, ratioMaxBytes+1)
 	}
 	bytesRead, err := dst.ReadFrom(reader)
 	if err != nil {
@@ -96,6 +153,16 @@ func (c *compressionPool) Decompress(dst *bytes.Buffer, src *bytes.Buffer, readM
 		}
 		return errorf(CodeInvalidArgument, "decompress: %w", err)
 	}
+	if ratioMaxBytes >= 0 && bytesRead > ratioMaxBytes && (readMaxBytes <= 0 || bytesRead <= readMaxBytes) {
+		// Closing a decompressor before EOF may fail, but we're discarding it
+		// anyway.
+		_ = c.putDecompressor(decompressor)
+		return errorf(
+			CodeResourceExhausted,
+			"message of %d compressed bytes decompresses to more than %d bytes, exceeding the configured max ratio %g",
+			compressedBytes, ratioMaxBytes, maxRatio,
+		)
+	}
 	if readMaxBytes > 0 && bytesRead > readMaxBytes {
 		discardedBytes, err := io.Copy(io.Discard, decompressor)
 		_ = c.putDecompressor(decompressor)
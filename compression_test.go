@@ -15,8 +15,14 @@
 package connect
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"connectrpc.com/connect/internal/assert"
@@ -159,3 +165,95 @@ func TestHandlerCompressionOptionTest(t *testing.T) {
 		checkPools(t, config)
 	})
 }
+
+func TestCompressionPoolDictionary(t *testing.T) {
+	t.Parallel()
+	// DEFLATE stands in for zstd, which also supports preset dictionaries but
+	// isn't in the standard library.
+	dictionary := []byte(`{"host":"web-","region":"us-east-1","metric":"http.requests","status":200,"latencyMs":}`)
+	newPool := func(dict []byte) *compressionPool {
+		return newCompressionPool(
+			func() Decompressor { return &flateDictDecompressor{dict: dict} },
+			func() Compressor {
+				compressor, err := flate.NewWriterDict(io.Discard, flate.BestCompression, dict)
+				assert.Nil(t, err)
+				return compressor
+			},
+		)
+	}
+	plain, withDict := newPool(nil), newPool(dictionary)
+	var plainSize, dictSize int
+	for i := 0; i < 10; i++ {
+		msg := fmt.Sprintf(`{"host":"web-%d","region":"us-east-1","metric":"http.requests","status":200,"latencyMs":%d}`, i, i*7)
+		for _, pool := range []*compressionPool{plain, withDict} {
+			compressed := &bytes.Buffer{}
+			assert.Nil(t, pool.Compress(compressed, bytes.NewBufferString(msg)))
+			if pool == plain {
+				plainSize += compressed.Len()
+			} else {
+				dictSize += compressed.Len()
+			}
+			// Pooled decompressors must keep the dictionary across resets.
+			decompressed := &bytes.Buffer{}
+			assert.Nil(t, pool.Decompress(decompressed, compressed, 0, 0))
+			assert.Equal(t, decompressed.String(), msg)
+		}
+	}
+	assert.True(t, dictSize*2 < plainSize)
+}
+
+func TestCompressionPoolMaxRatio(t *testing.T) {
+	t.Parallel()
+	pool := newCompressionPool(
+		func() Decompressor { return &gzip.Reader{} },
+		func() Compressor { return gzip.NewWriter(io.Discard) },
+	)
+	msg := bytes.Repeat([]byte("a"), 1024*1024)
+	compressed := &bytes.Buffer{}
+	assert.Nil(t, pool.Compress(compressed, bytes.NewBuffer(msg)))
+	ratio := float64(len(msg)) / float64(compressed.Len())
+	assert.True(t, ratio > 100)
+	decompress := func(readMaxBytes int64, maxRatio float64) (*bytes.Buffer, *Error) {
+		decompressed := &bytes.Buffer{}
+		return decompressed, pool.Decompress(decompressed, bytes.NewBuffer(compressed.Bytes()), readMaxBytes, maxRatio)
+	}
+
+	decompressed, err := decompress(0, ratio+1)
+	assert.Nil(t, err)
+	assert.Equal(t, decompressed.Bytes(), msg)
+
+	decompressed, err = decompress(0, 100)
+	assert.Equal(t, err.Code(), CodeResourceExhausted)
+	assert.True(t, strings.Contains(err.Message(), "max ratio 100"))
+	// The rest of the message isn't decompressed.
+	assert.Equal(t, decompressed.Len(), 100*compressed.Len()+1)
+
+	// When it's lower, the size limit takes precedence.
+	_, err = decompress(1024, 100)
+	assert.Equal(t, err.Code(), CodeResourceExhausted)
+	assert.Equal(t, err.Message(), fmt.Sprintf("message size %d is larger than configured max 1024", len(msg)))
+}
+
+// flateDictDecompressor adapts a DEFLATE reader with a preset dictionary to
+// the Decompressor interface.
+type flateDictDecompressor struct {
+	dict   []byte
+	reader io.ReadCloser
+}
+
+func (d *flateDictDecompressor) Read(data []byte) (int, error) {
+	return d.reader.Read(data)
+}
+
+func (d *flateDictDecompressor) Close() error {
+	return d.reader.Close()
+}
+
+func (d *flateDictDecompressor) Reset(reader io.Reader) error {
+	if d.reader == nil {
+		d.reader = flate.NewReaderDict(reader, d.dict)
+		return nil
+	}
+	resetter, _ := d.reader.(flate.Resetter)
+	return resetter.Reset(reader, d.dict)
+}
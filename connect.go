@@ -25,6 +25,7 @@
 package connect
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -152,6 +153,7 @@ type Request[T any] struct {
 	peer   Peer
 	header http.Header
 	method string
+	codec  string
 }
 
 // NewRequest wraps a generated request message.
@@ -182,6 +184,10 @@ func (r *Request[_]) Peer() Peer {
 // Header returns the HTTP headers for this request. Headers beginning with
 // "Connect-" and "Grpc-" are reserved for use by the Connect and gRPC
 // protocols: applications may read them but shouldn't write them.
+//
+// A header may have more than one value. [http.Header.Get] returns only the
+// first, so use [http.Header.Values] to read them all and
+// [DecodeBinaryHeaderValues] to decode every value of a binary header.
 func (r *Request[_]) Header() http.Header {
 	if r.header == nil {
 		r.header = make(http.Header)
@@ -203,6 +209,15 @@ func (r *Request[_]) HTTPMethod() string {
 	return r.method
 }
 
+// Codec returns the name of the codec used to encode the request message,
+// like "proto" or "json". Handlers see the codec negotiated with the client,
+// and clients see the codec they're configured to use; both are set before
+// interceptors run. Requests constructed with NewRequest return the empty
+// string until they're passed to a client.
+func (r *Request[_]) Codec() string {
+	return r.codec
+}
+
 // internalOnly implements AnyRequest.
 func (r *Request[_]) internalOnly() {}
 
@@ -227,6 +242,7 @@ type AnyRequest interface {
 	Peer() Peer
 	Header() http.Header
 	HTTPMethod() string
+	Codec() string
 
 	internalOnly()
 	setRequestMethod(string)
@@ -238,8 +254,11 @@ type AnyRequest interface {
 type Response[T any] struct {
 	Msg *T
 
-	header  http.Header
-	trailer http.Header
+	header      http.Header
+	trailer     http.Header
+	compression string
+	httpStatus  int
+	codec       string
 }
 
 // NewResponse wraps a generated response message.
@@ -260,7 +279,8 @@ func (r *Response[_]) Any() any {
 
 // Header returns the HTTP headers for this response. Headers beginning with
 // "Connect-" and "Grpc-" are reserved for use by the Connect and gRPC
-// protocols: applications may read them but shouldn't write them.
+// protocols: applications may read them but shouldn't write them. As with
+// requests, use [http.Header.Values] to read repeated headers.
 func (r *Response[_]) Header() http.Header {
 	if r.header == nil {
 		r.header = make(http.Header)
@@ -282,6 +302,36 @@ func (r *Response[_]) Trailer() http.Header {
 	return r.trailer
 }
 
+// Compression returns the name of the compression algorithm used to decode
+// the response body, or "identity" if the response wasn't compressed. It's
+// only populated on the client, after the response has been received, and
+// reflects the Content-Encoding (or protocol-specific equivalent) sent by the
+// server. Responses constructed with NewResponse return the empty string.
+//
+// Compression is primarily a debugging aid: for example, it makes it easy to
+// notice when a proxy strips the request's Accept-Encoding header.
+func (r *Response[_]) Compression() string {
+	return r.compression
+}
+
+// HTTPStatus returns the status code of the HTTP response. It's only
+// populated on the client, after the response has been received; responses
+// constructed with NewResponse return zero. Successful responses always have
+// a 200 OK status unless a proxy rewrote it. To inspect the status of failed
+// calls, use [Error.HTTPStatus].
+func (r *Response[_]) HTTPStatus() int {
+	return r.httpStatus
+}
+
+// Codec returns the name of the codec used to encode the response message,
+// like "proto" or "json". On the client, it's populated once the response has
+// been received. On the handler, it's populated for unary procedures after
+// the implementation returns, so interceptors see it. Responses constructed
+// with NewResponse otherwise return the empty string.
+func (r *Response[_]) Codec() string {
+	return r.codec
+}
+
 // internalOnly implements AnyResponse.
 func (r *Response[_]) internalOnly() {}
 
@@ -299,6 +349,7 @@ type AnyResponse interface {
 	Any() any
 	Header() http.Header
 	Trailer() http.Header
+	Codec() string
 
 	internalOnly()
 }
@@ -329,14 +380,23 @@ type Spec struct {
 //
 // On both the client and the server, Protocol is the RPC protocol in use.
 // Currently, it's either [ProtocolConnect], [ProtocolGRPC], or
-// [ProtocolGRPCWeb], but additional protocols may be added in the future.
+// [ProtocolGRPCWeb], or (for handlers only) [ProtocolSSE], but additional
+// protocols may be added in the future.
 //
 // Query contains the query parameters for the request. For the server, this
 // will reflect the actual query parameters sent. For the client, it is unset.
+//
+// TLS contains the server's view of the TLS connection, including any
+// certificates presented by the client (for mutual TLS, see
+// [tls.ConnectionState]'s PeerCertificates and VerifiedChains). It's only
+// populated when TLS is terminated by the Go server handling the RPC: it's
+// nil for plaintext connections (including h2c) and when TLS is offloaded to
+// a proxy or load balancer. For the client, it is unset.
 type Peer struct {
 	Addr     string
 	Protocol string
-	Query    url.Values // server-only
+	Query    url.Values           // server-only
+	TLS      *tls.ConnectionState // server-only
 }
 
 func newPeerFromURL(url *url.URL, protocol string) Peer {
@@ -380,8 +440,11 @@ func receiveUnaryResponse[T any](conn StreamingClientConn, initializer maybeInit
 		return nil, err
 	}
 	return &Response[T]{
-		Msg:     &msg,
-		header:  conn.ResponseHeader(),
-		trailer: conn.ResponseTrailer(),
+		Msg:         &msg,
+		header:      conn.ResponseHeader(),
+		trailer:     conn.ResponseTrailer(),
+		compression: responseCompressionOf(conn),
+		httpStatus:  responseStatusOf(conn),
+		codec:       codecNameOf(conn),
 	}, nil
 }
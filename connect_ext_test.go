@@ -19,16 +19,21 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -37,10 +42,19 @@ import (
 	"connectrpc.com/connect/internal/gen/connect/import/v1/importv1connect"
 	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
 	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	statusv1 "connectrpc.com/connect/internal/gen/connectext/grpc/status/v1"
 	"connectrpc.com/connect/internal/memhttp"
 	"connectrpc.com/connect/internal/memhttp/memhttptest"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 const errorMessage = "oh no"
@@ -647,6 +661,83 @@ func TestTimeoutParsing(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestStreamingTimeoutParsing(t *testing.T) {
+	t.Parallel()
+	const timeout = 200 * time.Millisecond
+	type result struct {
+		elapsed time.Duration
+		ctxErr  error
+		err     error
+	}
+	results := make(chan result, 1)
+	pingServer := &pluggablePingServer{
+		cumSum: func(ctx context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			start := time.Now()
+			_, ok := ctx.Deadline()
+			assert.True(t, ok)
+			for {
+				request, err := stream.Receive()
+				if err != nil {
+					elapsed := time.Since(start)
+					// The context's timer may fire a moment after the read is
+					// interrupted.
+					select {
+					case <-ctx.Done():
+					case <-time.After(time.Second):
+					}
+					results <- result{elapsed: elapsed, ctxErr: ctx.Err(), err: err}
+					return err
+				}
+				if err := stream.Send(&pingv1.CumSumResponse{Sum: request.GetNumber()}); err != nil {
+					return err
+				}
+			}
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer))
+	server := memhttptest.NewServer(t, mux)
+	for _, protocol := range []struct {
+		name   string
+		opt    connect.ClientOption
+		header string
+		value  string
+	}{
+		{name: "connect", opt: connect.WithProtoJSON(), header: "Connect-Timeout-Ms", value: "200"},
+		{name: "grpc", opt: connect.WithGRPC(), header: "Grpc-Timeout", value: "200m"},
+		{name: "grpcweb", opt: connect.WithGRPCWeb(), header: "Grpc-Timeout", value: "200m"},
+	} {
+		protocol := protocol
+		t.Run(protocol.name, func(t *testing.T) {
+			// Set the timeout header directly, rather than using a context with a
+			// deadline, so that the client doesn't cancel the stream itself: we
+			// want to see the handler enforce the timeout.
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opt)
+			stream := client.CumSum(context.Background())
+			stream.RequestHeader().Set(protocol.header, protocol.value)
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 1}))
+			response, err := stream.Receive()
+			assert.Nil(t, err)
+			assert.Equal(t, response.GetSum(), 1)
+			// Don't send anything else, so the handler blocks in Receive until its
+			// context's deadline passes.
+			select {
+			case res := <-results:
+				assert.ErrorIs(t, res.ctxErr, context.DeadlineExceeded)
+				assert.Equal(t, connect.CodeOf(res.err), connect.CodeDeadlineExceeded)
+				assert.True(t, res.elapsed >= timeout-50*time.Millisecond)
+				assert.True(t, res.elapsed < 5*time.Second)
+			case <-time.After(10 * time.Second):
+				t.Fatal("handler's context didn't time out")
+			}
+			_, err = stream.Receive()
+			assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+			assert.Nil(t, stream.CloseRequest())
+			assert.Nil(t, stream.CloseResponse())
+		})
+	}
+}
+
 func TestFailCodec(t *testing.T) {
 	t.Parallel()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
@@ -869,6 +960,41 @@ func TestCompressMinBytesClient(t *testing.T) {
 		t.Parallel()
 		assertContentType(t, strings.Repeat("ping", 2), "gzip")
 	})
+
+	// The gRPC protocols always send the Grpc-Encoding header, and mark each
+	// compressed message with a flag in its envelope.
+	assertEnvelopeFlags := func(tb testing.TB, opt connect.ClientOption, text string, expect byte) {
+		tb.Helper()
+		mux := http.NewServeMux()
+		mux.Handle("/", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			assert.Equal(tb, request.Header.Get("Grpc-Encoding"), "gzip")
+			var prefix [5]byte
+			_, err := io.ReadFull(request.Body, prefix[:])
+			assert.Nil(tb, err)
+			assert.Equal(tb, prefix[0], expect)
+			writer.Header().Set("Content-Type", request.Header.Get("Content-Type"))
+			writer.Header().Set("Grpc-Status", "0")
+		}))
+		server := memhttptest.NewServer(t, mux)
+		_, _ = pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			opt,
+			connect.WithSendGzip(),
+			connect.WithCompressMinBytes(8),
+		).Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: text}))
+	}
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb()} {
+		opt := opt
+		t.Run("grpc_request_uncompressed", func(t *testing.T) {
+			t.Parallel()
+			assertEnvelopeFlags(t, opt, "ping", 0)
+		})
+		t.Run("grpc_request_compressed", func(t *testing.T) {
+			t.Parallel()
+			assertEnvelopeFlags(t, opt, "pingping", 1)
+		})
+	}
 }
 
 func TestCompressMinBytes(t *testing.T) {
@@ -944,6 +1070,144 @@ func TestCustomCompression(t *testing.T) {
 	assert.Equal(t, response.Msg, &pingv1.PingResponse{Text: request.GetText()})
 }
 
+func TestResponseCompression(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Text: request.Msg.GetText()}), nil
+			},
+			countUp: func(ctx context.Context, request *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				stream.ResponseHeader().Set("Handler-Compression", stream.ResponseCompression())
+				return stream.Send(&pingv1.CountUpResponse{Number: request.Msg.GetNumber()})
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	testCompression := func(t *testing.T, expect string, opts ...connect.ClientOption) {
+		t.Helper()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		response, err := client.Ping(
+			context.Background(),
+			connect.NewRequest(&pingv1.PingRequest{Text: "compress me"}),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Compression(), expect)
+		stream, err := client.CountUp(
+			context.Background(),
+			connect.NewRequest(&pingv1.CountUpRequest{Number: 1}),
+		)
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Equal(t, stream.ResponseCompression(), expect)
+		assert.Equal(t, stream.ResponseHeader().Get("Handler-Compression"), expect)
+		assert.False(t, stream.Receive())
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	}
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+		{name: "grpcweb", opts: []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		protocol := protocol
+		t.Run(protocol.name, func(t *testing.T) {
+			t.Parallel()
+			t.Run("gzip", func(t *testing.T) {
+				testCompression(t, "gzip", protocol.opts...)
+			})
+			t.Run("identity", func(t *testing.T) {
+				opts := append([]connect.ClientOption{connect.WithAcceptCompression("gzip", nil, nil)}, protocol.opts...)
+				testCompression(t, "identity", opts...)
+			})
+		})
+	}
+	t.Run("builtin_interceptors", func(t *testing.T) {
+		t.Parallel()
+		testCompression(t, "gzip", connect.WithInterceptors(
+			connect.NewAuditInterceptor(connect.AuditSinkFunc(func(context.Context, *connect.AuditRecord) {}), nil),
+			connect.NewClientStreamLimitInterceptor(10),
+		))
+	})
+	t.Run("third_party_interceptor", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithInterceptors(newHeaderInterceptor(&atomic.Int32{}, nil, nil)),
+		)
+		stream, err := client.CountUp(
+			context.Background(),
+			connect.NewRequest(&pingv1.CountUpRequest{Number: 1}),
+		)
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Equal(t, stream.ResponseCompression(), "identity")
+		assert.Equal(t, stream.ResponseHeader().Get("Handler-Compression"), "gzip")
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("new_response", func(t *testing.T) {
+		t.Parallel()
+		assert.Zero(t, connect.NewResponse(&pingv1.PingResponse{}).Compression())
+	})
+}
+
+func TestBidiMixedCompression(t *testing.T) {
+	t.Parallel()
+	// Record the raw request stream, so we can check that the client
+	// compressed only some of its messages.
+	recorded := &syncBuffer{}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, http.HandlerFunc(func(respWriter http.ResponseWriter, request *http.Request) {
+		recorded.Reset()
+		request.Body = io.NopCloser(io.TeeReader(request.Body, recorded))
+		mux.ServeHTTP(respWriter, request)
+	}))
+	// Small numbers encode to 2 bytes and large numbers to 10, so only
+	// large numbers should be compressed.
+	numbers := []int64{1, 1 << 60, 2, 3, 1 << 62}
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+	} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			append(protocol.opts, connect.WithSendGzip(), connect.WithCompressMinBytes(8))...,
+		)
+		stream := client.CumSum(context.Background())
+		var sum int64
+		for _, number := range numbers {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: number}))
+			response, err := stream.Receive()
+			assert.Nil(t, err, assert.Sprintf(protocol.name))
+			sum += number
+			assert.Equal(t, response.GetSum(), sum, assert.Sprintf(protocol.name))
+		}
+		assert.Nil(t, stream.CloseRequest())
+		_, err := stream.Receive()
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Nil(t, stream.CloseResponse())
+
+		var compressed []bool
+		data := recorded.Bytes()
+		for len(data) >= 5 {
+			size := int(binary.BigEndian.Uint32(data[1:5]))
+			compressed = append(compressed, data[0]&1 == 1)
+			data = data[5+size:]
+		}
+		assert.Equal(t, compressed, []bool{false, true, false, false, true}, assert.Sprintf(protocol.name))
+	}
+}
+
 func TestClientWithoutGzipSupport(t *testing.T) {
 	// See https://connectrpc.com/connect/pull/349 for why we want to
 	// support this. TL;DR is that Microsoft's dapr sidecar can't handle
@@ -964,6 +1228,50 @@ func TestClientWithoutGzipSupport(t *testing.T) {
 	assert.True(t, strings.Contains(err.Error(), "unknown compression"))
 }
 
+func TestUnsupportedRequestCompressionAdvertisesEncodings(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, mux)
+	// The client registers a compressor the server doesn't know about.
+	withFakeCompression := connect.WithClientOptions(
+		connect.WithAcceptCompression(
+			"fake",
+			func() connect.Decompressor { return &gzip.Reader{} },
+			func() connect.Compressor { return gzip.NewWriter(io.Discard) },
+		),
+		connect.WithSendCompression("fake"),
+	)
+	for _, protocol := range []struct {
+		name   string
+		opt    connect.ClientOption
+		header string
+	}{
+		{name: "connect", opt: connect.WithProtoJSON(), header: "Accept-Encoding"},
+		{name: "grpc", opt: connect.WithGRPC(), header: "Grpc-Accept-Encoding"},
+		{name: "grpcweb", opt: connect.WithGRPCWeb(), header: "Grpc-Accept-Encoding"},
+	} {
+		protocol := protocol
+		t.Run(protocol.name, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opt, withFakeCompression)
+			request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+			_, err := client.Ping(context.Background(), request)
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeUnimplemented)
+			assert.Equal(t, connectErr.Meta().Get(protocol.header), "gzip")
+
+			// Having read the advertisement, the client can retry with a supported
+			// encoding (or identity).
+			client = pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opt)
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.GetNumber(), 42)
+		})
+	}
+}
+
 func TestInvalidHeaderTimeout(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
@@ -1201,6 +1509,28 @@ func TestHandlerWithHTTPMaxBytes(t *testing.T) {
 	})
 }
 
+func TestWithMaxDecompressionRatio(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithMaxDecompressionRatio(100)))
+	server := memhttptest.NewServer(t, mux)
+	// A megabyte of one repeated byte compresses about 1000:1, so it's only a
+	// few kilobytes on the wire.
+	bomb := &pingv1.PingRequest{Text: strings.Repeat("a", 1024*1024)}
+	assert.True(t, gzipCompressedSize(t, bomb)*100 < proto.Size(bomb))
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt, connect.WithSendGzip())
+		_, err := client.Ping(context.Background(), connect.NewRequest(bomb))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+		assert.True(t, strings.Contains(err.Error(), "exceeding the configured max ratio 100"))
+
+		// Ordinary messages barely compress, so they're unaffected.
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "hello"}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetText(), "hello")
+	}
+}
+
 func TestClientWithReadMaxBytes(t *testing.T) {
 	t.Parallel()
 	createServer := func(tb testing.TB, enableCompression bool) *memhttp.Server {
@@ -1291,6 +1621,59 @@ func TestClientWithReadMaxBytes(t *testing.T) {
 	})
 }
 
+func TestWithMaxStreamFrames(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithMaxStreamFrames(3)))
+	server := memhttptest.NewServer(t, mux)
+	testLimits := func(t *testing.T, opts ...connect.ClientOption) {
+		t.Helper()
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			append(opts, connect.WithMaxStreamFrames(2))...,
+		)
+		// Handler limit: three request messages are okay, four aren't.
+		for _, count := range []int{3, 4} {
+			stream := client.Sum(context.Background())
+			for i := 0; i < count; i++ {
+				if err := stream.Send(&pingv1.SumRequest{Number: 1}); err != nil {
+					break
+				}
+			}
+			response, err := stream.CloseAndReceive()
+			if count == 3 {
+				assert.Nil(t, err)
+				assert.Equal(t, response.Msg.GetSum(), 3)
+			} else {
+				assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+			}
+		}
+		// Client limit: two response messages are okay, three aren't.
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err)
+		var received int
+		for stream.Receive() {
+			received++
+		}
+		assert.Equal(t, received, 2)
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeResourceExhausted)
+		assert.Nil(t, stream.Close())
+	}
+	t.Run("connect", func(t *testing.T) {
+		t.Parallel()
+		testLimits(t)
+	})
+	t.Run("grpc", func(t *testing.T) {
+		t.Parallel()
+		testLimits(t, connect.WithGRPC())
+	})
+	t.Run("grpcweb", func(t *testing.T) {
+		t.Parallel()
+		testLimits(t, connect.WithGRPCWeb())
+	})
+}
+
 func TestHandlerWithSendMaxBytes(t *testing.T) {
 	t.Parallel()
 	sendMaxBytes := 1024
@@ -1966,6 +2349,90 @@ func TestConnectProtocolHeaderRequired(t *testing.T) {
 	}
 }
 
+func TestConnectProtocolVersionValidation(t *testing.T) {
+	t.Parallel()
+	for _, require := range []bool{false, true} {
+		var opts []connect.HandlerOption
+		if require {
+			opts = append(opts, connect.WithRequireConnectProtocolHeader())
+		}
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, opts...))
+		server := memhttptest.NewServer(t, mux)
+		for _, testCase := range []struct {
+			version     string
+			wantCode    connect.Code
+			wantMessage string
+		}{
+			{version: "", wantMessage: "missing required header: set Connect-Protocol-Version to \"1\""},
+			{version: "1"},
+			{version: "2", wantCode: connect.CodeInvalidArgument, wantMessage: "unsupported Connect-Protocol-Version \"2\": must be \"1\""},
+		} {
+			wantCode := testCase.wantCode
+			if testCase.version == "" && require {
+				wantCode = connect.CodeInvalidArgument
+			}
+			req, err := http.NewRequestWithContext(
+				context.Background(),
+				http.MethodPost,
+				server.URL()+pingv1connect.PingServicePingProcedure,
+				strings.NewReader("{}"),
+			)
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if testCase.version != "" {
+				req.Header.Set("Connect-Protocol-Version", testCase.version)
+			}
+			response, err := server.Client().Do(req)
+			assert.Nil(t, err)
+			body, err := io.ReadAll(response.Body)
+			assert.Nil(t, err)
+			assert.Nil(t, response.Body.Close())
+			if wantCode == 0 {
+				assert.Equal(t, response.StatusCode, http.StatusOK, assert.Sprintf("version %q, require %v", testCase.version, require))
+				continue
+			}
+			assert.Equal(t, response.StatusCode, http.StatusBadRequest, assert.Sprintf("version %q, require %v", testCase.version, require))
+			var wireErr struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}
+			assert.Nil(t, json.Unmarshal(body, &wireErr))
+			assert.Equal(t, wireErr.Code, wantCode.String())
+			assert.Equal(t, wireErr.Message, testCase.wantMessage)
+		}
+
+		// GET requests carry the version in a query parameter.
+		for version, wantStatus := range map[string]int{"v1": http.StatusOK, "v2": http.StatusBadRequest, "1": http.StatusBadRequest} {
+			query := url.Values{
+				"connect":  []string{version},
+				"encoding": []string{"json"},
+				"message":  []string{"{}"},
+			}
+			req, err := http.NewRequestWithContext(
+				context.Background(),
+				http.MethodGet,
+				server.URL()+pingv1connect.PingServicePingProcedure+"?"+query.Encode(),
+				http.NoBody,
+			)
+			assert.Nil(t, err)
+			response, err := server.Client().Do(req)
+			assert.Nil(t, err)
+			assert.Nil(t, response.Body.Close())
+			assert.Equal(t, response.StatusCode, wantStatus, assert.Sprintf("query version %q", version))
+		}
+	}
+
+	// ErrorWriter recognizes Connect GET requests by the same versions.
+	writer := connect.NewErrorWriter()
+	for version, want := range map[string]bool{"1": true, "2": false} {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", http.NoBody)
+		assert.Nil(t, err)
+		req.Header.Set("Connect-Protocol-Version", version)
+		assert.Equal(t, writer.IsSupported(req), want, assert.Sprintf("version %q", version))
+	}
+}
+
 func TestAllowCustomUserAgent(t *testing.T) {
 	t.Parallel()
 
@@ -2022,6 +2489,414 @@ func TestWebXUserAgent(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestGRPCWebText(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, mux)
+
+	for _, opts := range [][]connect.ClientOption{
+		{connect.WithGRPCWebText()},
+		{connect.WithGRPCWebText(), connect.WithProtoJSON()},
+	} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42, Text: "text"}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetNumber(), 42)
+		assert.Equal(t, response.Msg.GetText(), "text")
+		assert.Equal(t, response.Header().Get(handlerHeader), headerValue)
+		assert.Equal(t, response.Trailer().Get(handlerTrailer), trailerValue)
+
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 5}))
+		assert.Nil(t, err)
+		var got []int64
+		for stream.Receive() {
+			got = append(got, stream.Msg().GetNumber())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Equal(t, got, []int64{1, 2, 3, 4, 5})
+		assert.Equal(t, stream.ResponseTrailer().Get(handlerTrailer), trailerValue)
+		assert.Nil(t, stream.Close())
+
+		_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	}
+
+	t.Run("padded_chunks", func(t *testing.T) {
+		t.Parallel()
+		// Browsers encode each chunk of the request separately, so padding may
+		// appear in the middle of the body. Padding the envelope's prefix
+		// separately from the message exercises this.
+		message, err := proto.Marshal(&pingv1.CountUpRequest{Number: 2})
+		assert.Nil(t, err)
+		prefix := make([]byte, 5)
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+		body := base64.StdEncoding.EncodeToString(prefix) + base64.StdEncoding.EncodeToString(message)
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL()+pingv1connect.PingServiceCountUpProcedure,
+			strings.NewReader(body),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/grpc-web-text+proto")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Type"), "application/grpc-web-text+proto")
+		encoded, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		// Each message and the trailers are padded separately, so they can only
+		// be decoded one chunk at a time.
+		_, err = base64.StdEncoding.DecodeString(string(encoded))
+		assert.NotNil(t, err)
+		var decoded []byte
+		for rest := string(encoded); rest != ""; {
+			end := strings.IndexByte(rest, '=')
+			if end < 0 {
+				end = len(rest)
+			}
+			for end < len(rest) && rest[end] == '=' {
+				end++
+			}
+			chunk, err := base64.StdEncoding.DecodeString(rest[:end])
+			assert.Nil(t, err)
+			decoded = append(decoded, chunk...)
+			rest = rest[end:]
+		}
+		assert.True(t, bytes.Contains(decoded, []byte("grpc-status: 0")))
+	})
+}
+
+func TestMultipleErrorDetails(t *testing.T) {
+	t.Parallel()
+	// Validation failures often carry several details of different types,
+	// like field violations alongside a localized message.
+	details := []proto.Message{
+		durationpb.New(time.Second),
+		wrapperspb.String("bitte noch einmal versuchen"),
+		&pingv1.PingRequest{Number: 42},
+	}
+	newError := func() error {
+		connectErr := connect.NewError(connect.CodeInvalidArgument, errors.New(errorMessage))
+		for _, msg := range details {
+			detail, err := connect.NewErrorDetail(msg)
+			if err != nil {
+				return err
+			}
+			connectErr.AddDetail(detail)
+		}
+		return connectErr
+	}
+	assertDetails := func(t *testing.T, err error) {
+		t.Helper()
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeInvalidArgument)
+		assert.Equal(t, len(connectErr.Details()), len(details))
+		for i, detail := range connectErr.Details() {
+			assert.Equal(t, detail.Type(), string(details[i].ProtoReflect().Descriptor().FullName()))
+			value, err := detail.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, value, details[i])
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			return nil, newError()
+		},
+		countUp: func(_ context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+			if err := stream.Send(&pingv1.CountUpResponse{Number: 1}); err != nil {
+				return err
+			}
+			return newError()
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+		opts := []connect.ClientOption{}
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assertDetails(t, err)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.False(t, stream.Receive())
+		assertDetails(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	}
+}
+
+func TestLocalizedMessageDetails(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			connectErr := connect.NewError(connect.CodeInvalidArgument, errors.New(errorMessage))
+			connectErr.AddLocalizedMessage("en-US", "please try again")
+			connectErr.AddLocalizedMessage("de-CH", "bitte noch einmal versuchen")
+			return nil, connectErr
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+		opts := []connect.ClientOption{}
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, len(connectErr.Details()), 2)
+		assert.Equal(t, connectErr.Details()[0].Type(), "google.rpc.LocalizedMessage")
+		locale, message := connectErr.LocalizedMessage("fr", "de-DE")
+		assert.Equal(t, locale, "de-CH")
+		assert.Equal(t, message, "bitte noch einmal versuchen")
+		locale, message = connectErr.LocalizedMessage()
+		assert.Equal(t, locale, "en-US")
+		assert.Equal(t, message, "please try again")
+	}
+}
+
+func TestFieldViolationDetails(t *testing.T) {
+	t.Parallel()
+	violations := []connect.FieldViolation{
+		{Field: "number", Description: "must be positive"},
+		{Field: "text", Description: "must not be empty"},
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			connectErr := connect.NewInvalidArgumentError(violations...)
+			connectErr.AddLocalizedMessage("en-US", "please check your input")
+			return nil, connectErr
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+		opts := []connect.ClientOption{}
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeInvalidArgument)
+		assert.Equal(t, len(connectErr.Details()), 2)
+		assert.Equal(t, connect.FieldViolations(err), violations)
+		_, message := connectErr.LocalizedMessage()
+		assert.Equal(t, message, "please check your input")
+	}
+}
+
+func TestDebugInfoDetails(t *testing.T) {
+	t.Parallel()
+	implementation := &pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			if request.Msg.GetText() != "attached" {
+				return nil, connect.NewInternalError(errors.New("database unavailable"), true)
+			}
+			// A DebugInfo attached deliberately, rather than by NewInternalError.
+			value := protowire.AppendTag(nil, 2, protowire.BytesType)
+			value = protowire.AppendString(value, "retry with a smaller batch")
+			detail, err := connect.NewErrorDetail(&anypb.Any{
+				TypeUrl: "type.googleapis.com/google.rpc.DebugInfo",
+				Value:   value,
+			})
+			if err != nil {
+				return nil, err
+			}
+			connectErr := connect.NewError(connect.CodeResourceExhausted, errors.New("batch too large"))
+			connectErr.AddDetail(detail)
+			return nil, connectErr
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(implementation, connect.WithDebugErrors()))
+	path, handler := pingv1connect.NewPingServiceHandler(implementation)
+	mux.Handle("/production"+path, http.StripPrefix("/production", handler))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeInternal)
+		info := connectErr.DebugInfo()
+		assert.NotNil(t, info)
+		assert.Equal(t, info.Detail, "database unavailable")
+		assert.True(t, len(info.StackEntries) > 0)
+
+		client = pingv1connect.NewPingServiceClient(server.Client(), server.URL()+"/production", opt)
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeInternal)
+		assert.Equal(t, connectErr.Message(), "database unavailable")
+		assert.Nil(t, connectErr.DebugInfo())
+		assert.Zero(t, connectErr.Details())
+
+		// Handlers only remove the stack traces captured by NewInternalError.
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "attached"}))
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeResourceExhausted)
+		info = connectErr.DebugInfo()
+		assert.NotNil(t, info)
+		assert.Equal(t, info.Detail, "retry with a smaller batch")
+	}
+}
+
+func TestErrorWithRawDetails(t *testing.T) {
+	t.Parallel()
+	detail, err := anypb.New(wrapperspb.String("try again"))
+	assert.Nil(t, err)
+	raw, err := proto.Marshal(&statusv1.Status{
+		Code:    int32(connect.CodeUnavailable),
+		Message: errorMessage,
+		Details: []*anypb.Any{detail},
+	})
+	assert.Nil(t, err)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			return nil, connect.NewErrorWithRawDetails(connect.CodeUnavailable, errorMessage, raw)
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb(), connect.WithProtoJSON()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeUnavailable)
+		assert.Equal(t, connectErr.Message(), errorMessage)
+		assert.Equal(t, len(connectErr.Details()), 1)
+		value, err := connectErr.Details()[0].Value()
+		assert.Nil(t, err)
+		assert.True(t, proto.Equal(value, wrapperspb.String("try again")))
+	}
+}
+
+func TestTypeResolver(t *testing.T) {
+	t.Parallel()
+	// The detail's type is only in a custom registry, as it would be for a
+	// schema loaded at runtime.
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("connect/test/plugin.proto"),
+		Package: proto.String("connect.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("PluginDetail"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("reason"),
+				JsonName: proto.String("reason"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+		}},
+	}, protoregistry.GlobalFiles)
+	assert.Nil(t, err)
+	desc := file.Messages().ByName("PluginDetail")
+	var types protoregistry.Types
+	assert.Nil(t, types.RegisterMessage(dynamicpb.NewMessageType(desc)))
+	pluginDetail := dynamicpb.NewMessage(desc)
+	pluginDetail.Set(desc.Fields().ByName("reason"), protoreflect.ValueOfString("plugin disabled"))
+
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			connectErr := connect.NewError(connect.CodeFailedPrecondition, errors.New(errorMessage))
+			detail, err := connect.NewErrorDetail(pluginDetail)
+			if err != nil {
+				return nil, err
+			}
+			connectErr.AddDetail(detail)
+			return nil, connectErr
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb(), connect.WithProtoJSON()} {
+		ping := func(opts ...connect.ClientOption) *connect.ErrorDetail {
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), append([]connect.ClientOption{opt}, opts...)...)
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeFailedPrecondition)
+			assert.Equal(t, len(connectErr.Details()), 1)
+			return connectErr.Details()[0]
+		}
+		_, err := ping().Value()
+		assert.ErrorIs(t, err, protoregistry.NotFound)
+		detail := ping(connect.WithTypeResolver(&types))
+		assert.Equal(t, detail.Type(), "connect.test.PluginDetail")
+		value, err := detail.Value()
+		assert.Nil(t, err)
+		assert.True(t, proto.Equal(value, pluginDetail))
+	}
+}
+
+func TestStreamErrorTrailers(t *testing.T) {
+	t.Parallel()
+	// The in-memory test server runs a real HTTP/2 server, so errors sent in
+	// HTTP trailers (or, for gRPC-Web and Connect, in the body) after
+	// messages reach the client intact.
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		cumSum: func(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			var sum int64
+			for i := 0; i < 2; i++ {
+				msg, err := stream.Receive()
+				if err != nil {
+					return err
+				}
+				sum += msg.GetNumber()
+				if err := stream.Send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+					return err
+				}
+			}
+			stream.ResponseTrailer().Set(handlerTrailer, trailerValue)
+			return connect.NewError(connect.CodeAborted, errors.New(errorMessage))
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+		var opts []connect.ClientOption
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		stream := client.CumSum(context.Background())
+		var sums []int64
+		for i := int64(1); i <= 3; i++ {
+			if err := stream.Send(&pingv1.CumSumRequest{Number: i}); err != nil {
+				assert.ErrorIs(t, err, io.EOF)
+				break
+			}
+		}
+		assert.Nil(t, stream.CloseRequest())
+		for {
+			msg, err := stream.Receive()
+			if err != nil {
+				assert.Equal(t, connect.CodeOf(err), connect.CodeAborted)
+				var connectErr *connect.Error
+				assert.True(t, errors.As(err, &connectErr))
+				assert.Equal(t, connectErr.Meta().Get(handlerTrailer), trailerValue)
+				break
+			}
+			sums = append(sums, msg.GetSum())
+		}
+		assert.Equal(t, sums, []int64{1, 3})
+		assert.Nil(t, stream.CloseResponse())
+	}
+}
+
 func TestBidiOverHTTP1(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
@@ -2042,12 +2917,127 @@ func TestBidiOverHTTP1(t *testing.T) {
 	}
 	_, err := stream.Receive()
 	assert.NotNil(t, err)
-	assert.Equal(t, connect.CodeOf(err), connect.CodeUnknown)
-	assert.Equal(t, err.Error(), "unknown: HTTP status 505 HTTP Version Not Supported")
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+	assert.Equal(
+		t,
+		err.Error(),
+		fmt.Sprintf(
+			"unimplemented: response from %s%s is HTTP/1.1: bidi streams require at least HTTP/2",
+			server.URL(),
+			pingv1connect.PingServiceCumSumProcedure,
+		),
+	)
 	assert.Nil(t, stream.CloseRequest())
 	assert.Nil(t, stream.CloseResponse())
 }
 
+func TestUnaryAndServerStreamOverHTTP1(t *testing.T) {
+	t.Parallel()
+	// Only bidi streams need HTTP/2: unary and half-duplex streaming RPCs
+	// should work over HTTP/1.1.
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Text: connect.HTTPRequest(ctx).Proto}), nil
+			},
+			countUp: func(ctx context.Context, request *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				stream.ResponseHeader().Set("Request-Proto", connect.HTTPRequest(ctx).Proto)
+				for i := int64(1); i <= request.Msg.GetNumber(); i++ {
+					if err := stream.Send(&pingv1.CountUpResponse{Number: i}); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "connect_get", opts: []connect.ClientOption{connect.WithHTTPGet()}},
+		{name: "grpcweb", opts: []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		client := pingv1connect.NewPingServiceClient(
+			&http.Client{Transport: server.TransportHTTP1()},
+			server.URL(),
+			protocol.opts...,
+		)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err, assert.Sprintf(protocol.name))
+		assert.Equal(t, response.Msg.GetText(), "HTTP/1.1", assert.Sprintf(protocol.name))
+
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err, assert.Sprintf(protocol.name))
+		var received int
+		for stream.Receive() {
+			received++
+		}
+		assert.Nil(t, stream.Err(), assert.Sprintf(protocol.name))
+		assert.Equal(t, received, 3, assert.Sprintf(protocol.name))
+		assert.Equal(t, stream.ResponseHeader().Get("Request-Proto"), "HTTP/1.1", assert.Sprintf(protocol.name))
+		assert.Nil(t, stream.Close())
+	}
+}
+
+func TestRequireHTTP2(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(
+		&http.Client{Transport: server.TransportHTTP1()},
+		server.URL(),
+		connect.WithRequireHTTP2(),
+	)
+	ctx := context.Background()
+
+	// Unary calls are allowed over HTTP/1.1.
+	_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+
+	stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+	if err == nil {
+		assert.False(t, stream.Receive())
+		err = stream.Err()
+		assert.Nil(t, stream.Close())
+	}
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+	assert.Equal(
+		t,
+		err.Error(),
+		fmt.Sprintf(
+			"unavailable: response from %s%s is HTTP/1.1: client requires HTTP/2 for streaming calls, check for proxies that downgrade the protocol",
+			server.URL(),
+			pingv1connect.PingServiceCountUpProcedure,
+		),
+	)
+
+	bidi := client.CumSum(ctx)
+	if err := bidi.Send(&pingv1.CumSumRequest{Number: 2}); err != nil {
+		assert.ErrorIs(t, err, io.EOF)
+	}
+	_, err = bidi.Receive()
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+	assert.Nil(t, bidi.CloseRequest())
+	assert.Nil(t, bidi.CloseResponse())
+
+	// Over HTTP/2, streams work as usual.
+	client = pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithRequireHTTP2())
+	stream, err = client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+	assert.Nil(t, err)
+	var received int
+	for stream.Receive() {
+		received++
+	}
+	assert.Nil(t, stream.Err())
+	assert.Equal(t, received, 3)
+	assert.Nil(t, stream.Close())
+}
+
 func TestHandlerReturnsNilResponse(t *testing.T) {
 	// When user-written handlers return nil responses _and_ nil errors, ensure
 	// that the resulting panic includes at least the name of the procedure.
@@ -2313,6 +3303,31 @@ func TestBlankImportCodeGeneration(t *testing.T) {
 	assert.NotNil(t, desc)
 }
 
+// syncBuffer is a bytes.Buffer that's safe for concurrent use.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(data)
+}
+
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buffer.Reset()
+}
+
+// Bytes returns a copy of the buffer's contents.
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buffer.Bytes()...)
+}
+
 type unflushableWriter struct {
 	w http.ResponseWriter
 }
@@ -2397,11 +3412,12 @@ func failNoHTTP2(tb testing.TB, stream *connect.BidiStreamForClient[pingv1.CumSu
 	}
 	assert.Nil(tb, stream.CloseRequest())
 	_, err := stream.Receive()
-	assert.NotNil(tb, err) // should be 505
+	assert.NotNil(tb, err) // server should respond with a 505 over HTTP/1.1
+	assert.Equal(tb, connect.CodeOf(err), connect.CodeUnimplemented)
 	assert.True(
 		tb,
-		strings.Contains(err.Error(), "HTTP status 505"),
-		assert.Sprintf("expected 505, got %v", err),
+		strings.HasSuffix(err.Error(), "is HTTP/1.1: bidi streams require at least HTTP/2"),
+		assert.Sprintf("expected HTTP/2 error, got %v", err),
 	)
 	assert.Nil(tb, stream.CloseResponse())
 }
@@ -2645,3 +3661,49 @@ func (failCompressor) Close() error {
 }
 
 func (failCompressor) Reset(io.Writer) {}
+
+func TestConnectionObserver(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, mux)
+	ctx := context.Background()
+
+	observeReuse := func(httpClient connect.HTTPClient, opts ...connect.ClientOption) []bool {
+		t.Helper()
+		var reused []bool
+		var mu sync.Mutex
+		client := pingv1connect.NewPingServiceClient(
+			httpClient,
+			server.URL(),
+			append(opts, connect.WithConnectionObserver(func(_ context.Context, spec connect.Spec, info httptrace.GotConnInfo) {
+				assert.Equal(t, spec.Procedure, pingv1connect.PingServicePingProcedure)
+				mu.Lock()
+				reused = append(reused, info.Reused)
+				mu.Unlock()
+			}))...,
+		)
+		for i := 0; i < 3; i++ {
+			_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return reused
+	}
+	t.Run("keep_alive", func(t *testing.T) {
+		t.Parallel()
+		reused := observeReuse(server.Client())
+		assert.Equal(t, len(reused), 3)
+		assert.False(t, reused[0])
+		assert.True(t, reused[1])
+		assert.True(t, reused[2])
+	})
+	t.Run("disable_keep_alives", func(t *testing.T) {
+		t.Parallel()
+		transport := server.TransportHTTP1()
+		transport.DisableKeepAlives = false
+		reused := observeReuse(&http.Client{Transport: transport}, connect.WithDisableKeepAlives())
+		assert.Equal(t, reused, []bool{false, false, false})
+	})
+}
@@ -0,0 +1,29 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.20
+
+package connect
+
+import "context"
+
+// contextCauseError returns the cause of the context's cancellation if it's
+// an *Error, so that code which cancels an RPC with context.WithCancelCause
+// can choose the code reported to the peer. Otherwise, it returns nil.
+func contextCauseError(ctx context.Context) *Error {
+	if connectErr, ok := asError(context.Cause(ctx)); ok {
+		return connectErr
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.20
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestContextCancelCause(t *testing.T) {
+	t.Parallel()
+	abort := connect.NewError(connect.CodeAborted, errors.New("config changed"))
+	// Middleware hands a supervisor a way to cancel each request's context. The
+	// handler sends one message and waits for the supervisor. Then it either
+	// returns the context's error or tries to send another message.
+	cancels := make(chan context.CancelCauseFunc, 1)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		countUp: func(ctx context.Context, request *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+			if err := stream.Send(&pingv1.CountUpResponse{Number: 1}); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			if request.Msg.GetNumber() == 1 {
+				return ctx.Err()
+			}
+			return stream.Send(&pingv1.CountUpResponse{Number: 2})
+		},
+	}))
+	server := memhttptest.NewServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		cancels <- cancel
+		mux.ServeHTTP(w, r.WithContext(ctx))
+	}))
+
+	for _, protocol := range []struct {
+		name string
+		opt  connect.ClientOption
+	}{
+		{"connect", connect.WithProtoJSON()},
+		{"grpc", connect.WithGRPC()},
+		{"grpcweb", connect.WithGRPCWeb()},
+	} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opt)
+		for _, number := range []int64{1, 2} {
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: number}))
+			assert.Nil(t, err)
+			assert.True(t, stream.Receive())
+			cancel := <-cancels
+			cancel(abort)
+			assert.False(t, stream.Receive())
+			assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeAborted, assert.Sprintf("%s, number %d", protocol.name, number))
+			assert.Nil(t, stream.Close())
+		}
+	}
+
+	t.Run("client", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(abort)
+		_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeAborted)
+	})
+}
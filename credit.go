@@ -0,0 +1,281 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// CreditControlledBidi adds application-level backpressure to a bidirectional
+// stream. Each side advertises a window: the number of messages it's willing
+// to accept before the application has received them. Send blocks while the
+// peer's window is full, and Receive grants the peer more credit as the
+// application consumes messages, half a window at a time. Out is the type of
+// message this side sends and In is the type it receives.
+//
+// HTTP/2 flow control limits the bytes buffered in the network, but it can't
+// tell a fast producer that a consumer is still busy with the messages it has
+// already read. Credit lets the consumer pace the producer in messages
+// instead.
+//
+// Credit only flows between two peers that both use CreditControlledBidi over
+// the Connect protocol: the window travels in the Connect-Credit-Window
+// header, and credit grants are empty messages with a reserved envelope flag
+// that applications can't set with SendWithFlags (see
+// [CustomEnvelopeFlags]). If the peer doesn't advertise a window, or if
+// this side uses the gRPC or gRPC-Web protocols or a stream wrapped by an
+// interceptor, the stream isn't flow-controlled: Send never blocks and
+// Receive never grants credit. Because clients learn the handler's window from
+// its response headers, which they can't wait for without risking a deadlock
+// with handlers that don't participate, clients send without limit until the
+// headers arrive; messages sent before then still count against the window.
+// Once the peer stops sending, it can no longer grant credit, so Send stops
+// blocking.
+//
+// Send and Receive may be called concurrently with each other, but not with
+// themselves. Don't use the underlying stream's Send or Receive while a
+// CreditControlledBidi manages it; closing the stream is still the caller's
+// responsibility.
+type CreditControlledBidi[Out, In any] struct {
+	send           func(*Out) error
+	sendCredit     func() error
+	receive        func() (*In, error)
+	receivedCredit func() bool // whether the last message received granted credit
+	window         int         // zero if this side doesn't participate
+	// On clients, the handler's response headers. Once a message has arrived,
+	// they're always available without blocking.
+	responseHeader func() http.Header
+
+	sendMu sync.Mutex // serializes messages and credit grants
+
+	mu         sync.Mutex
+	changed    chan struct{} // closed and replaced when the fields below change
+	peerKnown  bool
+	peerWindow int  // zero unless both sides participate
+	credits    int  // negative if messages were sent before the peer was known
+	reading    bool // some call to Send or Receive is reading from the stream
+	buffered   []*In
+	consumed   int // messages received since credit was last granted
+	receiveErr error
+}
+
+// NewCreditControlledBidi manages a handler's bidirectional stream, accepting
+// up to window unreceived messages from the client. To let the client learn
+// the window promptly, it sends the response headers immediately, so set any
+// other response headers first.
+//
+// NewCreditControlledBidi panics if the window isn't positive.
+func NewCreditControlledBidi[Req, Res any](stream *BidiStream[Req, Res], window int) *CreditControlledBidi[Res, Req] {
+	bidi := newCreditControlledBidi(
+		stream.Send,
+		func() error { return sendWithFlags(stream.Conn(), new(Res), connectFlagEnvelopeCredit) },
+		stream.Receive,
+		func() bool { return receivedFlagsOf(stream.Conn())&connectFlagEnvelopeCredit != 0 },
+		window,
+	)
+	if !supportsEnvelopeFlags(stream.Conn()) {
+		bidi.window = 0
+	}
+	if bidi.window > 0 {
+		stream.ResponseHeader().Set(connectHeaderCreditWindow, strconv.Itoa(window))
+		// Errors sending headers also fail the next Send, so they're reported
+		// there.
+		_ = stream.Send(nil)
+	}
+	bidi.learnPeer(stream.RequestHeader())
+	return bidi
+}
+
+// NewCreditControlledBidiForClient manages a client's bidirectional stream,
+// accepting up to window unreceived messages from the handler. It sends the
+// request headers immediately, so set any other request headers first.
+//
+// NewCreditControlledBidiForClient panics if the window isn't positive.
+func NewCreditControlledBidiForClient[Req, Res any](stream *BidiStreamForClient[Req, Res], window int) *CreditControlledBidi[Req, Res] {
+	bidi := newCreditControlledBidi(
+		stream.Send,
+		func() error { return sendWithFlags(stream.conn, new(Req), connectFlagEnvelopeCredit) },
+		stream.Receive,
+		func() bool { return stream.err == nil && receivedFlagsOf(stream.conn)&connectFlagEnvelopeCredit != 0 },
+		window,
+	)
+	if stream.err != nil || !supportsEnvelopeFlags(stream.conn) {
+		bidi.window = 0
+		// Without a window of our own, credit never flows in either direction.
+		bidi.learnPeer(http.Header{})
+		return bidi
+	}
+	stream.RequestHeader().Set(connectHeaderCreditWindow, strconv.Itoa(window))
+	// Errors sending headers also fail the next Send, so they're reported
+	// there.
+	_ = stream.Send(nil)
+	bidi.responseHeader = stream.ResponseHeader
+	// Once the request is sent, the response headers always arrive or the
+	// request fails, so this goroutine doesn't leak.
+	go func() {
+		bidi.learnPeer(stream.ResponseHeader())
+	}()
+	return bidi
+}
+
+func newCreditControlledBidi[Out, In any](
+	send func(*Out) error,
+	sendCredit func() error,
+	receive func() (*In, error),
+	receivedCredit func() bool,
+	window int,
+) *CreditControlledBidi[Out, In] {
+	if window <= 0 {
+		panic(fmt.Sprintf("connect: credit window must be positive, got %d", window))
+	}
+	return &CreditControlledBidi[Out, In]{
+		send:           send,
+		sendCredit:     sendCredit,
+		receive:        receive,
+		receivedCredit: receivedCredit,
+		window:         window,
+		changed:        make(chan struct{}),
+	}
+}
+
+// Send a message to the peer, first waiting until the peer has granted
+// credit for it.
+func (c *CreditControlledBidi[Out, In]) Send(msg *Out) error {
+	c.acquire()
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.send(msg)
+}
+
+// Receive a message from the peer. When the peer is done sending messages,
+// Receive returns an error that wraps [io.EOF].
+func (c *CreditControlledBidi[Out, In]) Receive() (*In, error) {
+	for {
+		c.mu.Lock()
+		if len(c.buffered) > 0 {
+			msg := c.buffered[0]
+			c.buffered[0] = nil
+			c.buffered = c.buffered[1:]
+			batch := 0
+			if c.peerWindow > 0 {
+				c.consumed++
+				if c.consumed >= creditBatch(c.window) {
+					batch = creditBatch(c.window)
+					c.consumed -= batch
+				}
+			}
+			c.mu.Unlock()
+			if batch > 0 {
+				c.grant()
+			}
+			return msg, nil
+		}
+		if c.receiveErr != nil {
+			err := c.receiveErr
+			c.mu.Unlock()
+			return nil, err
+		}
+		c.waitOrRead()
+	}
+}
+
+// acquire waits until the peer has granted credit for another message, then
+// uses it. It doesn't wait if credit isn't flowing.
+func (c *CreditControlledBidi[Out, In]) acquire() {
+	for {
+		c.mu.Lock()
+		if c.peerWindow == 0 || c.credits > 0 || c.receiveErr != nil {
+			c.credits--
+			c.mu.Unlock()
+			return
+		}
+		c.waitOrRead()
+	}
+}
+
+// waitOrRead must be called with c.mu held, and it releases it. If another
+// call is already reading from the stream, it waits for the state to change;
+// otherwise, it reads the next message itself. Either way, the caller should
+// check the state again.
+func (c *CreditControlledBidi[Out, In]) waitOrRead() {
+	if c.reading {
+		changed := c.changed
+		c.mu.Unlock()
+		<-changed
+		return
+	}
+	c.reading = true
+	c.mu.Unlock()
+	msg, err := c.receive()
+	credit := c.receivedCredit()
+	if c.responseHeader != nil {
+		// The peer's credit may arrive before the goroutine started by
+		// NewCreditControlledBidiForClient learns its window.
+		c.learnPeer(c.responseHeader())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reading = false
+	switch {
+	case err != nil:
+		c.receiveErr = err
+	case c.window > 0 && credit:
+		c.credits += creditBatch(c.peerWindow)
+	default:
+		c.buffered = append(c.buffered, msg)
+	}
+	c.notifyLocked()
+}
+
+// grant sends the peer another batch of credit. Errors are ignored: if the
+// stream is broken, the caller's next Send reports it, and the peer stops
+// waiting for credit once our side of the stream ends.
+func (c *CreditControlledBidi[Out, In]) grant() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	_ = c.sendCredit()
+}
+
+// learnPeer reads the peer's window from its headers. Only the first call has
+// any effect.
+func (c *CreditControlledBidi[Out, In]) learnPeer(header http.Header) {
+	window, err := strconv.Atoi(getHeaderCanonical(header, connectHeaderCreditWindow))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peerKnown {
+		return
+	}
+	c.peerKnown = true
+	if c.window > 0 && err == nil && window > 0 {
+		c.peerWindow = window
+		c.credits += window
+	}
+	c.notifyLocked()
+}
+
+func (c *CreditControlledBidi[Out, In]) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// creditBatch is the amount of credit a consumer with the window grants at
+// once. Both peers compute it from the consumer's window, so credit grants
+// don't need a payload.
+func creditBatch(window int) int {
+	return (window + 1) / 2
+}
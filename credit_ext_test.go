@@ -0,0 +1,151 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestCreditControlledBidi(t *testing.T) {
+	t.Parallel()
+	const (
+		total  = 20
+		window = 4
+	)
+	// The handler streams as many sums as the client asks for, as fast as the
+	// client's credit allows.
+	newServer := func(t *testing.T, sent *atomic.Int64) *memhttp.Server {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+			cumSum: func(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+				bidi := connect.NewCreditControlledBidi(stream, window)
+				request, err := bidi.Receive()
+				if err != nil {
+					return err
+				}
+				for i := int64(0); i < request.GetNumber(); i++ {
+					if err := bidi.Send(&pingv1.CumSumResponse{Sum: i}); err != nil {
+						return err
+					}
+					sent.Add(1)
+				}
+				return nil
+			},
+		}))
+		return memhttptest.NewServer(t, mux)
+	}
+	// Without a CreditControlledBidi, handlers don't limit clients.
+	pingMux := http.NewServeMux()
+	pingMux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	pingServer := memhttptest.NewServer(t, pingMux)
+
+	receiveAll := func(t *testing.T, receive func() (*pingv1.CumSumResponse, error)) {
+		t.Helper()
+		for i := int64(0); i < total; i++ {
+			msg, err := receive()
+			assert.Nil(t, err)
+			assert.Equal(t, msg.GetSum(), i)
+		}
+		_, err := receive()
+		assert.True(t, errors.Is(err, io.EOF))
+	}
+
+	t.Run("backpressure", func(t *testing.T) {
+		t.Parallel()
+		var sent atomic.Int64
+		server := newServer(t, &sent)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		stream := client.CumSum(context.Background())
+		bidi := connect.NewCreditControlledBidiForClient(stream, window)
+		// Applications can't forge credit grants.
+		err := stream.SendWithFlags(&pingv1.CumSumRequest{}, 0b00100000)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInternal)
+		assert.Nil(t, bidi.Send(&pingv1.CumSumRequest{Number: total}))
+		// Until the client receives anything, the handler can only fill the
+		// client's window.
+		deadline := time.Now().Add(5 * time.Second)
+		for sent.Load() < window && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, sent.Load(), int64(window))
+		receiveAll(t, bidi.Receive)
+		assert.Equal(t, sent.Load(), int64(total))
+		// Closing the request would stop the client from granting credit, so
+		// it stays open until the handler is done.
+		assert.Nil(t, stream.CloseRequest())
+		assert.Nil(t, stream.CloseResponse())
+	})
+	t.Run("client_without_credit", func(t *testing.T) {
+		t.Parallel()
+		var sent atomic.Int64
+		server := newServer(t, &sent)
+		for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithProtoJSON()} {
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt)
+			stream := client.CumSum(context.Background())
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: total}))
+			assert.Nil(t, stream.CloseRequest())
+			receiveAll(t, stream.Receive)
+			assert.Nil(t, stream.CloseResponse())
+		}
+		assert.Equal(t, sent.Load(), int64(2*total))
+	})
+	t.Run("handler_without_credit", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(pingServer.Client(), pingServer.URL())
+		stream := client.CumSum(context.Background())
+		bidi := connect.NewCreditControlledBidiForClient(stream, 1)
+		// The handler never grants credit, so sends must not block.
+		var sum int64
+		for i := int64(1); i <= 10; i++ {
+			assert.Nil(t, bidi.Send(&pingv1.CumSumRequest{Number: i}))
+			sum += i
+		}
+		assert.Nil(t, stream.CloseRequest())
+		var last int64
+		for {
+			msg, err := bidi.Receive()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.Nil(t, err)
+			last = msg.GetSum()
+		}
+		assert.Equal(t, last, sum)
+		assert.Nil(t, stream.CloseResponse())
+	})
+	t.Run("invalid_window", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(pingServer.Client(), pingServer.URL())
+		stream := client.CumSum(context.Background())
+		assert.Panics(t, func() { connect.NewCreditControlledBidiForClient(stream, 0) })
+		assert.Nil(t, stream.CloseRequest())
+		assert.Nil(t, stream.CloseResponse())
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"time"
+)
+
+// NewDeadlinePropagationInterceptor returns a handler interceptor that
+// reserves part of each RPC's deadline for the handler's own work. Handlers
+// already derive a context deadline from the timeout sent by the client; this
+// interceptor moves that deadline earlier by the margin. Since Connect clients
+// send the time remaining until their context's deadline, calls made
+// downstream with the handler's context inherit the shrinking budget, and the
+// handler still has margin left to respond after they time out.
+//
+// The interceptor only ever tightens deadlines. RPCs without a deadline are
+// left alone, and if the margin exceeds the time remaining, the context is
+// already expired when the implementation runs. The interceptor has no effect
+// on clients.
+func NewDeadlinePropagationInterceptor(margin time.Duration) Interceptor {
+	return &deadlinePropagationInterceptor{margin: margin}
+}
+
+type deadlinePropagationInterceptor struct {
+	margin time.Duration
+}
+
+func (i *deadlinePropagationInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if request.Spec().IsClient {
+			return next(ctx, request)
+		}
+		ctx, cancel := i.withMargin(ctx)
+		defer cancel()
+		return next(ctx, request)
+	}
+}
+
+func (i *deadlinePropagationInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return next
+}
+
+func (i *deadlinePropagationInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return func(ctx context.Context, conn StreamingHandlerConn) error {
+		ctx, cancel := i.withMargin(ctx)
+		defer cancel()
+		return next(ctx, conn)
+	}
+}
+
+func (i *deadlinePropagationInterceptor) withMargin(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || i.margin <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, deadline.Add(-i.margin))
+}
@@ -0,0 +1,86 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestDeadlinePropagationInterceptor(t *testing.T) {
+	t.Parallel()
+	// remaining reports the handler's remaining budget in milliseconds, or -1
+	// if there's no deadline.
+	remaining := func(ctx context.Context) int64 {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return -1
+		}
+		return time.Until(deadline).Milliseconds()
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return connect.NewResponse(&pingv1.PingResponse{Number: remaining(ctx)}), nil
+			},
+			countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				return stream.Send(&pingv1.CountUpResponse{Number: remaining(ctx)})
+			},
+		},
+		connect.WithInterceptors(connect.NewDeadlinePropagationInterceptor(time.Second)),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+	t.Run("tightens", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		response, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, response.Msg.GetNumber() <= 9000)
+		assert.True(t, response.Msg.GetNumber() > 8000)
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.True(t, stream.Msg().GetNumber() <= 9000)
+		assert.True(t, stream.Msg().GetNumber() > 8000)
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("no_deadline", func(t *testing.T) {
+		t.Parallel()
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetNumber(), -1)
+	})
+	t.Run("exhausted", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+	})
+}
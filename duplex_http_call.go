@@ -23,6 +23,7 @@ import (
 	"net/url"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // duplexHTTPCall is a full-duplex stream between the client and server. The
@@ -32,10 +33,14 @@ import (
 // Be warned: we need to use some lesser-known APIs to do this with net/http.
 type duplexHTTPCall struct {
 	ctx              context.Context
+	start            time.Time
 	httpClient       HTTPClient
 	streamType       StreamType
 	onRequestSend    func(*http.Request)
 	validateResponse func(*http.Response) *Error
+	maxAttempts      int // zero means the transport may replay unary requests freely
+	skipDrain        bool
+	requireHTTP2     bool
 
 	// io.Pipe is used to implement the request body for client streaming calls.
 	// If the request is unary, requestBodyWriter is nil.
@@ -84,6 +89,7 @@ func newDuplexHTTPCall(
 	}).WithContext(ctx)
 	return &duplexHTTPCall{
 		ctx:           ctx,
+		start:         time.Now(),
 		httpClient:    httpClient,
 		streamType:    spec.StreamType,
 		request:       request,
@@ -108,7 +114,7 @@ func (d *duplexHTTPCall) Send(payload messagePayload) (int64, error) {
 		go d.makeRequest() // concurrent request
 	}
 	if err := d.ctx.Err(); err != nil {
-		return 0, wrapIfContextError(err)
+		return 0, d.wrapIfContextError(err)
 	}
 	if isFirst && payload.Len() == 0 {
 		// On first write a nil Send is used to send request headers. Avoid
@@ -134,12 +140,20 @@ func (d *duplexHTTPCall) sendUnary(payload messagePayload) (int64, error) {
 		return 0, fmt.Errorf("request already sent")
 	}
 	payloadLength := int64(payload.Len())
-	if payloadLength > 0 {
+	// Transports replay empty bodies without consulting GetBody, so we need a
+	// real body to enforce maxAttempts.
+	if payloadLength > 0 || d.maxAttempts > 0 {
 		// Build the request body from the payload.
 		payloadBody := newPayloadCloser(payload)
 		d.request.Body = payloadBody
 		d.request.ContentLength = payloadLength
+		attempts := 1
 		d.request.GetBody = func() (io.ReadCloser, error) {
+			// Transports (and redirects) call GetBody to replay the request.
+			if d.maxAttempts > 0 && attempts >= d.maxAttempts {
+				return nil, fmt.Errorf("payload can't be replayed: already made %d of %d allowed attempts", attempts, d.maxAttempts)
+			}
+			attempts++
 			if !payloadBody.Rewind() {
 				return nil, fmt.Errorf("payload cannot be retried")
 			}
@@ -155,7 +169,7 @@ func (d *duplexHTTPCall) sendUnary(payload messagePayload) (int64, error) {
 		// Check on response errors for context errors. Other errors are
 		// handled on read.
 		if err := d.ctx.Err(); err != nil {
-			return 0, wrapIfContextError(err)
+			return 0, d.wrapIfContextError(err)
 		}
 	}
 	return payloadLength, nil
@@ -218,9 +232,12 @@ func (d *duplexHTTPCall) Read(data []byte) (int, error) {
 	}
 	// Before we read, check if the context has been canceled.
 	if err := d.ctx.Err(); err != nil {
-		return 0, wrapIfContextError(err)
+		return 0, d.wrapIfContextError(err)
 	}
 	n, err := d.response.Body.Read(data)
+	if err != nil && d.ctx.Err() != nil {
+		err = d.wrapIfContextError(err)
+	}
 	return n, wrapIfRSTError(err)
 }
 
@@ -229,21 +246,26 @@ func (d *duplexHTTPCall) CloseRead() error {
 	if d.response == nil {
 		return nil
 	}
-	_, err := discard(d.response.Body)
+	var err error
+	if !d.skipDrain {
+		_, err = discard(d.response.Body)
+	}
 	closeErr := d.response.Body.Close()
 	if err == nil ||
 		errors.Is(err, context.Canceled) ||
 		errors.Is(err, context.DeadlineExceeded) {
 		err = closeErr
 	}
-	err = wrapIfContextError(err)
+	err = d.wrapIfContextError(err)
 	return wrapIfRSTError(err)
 }
 
-// ResponseStatusCode is the response's HTTP status code.
+// ResponseStatusCode is the response's HTTP status code. It's available even
+// if the response failed validation.
 func (d *duplexHTTPCall) ResponseStatusCode() (int, error) {
-	if err := d.BlockUntilResponseReady(); err != nil {
-		return 0, err
+	<-d.responseReady
+	if d.response == nil {
+		return 0, d.responseErr
 	}
 	return d.response.StatusCode, nil
 }
@@ -272,6 +294,56 @@ func (d *duplexHTTPCall) SetValidateResponse(validate func(*http.Response) *Erro
 	d.validateResponse = validate
 }
 
+// SetMaxAttempts limits the number of times the transport may send a unary
+// request, including the first attempt. Streaming requests are never replayed,
+// since their bodies can't be rewound.
+func (d *duplexHTTPCall) SetMaxAttempts(attempts int) {
+	d.maxAttempts = attempts
+}
+
+// DisableResponseDraining makes CloseRead close the response body without
+// first reading it to EOF.
+func (d *duplexHTTPCall) DisableResponseDraining() {
+	d.skipDrain = true
+}
+
+// RequireHTTP2 makes streaming calls fail if the response arrives over
+// HTTP/1.x.
+func (d *duplexHTTPCall) RequireHTTP2() {
+	d.requireHTTP2 = true
+}
+
+// DisableKeepAlives asks the transport to close the connection once the call
+// completes instead of returning it to the pool.
+func (d *duplexHTTPCall) DisableKeepAlives() {
+	d.request.Close = true
+}
+
+// wrapIfContextError is like the package-level wrapIfContextError, but it
+// also adds the call's timeout and elapsed time to the errors for
+// context.Canceled and context.DeadlineExceeded. These make it much easier to
+// tell whether a call ran out of time or was given too little of it.
+func (d *duplexHTTPCall) wrapIfContextError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := asError(err); ok {
+		return err
+	}
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return wrapIfContextError(err)
+	}
+	if causeErr := contextCauseError(d.ctx); causeErr != nil {
+		return causeErr
+	}
+	timing := "elapsed " + time.Since(d.start).Round(time.Millisecond).String()
+	if deadline, ok := d.ctx.Deadline(); ok {
+		timeout := deadline.Sub(d.start).Round(time.Millisecond)
+		timing = "timeout " + timeout.String() + ", " + timing
+	}
+	return wrapIfContextError(fmt.Errorf("%w (%s)", err, timing))
+}
+
 // BlockUntilResponseReady returns when the response is ready or reports an
 // error from initializing the request.
 func (d *duplexHTTPCall) BlockUntilResponseReady() error {
@@ -298,7 +370,7 @@ func (d *duplexHTTPCall) makeRequest() {
 	// pipe. Write's check for io.ErrClosedPipe and will convert this to io.EOF.
 	response, err := d.httpClient.Do(d.request) //nolint:bodyclose
 	if err != nil {
-		err = wrapIfContextError(err)
+		err = d.wrapIfContextError(err)
 		err = wrapIfLikelyH2CNotConfiguredError(d.request, err)
 		err = wrapIfLikelyWithGRPCNotUsedError(err)
 		err = wrapIfRSTError(err)
@@ -312,14 +384,22 @@ func (d *duplexHTTPCall) makeRequest() {
 	// We've got a response. We can now read from the response body.
 	// Closing the response body is delegated to the caller even on error.
 	d.response = response
-	if err := d.validateResponse(response); err != nil {
-		d.responseErr = err
+	if d.requireHTTP2 && d.streamType != StreamTypeUnary && response.ProtoMajor < 2 {
+		d.responseErr = errorf(
+			CodeUnavailable,
+			"response from %v is HTTP/%d.%d: client requires HTTP/2 for streaming calls, check for proxies that downgrade the protocol",
+			d.request.URL,
+			response.ProtoMajor,
+			response.ProtoMinor,
+		)
 		_ = d.CloseWrite()
 		return
 	}
 	if (d.streamType&StreamTypeBidi) == StreamTypeBidi && response.ProtoMajor < 2 {
 		// If we somehow dialed an HTTP/1.x server, fail with an explicit message
-		// rather than returning a more cryptic error later on.
+		// rather than returning a more cryptic error later on. We check this
+		// before validating the response, since Connect handlers reject bidi
+		// streams over HTTP/1.x with a bare HTTP 505.
 		d.responseErr = errorf(
 			CodeUnimplemented,
 			"response from %v is HTTP/%d.%d: bidi streams require at least HTTP/2",
@@ -328,6 +408,11 @@ func (d *duplexHTTPCall) makeRequest() {
 			response.ProtoMinor,
 		)
 		_ = d.CloseWrite()
+		return
+	}
+	if err := d.validateResponse(response); err != nil {
+		d.responseErr = err
+		_ = d.CloseWrite()
 	}
 }
 
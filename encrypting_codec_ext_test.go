@@ -0,0 +1,122 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"net/http"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEncryptingCodec(t *testing.T) {
+	t.Parallel()
+	newAEAD := func(t *testing.T, key byte) cipher.AEAD {
+		t.Helper()
+		block, err := aes.NewCipher(bytes.Repeat([]byte{key}, 32))
+		assert.Nil(t, err)
+		aead, err := cipher.NewGCM(block)
+		assert.Nil(t, err)
+		return aead
+	}
+	t.Run("round_trip", func(t *testing.T) {
+		t.Parallel()
+		codec := connect.NewEncryptingCodec(protoCodec{}, newAEAD(t, 1))
+		assert.Equal(t, codec.Name(), "proto-encrypted")
+		message := &pingv1.PingRequest{Number: 42, Text: "secret"}
+		first, err := codec.Marshal(message)
+		assert.Nil(t, err)
+		second, err := codec.Marshal(message)
+		assert.Nil(t, err)
+		assert.False(t, bytes.Equal(first, second)) // fresh nonces
+		assert.False(t, bytes.Contains(first, []byte("secret")))
+		var decoded pingv1.PingRequest
+		assert.Nil(t, codec.Unmarshal(first, &decoded))
+		assert.Equal(t, &decoded, message)
+	})
+	t.Run("tampered", func(t *testing.T) {
+		t.Parallel()
+		codec := connect.NewEncryptingCodec(protoCodec{}, newAEAD(t, 1))
+		data, err := codec.Marshal(&pingv1.PingRequest{Number: 42})
+		assert.Nil(t, err)
+		data[len(data)-1] ^= 1
+		assert.NotNil(t, codec.Unmarshal(data, &pingv1.PingRequest{}))
+		assert.NotNil(t, codec.Unmarshal(data[:4], &pingv1.PingRequest{}))
+	})
+	t.Run("wrong_type", func(t *testing.T) {
+		t.Parallel()
+		codec := connect.NewEncryptingCodec(protoCodec{}, newAEAD(t, 1))
+		data, err := codec.Marshal(&pingv1.PingRequest{Number: 42})
+		assert.Nil(t, err)
+		// PingResponse has a compatible wire format, but the authenticated
+		// data doesn't match.
+		assert.NotNil(t, codec.Unmarshal(data, &pingv1.PingResponse{}))
+	})
+	t.Run("wrong_key", func(t *testing.T) {
+		t.Parallel()
+		data, err := connect.NewEncryptingCodec(protoCodec{}, newAEAD(t, 1)).Marshal(&pingv1.PingRequest{Number: 42})
+		assert.Nil(t, err)
+		codec := connect.NewEncryptingCodec(protoCodec{}, newAEAD(t, 2))
+		assert.NotNil(t, codec.Unmarshal(data, &pingv1.PingRequest{}))
+	})
+	t.Run("end_to_end", func(t *testing.T) {
+		t.Parallel()
+		codec := connect.NewEncryptingCodec(protoCodec{}, newAEAD(t, 1))
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithCodec(codec)))
+		server := memhttptest.NewServer(t, mux)
+		for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+			opts := []connect.ClientOption{connect.WithCodec(codec)}
+			if opt != nil {
+				opts = append(opts, opt)
+			}
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.GetNumber(), 42)
+		}
+		// Clients without the key can't talk to the handler.
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithCodec(connect.NewEncryptingCodec(protoCodec{}, newAEAD(t, 2))),
+		)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+	})
+}
+
+// protoCodec is a minimal Protobuf codec, since the default codec isn't
+// exported.
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "proto" }
+
+func (protoCodec) Marshal(message any) ([]byte, error) {
+	return proto.Marshal(message.(proto.Message)) //nolint:forcetypeassert
+}
+
+func (protoCodec) Unmarshal(data []byte, message any) error {
+	return proto.Unmarshal(data, message.(proto.Message)) //nolint:forcetypeassert
+}
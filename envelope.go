@@ -19,6 +19,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"time"
 )
 
 // flagEnvelopeCompressed indicates that the data is compressed. It has the
@@ -126,6 +127,13 @@ type envelopeWriter struct {
 }
 
 func (w *envelopeWriter) Marshal(message any) *Error {
+	return w.MarshalWithFlags(message, 0)
+}
+
+// MarshalWithFlags is like Marshal, but it also sets the flags in the
+// message's envelope. The compression flag is managed by the writer, so
+// callers shouldn't set it.
+func (w *envelopeWriter) MarshalWithFlags(message any, flags uint8) *Error {
 	if message == nil {
 		// Send no-op message to create the request and send headers.
 		payload := nopPayload{}
@@ -138,9 +146,9 @@ func (w *envelopeWriter) Marshal(message any) *Error {
 		return nil
 	}
 	if appender, ok := w.codec.(marshalAppender); ok {
-		return w.marshalAppend(message, appender)
+		return w.marshalAppend(message, appender, flags)
 	}
-	return w.marshal(message)
+	return w.marshal(message, flags)
 }
 
 // Write writes the enveloped message, compressing as necessary. It doesn't
@@ -168,7 +176,7 @@ func (w *envelopeWriter) Write(env *envelope) *Error {
 	})
 }
 
-func (w *envelopeWriter) marshalAppend(message any, codec marshalAppender) *Error {
+func (w *envelopeWriter) marshalAppend(message any, codec marshalAppender, flags uint8) *Error {
 	// Codec supports MarshalAppend; try to re-use a []byte from the pool.
 	buffer := w.bufferPool.Get()
 	defer w.bufferPool.Put(buffer)
@@ -189,11 +197,11 @@ func (w *envelopeWriter) marshalAppend(message any, codec marshalAppender) *Erro
 		// copies but avoids allocating.
 		buffer.Write(raw)
 	}
-	envelope := &envelope{Data: buffer}
+	envelope := &envelope{Data: buffer, Flags: flags}
 	return w.Write(envelope)
 }
 
-func (w *envelopeWriter) marshal(message any) *Error {
+func (w *envelopeWriter) marshal(message any, flags uint8) *Error {
 	// Codec doesn't support MarshalAppend; let Marshal allocate a []byte.
 	raw, err := w.codec.Marshal(message)
 	if err != nil {
@@ -202,7 +210,7 @@ func (w *envelopeWriter) marshal(message any) *Error {
 	buffer := bytes.NewBuffer(raw)
 	// Put our new []byte into the pool for later reuse.
 	defer w.bufferPool.Put(buffer)
-	envelope := &envelope{Data: buffer}
+	envelope := &envelope{Data: buffer, Flags: flags}
 	return w.Write(envelope)
 }
 
@@ -222,8 +230,24 @@ type envelopeReader struct {
 	codec           Codec
 	last            envelope
 	compressionPool *compressionPool
+	lenientGzipPool *compressionPool // see WithLenientDecompression
 	bufferPool      *bufferPool
 	readMaxBytes    int
+	maxRatio        float64 // see WithMaxDecompressionRatio
+	maxFrames       int     // zero means unlimited
+	maxEmptyFrames  int     // consecutive; zero means unlimited
+	heartbeatFlags  uint8   // if non-zero, envelopes with exactly these flags are skipped
+	// If non-zero, envelopes with exactly these flags carry the send time of
+	// the next message. See WithMaxMessageAge.
+	timestampFlags uint8
+	maxMessageAge  time.Duration // if positive, stamped messages older than this are skipped
+	sentAt         time.Time     // from the last timestamp envelope
+	// Flags that messages may carry in addition to the compression flag. See
+	// CustomEnvelopeFlags.
+	customFlags uint8
+	lastFlags   uint8 // custom flags of the last message read
+	frames      int
+	emptyFrames int
 }
 
 func (r *envelopeReader) Unmarshal(message any) *Error {
@@ -232,12 +256,50 @@ func (r *envelopeReader) Unmarshal(message any) *Error {
 
 	env := &envelope{Data: buffer}
 	err := r.Read(env)
+	for err == nil {
+		if r.heartbeatFlags != 0 && env.Flags == r.heartbeatFlags {
+			// Heartbeats only keep the stream alive, so they're never surfaced.
+			// They still count as empty frames, so a peer can't flood the stream
+			// with them.
+			if limitErr := r.countEmptyFrame(); limitErr != nil {
+				return limitErr
+			}
+			buffer.Reset()
+			err = r.Read(env)
+			continue
+		}
+		if r.timestampFlags != 0 && env.Flags == r.timestampFlags {
+			if env.Data.Len() != 8 {
+				return errorf(CodeInternal, "protocol error: invalid message timestamp of %d bytes", env.Data.Len())
+			}
+			r.sentAt = time.Unix(0, int64(binary.BigEndian.Uint64(env.Data.Bytes())))
+			buffer.Reset()
+			err = r.Read(env)
+			continue
+		}
+		if !r.isMessage(env.Flags) {
+			break
+		}
+		if limitErr := r.countFrame(env); limitErr != nil {
+			return limitErr
+		}
+		sentAt := r.sentAt
+		r.sentAt = time.Time{}
+		if r.maxMessageAge <= 0 || sentAt.IsZero() || time.Since(sentAt) <= r.maxMessageAge {
+			break
+		}
+		// The message is stale, so drop it without decoding it.
+		buffer.Reset()
+		err = r.Read(env)
+	}
+	if err == nil && r.isMessage(env.Flags) {
+		r.lastFlags = env.Flags & r.customFlags
+	}
 	switch {
-	case err == nil &&
-		(env.Flags == 0 || env.Flags == flagEnvelopeCompressed) &&
-		env.Data.Len() == 0:
-		// This is a standard message (because none of the top 7 bits are set) and
-		// there's no data, so the zero value of the message is correct.
+	case err == nil && r.isMessage(env.Flags) && env.Data.Len() == 0:
+		// This is a standard message (because none of the protocol-specific
+		// flags are set) and there's no data, so the zero value of the message
+		// is correct.
 		return nil
 	case err != nil && errors.Is(err, io.EOF):
 		// The stream has ended. Propagate the EOF to the caller.
@@ -257,13 +319,20 @@ func (r *envelopeReader) Unmarshal(message any) *Error {
 		}
 		decompressed := r.bufferPool.Get()
 		defer r.bufferPool.Put(decompressed)
-		if err := r.compressionPool.Decompress(decompressed, data, int64(r.readMaxBytes)); err != nil {
+		if err := r.compressionPool.Decompress(decompressed, data, int64(r.readMaxBytes), r.maxRatio); err != nil {
+			return err
+		}
+		data = decompressed
+	} else if r.lenientGzipPool != nil && !env.IsSet(flagEnvelopeCompressed) && isGzipped(data.Bytes()) {
+		decompressed := r.bufferPool.Get()
+		defer r.bufferPool.Put(decompressed)
+		if err := r.lenientGzipPool.Decompress(decompressed, data, int64(r.readMaxBytes), r.maxRatio); err != nil {
 			return err
 		}
 		data = decompressed
 	}
 
-	if env.Flags != 0 && env.Flags != flagEnvelopeCompressed {
+	if !r.isMessage(env.Flags) {
 		// Drain the rest of the stream to ensure there is no extra data.
 		if numBytes, err := discard(r.reader); err != nil {
 			err = wrapIfContextError(err)
@@ -293,6 +362,38 @@ func (r *envelopeReader) Unmarshal(message any) *Error {
 	return nil
 }
 
+// isMessage reports whether an envelope with the flags holds a message, rather
+// than protocol-specific data like the end of a Connect stream.
+func (r *envelopeReader) isMessage(flags uint8) bool {
+	return flags&^(flagEnvelopeCompressed|r.customFlags) == 0
+}
+
+// countFrame enforces the limits on the number of frames in a stream. Only
+// message frames count: protocol-specific frames, like the end of a
+// Connect stream, don't. Heartbeats only count towards the limit on empty
+// frames.
+func (r *envelopeReader) countFrame(env *envelope) *Error {
+	r.frames++
+	if r.maxFrames > 0 && r.frames > r.maxFrames {
+		return errorf(CodeResourceExhausted, "stream exceeded configured max of %d messages", r.maxFrames)
+	}
+	if env.Data.Len() > 0 {
+		r.emptyFrames = 0
+		return nil
+	}
+	return r.countEmptyFrame()
+}
+
+// countEmptyFrame enforces the limit on consecutive empty frames, which
+// include heartbeats.
+func (r *envelopeReader) countEmptyFrame() *Error {
+	r.emptyFrames++
+	if r.maxEmptyFrames > 0 && r.emptyFrames > r.maxEmptyFrames {
+		return errorf(CodeResourceExhausted, "stream exceeded configured max of %d consecutive empty messages", r.maxEmptyFrames)
+	}
+	return nil
+}
+
 func (r *envelopeReader) Read(env *envelope) *Error {
 	prefixes := [5]byte{}
 	// io.ReadFull reads the number of bytes requested, or returns an error.
@@ -357,6 +458,29 @@ func (r *envelopeReader) Read(env *envelope) *Error {
 	return nil
 }
 
+// unmarshalEnvelope decodes a single enveloped message from reader, exactly
+// as handlers and clients do when reading from the network. It's a direct
+// seam into the decode path for tests, especially fuzz tests feeding
+// malformed frames, that don't want to construct a full HTTP request. A nil
+// compressionPool means the message mustn't be compressed, and a
+// non-positive readMaxBytes means there's no size limit.
+func unmarshalEnvelope(
+	reader io.Reader,
+	codec Codec,
+	compressionPool *compressionPool,
+	readMaxBytes int,
+	message any,
+) *Error {
+	envReader := envelopeReader{
+		reader:          reader,
+		codec:           codec,
+		compressionPool: compressionPool,
+		bufferPool:      newBufferPool(),
+		readMaxBytes:    readMaxBytes,
+	}
+	return envReader.Unmarshal(message)
+}
+
 func makeEnvelopePrefix(flags uint8, size int) [5]byte {
 	prefix := [5]byte{}
 	prefix[0] = flags
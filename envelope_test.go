@@ -16,10 +16,13 @@ package connect
 
 import (
 	"bytes"
+	"compress/gzip"
 	"io"
 	"testing"
 
 	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestEnvelope(t *testing.T) {
@@ -100,6 +103,76 @@ func TestEnvelope(t *testing.T) {
 	})
 }
 
+func TestEnvelopeReaderFrameLimits(t *testing.T) {
+	t.Parallel()
+	payload, err := proto.Marshal(&pingv1.PingRequest{Number: 1})
+	assert.Nil(t, err)
+	// Builds a stream of frames: true for a message, false for an empty frame.
+	newStream := func(frames ...bool) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		for _, full := range frames {
+			data := payload
+			if !full {
+				data = nil
+			}
+			head := makeEnvelopePrefix(0, len(data))
+			buf.Write(head[:])
+			buf.Write(data)
+		}
+		return buf
+	}
+	// Reads until error, returning the error and the number of successful
+	// reads.
+	readAll := func(reader envelopeReader) (int, *Error) {
+		reader.codec = &protoBinaryCodec{}
+		reader.bufferPool = newBufferPool()
+		var count int
+		for {
+			if err := reader.Unmarshal(&pingv1.PingRequest{}); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	t.Run("unlimited", func(t *testing.T) {
+		t.Parallel()
+		count, err := readAll(envelopeReader{reader: newStream(true, false, false, false, true)})
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, count, 5)
+	})
+	t.Run("max_frames", func(t *testing.T) {
+		t.Parallel()
+		count, err := readAll(envelopeReader{
+			reader:    newStream(true, false, true, true),
+			maxFrames: 3,
+		})
+		assert.Equal(t, err.Code(), CodeResourceExhausted)
+		assert.Equal(t, count, 3)
+		count, err = readAll(envelopeReader{
+			reader:    newStream(true, false, true),
+			maxFrames: 3,
+		})
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, count, 3)
+	})
+	t.Run("max_empty_frames", func(t *testing.T) {
+		t.Parallel()
+		// Non-empty messages reset the count.
+		count, err := readAll(envelopeReader{
+			reader:         newStream(false, false, true, false, false, true),
+			maxEmptyFrames: 2,
+		})
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, count, 6)
+		count, err = readAll(envelopeReader{
+			reader:         newStream(true, false, false, false, true),
+			maxEmptyFrames: 2,
+		})
+		assert.Equal(t, err.Code(), CodeResourceExhausted)
+		assert.Equal(t, count, 3)
+	})
+}
+
 // byteByByteReader is test reader that reads a single byte at a time.
 type byteByByteReader struct {
 	reader io.ByteReader
@@ -116,3 +189,68 @@ func (b byteByByteReader) Read(data []byte) (int, error) {
 	data[0] = next
 	return 1, nil
 }
+
+func TestUnmarshalEnvelope(t *testing.T) {
+	t.Parallel()
+	payload, err := proto.Marshal(&pingv1.PingRequest{Number: 42})
+	assert.Nil(t, err)
+	frame := func(flags uint8, size int, data []byte) *bytes.Buffer {
+		head := makeEnvelopePrefix(flags, size)
+		return bytes.NewBuffer(append(head[:], data...))
+	}
+	gzipPool := newCompressionPool(
+		func() Decompressor { return &gzip.Reader{} },
+		func() Compressor { return gzip.NewWriter(io.Discard) },
+	)
+	codec := &protoBinaryCodec{}
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		var msg pingv1.PingRequest
+		assert.Nil(t, unmarshalEnvelope(frame(0, len(payload), payload), codec, nil, 0, &msg))
+		assert.Equal(t, msg.GetNumber(), int64(42))
+	})
+	t.Run("compressed", func(t *testing.T) {
+		t.Parallel()
+		compressed := &bytes.Buffer{}
+		writer := gzip.NewWriter(compressed)
+		_, err := writer.Write(payload)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.Close())
+		var msg pingv1.PingRequest
+		err = unmarshalEnvelope(frame(flagEnvelopeCompressed, compressed.Len(), compressed.Bytes()), codec, gzipPool, 0, &msg)
+		assert.Nil(t, err)
+		assert.Equal(t, msg.GetNumber(), int64(42))
+		err = unmarshalEnvelope(frame(flagEnvelopeCompressed, compressed.Len(), compressed.Bytes()), codec, nil, 0, &msg)
+		assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		t.Parallel()
+		err := unmarshalEnvelope(frame(0, len(payload)+10, payload), codec, nil, 0, &pingv1.PingRequest{})
+		assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+		err = unmarshalEnvelope(bytes.NewReader([]byte{0, 0}), codec, nil, 0, &pingv1.PingRequest{})
+		assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	})
+	t.Run("oversized", func(t *testing.T) {
+		t.Parallel()
+		err := unmarshalEnvelope(frame(0, 1<<31, payload), codec, nil, 1024, &pingv1.PingRequest{})
+		assert.Equal(t, CodeOf(err), CodeResourceExhausted)
+	})
+}
+
+func FuzzUnmarshalEnvelope(f *testing.F) {
+	payload, err := proto.Marshal(&pingv1.PingRequest{Number: 42, Text: "fuzz"})
+	assert.Nil(f, err)
+	head := makeEnvelopePrefix(0, len(payload))
+	f.Add(append(head[:], payload...))
+	f.Add(head[:])
+	f.Add([]byte{flagEnvelopeCompressed, 0, 0, 0, 2, 0x1f, 0x8b})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff})
+	gzipPool := newCompressionPool(
+		func() Decompressor { return &gzip.Reader{} },
+		func() Compressor { return gzip.NewWriter(io.Discard) },
+	)
+	f.Fuzz(func(_ *testing.T, data []byte) {
+		// Malformed input must produce an error, never a panic or a hang.
+		_ = unmarshalEnvelope(bytes.NewReader(data), &protoBinaryCodec{}, gzipPool, 1024, &pingv1.PingRequest{})
+	})
+}
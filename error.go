@@ -16,13 +16,17 @@ package connect
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	statusv1 "connectrpc.com/connect/internal/gen/connectext/grpc/status/v1"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 )
@@ -48,9 +52,23 @@ var (
 //
 // The [google.golang.org/genproto/googleapis/rpc/errdetails] package contains a
 // variety of Protobuf messages commonly used as error details.
+//
+// In the Connect protocol's JSON error format, each detail carries its
+// binary-encoded value in base64. When the detail's message type is linked
+// into the handler, the detail also includes a "debug" field containing the
+// message in the Protobuf JSON format. This lets clients without a Protobuf
+// runtime, like a browser making plain fetch calls, display the detail. Since
+// the debug field holds the same information as the base64 value, the detail
+// must not contain anything the client shouldn't see.
 type ErrorDetail struct {
 	pb       *anypb.Any
 	wireJSON string // preserve human-readable JSON
+	// resolver is set on details received by clients configured with
+	// WithTypeResolver.
+	resolver protoResolver
+	// stackTrace marks the google.rpc.DebugInfo details attached by
+	// NewInternalError, which handlers remove unless WithDebugErrors is set.
+	stackTrace bool
 }
 
 // NewErrorDetail constructs a new error detail. If msg is an *[anypb.Any] then
@@ -90,9 +108,14 @@ func (d *ErrorDetail) Bytes() []byte {
 }
 
 // Value uses the Protobuf runtime's package-global registry to unmarshal the
-// Detail into a strongly-typed message. Typically, clients use Go type
-// assertions to cast from the proto.Message interface to concrete types.
+// Detail into a strongly-typed message. If the detail was received by a client
+// configured with [WithTypeResolver], Value uses that resolver instead.
+// Typically, clients use Go type assertions to cast from the proto.Message
+// interface to concrete types.
 func (d *ErrorDetail) Value() (proto.Message, error) {
+	if d.resolver != nil {
+		return anypb.UnmarshalNew(d.pb, proto.UnmarshalOptions{Resolver: d.resolver})
+	}
 	return d.pb.UnmarshalNew()
 }
 
@@ -120,6 +143,16 @@ type Error struct {
 	details []*ErrorDetail
 	meta    http.Header
 	wireErr bool
+	// httpStatus is the status code of the HTTP response that carried the
+	// error, if any.
+	httpStatus int
+	// retryAfter is the delay from the response's Retry-After header, if
+	// hasRetryAfter is set.
+	retryAfter    time.Duration
+	hasRetryAfter bool
+	// rawStatus is a serialized google.rpc.Status sent verbatim to gRPC and
+	// gRPC-Web clients. See NewErrorWithRawDetails.
+	rawStatus []byte
 }
 
 // NewError annotates any Go error with a status code.
@@ -146,6 +179,35 @@ func NewWireError(c Code, underlying error) *Error {
 	return err
 }
 
+// NewErrorWithRawDetails constructs an error that carries a pre-built,
+// binary-encoded google.rpc.Status, such as the output of [proto.Marshal] on
+// a status from [google.golang.org/genproto/googleapis/rpc/status]. It's an
+// escape hatch for interoperating with gRPC peers that expect a particular
+// encoding of the Grpc-Status-Details-Bin trailer: gRPC and gRPC-Web handlers
+// send the status bytes exactly as given, rather than building a status from
+// the error's code, message, and details. The code and message are still sent
+// in the Grpc-Status and Grpc-Message trailers, so they should match the
+// status.
+//
+// The Connect protocol has no equivalent of the raw status, so Connect
+// handlers send the code, message, and details as usual. To keep the
+// protocols consistent, the error's details are taken from the status if it
+// can be parsed. Details added later with [Error.AddDetail] are only sent to
+// Connect clients. Most handlers should use [NewError] and [NewErrorDetail]
+// instead.
+func NewErrorWithRawDetails(c Code, message string, status []byte) *Error {
+	err := NewError(c, errors.New(message))
+	err.rawStatus = make([]byte, len(status))
+	copy(err.rawStatus, status)
+	var parsed statusv1.Status
+	if proto.Unmarshal(status, &parsed) == nil {
+		for _, detail := range parsed.GetDetails() {
+			err.details = append(err.details, &ErrorDetail{pb: detail})
+		}
+	}
+	return err
+}
+
 // IsWireError checks whether the error was returned by the server, as opposed
 // to being synthesized by the client.
 //
@@ -205,16 +267,45 @@ func (e *Error) Code() Code {
 	return e.code
 }
 
-// Details returns the error's details.
+// Details returns the error's details, in the order they were added.
 func (e *Error) Details() []*ErrorDetail {
 	return e.details
 }
 
-// AddDetail appends to the error's details.
+// AddDetail appends to the error's details. An error may carry any number of
+// details, of any mix of types; all protocols send them all to the client.
 func (e *Error) AddDetail(d *ErrorDetail) {
 	e.details = append(e.details, d)
 }
 
+// HTTPStatus returns the status code of the HTTP response that carried the
+// error. It's only populated on the client, for errors sent by the server (see
+// [IsWireError]) and errors caused by an unexpected HTTP status; otherwise, it
+// returns zero.
+//
+// With the Connect protocol, unary errors use a variety of HTTP statuses, so
+// HTTPStatus is useful when debugging proxies that rewrite them. The gRPC and
+// gRPC-Web protocols, and Connect streaming, send errors in trailers or in the
+// body of a 200 OK response, so their status is almost always 200.
+func (e *Error) HTTPStatus() int {
+	return e.httpStatus
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of an HTTP
+// 429 Too Many Requests or 503 Service Unavailable response. Servers and
+// proxies use it to tell rate-limited or overloaded clients when to try again,
+// so retry logic should prefer it to its own computed backoff. The header may
+// hold either a number of seconds or an HTTP date; dates in the past result
+// in a zero delay. The unparsed header is also included in the error's
+// [Error.Meta].
+//
+// Like [Error.HTTPStatus], RetryAfter is only populated on the client. If the
+// response had a different status or no valid Retry-After header, the second
+// return value is false.
+func (e *Error) RetryAfter() (time.Duration, bool) {
+	return e.retryAfter, e.hasRetryAfter
+}
+
 // Meta allows the error to carry additional information as key-value pairs.
 //
 // Metadata attached to errors returned by unary handlers is always sent as
@@ -233,6 +324,38 @@ func (e *Error) Meta() http.Header {
 	return e.meta
 }
 
+// MarshalJSON implements [json.Marshaler]. It produces the Connect protocol's
+// JSON representation of the error: an object with the code's string form,
+// the message, and the details, whose values are base64-encoded Protobuf.
+// Together with UnmarshalJSON, it lets errors travel over transports other
+// than HTTP, like message queues. Metadata and the HTTP status aren't
+// included.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	if e == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(newConnectWireError(e))
+}
+
+// UnmarshalJSON implements [json.Unmarshaler]. It accepts the Connect
+// protocol's JSON representation of an error, as produced by MarshalJSON.
+// Non-canonical codes (like "code_42") become [CodeUnknown], and details can
+// be resolved with [ErrorDetail.Value] as usual. The resulting error returns
+// true when tested with [IsWireError], since it was sent by another process.
+// Following the conventions of encoding/json, a JSON null leaves the error
+// unchanged.
+func (e *Error) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var wire connectWireError
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*e = *wire.asError()
+	return nil
+}
+
 func (e *Error) detailsAsAny() []*anypb.Any {
 	anys := make([]*anypb.Any, 0, len(e.details))
 	for _, detail := range e.details {
@@ -420,3 +543,43 @@ func asMaxBytesError(err error, tmpl string, args ...any) *Error {
 func typeNameFromURL(url string) string {
 	return url[strings.LastIndexByte(url, '/')+1:]
 }
+
+// setHTTPResponse records the status of the HTTP response that carried the
+// error. For 429 and 503 responses, it also records the delay in the
+// Retry-After header, if any.
+func (e *Error) setHTTPResponse(response *http.Response) {
+	e.httpStatus = response.StatusCode
+	if response.StatusCode != http.StatusTooManyRequests &&
+		response.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	value := getHeaderCanonical(response.Header, headerRetryAfter)
+	delay, ok := parseRetryAfter(value, time.Now())
+	if !ok {
+		return
+	}
+	e.retryAfter, e.hasRetryAfter = delay, true
+	if getHeaderCanonical(e.Meta(), headerRetryAfter) == "" {
+		e.meta[headerRetryAfter] = []string{value}
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// non-negative number of seconds or an HTTP date, relative to now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
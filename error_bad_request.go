@@ -0,0 +1,135 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"errors"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// badRequestType is the fully-qualified name of the well-known
+// google.rpc.BadRequest error detail, which we encode and decode by hand (see
+// rangeBytesFields).
+const badRequestType = "google.rpc.BadRequest"
+
+// Field numbers from google/rpc/error_details.proto.
+const (
+	badRequestFieldViolationsField protowire.Number = 1
+	fieldViolationFieldField       protowire.Number = 1
+	fieldViolationDescriptionField protowire.Number = 2
+)
+
+// A FieldViolation describes a single invalid field in a request, as carried
+// by the google.rpc.BadRequest error detail. Field is a path to the field,
+// like "address.zip_code" or "items[2].quantity", and Description explains
+// why the value is invalid.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// NewInvalidArgumentError constructs an error with [CodeInvalidArgument] and a
+// google.rpc.BadRequest detail listing the field violations. The error's
+// message summarizes the violations, so it's still useful to clients that
+// don't inspect details; clients can get the structured violations back with
+// [FieldViolations]. Other details may be added to the error as usual.
+//
+// The detail is wire-compatible with the message in
+// [google.golang.org/genproto/googleapis/rpc/errdetails], so clients in other
+// languages can decode it with their usual gRPC tooling.
+func NewInvalidArgumentError(violations ...FieldViolation) *Error {
+	summaries := make([]string, 0, len(violations))
+	var value []byte
+	for _, violation := range violations {
+		summaries = append(summaries, violation.Field+": "+violation.Description)
+		var encoded []byte
+		encoded = protowire.AppendTag(encoded, fieldViolationFieldField, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, violation.Field)
+		encoded = protowire.AppendTag(encoded, fieldViolationDescriptionField, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, violation.Description)
+		value = protowire.AppendTag(value, badRequestFieldViolationsField, protowire.BytesType)
+		value = protowire.AppendBytes(value, encoded)
+	}
+	connectErr := NewError(CodeInvalidArgument, errors.New(strings.Join(summaries, "; ")))
+	connectErr.AddDetail(&ErrorDetail{pb: &anypb.Any{
+		TypeUrl: defaultAnyResolverPrefix + badRequestType,
+		Value:   value,
+	}})
+	return connectErr
+}
+
+// FieldViolations returns the field violations from every
+// google.rpc.BadRequest detail attached to the error, in order. It returns nil
+// if err isn't an [*Error] or doesn't have any field violations.
+func FieldViolations(err error) []FieldViolation {
+	connectErr, ok := asError(err)
+	if !ok {
+		return nil
+	}
+	var violations []FieldViolation
+	for _, detail := range connectErr.Details() {
+		if detail.Type() != badRequestType {
+			continue
+		}
+		decoded, ok := decodeBadRequest(detail.pb.GetValue())
+		if !ok {
+			continue
+		}
+		violations = append(violations, decoded...)
+	}
+	return violations
+}
+
+// decodeBadRequest parses the binary encoding of a google.rpc.BadRequest,
+// skipping any unknown fields.
+func decodeBadRequest(value []byte) ([]FieldViolation, bool) {
+	var violations []FieldViolation
+	ok := rangeBytesFields(value, func(number protowire.Number, field []byte) bool {
+		if number != badRequestFieldViolationsField {
+			return true
+		}
+		violation, ok := decodeFieldViolation(field)
+		if ok {
+			violations = append(violations, violation)
+		}
+		return ok
+	})
+	if !ok {
+		return nil, false
+	}
+	return violations, true
+}
+
+// decodeFieldViolation parses the binary encoding of a
+// google.rpc.BadRequest.FieldViolation, skipping any unknown fields.
+func decodeFieldViolation(value []byte) (FieldViolation, bool) {
+	var violation FieldViolation
+	ok := rangeBytesFields(value, func(number protowire.Number, field []byte) bool {
+		switch number {
+		case fieldViolationFieldField:
+			violation.Field = string(field)
+		case fieldViolationDescriptionField:
+			violation.Description = string(field)
+		}
+		return true
+	})
+	if !ok {
+		return FieldViolation{}, false
+	}
+	return violation, true
+}
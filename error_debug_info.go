@@ -0,0 +1,145 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"fmt"
+	"runtime"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// debugInfoType is the fully-qualified name of the well-known
+// google.rpc.DebugInfo error detail, which we encode and decode by hand (see
+// rangeBytesFields).
+const debugInfoType = "google.rpc.DebugInfo"
+
+// Field numbers from google/rpc/error_details.proto.
+const (
+	debugInfoStackEntriesField protowire.Number = 1
+	debugInfoDetailField       protowire.Number = 2
+)
+
+// maxDebugInfoFrames limits the size of the stack trace captured by
+// NewInternalError.
+const maxDebugInfoFrames = 32
+
+// DebugInfo is the content of a google.rpc.DebugInfo error detail: a stack
+// trace and any other information that's useful while debugging a failed RPC.
+type DebugInfo struct {
+	StackEntries []string
+	Detail       string
+}
+
+// NewInternalError constructs an error with [CodeInternal] that wraps the
+// underlying error. If withDebugInfo is true, it also attaches a
+// google.rpc.DebugInfo detail with the caller's stack trace and the
+// underlying error's message; otherwise, the stack isn't captured at all.
+// Clients can read the detail with [Error.DebugInfo].
+//
+// Stack traces reveal a lot about the server's implementation, so handlers
+// only send the DebugInfo details attached by NewInternalError when they're
+// constructed with [WithDebugErrors] and remove them from errors otherwise.
+// DebugInfo details attached with [NewErrorDetail] are always sent. Callers typically pass the same flag
+// to both, so that debug builds or non-production environments get stack
+// traces and production servers never capture them.
+//
+// The detail is wire-compatible with the message in
+// [google.golang.org/genproto/googleapis/rpc/errdetails], so clients in other
+// languages can decode it with their usual gRPC tooling.
+func NewInternalError(underlying error, withDebugInfo bool) *Error {
+	connectErr := NewError(CodeInternal, underlying)
+	if !withDebugInfo {
+		return connectErr
+	}
+	var pcs [maxDebugInfoFrames]uintptr
+	// Skip runtime.Callers and NewInternalError.
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs[:])])
+	var value []byte
+	for {
+		frame, more := frames.Next()
+		entry := fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+		value = protowire.AppendTag(value, debugInfoStackEntriesField, protowire.BytesType)
+		value = protowire.AppendString(value, entry)
+		if !more {
+			break
+		}
+	}
+	if underlying != nil {
+		value = protowire.AppendTag(value, debugInfoDetailField, protowire.BytesType)
+		value = protowire.AppendString(value, underlying.Error())
+	}
+	connectErr.AddDetail(&ErrorDetail{
+		pb: &anypb.Any{
+			TypeUrl: defaultAnyResolverPrefix + debugInfoType,
+			Value:   value,
+		},
+		stackTrace: true,
+	})
+	return connectErr
+}
+
+// DebugInfo returns the first google.rpc.DebugInfo detail attached to the
+// error, or nil if there isn't one.
+func (e *Error) DebugInfo() *DebugInfo {
+	for _, detail := range e.Details() {
+		if detail.Type() != debugInfoType {
+			continue
+		}
+		if info, ok := decodeDebugInfo(detail.pb.GetValue()); ok {
+			return info
+		}
+	}
+	return nil
+}
+
+// withoutStackTraces returns the error without the google.rpc.DebugInfo
+// details attached by NewInternalError. If there aren't any, it returns the
+// error unchanged; otherwise, it returns a copy, so errors shared between RPCs
+// aren't modified.
+func (e *Error) withoutStackTraces() *Error {
+	kept := make([]*ErrorDetail, 0, len(e.details))
+	for _, detail := range e.details {
+		if !detail.stackTrace {
+			kept = append(kept, detail)
+		}
+	}
+	if len(kept) == len(e.details) {
+		return e
+	}
+	stripped := *e
+	stripped.details = kept
+	return &stripped
+}
+
+// decodeDebugInfo parses the binary encoding of a google.rpc.DebugInfo,
+// skipping any unknown fields.
+func decodeDebugInfo(value []byte) (*DebugInfo, bool) {
+	info := &DebugInfo{}
+	ok := rangeBytesFields(value, func(number protowire.Number, field []byte) bool {
+		switch number {
+		case debugInfoStackEntriesField:
+			info.StackEntries = append(info.StackEntries, string(field))
+		case debugInfoDetailField:
+			info.Detail = string(field)
+		}
+		return true
+	})
+	if !ok {
+		return nil, false
+	}
+	return info, true
+}
@@ -0,0 +1,55 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+// rangeBytesFields calls fn with the number and contents of each
+// length-delimited field in the binary encoding of a message, skipping fields
+// of any other wire type. It returns false if the encoding is invalid or fn
+// returns false.
+//
+// It's how we decode the well-known error details from
+// google/rpc/error_details.proto, like google.rpc.LocalizedMessage. We encode
+// and decode them by hand rather than depending on the genproto module, and
+// rather than registering our own copies of the types, which would conflict
+// with genproto's. The fields we read are all strings or embedded messages,
+// which are length-delimited.
+func rangeBytesFields(value []byte, fn func(protowire.Number, []byte) bool) bool {
+	for len(value) > 0 {
+		number, wireType, n := protowire.ConsumeTag(value)
+		if n < 0 {
+			return false
+		}
+		value = value[n:]
+		if wireType == protowire.BytesType {
+			field, n := protowire.ConsumeBytes(value)
+			if n < 0 {
+				return false
+			}
+			value = value[n:]
+			if !fn(number, field) {
+				return false
+			}
+			continue
+		}
+		n = protowire.ConsumeFieldValue(number, wireType, value)
+		if n < 0 {
+			return false
+		}
+		value = value[n:]
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// localizedMessageType is the fully-qualified name of the well-known
+// google.rpc.LocalizedMessage error detail, which we encode and decode by hand
+// (see rangeBytesFields).
+const localizedMessageType = "google.rpc.LocalizedMessage"
+
+// Field numbers from google/rpc/error_details.proto.
+const (
+	localizedMessageLocaleField  protowire.Number = 1
+	localizedMessageMessageField protowire.Number = 2
+)
+
+// AddLocalizedMessage attaches a google.rpc.LocalizedMessage detail to the
+// error. The locale should be a BCP 47 language tag, such as "en-US" or
+// "fr-CH", and the message should be safe to show to end users. Errors may
+// carry messages in several locales; clients choose between them with
+// [Error.LocalizedMessage].
+//
+// The detail is wire-compatible with the message in
+// [google.golang.org/genproto/googleapis/rpc/errdetails], so clients in other
+// languages can decode it with their usual gRPC tooling.
+func (e *Error) AddLocalizedMessage(locale, message string) {
+	var value []byte
+	value = protowire.AppendTag(value, localizedMessageLocaleField, protowire.BytesType)
+	value = protowire.AppendString(value, locale)
+	value = protowire.AppendTag(value, localizedMessageMessageField, protowire.BytesType)
+	value = protowire.AppendString(value, message)
+	e.AddDetail(&ErrorDetail{pb: &anypb.Any{
+		TypeUrl: defaultAnyResolverPrefix + localizedMessageType,
+		Value:   value,
+	}})
+}
+
+// LocalizedMessage returns the locale and text of the google.rpc.LocalizedMessage
+// detail that best matches the preferred locales, which are tried in order.
+// A preferred locale matches a detail with the same tag, ignoring case; if
+// none match exactly, it matches a detail with the same primary language (so
+// "en-GB" matches "en-US"). If no preferred locale matches, LocalizedMessage
+// returns the first localized message attached to the error. If the error
+// has no localized messages, it returns empty strings.
+func (e *Error) LocalizedMessage(preferred ...string) (locale, message string) {
+	type localized struct {
+		locale, message string
+	}
+	var candidates []localized
+	for _, detail := range e.Details() {
+		if detail.Type() != localizedMessageType {
+			continue
+		}
+		locale, message, ok := decodeLocalizedMessage(detail.pb.GetValue())
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, localized{locale: locale, message: message})
+	}
+	if len(candidates) == 0 {
+		return "", ""
+	}
+	for _, want := range preferred {
+		for _, candidate := range candidates {
+			if strings.EqualFold(candidate.locale, want) {
+				return candidate.locale, candidate.message
+			}
+		}
+	}
+	for _, want := range preferred {
+		for _, candidate := range candidates {
+			if strings.EqualFold(primaryLanguage(candidate.locale), primaryLanguage(want)) {
+				return candidate.locale, candidate.message
+			}
+		}
+	}
+	return candidates[0].locale, candidates[0].message
+}
+
+// decodeLocalizedMessage parses the binary encoding of a
+// google.rpc.LocalizedMessage, skipping any unknown fields.
+func decodeLocalizedMessage(value []byte) (locale, message string, ok bool) {
+	ok = rangeBytesFields(value, func(number protowire.Number, field []byte) bool {
+		switch number {
+		case localizedMessageLocaleField:
+			locale = string(field)
+		case localizedMessageMessageField:
+			message = string(field)
+		}
+		return true
+	})
+	if !ok {
+		return "", "", false
+	}
+	return locale, message, true
+}
+
+// primaryLanguage returns the primary language subtag of a BCP 47 language
+// tag: "en" for "en-US". It accepts underscores as separators, as in POSIX
+// locale names.
+func primaryLanguage(tag string) string {
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
@@ -15,6 +15,7 @@
 package connect
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -22,6 +23,7 @@ import (
 	"time"
 
 	"connectrpc.com/connect/internal/assert"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -94,6 +96,55 @@ func TestErrorDetails(t *testing.T) {
 	assert.Equal(t, detail.Bytes(), secondBin)
 }
 
+func TestErrorJSON(t *testing.T) {
+	t.Parallel()
+	second := durationpb.New(time.Second)
+	detail, err := NewErrorDetail(second)
+	assert.Nil(t, err)
+	original := NewError(CodeFailedPrecondition, errors.New("oh no"))
+	original.AddDetail(detail)
+	original.Meta().Set("Foo", "bar")
+
+	data, err := json.Marshal(original)
+	assert.Nil(t, err)
+	var wire map[string]any
+	assert.Nil(t, json.Unmarshal(data, &wire))
+	assert.Equal(t, wire["code"], any("failed_precondition"))
+	assert.Equal(t, wire["message"], any("oh no"))
+	assert.Nil(t, wire["metadata"])
+
+	var roundTripped Error
+	assert.Nil(t, json.Unmarshal(data, &roundTripped))
+	assert.Equal(t, roundTripped.Code(), CodeFailedPrecondition)
+	assert.Equal(t, roundTripped.Message(), "oh no")
+	assert.True(t, IsWireError(&roundTripped))
+	assert.Equal(t, len(roundTripped.Details()), 1)
+	value, err := roundTripped.Details()[0].Value()
+	assert.Nil(t, err)
+	assert.Equal(t, value, proto.Message(second))
+	// Re-marshaling preserves the original JSON.
+	again, err := json.Marshal(&roundTripped)
+	assert.Nil(t, err)
+	assert.Equal(t, string(again), string(data))
+
+	var unknown Error
+	assert.Nil(t, json.Unmarshal([]byte(`{"code":"code_42","message":"huh"}`), &unknown))
+	assert.Equal(t, unknown.Code(), CodeUnknown)
+	assert.Equal(t, unknown.Message(), "huh")
+	assert.NotNil(t, json.Unmarshal([]byte(`{"code":"not_a_code"}`), &unknown))
+
+	var nilErr *Error
+	data, err = json.Marshal(nilErr)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "null")
+	// Unmarshaling null is a no-op.
+	assert.Nil(t, json.Unmarshal(data, &unknown))
+	assert.Equal(t, unknown.Code(), CodeUnknown)
+	assert.Equal(t, unknown.Message(), "huh")
+	assert.Nil(t, (&roundTripped).UnmarshalJSON([]byte("null")))
+	assert.Equal(t, roundTripped.Code(), CodeFailedPrecondition)
+}
+
 func TestErrorIs(t *testing.T) {
 	t.Parallel()
 	// errors.New and fmt.Errorf return *errors.errorString. errors.Is
@@ -149,3 +200,129 @@ func TestTypeNameFromURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "5", want: 5 * time.Second, wantOK: true},
+		{value: " 120 ", want: 2 * time.Minute, wantOK: true},
+		{value: "0", want: 0, wantOK: true},
+		{value: "Fri, 01 Mar 2024 12:00:30 GMT", want: 30 * time.Second, wantOK: true},
+		{value: "Fri, 01 Mar 2024 11:00:00 GMT", want: 0, wantOK: true},
+		{value: "", wantOK: false},
+		{value: "-5", wantOK: false},
+		{value: "1.5", wantOK: false},
+		{value: "soon", wantOK: false},
+	} {
+		got, ok := parseRetryAfter(test.value, now)
+		assert.Equal(t, ok, test.wantOK, assert.Sprintf("value %q", test.value))
+		assert.Equal(t, got, test.want, assert.Sprintf("value %q", test.value))
+	}
+}
+
+func TestErrorLocalizedMessage(t *testing.T) {
+	t.Parallel()
+	connectErr := NewError(CodeInvalidArgument, errors.New("oh no"))
+	locale, message := connectErr.LocalizedMessage("en")
+	assert.Zero(t, locale)
+	assert.Zero(t, message)
+
+	detail, err := NewErrorDetail(durationpb.New(time.Second))
+	assert.Nil(t, err)
+	connectErr.AddDetail(detail)
+	connectErr.AddLocalizedMessage("en-US", "color")
+	connectErr.AddLocalizedMessage("en-GB", "colour")
+	connectErr.AddLocalizedMessage("fr", "couleur")
+	assert.Equal(t, len(connectErr.Details()), 4)
+
+	for _, testCase := range []struct {
+		preferred   []string
+		wantLocale  string
+		wantMessage string
+	}{
+		{nil, "en-US", "color"},
+		{[]string{"en-gb"}, "en-GB", "colour"},
+		{[]string{"fr-CA", "en-GB"}, "en-GB", "colour"},
+		{[]string{"fr-CA"}, "fr", "couleur"},
+		{[]string{"en_AU"}, "en-US", "color"},
+		{[]string{"ja"}, "en-US", "color"},
+	} {
+		locale, message := connectErr.LocalizedMessage(testCase.preferred...)
+		assert.Equal(t, locale, testCase.wantLocale, assert.Sprintf("preferred %v", testCase.preferred))
+		assert.Equal(t, message, testCase.wantMessage)
+	}
+
+	// Unknown fields are skipped, and the encoding matches the well-known type.
+	value := protowire.AppendTag(nil, 3, protowire.VarintType)
+	value = protowire.AppendVarint(value, 7)
+	value = append(value, connectErr.Details()[1].Bytes()...)
+	locale, message, ok := decodeLocalizedMessage(value)
+	assert.True(t, ok)
+	assert.Equal(t, locale, "en-US")
+	assert.Equal(t, message, "color")
+	_, _, ok = decodeLocalizedMessage([]byte{0xff})
+	assert.False(t, ok)
+}
+
+func TestFieldViolations(t *testing.T) {
+	t.Parallel()
+	assert.Zero(t, FieldViolations(errors.New("oh no")))
+	assert.Zero(t, FieldViolations(NewError(CodeInvalidArgument, errors.New("oh no"))))
+
+	violations := []FieldViolation{
+		{Field: "name", Description: "must not be empty"},
+		{Field: "items[2].quantity", Description: "must be positive"},
+	}
+	connectErr := NewInvalidArgumentError(violations...)
+	assert.Equal(t, connectErr.Code(), CodeInvalidArgument)
+	assert.Equal(t, connectErr.Message(), "name: must not be empty; items[2].quantity: must be positive")
+	assert.Equal(t, len(connectErr.Details()), 1)
+	assert.Equal(t, connectErr.Details()[0].Type(), "google.rpc.BadRequest")
+	assert.Equal(t, FieldViolations(fmt.Errorf("wrapped: %w", connectErr)), violations)
+
+	// Unknown fields, like the newer reason field, are skipped.
+	violation := protowire.AppendTag(nil, fieldViolationFieldField, protowire.BytesType)
+	violation = protowire.AppendString(violation, "email")
+	violation = protowire.AppendTag(violation, 3, protowire.BytesType)
+	violation = protowire.AppendString(violation, "INVALID_FORMAT")
+	value := protowire.AppendTag(nil, badRequestFieldViolationsField, protowire.BytesType)
+	value = protowire.AppendBytes(value, violation)
+	value = protowire.AppendTag(value, 7, protowire.VarintType)
+	value = protowire.AppendVarint(value, 1)
+	decoded, ok := decodeBadRequest(value)
+	assert.True(t, ok)
+	assert.Equal(t, decoded, []FieldViolation{{Field: "email"}})
+	_, ok = decodeBadRequest([]byte{0x0a, 0x05})
+	assert.False(t, ok)
+}
+
+func TestNewInternalError(t *testing.T) {
+	t.Parallel()
+	connectErr := NewInternalError(errors.New("oh no"), false)
+	assert.Equal(t, connectErr.Code(), CodeInternal)
+	assert.Equal(t, connectErr.Message(), "oh no")
+	assert.Zero(t, connectErr.Details())
+	assert.Nil(t, connectErr.DebugInfo())
+
+	connectErr = NewInternalError(errors.New("oh no"), true)
+	connectErr.AddLocalizedMessage("en-US", "something went wrong")
+	info := connectErr.DebugInfo()
+	assert.NotNil(t, info)
+	assert.Equal(t, info.Detail, "oh no")
+	assert.True(t, len(info.StackEntries) > 0)
+	assert.True(t, strings.HasPrefix(info.StackEntries[0], "connectrpc.com/connect.TestNewInternalError "))
+
+	stripped := connectErr.withoutStackTraces()
+	assert.Nil(t, stripped.DebugInfo())
+	assert.Equal(t, len(stripped.Details()), 1)
+	assert.Equal(t, stripped.Message(), "oh no")
+	// The original error is unchanged.
+	assert.NotNil(t, connectErr.DebugInfo())
+	assert.Equal(t, len(connectErr.Details()), 2)
+	assert.True(t, stripped.withoutStackTraces() == stripped)
+}
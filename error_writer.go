@@ -45,6 +45,8 @@ type ErrorWriter struct {
 	grpcWebContentTypes          map[string]struct{}
 	unaryConnectContentTypes     map[string]struct{}
 	streamingConnectContentTypes map[string]struct{}
+	connectQueryParameter        string
+	handleConnect                bool
 }
 
 // NewErrorWriter constructs an ErrorWriter. To properly recognize supported
@@ -60,13 +62,17 @@ func NewErrorWriter(opts ...HandlerOption) *ErrorWriter {
 		grpcWebContentTypes:          make(map[string]struct{}),
 		unaryConnectContentTypes:     make(map[string]struct{}),
 		streamingConnectContentTypes: make(map[string]struct{}),
+		connectQueryParameter:        config.GetParamNames.withDefaults().Connect,
 	}
-	for name := range config.Codecs {
-		unary := connectContentTypeFromCodecName(StreamTypeUnary, name)
-		writer.unaryConnectContentTypes[unary] = struct{}{}
-		streaming := connectContentTypeFromCodecName(StreamTypeBidi, name)
-		writer.streamingConnectContentTypes[streaming] = struct{}{}
+	if config.HandleConnect {
+		for name := range config.Codecs {
+			unary := connectContentTypeFromCodecName(StreamTypeUnary, name)
+			writer.unaryConnectContentTypes[unary] = struct{}{}
+			streaming := connectContentTypeFromCodecName(StreamTypeBidi, name)
+			writer.streamingConnectContentTypes[streaming] = struct{}{}
+		}
 	}
+	writer.handleConnect = config.HandleConnect
 	if config.HandleGRPC {
 		writer.grpcContentTypes[grpcContentTypeDefault] = struct{}{}
 		for name := range config.Codecs {
@@ -76,9 +82,13 @@ func NewErrorWriter(opts ...HandlerOption) *ErrorWriter {
 	}
 	if config.HandleGRPCWeb {
 		writer.grpcWebContentTypes[grpcWebContentTypeDefault] = struct{}{}
+		writer.grpcWebContentTypes[grpcWebTextContentTypeDefault] = struct{}{}
 		for name := range config.Codecs {
 			ct := grpcContentTypeFromCodecName(true /* web */, name)
 			writer.grpcWebContentTypes[ct] = struct{}{}
+			// Errors are trailers-only responses, so text mode needs no special
+			// handling.
+			writer.grpcWebContentTypes[grpcWebTextContentTypePrefix+name] = struct{}{}
 		}
 	}
 	return writer
@@ -100,13 +110,13 @@ func (w *ErrorWriter) classifyRequest(request *http.Request) protocolType {
 	}
 	// Check for Connect-Protocol-Version header or connect protocol query
 	// parameter to support connect GET requests.
-	if request.Method == http.MethodGet {
-		connectVersion := getHeaderCanonical(request.Header, connectProtocolVersion)
-		if connectVersion == connectProtocolVersion {
+	if request.Method == http.MethodGet && w.handleConnect {
+		connectVersion := getHeaderCanonical(request.Header, connectHeaderProtocolVersion)
+		if connectProtocolVersionSupported(connectVersion) {
 			return connectUnaryProtocol
 		}
-		connectVersion = request.URL.Query().Get(connectUnaryConnectQueryParameter)
-		if connectVersion == connectUnaryConnectQueryValue {
+		connectVersion = request.URL.Query().Get(w.connectQueryParameter)
+		if connectQueryVersionSupported(connectVersion) {
 			return connectUnaryProtocol
 		}
 	}
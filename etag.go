@@ -0,0 +1,80 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	headerETag        = "Etag"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+// checkETag implements WithETags for a successful response to a GET request.
+// It sets the response's ETag, unless the implementation already set one, and
+// returns a not-modified error if the request's If-None-Match header matches.
+func checkETag(requestHeader http.Header, response AnyResponse) error {
+	etag := getHeaderCanonical(response.Header(), headerETag)
+	if etag == "" {
+		etag = computeETag(response.Any())
+		if etag == "" {
+			return nil
+		}
+		setHeaderCanonical(response.Header(), headerETag, etag)
+	}
+	if !etagMatches(requestHeader.Values(headerIfNoneMatch), etag) {
+		return nil
+	}
+	notModified := NewNotModifiedError(nil)
+	mergeHeaders(notModified.Meta(), response.Header())
+	return notModified
+}
+
+// computeETag returns a strong entity tag for a response message, derived
+// from its deterministic Protobuf encoding. It returns an empty string for
+// messages that aren't Protobuf.
+func computeETag(message any) string {
+	protoMessage, ok := message.(proto.Message)
+	if !ok {
+		return ""
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(protoMessage)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches performs the weak comparison required by If-None-Match (RFC
+// 9110 § 13.1.2).
+func etagMatches(ifNoneMatch []string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, field := range ifNoneMatch {
+		for _, candidate := range strings.Split(field, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"testing"
+
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+)
+
+func TestETagMatches(t *testing.T) {
+	t.Parallel()
+	const etag = `"abc"`
+	assert.False(t, etagMatches(nil, etag))
+	assert.True(t, etagMatches([]string{`"abc"`}, etag))
+	assert.True(t, etagMatches([]string{`W/"abc"`}, etag))
+	assert.True(t, etagMatches([]string{`"x", "abc"`}, etag))
+	assert.True(t, etagMatches([]string{`"x"`, `"abc"`}, etag))
+	assert.True(t, etagMatches([]string{"*"}, etag))
+	assert.True(t, etagMatches([]string{`"abc"`}, `W/"abc"`))
+	assert.False(t, etagMatches([]string{`"abcd"`}, etag))
+}
+
+func TestComputeETag(t *testing.T) {
+	t.Parallel()
+	first := computeETag(&pingv1.PingResponse{Number: 1, Text: "foo"})
+	assert.Equal(t, computeETag(&pingv1.PingResponse{Number: 1, Text: "foo"}), first)
+	assert.NotEqual(t, computeETag(&pingv1.PingResponse{Number: 2, Text: "foo"}), first)
+	assert.Match(t, first, `^"[A-Za-z0-9_-]{22}"$`)
+	assert.Zero(t, computeETag("not a proto"))
+}
@@ -0,0 +1,96 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// An H2COption configures [NewH2CHandler].
+type H2COption interface {
+	applyToH2C(*http2.Server)
+}
+
+// WithH2CMaxConcurrentStreams limits the number of concurrent streams (and
+// so, concurrent RPCs) each client connection may open. If zero, the
+// golang.org/x/net/http2 default of 250 streams applies.
+func WithH2CMaxConcurrentStreams(max uint32) H2COption {
+	return &h2cMaxConcurrentStreamsOption{max: max}
+}
+
+// WithH2CMaxReadFrameSize sets the largest HTTP/2 frame the server is willing
+// to read, in bytes. Valid sizes are between 16KiB and 16MiB; other values,
+// including zero, use the golang.org/x/net/http2 default of 1MiB.
+func WithH2CMaxReadFrameSize(size uint32) H2COption {
+	return &h2cMaxReadFrameSizeOption{size: size}
+}
+
+// WithH2CIdleTimeout closes client connections that have had no active
+// streams for the given duration. If zero, idle connections are never closed
+// by the server.
+func WithH2CIdleTimeout(timeout time.Duration) H2COption {
+	return &h2cIdleTimeoutOption{timeout: timeout}
+}
+
+// NewH2CHandler wraps a handler so that it serves HTTP/2 without TLS (h2c),
+// which gRPC clients and Connect clients using bidirectional streaming need
+// when the server doesn't terminate TLS itself, for example behind a load
+// balancer. Requests using HTTP/1.1 are passed through unchanged. The returned
+// handler works with any [http.Server]:
+//
+//	server := &http.Server{
+//		Addr:              ":8080",
+//		Handler:           connect.NewH2CHandler(mux, connect.WithH2CIdleTimeout(time.Minute)),
+//		ReadHeaderTimeout: 5 * time.Second,
+//	}
+//
+// Since h2c connections aren't encrypted, only use this handler on trusted
+// networks. Servers using TLS negotiate HTTP/2 automatically and don't need
+// it.
+func NewH2CHandler(handler http.Handler, options ...H2COption) http.Handler {
+	server := &http2.Server{}
+	for _, opt := range options {
+		opt.applyToH2C(server)
+	}
+	return h2c.NewHandler(handler, server)
+}
+
+type h2cMaxConcurrentStreamsOption struct {
+	max uint32
+}
+
+func (o *h2cMaxConcurrentStreamsOption) applyToH2C(server *http2.Server) {
+	server.MaxConcurrentStreams = o.max
+}
+
+type h2cMaxReadFrameSizeOption struct {
+	size uint32
+}
+
+func (o *h2cMaxReadFrameSizeOption) applyToH2C(server *http2.Server) {
+	server.MaxReadFrameSize = o.size
+}
+
+type h2cIdleTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (o *h2cIdleTimeoutOption) applyToH2C(server *http2.Server) {
+	server.IdleTimeout = o.timeout
+}
@@ -0,0 +1,76 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"golang.org/x/net/http2"
+)
+
+func TestNewH2CHandler(t *testing.T) {
+	t.Parallel()
+	protos := make(chan int, 2)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+		},
+	}))
+	handler := connect.NewH2CHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			protos <- r.ProtoMajor
+			mux.ServeHTTP(w, r)
+		}),
+		connect.WithH2CMaxConcurrentStreams(10),
+		connect.WithH2CMaxReadFrameSize(1<<20),
+		connect.WithH2CIdleTimeout(time.Minute),
+	)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}}
+	for _, tt := range []struct {
+		name      string
+		client    *http.Client
+		opts      []connect.ClientOption
+		wantProto int
+	}{
+		{name: "grpc_h2c", client: h2cClient, opts: []connect.ClientOption{connect.WithGRPC()}, wantProto: 2},
+		{name: "connect_http1", client: server.Client(), wantProto: 1},
+	} {
+		client := pingv1connect.NewPingServiceClient(tt.client, server.URL, tt.opts...)
+		res, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err, assert.Sprintf("%s", tt.name))
+		assert.Equal(t, res.Msg.GetNumber(), 42)
+		assert.Equal(t, <-protos, tt.wantProto, assert.Sprintf("%s", tt.name))
+	}
+}
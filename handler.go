@@ -16,8 +16,15 @@ package connect
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // A Handler is the server-side implementation of a single RPC defined by a
@@ -32,6 +39,17 @@ type Handler struct {
 	protocolHandlers map[string][]protocolHandler // Method to protocol handlers
 	allowMethod      string                       // Allow header
 	acceptPost       string                       // Accept-Post header
+	responseHeader   func(context.Context, Spec, http.Header)
+	requireTLS       bool
+	trustedTLSProxy  func(*http.Request) bool
+	contextValues    func(context.Context, *http.Request) context.Context
+	connStreams      *connStreamLimiter
+	inFlight         *InFlightTracker
+	logger           Logger
+	slowThreshold    time.Duration
+	sendTimeout      time.Duration
+	errorRedactor    func(*Error) *Error
+	debugErrors      bool
 }
 
 // NewUnaryHandler constructs a [Handler] for a request-response procedure.
@@ -40,6 +58,7 @@ func NewUnaryHandler[Req, Res any](
 	unary func(context.Context, *Request[Req]) (*Response[Res], error),
 	options ...HandlerOption,
 ) *Handler {
+	config := newHandlerConfig(procedure, StreamTypeUnary, options)
 	// Wrap the strongly-typed implementation so we can apply interceptors.
 	untyped := UnaryFunc(func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
 		if err := ctx.Err(); err != nil {
@@ -49,15 +68,23 @@ func NewUnaryHandler[Req, Res any](
 		if !ok {
 			return nil, errorf(CodeInternal, "unexpected handler request type %T", request)
 		}
+		if config.isDryRun(typed.Header()) {
+			res := NewResponse(new(Res))
+			res.Header().Set(headerDryRun, "1")
+			res.codec = typed.codec
+			return res, nil
+		}
 		res, err := unary(ctx, typed)
 		if res == nil && err == nil {
 			// This is going to panic during serialization. Debugging is much easier
 			// if we panic here instead, so we can include the procedure name.
 			panic(fmt.Sprintf("%s returned nil *connect.Response and nil error", procedure)) //nolint: forbidigo
 		}
+		if res != nil {
+			res.codec = typed.codec
+		}
 		return res, err
 	})
-	config := newHandlerConfig(procedure, StreamTypeUnary, options)
 	if interceptor := config.Interceptor; interceptor != nil {
 		untyped = interceptor.WrapUnary(untyped)
 	}
@@ -80,11 +107,17 @@ func NewUnaryHandler[Req, Res any](
 			peer:   conn.Peer(),
 			header: conn.RequestHeader(),
 			method: method,
+			codec:  codecNameOf(conn),
 		}
 		response, err := untyped(ctx, request)
 		if err != nil {
 			return err
 		}
+		if config.ETags && method == http.MethodGet {
+			if err := checkETag(request.header, response); err != nil {
+				return err
+			}
+		}
 		mergeHeaders(conn.ResponseHeader(), response.Header())
 		mergeHeaders(conn.ResponseTrailer(), response.Trailer())
 		return conn.Send(response.Any())
@@ -97,6 +130,17 @@ func NewUnaryHandler[Req, Res any](
 		protocolHandlers: mappedMethodHandlers(protocolHandlers),
 		allowMethod:      sortedAllowMethodValue(protocolHandlers),
 		acceptPost:       sortedAcceptPostValue(protocolHandlers),
+		responseHeader:   config.ResponseHeaderFunc,
+		requireTLS:       config.RequireTLS,
+		trustedTLSProxy:  config.TrustedTLSProxy,
+		contextValues:    config.ContextValues,
+		connStreams:      config.ConnStreamLimiter,
+		inFlight:         config.InFlightTracker,
+		logger:           config.logger(),
+		slowThreshold:    config.SlowRequestThreshold,
+		sendTimeout:      config.SendTimeout,
+		errorRedactor:    config.ErrorRedactor,
+		debugErrors:      config.DebugErrors,
 	}
 }
 
@@ -114,6 +158,16 @@ func NewClientStreamHandler[Req, Res any](
 				conn:        conn,
 				initializer: config.Initializer,
 			}
+			if config.isDryRun(conn.RequestHeader()) {
+				conn.ResponseHeader().Set(headerDryRun, "1")
+				for stream.Receive() {
+					// Decode every message, but discard them.
+				}
+				if err := stream.Err(); err != nil {
+					return err
+				}
+				return conn.Send(new(Res))
+			}
 			res, err := implementation(ctx, stream)
 			if err != nil {
 				return err
@@ -147,6 +201,14 @@ func NewServerStreamHandler[Req, Res any](
 			if err := conn.Receive(&msg); err != nil {
 				return err
 			}
+			if config.isDryRun(conn.RequestHeader()) {
+				conn.ResponseHeader().Set(headerDryRun, "1")
+				return nil
+			}
+			method := http.MethodPost
+			if hasRequestMethod, ok := conn.(interface{ getHTTPMethod() string }); ok {
+				method = hasRequestMethod.getHTTPMethod()
+			}
 			return implementation(
 				ctx,
 				&Request[Req]{
@@ -154,7 +216,8 @@ func NewServerStreamHandler[Req, Res any](
 					spec:   conn.Spec(),
 					peer:   conn.Peer(),
 					header: conn.RequestHeader(),
-					method: http.MethodPost,
+					method: method,
+					codec:  codecNameOf(conn),
 				},
 				&ServerStream[Res]{conn: conn},
 			)
@@ -172,13 +235,21 @@ func NewBidiStreamHandler[Req, Res any](
 	return newStreamHandler(
 		config,
 		func(ctx context.Context, conn StreamingHandlerConn) error {
-			return implementation(
-				ctx,
-				&BidiStream[Req, Res]{
-					conn:        conn,
-					initializer: config.Initializer,
-				},
-			)
+			stream := &BidiStream[Req, Res]{
+				conn:        conn,
+				initializer: config.Initializer,
+			}
+			if config.isDryRun(conn.RequestHeader()) {
+				conn.ResponseHeader().Set(headerDryRun, "1")
+				for {
+					if _, err := stream.Receive(); errors.Is(err, io.EOF) {
+						return nil
+					} else if err != nil {
+						return err
+					}
+				}
+			}
+			return implementation(ctx, stream)
 		},
 	)
 }
@@ -209,11 +280,15 @@ func (h *Handler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Re
 	contentType := canonicalizeContentType(getHeaderCanonical(request.Header, headerContentType))
 
 	// Find our implementation of the RPC protocol in use.
-	var protocolHandler protocolHandler
-	for _, handler := range protocolHandlers {
-		if handler.CanHandlePayload(request, contentType) {
-			protocolHandler = handler
-			break
+	protocolHandler := findProtocolHandler(protocolHandlers, request, contentType)
+	if protocolHandler == nil {
+		// Some browsers and HTTP libraries always append parameters, like a
+		// charset or multipart boundary, that none of our codecs register. If
+		// the bare media type matches, use it instead.
+		if mediaType, ok := contentTypeWithoutParams(contentType); ok {
+			if protocolHandler = findProtocolHandler(protocolHandlers, request, mediaType); protocolHandler != nil {
+				contentType = mediaType
+			}
 		}
 	}
 	if protocolHandler == nil {
@@ -248,23 +323,296 @@ func (h *Handler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Re
 	}
 	if cancel != nil {
 		defer cancel()
+		// Reading the request body doesn't respect the context, so without a
+		// read deadline, handlers blocked in Receive would wait past the
+		// client's timeout.
+		if deadline, ok := ctx.Deadline(); ok {
+			if setter, ok := findResponseWriter[readDeadlineSetter](responseWriter); ok {
+				_ = setter.SetReadDeadline(deadline)
+			}
+		}
 	}
-	connCloser, ok := protocolHandler.NewConn(
-		responseWriter,
-		request.WithContext(ctx),
-	)
+	request = request.WithContext(ctx)
+	ctx = context.WithValue(ctx, httpRequestContextKey{}, request)
+	if h.contextValues != nil {
+		ctx = h.contextValues(ctx, request)
+	}
+	connCloser, ok := protocolHandler.NewConn(responseWriter, request)
 	if !ok {
 		// Failed to create stream, usually because client used an unknown
 		// compression algorithm. Nothing further to do.
 		return
 	}
+	if h.responseHeader != nil {
+		// Headers are sent lazily, so it's safe to modify them until the
+		// first message is sent.
+		h.responseHeader(ctx, h.spec, connCloser.ResponseHeader())
+	}
 	if timeoutErr != nil {
-		_ = connCloser.Close(timeoutErr)
+		_ = connCloser.Close(h.redact(timeoutErr))
+		return
+	}
+	if h.requireTLS && !h.isTLS(request) {
+		_ = connCloser.Close(h.redact(errorf(CodePermissionDenied, "%s requires TLS", h.spec.Procedure)))
+		return
+	}
+	if h.connStreams != nil {
+		if !h.connStreams.acquire(request.RemoteAddr) {
+			_ = connCloser.Close(h.redact(errorf(
+				CodeResourceExhausted,
+				"too many concurrent streams on this connection: limit is %d",
+				h.connStreams.limit,
+			)))
+			return
+		}
+	}
+	// The request has been accepted for this procedure: only count it as in
+	// flight from here on, so rejected requests don't skew the counts.
+	if h.inFlight != nil {
+		h.inFlight.start(h.spec.StreamType)
+		defer h.inFlight.finish(h.spec.StreamType)
+	}
+	var start time.Time
+	if h.slowThreshold > 0 {
+		start = time.Now()
+	}
+	var conn StreamingHandlerConn = connCloser
+	if h.sendTimeout > 0 {
+		if setter, ok := findResponseWriter[writeDeadlineSetter](responseWriter); ok {
+			conn = &sendTimeoutConn{
+				StreamingHandlerConn: connCloser,
+				setter:               setter,
+				timeout:              h.sendTimeout,
+				serverDeadline:       serverWriteDeadline(request),
+			}
+		}
+	}
+	err := h.serve(ctx, conn, request.RemoteAddr)
+	if code := CodeOf(wrapIfContextError(err)); ctx.Err() != nil &&
+		(code == CodeCanceled || code == CodeDeadlineExceeded) {
+		// Handlers often return the context's error, so prefer its cause if
+		// whatever canceled the RPC chose a code (see contextCauseError).
+		if causeErr := contextCauseError(ctx); causeErr != nil {
+			err = causeErr
+		}
+	}
+	_ = connCloser.Close(h.redact(err))
+	if h.slowThreshold > 0 {
+		if elapsed := time.Since(start); elapsed > h.slowThreshold {
+			h.logger.Warn("slow RPC", "procedure", h.spec.Procedure, "code", codeOrOK(err), "duration", elapsed)
+		}
+	}
+}
+
+// serve runs the implementation. If the handler limits streams per connection,
+// it releases the stream's slot before the caller closes the conn, so that
+// clients can start another stream as soon as they see this one end (even if
+// the implementation panics).
+func (h *Handler) serve(ctx context.Context, conn StreamingHandlerConn, addr string) error {
+	if h.connStreams != nil {
+		defer h.connStreams.release(addr)
+	}
+	return h.implementation(ctx, conn)
+}
+
+// redact removes google.rpc.DebugInfo details unless the handler was
+// constructed WithDebugErrors, then applies the error redactor configured
+// with WithErrorRedactor, if any, to an error that's about to be sent to the
+// client.
+func (h *Handler) redact(err error) error {
+	if err == nil {
+		return err
+	}
+	connectErr, ok := asError(wrapIfContextError(err))
+	if !ok {
+		if h.errorRedactor == nil {
+			return err
+		}
+		connectErr = NewError(CodeUnknown, err)
+	}
+	if !h.debugErrors {
+		connectErr = connectErr.withoutStackTraces()
+	}
+	if h.errorRedactor == nil || IsNotModifiedError(connectErr) {
+		// Redacting a not-modified error would turn the 304 into a real error.
+		return connectErr
+	}
+	if redacted := h.errorRedactor(connectErr); redacted != nil {
+		return redacted
+	}
+	return connectErr
+}
+
+// findProtocolHandler returns the first of the handlers that can handle the
+// request's payload, or nil if none of them can.
+func findProtocolHandler(handlers []protocolHandler, request *http.Request, contentType string) protocolHandler {
+	for _, handler := range handlers {
+		if handler.CanHandlePayload(request, contentType) {
+			return handler
+		}
+	}
+	return nil
+}
+
+// codeOrOK describes the outcome of an RPC for logs.
+func codeOrOK(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return CodeOf(err).String()
+}
+
+// connStreamLimiter counts the active RPCs on each client connection, which
+// we identify by the remote address.
+type connStreamLimiter struct {
+	limit int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newConnStreamLimiter(limit int) *connStreamLimiter {
+	return &connStreamLimiter{
+		limit:  limit,
+		active: make(map[string]int),
+	}
+}
+
+func (l *connStreamLimiter) acquire(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[addr] >= l.limit {
+		return false
+	}
+	l.active[addr]++
+	return true
+}
+
+func (l *connStreamLimiter) release(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[addr] <= 1 {
+		delete(l.active, addr) // don't keep closed connections around
 		return
 	}
-	_ = connCloser.Close(h.implementation(ctx, connCloser))
+	l.active[addr]--
+}
+
+// writeDeadlineSetter and readDeadlineSetter are implemented by the
+// http.ResponseWriters of the net/http and golang.org/x/net/http2 servers.
+// They're the same methods that http.ResponseController uses.
+type writeDeadlineSetter interface {
+	SetWriteDeadline(time.Time) error
+}
+
+type readDeadlineSetter interface {
+	SetReadDeadline(time.Time) error
+}
+
+// findResponseWriter unwraps the response writer until it finds one that
+// implements T.
+func findResponseWriter[T any](responseWriter http.ResponseWriter) (T, bool) {
+	for {
+		if found, ok := any(responseWriter).(T); ok {
+			return found, true
+		}
+		unwrapper, ok := responseWriter.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		responseWriter = unwrapper.Unwrap()
+	}
+}
+
+// sendTimeoutConn sets a write deadline around each Send, so that clients
+// that stop reading can't block the handler forever. See WithSendTimeout.
+type sendTimeoutConn struct {
+	StreamingHandlerConn
+
+	setter  writeDeadlineSetter
+	timeout time.Duration
+	// The deadline the server's WriteTimeout imposes on the response, restored
+	// after each Send. Zero if the server has no WriteTimeout.
+	serverDeadline time.Time
+}
+
+func (c *sendTimeoutConn) Send(msg any) error {
+	return c.sendWithFlags(msg, 0)
+}
+
+func (c *sendTimeoutConn) sendWithFlags(msg any, flags uint8) error {
+	start := time.Now()
+	if err := c.setter.SetWriteDeadline(start.Add(c.timeout)); err != nil {
+		return sendWithFlags(c.StreamingHandlerConn, msg, flags)
+	}
+	err := sendWithFlags(c.StreamingHandlerConn, msg, flags)
+	_ = c.setter.SetWriteDeadline(c.serverDeadline)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		return errorf(CodeDeadlineExceeded, "send timed out after %v: %w", c.timeout, err)
+	}
+	return err
+}
+
+func (c *sendTimeoutConn) receivedFlags() uint8 {
+	return receivedFlagsOf(c.StreamingHandlerConn)
+}
+
+func (c *sendTimeoutConn) getHTTPMethod() string {
+	if methoder, ok := c.StreamingHandlerConn.(interface{ getHTTPMethod() string }); ok {
+		return methoder.getHTTPMethod()
+	}
+	return http.MethodPost
+}
+
+func (c *sendTimeoutConn) getResponseCompression() string {
+	return responseCompressionOf(c.StreamingHandlerConn)
+}
+
+func (c *sendTimeoutConn) getCodecName() string {
+	return codecNameOf(c.StreamingHandlerConn)
+}
+
+// serverWriteDeadline approximates the write deadline that the server's
+// WriteTimeout set for the response. Servers start the timeout when they read
+// the request, shortly before calling the handler, so the approximation errs on
+// the side of leniency.
+func serverWriteDeadline(request *http.Request) time.Time {
+	server, ok := request.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok || server.WriteTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(server.WriteTimeout)
+}
+
+// isTLS reports whether the request arrived over TLS, either directly or via a
+// trusted proxy that terminated TLS and set X-Forwarded-Proto.
+func (h *Handler) isTLS(request *http.Request) bool {
+	if request.TLS != nil {
+		return true
+	}
+	if h.trustedTLSProxy == nil || !h.trustedTLSProxy(request) {
+		return false
+	}
+	return strings.EqualFold(getHeaderCanonical(request.Header, headerForwardedProto), "https")
+}
+
+// HTTPRequest returns the underlying HTTP request for the RPC being handled.
+// It's an escape hatch for the rare cases that Connect doesn't model, like
+// reading a cookie or the raw URL query. Most handlers should use the
+// request headers and [Peer] instead.
+//
+// The returned request must be treated as read-only: mutating it (including
+// reading from its body) is unsupported and may break the RPC. HTTPRequest
+// returns nil if the context didn't come from a [Handler], so it's always nil
+// on the client side.
+func HTTPRequest(ctx context.Context) *http.Request {
+	request, _ := ctx.Value(httpRequestContextKey{}).(*http.Request)
+	return request
 }
 
+type httpRequestContextKey struct{}
+
 type handlerConfig struct {
 	CompressionPools             map[string]*compressionPool
 	CompressionNames             []string
@@ -274,14 +622,37 @@ type handlerConfig struct {
 	Procedure                    string
 	Schema                       any
 	Initializer                  maybeInitializer
+	HandleConnect                bool
 	HandleGRPC                   bool
 	HandleGRPCWeb                bool
 	RequireConnectProtocolHeader bool
 	IdempotencyLevel             IdempotencyLevel
 	BufferPool                   *bufferPool
 	ReadMaxBytes                 int
+	MaxDecompressionRatio        float64
+	ReadMaxFrames                int
+	ReadMaxEmptyFrames           int
 	SendMaxBytes                 int
 	StreamType                   StreamType
+	ResponseHeaderFunc           func(context.Context, Spec, http.Header)
+	RequireTLS                   bool
+	TrustedTLSProxy              func(*http.Request) bool
+	ServerSentEvents             bool
+	AcceptCompressionNames       []string
+	ContextValues                func(context.Context, *http.Request) context.Context
+	ConnStreamLimiter            *connStreamLimiter
+	InFlightTracker              *InFlightTracker
+	CompressionSelector          func(context.Context, Spec, []string) string
+	Logger                       Logger
+	SlowRequestThreshold         time.Duration
+	SendTimeout                  time.Duration
+	StreamHeartbeat              time.Duration
+	ErrorRedactor                func(*Error) *Error
+	DebugErrors                  bool
+	ETags                        bool
+	AllowDryRun                  bool
+	GRPCWebTrailerMode           GRPCWebTrailerMode
+	GetParamNames                ConnectGetParamNames
 }
 
 func newHandlerConfig(procedure string, streamType StreamType, options []HandlerOption) *handlerConfig {
@@ -290,6 +661,7 @@ func newHandlerConfig(procedure string, streamType StreamType, options []Handler
 		Procedure:        protoPath,
 		CompressionPools: make(map[string]*compressionPool),
 		Codecs:           make(map[string]Codec),
+		HandleConnect:    true,
 		HandleGRPC:       true,
 		HandleGRPCWeb:    true,
 		BufferPool:       newBufferPool(),
@@ -304,6 +676,19 @@ func newHandlerConfig(procedure string, streamType StreamType, options []Handler
 	return &config
 }
 
+func (c *handlerConfig) logger() Logger {
+	if c.Logger == nil {
+		return stdLogger{logger: log.Default()}
+	}
+	return c.Logger
+}
+
+// isDryRun reports whether the handler allows dry runs and the client asked
+// for one.
+func (c *handlerConfig) isDryRun(header http.Header) bool {
+	return c.AllowDryRun && getHeaderCanonical(header, headerDryRun) == "1"
+}
+
 func (c *handlerConfig) newSpec() Spec {
 	return Spec{
 		Procedure:        c.Procedure,
@@ -314,7 +699,10 @@ func (c *handlerConfig) newSpec() Spec {
 }
 
 func (c *handlerConfig) newProtocolHandlers() []protocolHandler {
-	protocols := []protocol{&protocolConnect{}}
+	var protocols []protocol
+	if c.HandleConnect {
+		protocols = append(protocols, &protocolConnect{})
+	}
 	if c.HandleGRPC {
 		protocols = append(protocols, &protocolGRPC{web: false})
 	}
@@ -327,22 +715,58 @@ func (c *handlerConfig) newProtocolHandlers() []protocolHandler {
 		c.CompressionPools,
 		c.CompressionNames,
 	)
+	params := protocolHandlerParams{
+		Spec:                         c.newSpec(),
+		Codecs:                       codecs,
+		CompressionPools:             compressors,
+		AcceptCompression:            c.acceptCompression(compressors),
+		CompressMinBytes:             c.CompressMinBytes,
+		BufferPool:                   c.BufferPool,
+		ReadMaxBytes:                 c.ReadMaxBytes,
+		MaxDecompressionRatio:        c.MaxDecompressionRatio,
+		ReadMaxFrames:                c.ReadMaxFrames,
+		ReadMaxEmptyFrames:           c.ReadMaxEmptyFrames,
+		SendMaxBytes:                 c.SendMaxBytes,
+		RequireConnectProtocolHeader: c.RequireConnectProtocolHeader,
+		IdempotencyLevel:             c.IdempotencyLevel,
+		CompressionSelector:          c.CompressionSelector,
+		GRPCWebTrailerMode:           c.GRPCWebTrailerMode,
+		GetParamNames:                c.GetParamNames.withDefaults(),
+		StreamHeartbeat:              c.StreamHeartbeat,
+	}
 	for _, protocol := range protocols {
-		handlers = append(handlers, protocol.NewHandler(&protocolHandlerParams{
-			Spec:                         c.newSpec(),
-			Codecs:                       codecs,
-			CompressionPools:             compressors,
-			CompressMinBytes:             c.CompressMinBytes,
-			BufferPool:                   c.BufferPool,
-			ReadMaxBytes:                 c.ReadMaxBytes,
-			SendMaxBytes:                 c.SendMaxBytes,
-			RequireConnectProtocolHeader: c.RequireConnectProtocolHeader,
-			IdempotencyLevel:             c.IdempotencyLevel,
-		}))
+		handlers = append(handlers, protocol.NewHandler(&params))
+	}
+	if c.ServerSentEvents && c.HandleConnect && c.StreamType == StreamTypeServer {
+		handlers = append(handlers, newSSEHandler(&params))
 	}
 	return handlers
 }
 
+// acceptCompression returns the compressors the handler advertises, which
+// default to all registered compressors.
+func (c *handlerConfig) acceptCompression(compressors readOnlyCompressionPools) readOnlyCompressionPools {
+	if c.AcceptCompressionNames == nil {
+		return compressors
+	}
+	advertised := make(map[string]struct{}, len(c.AcceptCompressionNames))
+	for _, name := range c.AcceptCompressionNames {
+		advertised[name] = struct{}{}
+	}
+	nameToPool := make(map[string]*compressionPool, len(advertised))
+	names := make([]string, 0, len(advertised))
+	for _, name := range c.CompressionNames {
+		if _, ok := advertised[name]; !ok {
+			continue
+		}
+		if pool, ok := c.CompressionPools[name]; ok {
+			nameToPool[name] = pool
+			names = append(names, name)
+		}
+	}
+	return newReadOnlyCompressionPools(nameToPool, names)
+}
+
 func newStreamHandler(
 	config *handlerConfig,
 	implementation StreamingHandlerFunc,
@@ -357,5 +781,16 @@ func newStreamHandler(
 		protocolHandlers: mappedMethodHandlers(protocolHandlers),
 		allowMethod:      sortedAllowMethodValue(protocolHandlers),
 		acceptPost:       sortedAcceptPostValue(protocolHandlers),
+		responseHeader:   config.ResponseHeaderFunc,
+		requireTLS:       config.RequireTLS,
+		trustedTLSProxy:  config.TrustedTLSProxy,
+		contextValues:    config.ContextValues,
+		connStreams:      config.ConnStreamLimiter,
+		inFlight:         config.InFlightTracker,
+		logger:           config.logger(),
+		slowThreshold:    config.SlowRequestThreshold,
+		sendTimeout:      config.SendTimeout,
+		errorRedactor:    config.ErrorRedactor,
+		debugErrors:      config.DebugErrors,
 	}
 }
@@ -83,6 +83,23 @@ func (p *ExamplePingServer) CumSum(ctx context.Context, stream *connect.BidiStre
 	}
 }
 
+func ExampleBidiChannels() {
+	// This handler is equivalent to ExamplePingServer's CumSum, but it ranges
+	// over incoming messages rather than calling Receive in a loop.
+	cumSum := func(ctx context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+		requests, send, wait := connect.BidiChannels(ctx, stream)
+		var sum int64
+		for msg := range requests {
+			sum += msg.GetNumber()
+			if err := send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+				return err
+			}
+		}
+		return wait()
+	}
+	_ = cumSum
+}
+
 func Example_handler() {
 	// protoc-gen-connect-go generates constructors that return plain net/http
 	// Handlers, so they're compatible with most Go HTTP routers and middleware
@@ -16,25 +16,37 @@ package connect_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	connect "connectrpc.com/connect"
 	"connectrpc.com/connect/internal/assert"
 	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
 	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp"
 	"connectrpc.com/connect/internal/memhttp/memhttptest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func TestHandler_ServeHTTP(t *testing.T) {
@@ -179,6 +191,10 @@ func TestHandler_ServeHTTP(t *testing.T) {
 			"application/grpc-web+json",
 			"application/grpc-web+json; charset=utf-8",
 			"application/grpc-web+proto",
+			"application/grpc-web-text",
+			"application/grpc-web-text+json",
+			"application/grpc-web-text+json; charset=utf-8",
+			"application/grpc-web-text+proto",
 			"application/json",
 			"application/json; charset=utf-8",
 			"application/proto",
@@ -201,6 +217,34 @@ func TestHandler_ServeHTTP(t *testing.T) {
 		assert.Equal(t, resp.StatusCode, http.StatusOK)
 	})
 
+	t.Run("params_in_content_type_header", func(t *testing.T) {
+		t.Parallel()
+		for contentType, want := range map[string]string{
+			"application/json; charset=utf-8; foo=bar": "application/json",
+			"application/json; boundary=abc":           "application/json",
+			"application/proto; boundary=abc":          "application/proto",
+			"application/proto; charset=UTF-8":         "application/proto",
+		} {
+			body := "{}"
+			if strings.HasSuffix(want, "proto") {
+				body = ""
+			}
+			req, err := http.NewRequestWithContext(
+				context.Background(),
+				http.MethodPost,
+				server.URL()+pingProcedure,
+				strings.NewReader(body),
+			)
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", contentType)
+			resp, err := client.Do(req)
+			assert.Nil(t, err)
+			assert.Equal(t, resp.StatusCode, http.StatusOK, assert.Sprintf("content type %q", contentType))
+			assert.Equal(t, resp.Header.Get("Content-Type"), want, assert.Sprintf("content type %q", contentType))
+			assert.Nil(t, resp.Body.Close())
+		}
+	})
+
 	t.Run("unsupported_charset", func(t *testing.T) {
 		t.Parallel()
 		req, err := http.NewRequestWithContext(
@@ -245,6 +289,1726 @@ func TestHandler_ServeHTTP(t *testing.T) {
 	})
 }
 
+func TestHandlerPeerTLS(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				response := connect.NewResponse(&pingv1.PingResponse{})
+				if state := request.Peer().TLS; state != nil {
+					response.Header().Set("Peer-Certificates", strconv.Itoa(len(state.PeerCertificates)))
+				}
+				return response, nil
+			},
+			countUp: func(_ context.Context, request *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				if state := request.Peer().TLS; state != nil {
+					stream.ResponseHeader().Set("Peer-Certificates", strconv.Itoa(len(state.PeerCertificates)))
+				}
+				return nil
+			},
+		},
+	))
+	assertPeerCertificates := func(t *testing.T, client pingv1connect.PingServiceClient, want string) {
+		t.Helper()
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Header().Get("Peer-Certificates"), want)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Nil(t, stream.Err())
+		assert.Equal(t, stream.ResponseHeader().Get("Peer-Certificates"), want)
+		assert.Nil(t, stream.Close())
+	}
+	t.Run("mtls", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewUnstartedServer(mux)
+		server.EnableHTTP2 = true
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert} //nolint:gosec
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		httpClient := server.Client()
+		transport, ok := httpClient.Transport.(*http.Transport)
+		assert.True(t, ok)
+		// For simplicity, the client presents the server's certificate.
+		transport.TLSClientConfig.Certificates = server.TLS.Certificates
+		for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb()} {
+			client := pingv1connect.NewPingServiceClient(httpClient, server.URL, opt)
+			assertPeerCertificates(t, client, "1")
+		}
+	})
+	t.Run("h2c", func(t *testing.T) {
+		t.Parallel()
+		server := memhttptest.NewServer(t, mux)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithGRPC())
+		assertPeerCertificates(t, client, "")
+	})
+}
+
+func TestWithContextValues(t *testing.T) {
+	t.Parallel()
+	type contextKey struct{}
+	appendValue := func(ctx context.Context, value string) context.Context {
+		previous, _ := ctx.Value(contextKey{}).(string)
+		return context.WithValue(ctx, contextKey{}, previous+value)
+	}
+	var interceptorSaw string
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				value, _ := ctx.Value(contextKey{}).(string)
+				return connect.NewResponse(&pingv1.PingResponse{Text: value}), nil
+			},
+			countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				value, _ := ctx.Value(contextKey{}).(string)
+				stream.ResponseHeader().Set("Value", value)
+				return nil
+			},
+		},
+		connect.WithContextValues(func(ctx context.Context, request *http.Request) context.Context {
+			return appendValue(ctx, request.Header.Get("Tenant"))
+		}),
+		connect.WithContextValues(func(ctx context.Context, request *http.Request) context.Context {
+			assert.True(t, connect.HTTPRequest(ctx) == request)
+			return appendValue(ctx, "/second")
+		}),
+		connect.WithInterceptors(connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+			return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+				interceptorSaw, _ = ctx.Value(contextKey{}).(string)
+				return next(ctx, request)
+			}
+		})),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+	request := connect.NewRequest(&pingv1.PingRequest{})
+	request.Header().Set("Tenant", "acme")
+	response, err := client.Ping(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.GetText(), "acme/second")
+	assert.Equal(t, interceptorSaw, "acme/second")
+
+	// Values are per request.
+	streamRequest := connect.NewRequest(&pingv1.CountUpRequest{})
+	streamRequest.Header().Set("Tenant", "initech")
+	stream, err := client.CountUp(context.Background(), streamRequest)
+	assert.Nil(t, err)
+	assert.False(t, stream.Receive())
+	assert.Nil(t, stream.Err())
+	assert.Equal(t, stream.ResponseHeader().Get("Value"), "initech/second")
+	assert.Nil(t, stream.Close())
+}
+
+func TestServerStreamFlushesEachMessage(t *testing.T) {
+	t.Parallel()
+	const messages = 5
+	received := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+			for i := 1; i <= messages; i++ {
+				if err := stream.Send(&pingv1.CountUpResponse{Number: int64(i)}); err != nil {
+					return err
+				}
+				// Don't send message k+1 until the client has received message k.
+				// If Send buffered, the client would never see message k.
+				select {
+				case <-received:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+		var opts []connect.ClientOption
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		for i := 1; i <= messages; i++ {
+			assert.True(t, stream.Receive())
+			assert.Equal(t, stream.Msg().GetNumber(), int64(i))
+			select {
+			case received <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		assert.False(t, stream.Receive())
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		cancel()
+	}
+}
+
+func TestWithMaxStreamsPerConn(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+			countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				if err := stream.Send(&pingv1.CountUpResponse{Number: 1}); err != nil {
+					return err
+				}
+				started <- struct{}{}
+				select {
+				case <-release:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		},
+		connect.WithMaxStreamsPerConn(2),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var streams []*connect.ServerStreamForClient[pingv1.CountUpResponse]
+	for i := 0; i < 2; i++ {
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		<-started
+		streams = append(streams, stream)
+	}
+	// The limit is shared by all the service's procedures.
+	_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+	assert.Nil(t, err)
+	assert.False(t, stream.Receive())
+	assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeResourceExhausted)
+	assert.Nil(t, stream.Close())
+
+	// Finished streams free up their slots.
+	release <- struct{}{}
+	assert.False(t, streams[0].Receive())
+	assert.Nil(t, streams[0].Err())
+	assert.Nil(t, streams[0].Close())
+	_, err = client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	close(release)
+	assert.False(t, streams[1].Receive())
+	assert.Nil(t, streams[1].Close())
+}
+
+func TestHandlerRequireTLS(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithRequireTLS()))
+	mux.Handle("/proxied"+pingv1connect.PingServicePingProcedure, http.StripPrefix("/proxied", connect.NewUnaryHandler(
+		pingv1connect.PingServicePingProcedure,
+		pingServer{}.Ping,
+		connect.WithRequireTLSBehindProxy(func(request *http.Request) bool {
+			return request.Header.Get("Proxy-Secret") == "trusted"
+		}),
+	)))
+	ping := func(t *testing.T, httpClient *http.Client, url string, header http.Header) error {
+		t.Helper()
+		client := pingv1connect.NewPingServiceClient(httpClient, url, connect.WithGRPC())
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		for key, values := range header {
+			request.Header()[key] = values
+		}
+		_, err := client.Ping(context.Background(), request)
+		return err
+	}
+	t.Run("tls", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewUnstartedServer(mux)
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		assert.Nil(t, ping(t, server.Client(), server.URL, nil))
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("cleartext", func(t *testing.T) {
+		t.Parallel()
+		server := memhttptest.NewServer(t, mux)
+		err := ping(t, server.Client(), server.URL(), nil)
+		assert.Equal(t, connect.CodeOf(err), connect.CodePermissionDenied)
+		assert.Equal(t, err.Error(), "permission_denied: "+pingv1connect.PingServicePingProcedure+" requires TLS")
+		client := pingv1connect.NewPingServiceClient(&http.Client{Transport: server.TransportHTTP1()}, server.URL())
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodePermissionDenied)
+		client = pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodePermissionDenied)
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("proxy", func(t *testing.T) {
+		t.Parallel()
+		server := memhttptest.NewServer(t, mux)
+		url := server.URL() + "/proxied"
+		err := ping(t, server.Client(), url, http.Header{
+			"Proxy-Secret":      []string{"trusted"},
+			"X-Forwarded-Proto": []string{"https"},
+		})
+		assert.Nil(t, err)
+		err = ping(t, server.Client(), url, http.Header{
+			"Proxy-Secret":      []string{"trusted"},
+			"X-Forwarded-Proto": []string{"http"},
+		})
+		assert.Equal(t, connect.CodeOf(err), connect.CodePermissionDenied)
+		// Untrusted clients can't bypass the check by setting the header.
+		err = ping(t, server.Client(), url, http.Header{"X-Forwarded-Proto": []string{"https"}})
+		assert.Equal(t, connect.CodeOf(err), connect.CodePermissionDenied)
+	})
+}
+
+func TestHandlerClientDisconnect(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{}, 1)
+	observed := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				started <- struct{}{}
+				<-ctx.Done()
+				observed <- ctx.Err()
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+			countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				started <- struct{}{}
+				<-ctx.Done()
+				err := stream.Send(&pingv1.CountUpResponse{Number: 1})
+				observed <- err
+				return err
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+		assert.ErrorIs(t, <-observed, context.Canceled)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+		if err == nil {
+			assert.False(t, stream.Receive())
+			err = stream.Err()
+			assert.Nil(t, stream.Close())
+		}
+		assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+		// Sending after the client disconnects fails fast with a coded error,
+		// rather than a raw I/O error.
+		sendErr := <-observed
+		assert.Equal(t, connect.CodeOf(sendErr), connect.CodeCanceled)
+		assert.ErrorIs(t, sendErr, context.Canceled)
+	}
+}
+
+func TestClientStreamHalfClose(t *testing.T) {
+	t.Parallel()
+	type result struct {
+		received int
+		err      error
+	}
+	started := make(chan struct{}, 1)
+	results := make(chan result, 1)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			sum: func(_ context.Context, stream *connect.ClientStream[pingv1.SumRequest]) (*connect.Response[pingv1.SumResponse], error) {
+				var res result
+				for stream.Receive() {
+					if res.received == 0 {
+						started <- struct{}{}
+					}
+					res.received++
+				}
+				res.err = stream.Err()
+				results <- res
+				if res.err != nil {
+					return nil, res.err
+				}
+				return connect.NewResponse(&pingv1.SumResponse{Sum: int64(res.received)}), nil
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPC(), connect.WithGRPCWeb()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opt)
+
+		stream := client.Sum(context.Background())
+		for i := 0; i < 3; i++ {
+			assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+		}
+		response, err := stream.CloseAndReceive()
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetSum(), 3)
+		<-started
+		res := <-results
+		assert.Equal(t, res.received, 3)
+		assert.Nil(t, res.err)
+
+		// Canceling isn't a half-close: the handler sees an error.
+		ctx, cancel := context.WithCancel(context.Background())
+		stream = client.Sum(ctx)
+		assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+		<-started
+		cancel()
+		res = <-results
+		assert.Equal(t, res.received, 1)
+		assert.NotNil(t, res.err)
+		assert.False(t, errors.Is(res.err, io.EOF))
+		_, err = stream.CloseAndReceive()
+		assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+	}
+}
+
+func TestServerSentEvents(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithServerSentEvents()))
+	server := memhttptest.NewServer(t, mux)
+	get := func(t *testing.T, procedure string, query url.Values) (*http.Response, string) {
+		t.Helper()
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodGet,
+			server.URL()+procedure+"?"+query.Encode(),
+			http.NoBody,
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Accept", "text/event-stream")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		return response, string(body)
+	}
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+		response, body := get(t, pingv1connect.PingServiceCountUpProcedure, url.Values{
+			"message": []string{`{"number": 2}`},
+		})
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Type"), "text/event-stream")
+		assert.Equal(t, response.Header.Get("Cache-Control"), "no-cache")
+		assert.Equal(t, response.Header.Get(handlerHeader), headerValue)
+		assert.Equal(t, body, fmt.Sprintf(
+			"data: {\"number\":\"1\"}\n\n"+
+				"data: {\"number\":\"2\"}\n\n"+
+				"event: end\ndata: {\"metadata\":{%q:[%q]}}\n\n",
+			handlerTrailer,
+			trailerValue,
+		))
+	})
+	t.Run("base64_proto", func(t *testing.T) {
+		t.Parallel()
+		data, err := proto.Marshal(&pingv1.CountUpRequest{Number: 1})
+		assert.Nil(t, err)
+		response, body := get(t, pingv1connect.PingServiceCountUpProcedure, url.Values{
+			"message":  []string{base64.RawURLEncoding.EncodeToString(data)},
+			"encoding": []string{"proto"},
+			"base64":   []string{"1"},
+		})
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.True(t, strings.HasPrefix(body, "data: {\"number\":\"1\"}\n\n"))
+	})
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		response, body := get(t, pingv1connect.PingServiceCountUpProcedure, url.Values{
+			"message": []string{`{"number": 0}`},
+		})
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.True(t, strings.HasPrefix(body, "event: error\ndata: "))
+		var wireErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(body), "event: error\ndata: ")), &wireErr))
+		assert.Equal(t, wireErr.Code, connect.CodeInvalidArgument.String())
+		assert.Equal(t, wireErr.Message, "number must be positive: got 0")
+	})
+	t.Run("invalid_encoding", func(t *testing.T) {
+		t.Parallel()
+		_, body := get(t, pingv1connect.PingServiceCountUpProcedure, url.Values{
+			"message":  []string{`{}`},
+			"encoding": []string{"xml"},
+		})
+		assert.True(t, strings.HasPrefix(body, "event: error\ndata: {\"code\":\"invalid_argument\""))
+	})
+	t.Run("not_server_stream", func(t *testing.T) {
+		t.Parallel()
+		// Bidi and client streams don't accept GETs at all.
+		response, _ := get(t, pingv1connect.PingServiceSumProcedure, url.Values{})
+		assert.Equal(t, response.StatusCode, http.StatusMethodNotAllowed)
+	})
+	t.Run("connect_client", func(t *testing.T) {
+		t.Parallel()
+		// Ordinary clients are unaffected.
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+		assert.Nil(t, err)
+		var received int
+		for stream.Receive() {
+			received++
+		}
+		assert.Nil(t, stream.Err())
+		assert.Equal(t, received, 2)
+		assert.Nil(t, stream.Close())
+	})
+}
+
+func TestHTTPRequestFromContext(t *testing.T) {
+	t.Parallel()
+	cookieValue := func(ctx context.Context) string {
+		request := connect.HTTPRequest(ctx)
+		if request == nil {
+			return "no request"
+		}
+		cookie, err := request.Cookie("session")
+		if err != nil {
+			return err.Error()
+		}
+		return cookie.Value
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Text: cookieValue(ctx)}), nil
+			},
+			countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				stream.ResponseHeader().Set("Session", cookieValue(ctx))
+				return nil
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+
+	request := connect.NewRequest(&pingv1.PingRequest{})
+	request.Header().Set("Cookie", "session=abc")
+	response, err := client.Ping(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.GetText(), "abc")
+
+	streamRequest := connect.NewRequest(&pingv1.CountUpRequest{})
+	streamRequest.Header().Set("Cookie", "session=def")
+	stream, err := client.CountUp(context.Background(), streamRequest)
+	assert.Nil(t, err)
+	assert.False(t, stream.Receive())
+	assert.Nil(t, stream.Err())
+	assert.Equal(t, stream.ResponseHeader().Get("Session"), "def")
+	assert.Nil(t, stream.Close())
+
+	assert.Nil(t, connect.HTTPRequest(context.Background()))
+}
+
+func TestHandlerErrorDetailDebug(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				connectErr := connect.NewError(connect.CodeUnavailable, errors.New("try later"))
+				detail, err := connect.NewErrorDetail(durationpb.New(time.Second))
+				if err != nil {
+					return nil, err
+				}
+				connectErr.AddDetail(detail)
+				return nil, connectErr
+			},
+		},
+	))
+	server := memhttptest.NewServer(t, mux)
+	// Call the handler the way a browser's fetch would, without any Connect
+	// or Protobuf runtime.
+	request, err := http.NewRequestWithContext(
+		context.Background(),
+		http.MethodPost,
+		server.URL()+pingv1connect.PingServicePingProcedure,
+		strings.NewReader("{}"),
+	)
+	assert.Nil(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := server.Client().Do(request)
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, response.StatusCode, http.StatusServiceUnavailable)
+	var body struct {
+		Code    string `json:"code"`
+		Details []struct {
+			Type  string         `json:"type"`
+			Value string         `json:"value"`
+			Debug map[string]any `json:"debug"`
+		} `json:"details"`
+	}
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&body))
+	assert.Equal(t, body.Code, "unavailable")
+	assert.Equal(t, len(body.Details), 1)
+	assert.Equal(t, body.Details[0].Type, "google.protobuf.Duration")
+	assert.NotZero(t, body.Details[0].Value)
+	assert.Equal(t, body.Details[0].Debug["value"], any("1s"))
+}
+
+func TestWithHandlerAcceptCompression(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithHandlerAcceptCompression(),
+		connect.WithCompressMinBytes(1),
+	))
+	server := memhttptest.NewServer(t, mux)
+	t.Run("raw", func(t *testing.T) {
+		t.Parallel()
+		var body bytes.Buffer
+		writer := gzip.NewWriter(&body)
+		_, err := writer.Write([]byte(`{"number": 42, "text": "foobar"}`))
+		assert.Nil(t, err)
+		assert.Nil(t, writer.Close())
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL()+pingv1connect.PingServicePingProcedure,
+			&body,
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Content-Encoding", "gzip")
+		request.Header.Set("Accept-Encoding", "gzip")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		// The request is decompressed, but the response isn't compressed and
+		// the handler doesn't advertise gzip.
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Encoding"), "")
+		assert.Equal(t, response.Header.Get("Accept-Encoding"), "")
+		var got pingv1.PingResponse
+		data, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, protojson.Unmarshal(data, &got))
+		assert.Equal(t, got.GetNumber(), 42)
+	})
+	t.Run("unknown_compression", func(t *testing.T) {
+		t.Parallel()
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL()+pingv1connect.PingServicePingProcedure,
+			strings.NewReader("{}"),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Content-Encoding", "invalid")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		// Requests may still use gzip, so the error lists it even though it's
+		// not advertised.
+		var message struct {
+			Message string `json:"message"`
+		}
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&message))
+		assert.Equal(t, message.Message, `unknown compression "invalid": supported encodings are gzip`)
+	})
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+		opts := []connect.ClientOption{connect.WithSendGzip()}
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetNumber(), 42)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+		assert.Nil(t, err)
+		for stream.Receive() {
+			assert.NotZero(t, stream.Msg().GetNumber())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	}
+}
+
+func TestWithCompressionSelector(t *testing.T) {
+	t.Parallel()
+	var sawAccepted sync.Map
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithCompressMinBytes(1),
+		connect.WithCompressionSelector(func(_ context.Context, spec connect.Spec, accepted []string) string {
+			sawAccepted.Store(spec.Procedure, accepted)
+			switch spec.Procedure {
+			case pingv1connect.PingServicePingProcedure:
+				return "identity" // under load
+			case pingv1connect.PingServiceCountUpProcedure:
+				return "br" // unsupported
+			default:
+				return accepted[0]
+			}
+		}),
+	))
+	server := memhttptest.NewServer(t, mux)
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb(), nil} {
+		var opts []connect.ClientOption
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+		request.Header().Set(clientHeader, headerValue)
+		response, err := client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Compression(), "identity")
+		accepted, _ := sawAccepted.Load(pingv1connect.PingServicePingProcedure)
+		assert.Equal(t, accepted, any([]string{"gzip"}))
+
+		countUpRequest := connect.NewRequest(&pingv1.CountUpRequest{Number: 2})
+		countUpRequest.Header().Set(clientHeader, headerValue)
+		stream, err := client.CountUp(context.Background(), countUpRequest)
+		assert.Nil(t, err)
+		for stream.Receive() {
+			assert.NotZero(t, stream.Msg().GetNumber())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Equal(t, stream.ResponseHeader().Get("Connect-Content-Encoding"), "")
+		assert.Equal(t, stream.ResponseHeader().Get("Grpc-Encoding"), "")
+		assert.Nil(t, stream.Close())
+
+		sum := client.Sum(context.Background())
+		sum.RequestHeader().Set(clientHeader, headerValue)
+		assert.Nil(t, sum.Send(&pingv1.SumRequest{Number: 1}))
+		sumResponse, err := sum.CloseAndReceive()
+		assert.Nil(t, err)
+		assert.Equal(t, sumResponse.Compression(), "gzip")
+	}
+}
+
+func TestWithSlowRequestThreshold(t *testing.T) {
+	t.Parallel()
+	logs := make(chan string, 10)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				if request.Msg.GetText() == "slow" {
+					time.Sleep(100 * time.Millisecond)
+					return nil, connect.NewError(connect.CodeUnavailable, errors.New(errorMessage))
+				}
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+			countUp: func(_ context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				if err := stream.Send(&pingv1.CountUpResponse{Number: 1}); err != nil {
+					return err
+				}
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			},
+		},
+		connect.WithLogger(channelLogger(logs)),
+		connect.WithSlowRequestThreshold(50*time.Millisecond),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "slow"}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+	line := <-logs
+	assert.True(t, strings.HasPrefix(line, "WARN slow RPC procedure="+pingv1connect.PingServicePingProcedure+" code=unavailable duration="))
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+	assert.Nil(t, err)
+	for stream.Receive() {
+		assert.Equal(t, stream.Msg().GetNumber(), 1)
+	}
+	assert.Nil(t, stream.Err())
+	assert.Nil(t, stream.Close())
+	line = <-logs
+	assert.True(t, strings.HasPrefix(line, "WARN slow RPC procedure="+pingv1connect.PingServiceCountUpProcedure+" code=ok duration="))
+	// The fast call didn't log.
+	assert.Equal(t, len(logs), 0)
+}
+
+func TestHandlerStrictJSON(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithStrictJSON()))
+	strictServer := memhttptest.NewServer(t, mux)
+	mux = http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	lenientServer := memhttptest.NewServer(t, mux)
+	post := func(t *testing.T, server *memhttp.Server, body string) (*http.Response, []byte) {
+		t.Helper()
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL()+pingv1connect.PingServicePingProcedure,
+			strings.NewReader(body),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		responseBody, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		return response, responseBody
+	}
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, strictServer, `{"number": 1}`)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, string(body), `{"number":"1"}`)
+		response, body = post(t, strictServer, `{"numbr": 1}`)
+		assert.Equal(t, response.StatusCode, http.StatusBadRequest)
+		var wireErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		assert.Nil(t, json.Unmarshal(body, &wireErr))
+		assert.Equal(t, wireErr.Code, connect.CodeInvalidArgument.String())
+		assert.True(t, strings.Contains(wireErr.Message, `unknown field "numbr"`))
+	})
+	t.Run("lenient", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, lenientServer, `{"numbr": 1}`)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, string(body), `{}`)
+	})
+}
+
+func TestWithResponseHeaderFunc(t *testing.T) {
+	t.Parallel()
+	var interceptorSaw string
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				if request.Msg.GetText() == "fail" {
+					return nil, connect.NewError(connect.CodeInternal, errors.New("oops"))
+				}
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+			countUp: func(_ context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				return stream.Send(&pingv1.CountUpResponse{Number: 1})
+			},
+		},
+		connect.WithResponseHeaderFunc(func(_ context.Context, spec connect.Spec, header http.Header) {
+			header.Set("Cache-Control", "no-store")
+			header.Set("Procedure", spec.Procedure)
+		}),
+		connect.WithResponseHeaderFunc(func(_ context.Context, _ connect.Spec, header http.Header) {
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("Cache-Control", header.Get("Cache-Control")+", private")
+		}),
+		connect.WithInterceptors(connect.StreamingHandlerInterceptorFunc(
+			func(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+				return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+					interceptorSaw = conn.ResponseHeader().Get("X-Content-Type-Options")
+					return next(ctx, conn)
+				}
+			},
+		)),
+	))
+	server := memhttptest.NewServer(t, mux)
+	assertHeaders := func(t *testing.T, header http.Header, procedure string) {
+		t.Helper()
+		assert.Equal(t, header.Get("Cache-Control"), "no-store, private")
+		assert.Equal(t, header.Get("X-Content-Type-Options"), "nosniff")
+		assert.Equal(t, header.Get("Procedure"), procedure)
+	}
+	for _, protocol := range []struct {
+		name string
+		opt  connect.ClientOption
+	}{
+		{name: "connect", opt: connect.WithProtoJSON()},
+		{name: "grpc", opt: connect.WithGRPC()},
+		{name: "grpcweb", opt: connect.WithGRPCWeb()},
+	} {
+		protocol := protocol
+		t.Run(protocol.name, func(t *testing.T) {
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opt)
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+			assertHeaders(t, response.Header(), pingv1connect.PingServicePingProcedure)
+
+			_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "fail"}))
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assertHeaders(t, connectErr.Meta(), pingv1connect.PingServicePingProcedure)
+
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+			assert.Nil(t, err)
+			for stream.Receive() {
+				assert.NotNil(t, stream.Msg())
+			}
+			assert.Nil(t, stream.Err())
+			assertHeaders(t, stream.ResponseHeader(), pingv1connect.PingServiceCountUpProcedure)
+			assert.Nil(t, stream.Close())
+			assert.Equal(t, interceptorSaw, "nosniff")
+		})
+	}
+}
+
+func TestWithEchoHeaders(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				if request.Msg.GetText() == "fail" {
+					return nil, connect.NewError(connect.CodeInternal, errors.New("oops"))
+				}
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+			countUp: func(_ context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				return stream.Send(&pingv1.CountUpResponse{Number: 1})
+			},
+		},
+		connect.WithEchoHeaders("X-Debug-"),
+	))
+	server := memhttptest.NewServer(t, mux)
+	setHeaders := func(header http.Header) {
+		header.Set("X-Debug-Trace", "abc")
+		header.Set("X-Other", "ignored")
+		header.Set("Authorization", "Bearer secret")
+	}
+	assertHeaders := func(t *testing.T, header http.Header) {
+		t.Helper()
+		assert.Equal(t, header.Get("X-Debug-Trace"), "abc")
+		assert.Equal(t, header.Get("X-Other"), "")
+		assert.Equal(t, header.Get("Authorization"), "")
+	}
+	for _, protocol := range []struct {
+		name string
+		opt  connect.ClientOption
+	}{
+		{name: "connect", opt: connect.WithProtoJSON()},
+		{name: "grpc", opt: connect.WithGRPC()},
+		{name: "grpcweb", opt: connect.WithGRPCWeb()},
+	} {
+		protocol := protocol
+		t.Run(protocol.name, func(t *testing.T) {
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opt)
+			request := connect.NewRequest(&pingv1.PingRequest{})
+			setHeaders(request.Header())
+			response, err := client.Ping(context.Background(), request)
+			assert.Nil(t, err)
+			assertHeaders(t, response.Header())
+
+			request = connect.NewRequest(&pingv1.PingRequest{Text: "fail"})
+			setHeaders(request.Header())
+			_, err = client.Ping(context.Background(), request)
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assertHeaders(t, connectErr.Meta())
+
+			countUpRequest := connect.NewRequest(&pingv1.CountUpRequest{})
+			setHeaders(countUpRequest.Header())
+			stream, err := client.CountUp(context.Background(), countUpRequest)
+			assert.Nil(t, err)
+			for stream.Receive() {
+				assert.NotNil(t, stream.Msg())
+			}
+			assert.Nil(t, stream.Err())
+			assertHeaders(t, stream.ResponseHeader())
+			assert.Nil(t, stream.Close())
+		})
+	}
+}
+
+func TestWithErrorRedactor(t *testing.T) {
+	t.Parallel()
+	detail, err := connect.NewErrorDetail(&pingv1.PingRequest{Text: "keep me"})
+	assert.Nil(t, err)
+	var intercepted atomic.Value
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				connectErr := connect.NewError(connect.CodeInternal, errors.New("dial postgres://admin:hunter2@db"))
+				connectErr.AddDetail(detail)
+				connectErr.Meta().Set("Retry-Hint", "later")
+				return nil, connectErr
+			},
+			sum: func(context.Context, *connect.ClientStream[pingv1.SumRequest]) (*connect.Response[pingv1.SumResponse], error) {
+				return nil, errors.New("open /etc/secrets/key.pem: permission denied")
+			},
+			countUp: func(context.Context, *connect.Request[pingv1.CountUpRequest], *connect.ServerStream[pingv1.CountUpResponse]) error {
+				return connect.NewError(connect.CodeNotFound, errors.New("no such widget"))
+			},
+		},
+		connect.WithInterceptors(connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+			return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+				res, err := next(ctx, request)
+				intercepted.Store(err)
+				return res, err
+			}
+		})),
+		connect.WithErrorRedactor(func(err *connect.Error) *connect.Error {
+			if err.Code() != connect.CodeInternal && err.Code() != connect.CodeUnknown {
+				return nil
+			}
+			redacted := connect.NewError(err.Code(), errors.New("internal error"))
+			for _, detail := range err.Details() {
+				redacted.AddDetail(detail)
+			}
+			for key, values := range err.Meta() {
+				redacted.Meta()[key] = values
+			}
+			return redacted
+		}),
+	))
+	server := memhttptest.NewServer(t, mux)
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+		{name: "grpcweb", opts: []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		t.Run(protocol.name, func(t *testing.T) {
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opts...)
+			ctx := context.Background()
+			var connectErr *connect.Error
+
+			_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeInternal)
+			assert.Equal(t, connectErr.Message(), "internal error")
+			assert.Equal(t, connectErr.Meta().Get("Retry-Hint"), "later")
+			assert.Equal(t, len(connectErr.Details()), 1)
+			value, err := connectErr.Details()[0].Value()
+			assert.Nil(t, err)
+			assert.True(t, proto.Equal(value, &pingv1.PingRequest{Text: "keep me"}))
+			// Interceptors see the original error.
+			originalErr, _ := intercepted.Load().(error)
+			var original *connect.Error
+			assert.True(t, errors.As(originalErr, &original))
+			assert.Equal(t, original.Message(), "dial postgres://admin:hunter2@db")
+
+			_, err = client.Sum(ctx).CloseAndReceive()
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeUnknown)
+			assert.Equal(t, connectErr.Message(), "internal error")
+
+			stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+			assert.Nil(t, err)
+			assert.False(t, stream.Receive())
+			assert.True(t, errors.As(stream.Err(), &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeNotFound)
+			assert.Equal(t, connectErr.Message(), "no such widget")
+			assert.Nil(t, stream.Close())
+		})
+	}
+}
+
+func TestWithErrorRedactorAndETags(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithETags(),
+		connect.WithErrorRedactor(func(err *connect.Error) *connect.Error {
+			return connect.NewError(err.Code(), errors.New("internal error"))
+		}),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithHTTPGet())
+	response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+	assert.Nil(t, err)
+	etag := response.Header().Get("Etag")
+	assert.NotZero(t, etag)
+	request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+	request.Header().Set("If-None-Match", etag)
+	_, err = client.Ping(context.Background(), request)
+	assert.True(t, connect.IsNotModifiedError(err))
+}
+
+func TestWithSendTimeout(t *testing.T) {
+	t.Parallel()
+	const procedure = "/connect.ping.v1.PingService/Ping"
+	text := strings.Repeat("a", 64*1024)
+	newServer := func(t *testing.T, count int, errs chan<- error) *memhttp.Server {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.Handle(procedure, connect.NewServerStreamHandler(
+			procedure,
+			func(_ context.Context, _ *connect.Request[pingv1.PingRequest], stream *connect.ServerStream[pingv1.PingResponse]) error {
+				var err error
+				for i := 0; i < count && err == nil; i++ {
+					err = stream.Send(&pingv1.PingResponse{Number: int64(i), Text: text})
+				}
+				errs <- err
+				return err
+			},
+			connect.WithSendTimeout(200*time.Millisecond),
+			// Compression would shrink the messages enough to avoid flow control.
+			connect.WithCompression("gzip", nil, nil),
+		))
+		return memhttptest.NewServer(t, mux)
+	}
+	t.Run("slow_client", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		server := newServer(t, 1024, errs) // far more than the HTTP/2 flow control window
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](server.Client(), server.URL()+procedure)
+		stream, err := client.CallServerStream(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		t.Cleanup(func() { _ = stream.Close() })
+		assert.True(t, stream.Receive())
+		// Stop reading, so the handler's sends eventually stall.
+		select {
+		case err := <-errs:
+			assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+		case <-time.After(10 * time.Second):
+			t.Fatal("handler's send didn't time out")
+		}
+	})
+	t.Run("fast_client", func(t *testing.T) {
+		t.Parallel()
+		const count = 256
+		errs := make(chan error, 1)
+		server := newServer(t, count, errs)
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](server.Client(), server.URL()+procedure)
+		stream, err := client.CallServerStream(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		var received int
+		for stream.Receive() {
+			received++
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, received, count)
+		assert.Nil(t, <-errs)
+	})
+	t.Run("get_with_etags", func(t *testing.T) {
+		t.Parallel()
+		// The send timeout wraps the handler's conn, which must still expose
+		// the request's HTTP method and codec.
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(
+			&pluggablePingServer{
+				ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+					if request.HTTPMethod() != http.MethodGet || request.Codec() != "proto" {
+						return nil, connect.NewError(connect.CodeInternal, fmt.Errorf(
+							"got method %q and codec %q", request.HTTPMethod(), request.Codec(),
+						))
+					}
+					return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+				},
+			},
+			connect.WithSendTimeout(time.Second),
+			connect.WithETags(),
+		))
+		server := memhttptest.NewServer(t, mux)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithHTTPGet())
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err)
+		etag := response.Header().Get("Etag")
+		assert.NotZero(t, etag)
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+		request.Header().Set("If-None-Match", etag)
+		_, err = client.Ping(context.Background(), request)
+		assert.True(t, connect.IsNotModifiedError(err))
+	})
+}
+
+func TestInFlightTracker(t *testing.T) {
+	t.Parallel()
+	const numUnary, numStreaming = 5, 2
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(numUnary + numStreaming)
+	tracker := connect.NewInFlightTracker()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				started.Done()
+				<-release
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+			countUp: func(_ context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				started.Done()
+				<-release
+				return stream.Send(&pingv1.CountUpResponse{Number: 1})
+			},
+		},
+		connect.WithInFlightTracker(tracker),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+	assert.Nil(t, tracker.Wait(context.Background())) // nothing in flight
+
+	var finished sync.WaitGroup
+	for i := 0; i < numUnary; i++ {
+		finished.Add(1)
+		go func() {
+			defer finished.Done()
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+		}()
+	}
+	for i := 0; i < numStreaming; i++ {
+		finished.Add(1)
+		go func() {
+			defer finished.Done()
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+			assert.Nil(t, err)
+			for stream.Receive() {
+			}
+			assert.Nil(t, stream.Err())
+			assert.Nil(t, stream.Close())
+		}()
+	}
+	started.Wait()
+	assert.Equal(t, tracker.Unary(), numUnary)
+	assert.Equal(t, tracker.Streaming(), numStreaming)
+	assert.Equal(t, tracker.InFlight(), numUnary+numStreaming)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, tracker.Wait(ctx), context.DeadlineExceeded)
+
+	close(release)
+	assert.Nil(t, tracker.Wait(context.Background()))
+	assert.Equal(t, tracker.InFlight(), 0)
+	assert.Equal(t, tracker.Unary(), 0)
+	assert.Equal(t, tracker.Streaming(), 0)
+	finished.Wait()
+}
+
+func TestInFlightTrackerRejectedRequests(t *testing.T) {
+	t.Parallel()
+	tracker := connect.NewInFlightTracker()
+	path, handler := pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{},
+		connect.WithInFlightTracker(tracker),
+		connect.WithRequireTLS(),
+	)
+	for _, testCase := range []struct {
+		name        string
+		method      string
+		contentType string
+		wantStatus  int
+	}{
+		{name: "method", method: http.MethodPut, contentType: "application/proto", wantStatus: http.StatusMethodNotAllowed},
+		{name: "content_type", method: http.MethodPost, contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "tls", method: http.MethodPost, contentType: "application/proto", wantStatus: http.StatusForbidden},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			request := httptest.NewRequest(testCase.method, path+"Ping", strings.NewReader(""))
+			request.Header.Set("Content-Type", testCase.contentType)
+			recorder := &inFlightRecorder{ResponseRecorder: httptest.NewRecorder(), tracker: tracker}
+			handler.ServeHTTP(recorder, request)
+			assert.Equal(t, recorder.Code, testCase.wantStatus)
+			assert.Equal(t, recorder.inFlight, 0)
+		})
+	}
+}
+
+// inFlightRecorder records how many RPCs a tracker counted when the response
+// status was written.
+type inFlightRecorder struct {
+	*httptest.ResponseRecorder
+
+	tracker  *connect.InFlightTracker
+	inFlight int
+}
+
+func (r *inFlightRecorder) WriteHeader(code int) {
+	r.inFlight = r.tracker.InFlight()
+	r.ResponseRecorder.WriteHeader(code)
+}
+
+func TestWithAllowDryRun(t *testing.T) {
+	t.Parallel()
+	// Dry runs must pass through interceptors, like this authorization check.
+	authorize := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+			if msg, ok := request.Any().(*pingv1.PingRequest); ok && msg.GetText() == "forbidden" {
+				return nil, connect.NewError(connect.CodePermissionDenied, errors.New("not allowed"))
+			}
+			return next(ctx, request)
+		}
+	})
+	newClient := func(t *testing.T, opts ...connect.HandlerOption) pingv1connect.PingServiceClient {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(
+			pingServer{},
+			append(opts, connect.WithInterceptors(authorize))...,
+		))
+		server := memhttptest.NewServer(t, mux)
+		return pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithGRPC())
+	}
+	newPing := func(msg *pingv1.PingRequest) *connect.Request[pingv1.PingRequest] {
+		request := connect.NewRequest(msg)
+		request.Header().Set("Connect-Dry-Run", "1")
+		return request
+	}
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		client := newClient(t, connect.WithAllowDryRun())
+		response, err := client.Ping(context.Background(), newPing(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetNumber(), 0)
+		assert.Equal(t, response.Header().Get("Connect-Dry-Run"), "1")
+
+		_, err = client.Ping(context.Background(), newPing(&pingv1.PingRequest{Text: "forbidden"}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodePermissionDenied)
+
+		// Without the header, the implementation runs as usual.
+		response, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetNumber(), 42)
+		assert.Equal(t, response.Header().Get("Connect-Dry-Run"), "")
+	})
+	t.Run("streaming", func(t *testing.T) {
+		t.Parallel()
+		client := newClient(t, connect.WithAllowDryRun())
+		countUpRequest := connect.NewRequest(&pingv1.CountUpRequest{Number: 3})
+		countUpRequest.Header().Set("Connect-Dry-Run", "1")
+		countUp, err := client.CountUp(context.Background(), countUpRequest)
+		assert.Nil(t, err)
+		assert.False(t, countUp.Receive())
+		assert.Nil(t, countUp.Err())
+		assert.Equal(t, countUp.ResponseHeader().Get("Connect-Dry-Run"), "1")
+		assert.Nil(t, countUp.Close())
+
+		sum := client.Sum(context.Background())
+		sum.RequestHeader().Set("Connect-Dry-Run", "1")
+		assert.Nil(t, sum.Send(&pingv1.SumRequest{Number: 1}))
+		assert.Nil(t, sum.Send(&pingv1.SumRequest{Number: 2}))
+		sumResponse, err := sum.CloseAndReceive()
+		assert.Nil(t, err)
+		assert.Equal(t, sumResponse.Msg.GetSum(), 0)
+		assert.Equal(t, sumResponse.Header().Get("Connect-Dry-Run"), "1")
+
+		cumSum := client.CumSum(context.Background())
+		cumSum.RequestHeader().Set("Connect-Dry-Run", "1")
+		assert.Nil(t, cumSum.Send(&pingv1.CumSumRequest{Number: 1}))
+		assert.Nil(t, cumSum.CloseRequest())
+		_, err = cumSum.Receive()
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, cumSum.ResponseHeader().Get("Connect-Dry-Run"), "1")
+		assert.Nil(t, cumSum.CloseResponse())
+	})
+	t.Run("not_allowed", func(t *testing.T) {
+		t.Parallel()
+		client := newClient(t)
+		response, err := client.Ping(context.Background(), newPing(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetNumber(), 42)
+		assert.Equal(t, response.Header().Get("Connect-Dry-Run"), "")
+	})
+}
+
+func TestWithStreamHeartbeat(t *testing.T) {
+	t.Parallel()
+	const procedure = "/connect.ping.v1.PingService/Ping"
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewServerStreamHandler(
+		procedure,
+		func(ctx context.Context, _ *connect.Request[pingv1.PingRequest], stream *connect.ServerStream[pingv1.PingResponse]) error {
+			if err := stream.Send(&pingv1.PingResponse{Number: 1}); err != nil {
+				return err
+			}
+			// A long computation, during which heartbeats keep the stream alive.
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return stream.Send(&pingv1.PingResponse{Number: 2})
+		},
+		connect.WithStreamHeartbeat(10*time.Millisecond),
+	))
+	const slowHeaderProcedure = "/connect.ping.v1.PingService/CountUp"
+	mux.Handle(slowHeaderProcedure, connect.NewServerStreamHandler(
+		slowHeaderProcedure,
+		func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+			// Heartbeats don't start before the first message, so they can't send
+			// the headers early.
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			stream.ResponseHeader().Set("Computed", "yes")
+			return stream.Send(&pingv1.CountUpResponse{Number: 1})
+		},
+		connect.WithStreamHeartbeat(time.Millisecond),
+	))
+	server := memhttptest.NewServer(t, mux)
+
+	t.Run("clients_discard_heartbeats", func(t *testing.T) {
+		t.Parallel()
+		for _, opts := range [][]connect.ClientOption{
+			{connect.WithAcceptHeartbeats()},
+			{connect.WithAcceptHeartbeats(), connect.WithGRPC()},
+			{connect.WithAcceptHeartbeats(), connect.WithGRPCWeb()},
+		} {
+			client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](server.Client(), server.URL()+procedure, opts...)
+			stream, err := client.CallServerStream(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+			var numbers []int64
+			for stream.Receive() {
+				numbers = append(numbers, stream.Msg().GetNumber())
+			}
+			assert.Nil(t, stream.Err())
+			assert.Nil(t, stream.Close())
+			assert.Equal(t, numbers, []int64{1, 2})
+		}
+	})
+	t.Run("wire", func(t *testing.T) {
+		t.Parallel()
+		countFlags := func(t *testing.T, acceptHeartbeat bool) map[byte]int {
+			t.Helper()
+			payload, err := proto.Marshal(&pingv1.PingRequest{})
+			assert.Nil(t, err)
+			body := make([]byte, 5, 5+len(payload))
+			binary.BigEndian.PutUint32(body[1:], uint32(len(payload)))
+			body = append(body, payload...)
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL()+procedure, bytes.NewReader(body))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", "application/connect+proto")
+			if acceptHeartbeat {
+				req.Header.Set("Connect-Accept-Heartbeat", "1")
+			}
+			res, err := server.Client().Do(req)
+			assert.Nil(t, err)
+			defer func() { assert.Nil(t, res.Body.Close()) }()
+			assert.Equal(t, res.StatusCode, http.StatusOK)
+			flags := make(map[byte]int)
+			for {
+				var prefix [5]byte
+				if _, err := io.ReadFull(res.Body, prefix[:]); errors.Is(err, io.EOF) {
+					return flags
+				} else if !assert.Nil(t, err) {
+					return flags
+				}
+				_, err := io.CopyN(io.Discard, res.Body, int64(binary.BigEndian.Uint32(prefix[1:])))
+				assert.Nil(t, err)
+				flags[prefix[0]]++
+			}
+		}
+		flags := countFlags(t, true)
+		assert.Equal(t, flags[0], 2)    // messages
+		assert.Equal(t, flags[0b10], 1) // end of stream
+		assert.True(t, flags[0b10000000] > 0, assert.Sprintf("heartbeats: %d", flags[0b10000000]))
+		// Without the header, clients don't get heartbeats.
+		assert.Equal(t, countFlags(t, false), map[byte]int{0: 2, 0b10: 1})
+	})
+	t.Run("headers_set_before_first_message", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[pingv1.CountUpRequest, pingv1.CountUpResponse](server.Client(), server.URL()+slowHeaderProcedure)
+		stream, err := client.CallServerStream(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Equal(t, stream.ResponseHeader().Get("Computed"), "yes")
+		assert.False(t, stream.Receive())
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("heartbeats_count_as_empty_frames", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+			server.Client(),
+			server.URL()+procedure,
+			connect.WithAcceptHeartbeats(),
+			connect.WithMaxEmptyStreamFrames(2),
+		)
+		stream, err := client.CallServerStream(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeResourceExhausted)
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("not_requested_by_default", func(t *testing.T) {
+		t.Parallel()
+		// Without WithAcceptHeartbeats, the handler doesn't send any, so they
+		// can't exceed the limit on empty frames.
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+			server.Client(),
+			server.URL()+procedure,
+			connect.WithMaxEmptyStreamFrames(2),
+		)
+		stream, err := client.CallServerStream(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		var numbers []int64
+		for stream.Receive() {
+			numbers = append(numbers, stream.Msg().GetNumber())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, numbers, []int64{1, 2})
+	})
+}
+
+func TestWithGRPCWebTrailerMode(t *testing.T) {
+	t.Parallel()
+	newServer := func(t *testing.T, mode connect.GRPCWebTrailerMode) *memhttp.Server {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(
+			&pluggablePingServer{
+				ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+					if request.Msg.GetText() == "fail" {
+						return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("oops"))
+					}
+					return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+				},
+			},
+			connect.WithGRPCWebTrailerMode(mode),
+		))
+		return memhttptest.NewServer(t, mux)
+	}
+	// Sends a raw gRPC-Web request, returning the response headers and whether
+	// the body ends with a trailer frame.
+	call := func(t *testing.T, server *memhttp.Server, msg *pingv1.PingRequest) (http.Header, bool) {
+		t.Helper()
+		data, err := proto.Marshal(msg)
+		assert.Nil(t, err)
+		body := make([]byte, 5, 5+len(data))
+		binary.BigEndian.PutUint32(body[1:], uint32(len(data)))
+		body = append(body, data...)
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL()+pingv1connect.PingServicePingProcedure,
+			bytes.NewReader(body),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/grpc-web+proto")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		responseBody, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		var sawTrailerFrame bool
+		for len(responseBody) >= 5 {
+			sawTrailerFrame = responseBody[0]&0x80 != 0
+			responseBody = responseBody[5+binary.BigEndian.Uint32(responseBody[1:5]):]
+		}
+		return response.Header, sawTrailerFrame
+	}
+	for _, testCase := range []struct {
+		name          string
+		mode          connect.GRPCWebTrailerMode
+		errorInHeader bool
+		errorInFrame  bool
+	}{
+		{name: "default", mode: connect.GRPCWebTrailerModeDefault, errorInHeader: true, errorInFrame: false},
+		{name: "frame", mode: connect.GRPCWebTrailerModeFrame, errorInHeader: false, errorInFrame: true},
+		{name: "duplicate", mode: connect.GRPCWebTrailerModeDuplicate, errorInHeader: true, errorInFrame: true},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			server := newServer(t, testCase.mode)
+			header, sawTrailerFrame := call(t, server, &pingv1.PingRequest{Text: "fail"})
+			assert.Equal(t, header.Get("Grpc-Status") != "", testCase.errorInHeader)
+			assert.Equal(t, sawTrailerFrame, testCase.errorInFrame)
+
+			header, sawTrailerFrame = call(t, server, &pingv1.PingRequest{Number: 42})
+			assert.Equal(t, header.Get("Grpc-Status"), "")
+			assert.True(t, sawTrailerFrame)
+
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithGRPCWeb())
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.GetNumber(), 42)
+			_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "fail"}))
+			assert.Equal(t, connect.CodeOf(err), connect.CodeFailedPrecondition)
+		})
+	}
+}
+
+func TestCustomEnvelopeFlags(t *testing.T) {
+	t.Parallel()
+	const (
+		snapshot uint8 = 0b00000100
+		reserved uint8 = 0b00000010
+	)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			// Echo each message's flags back to the client.
+			cumSum: func(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+				for {
+					msg, err := stream.Receive()
+					if errors.Is(err, io.EOF) {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					response := &pingv1.CumSumResponse{Sum: msg.GetNumber()}
+					if err := stream.SendWithFlags(response, stream.ReceivedFlags()); err != nil {
+						return err
+					}
+				}
+			},
+			countUp: func(_ context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				if err := stream.SendWithFlags(&pingv1.CountUpResponse{Number: 1}, reserved); err != nil {
+					return err
+				}
+				return stream.Send(&pingv1.CountUpResponse{Number: 2})
+			},
+		},
+		connect.WithCompressMinBytes(0),
+	))
+	server := memhttptest.NewServer(t, mux)
+
+	t.Run("connect", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithSendGzip(),
+			connect.WithCompressMinBytes(0),
+		)
+		stream := client.CumSum(context.Background())
+		for i, flags := range []uint8{snapshot, 0, connect.CustomEnvelopeFlags} {
+			assert.Nil(t, stream.SendWithFlags(&pingv1.CumSumRequest{Number: int64(i)}, flags))
+			msg, err := stream.Receive()
+			assert.Nil(t, err)
+			assert.Equal(t, msg.GetSum(), int64(i))
+			assert.Equal(t, stream.ReceivedFlags(), flags)
+		}
+		err := stream.SendWithFlags(&pingv1.CumSumRequest{}, reserved)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInternal)
+		// CreditControlledBidi's flag is reserved too.
+		err = stream.SendWithFlags(&pingv1.CumSumRequest{}, 0b00100000)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInternal)
+		assert.Nil(t, stream.CloseRequest())
+		_, err = stream.Receive()
+		assert.True(t, errors.Is(err, io.EOF))
+		assert.Nil(t, stream.CloseResponse())
+	})
+	t.Run("reserved_bits", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeInternal)
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("grpc", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithGRPC())
+		stream := client.CumSum(context.Background())
+		err := stream.SendWithFlags(&pingv1.CumSumRequest{Number: 1}, snapshot)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+		// Zero flags are always allowed.
+		assert.Nil(t, stream.SendWithFlags(&pingv1.CumSumRequest{Number: 1}, 0))
+		_, err = stream.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, stream.ReceivedFlags(), uint8(0))
+		assert.Nil(t, stream.CloseRequest())
+		assert.Nil(t, stream.CloseResponse())
+	})
+}
+
+func TestWithOnlyProtocol(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithOnlyProtocol(connect.ProtocolGRPC)))
+	path, handler := pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithOnlyProtocol(connect.ProtocolConnect))
+	mux.Handle("/connect"+path, http.StripPrefix("/connect", handler))
+	server := memhttptest.NewServer(t, mux)
+	ping := func(t *testing.T, url string, opts ...connect.ClientOption) error {
+		t.Helper()
+		client := pingv1connect.NewPingServiceClient(server.Client(), url, opts...)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		if err == nil {
+			assert.Equal(t, response.Msg.GetNumber(), 42)
+		}
+		return err
+	}
+
+	t.Run("grpc_only", func(t *testing.T) {
+		t.Parallel()
+		assert.Nil(t, ping(t, server.URL(), connect.WithGRPC()))
+		for _, opt := range []connect.ClientOption{connect.WithProtoJSON(), connect.WithGRPCWeb()} {
+			err := ping(t, server.URL(), opt)
+			assert.NotNil(t, err)
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.HTTPStatus(), http.StatusUnsupportedMediaType)
+		}
+	})
+	t.Run("connect_only", func(t *testing.T) {
+		t.Parallel()
+		assert.Nil(t, ping(t, server.URL()+"/connect"))
+		assert.Nil(t, ping(t, server.URL()+"/connect", connect.WithHTTPGet()))
+		err := ping(t, server.URL()+"/connect", connect.WithGRPC())
+		assert.NotNil(t, err)
+	})
+	t.Run("accept_post", func(t *testing.T) {
+		t.Parallel()
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL()+pingv1connect.PingServicePingProcedure,
+			strings.NewReader("{}"),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, response.StatusCode, http.StatusUnsupportedMediaType)
+		assert.Equal(t, response.Header.Get("Accept-Post"), strings.Join([]string{
+			"application/grpc",
+			"application/grpc+json",
+			"application/grpc+json; charset=utf-8",
+			"application/grpc+proto",
+		}, ", "))
+	})
+	t.Run("unknown_protocol", func(t *testing.T) {
+		t.Parallel()
+		assert.Panics(t, func() { connect.WithOnlyProtocol(connect.ProtocolSSE) })
+	})
+}
+
 func TestHandlerMaliciousPrefix(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
@@ -534,3 +2298,78 @@ type successPingServer struct {
 func (successPingServer) Ping(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
 	return &connect.Response[pingv1.PingResponse]{}, nil
 }
+
+// channelLogger sends each log entry to a channel as a line like
+// "WARN msg key=value".
+type channelLogger chan<- string
+
+func (l channelLogger) Debug(msg string, keyvals ...any) { l.log("DEBUG", msg, keyvals) }
+func (l channelLogger) Info(msg string, keyvals ...any)  { l.log("INFO", msg, keyvals) }
+func (l channelLogger) Warn(msg string, keyvals ...any)  { l.log("WARN", msg, keyvals) }
+
+func (l channelLogger) log(level, msg string, keyvals []any) {
+	var line strings.Builder
+	line.WriteString(level + " " + msg)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fmt.Fprintf(&line, " %v=%v", keyvals[i], keyvals[i+1])
+	}
+	l <- line.String()
+}
+
+func TestBidiChannels(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		cumSum: func(ctx context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			if stream.RequestHeader().Get("Cancel-Before-Receive") != "" {
+				ctx, cancel := context.WithCancel(ctx)
+				cancel()
+				_, _, wait := connect.BidiChannels(ctx, stream)
+				return wait()
+			}
+			requests, send, wait := connect.BidiChannels(ctx, stream)
+			var sum int64
+			for msg := range requests {
+				sum += msg.GetNumber()
+				if err := send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+					return err
+				}
+			}
+			return wait()
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+		{name: "grpcweb", opts: []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		protocol := protocol
+		t.Run(protocol.name, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opts...)
+			stream := client.CumSum(context.Background())
+			for i := int64(1); i <= 3; i++ {
+				assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: i}))
+				res, err := stream.Receive()
+				assert.Nil(t, err)
+				assert.Equal(t, res.GetSum(), i*(i+1)/2)
+			}
+			assert.Nil(t, stream.CloseRequest())
+			_, err := stream.Receive()
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Nil(t, stream.CloseResponse())
+
+			stream = client.CumSum(context.Background())
+			stream.RequestHeader().Set("Cancel-Before-Receive", "1")
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 1}))
+			_, err = stream.Receive()
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+			assert.Nil(t, stream.CloseRequest())
+			assert.Nil(t, stream.CloseResponse())
+		})
+	}
+}
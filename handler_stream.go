@@ -15,6 +15,7 @@
 package connect
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -51,6 +52,21 @@ func (c *ClientStream[Req]) RequestHeader() http.Header {
 // either by reaching the end or by encountering an unexpected error. After
 // Receive returns false, the Err method will return any unexpected error
 // encountered.
+//
+// When the client half-closes the stream (for example, by calling
+// [ClientStreamForClient.CloseAndReceive]), Receive returns false and Err
+// returns nil. Any other way of stopping the stream, including the client
+// canceling the call or disconnecting, leaves a non-nil error in Err. Handlers
+// can therefore loop until Receive returns false and then check Err to
+// distinguish a clean half-close from a failure:
+//
+//	for stream.Receive() {
+//		// process stream.Msg()
+//	}
+//	if err := stream.Err(); err != nil {
+//		return nil, err // the stream failed
+//	}
+//	// the client half-closed the stream
 func (c *ClientStream[Req]) Receive() bool {
 	if c.err != nil {
 		return false
@@ -72,7 +88,18 @@ func (c *ClientStream[Req]) Msg() *Req {
 	return c.msg
 }
 
-// Err returns the first non-EOF error that was encountered by Receive.
+// ReceivedFlags returns the custom envelope flags (see [CustomEnvelopeFlags])
+// of the most recent message unmarshaled by a call to Receive. It returns zero
+// if the message didn't have any custom flags, if the stream doesn't use the
+// Connect protocol, or if the stream is wrapped by an interceptor.
+func (c *ClientStream[Req]) ReceivedFlags() uint8 {
+	return receivedFlagsOf(c.conn) & CustomEnvelopeFlags
+}
+
+// Err returns the first non-EOF error that was encountered by Receive. It
+// returns nil if the client half-closed the stream. Code using the underlying
+// [StreamingHandlerConn] directly sees half-closes as errors that match
+// [io.EOF] with [errors.Is].
 func (c *ClientStream[Req]) Err() error {
 	if c.err == nil || errors.Is(c.err, io.EOF) {
 		return nil
@@ -104,7 +131,10 @@ func (s *ServerStream[Res]) ResponseHeader() http.Header {
 }
 
 // ResponseTrailer returns the response trailers. Handlers may write to the
-// response trailers at any time before returning.
+// response trailers at any time before returning. With the Connect protocol,
+// trailers are sent as the metadata of the final EndStream message, so they're
+// a good place for values only known at the end of the stream (for example,
+// pagination cursors or result counts).
 //
 // Trailers beginning with "Connect-" and "Grpc-" are reserved for use by the
 // Connect and gRPC protocols. Applications shouldn't write them.
@@ -112,8 +142,21 @@ func (s *ServerStream[Res]) ResponseTrailer() http.Header {
 	return s.conn.ResponseTrailer()
 }
 
+// ResponseCompression returns the name of the compression algorithm negotiated
+// for response messages, or "identity" if responses won't be compressed.
+// Messages smaller than the configured [WithCompressMinBytes] threshold are
+// always sent uncompressed.
+// Interceptors defined outside this package hide the negotiated algorithm, so
+// if one wraps the stream's conn, ResponseCompression always reports
+// "identity".
+func (s *ServerStream[Res]) ResponseCompression() string {
+	return responseCompressionOf(s.conn)
+}
+
 // Send a message to the client. The first call to Send also sends the response
-// headers.
+// headers. Each message is flushed to the network before Send returns, so
+// clients can receive it without waiting for later messages: handlers never
+// batch messages, and there's no option to make them do so.
 func (s *ServerStream[Res]) Send(msg *Res) error {
 	if msg == nil {
 		return s.conn.Send(nil)
@@ -121,6 +164,18 @@ func (s *ServerStream[Res]) Send(msg *Res) error {
 	return s.conn.Send(msg)
 }
 
+// SendWithFlags is like Send, but it also sets custom envelope flags on the
+// message, which clients can read with [ServerStreamForClient.ReceivedFlags].
+// The flags may only use the bits in [CustomEnvelopeFlags]. SendWithFlags
+// returns an error if the stream doesn't use the Connect protocol, or if the
+// stream is wrapped by an interceptor.
+func (s *ServerStream[Res]) SendWithFlags(msg *Res, flags uint8) error {
+	if msg == nil {
+		return sendWithCustomFlags(s.conn, nil, flags)
+	}
+	return sendWithCustomFlags(s.conn, msg, flags)
+}
+
 // Conn exposes the underlying StreamingHandlerConn. This may be useful if
 // you'd prefer to wrap the connection in a different high-level API.
 func (s *ServerStream[Res]) Conn() StreamingHandlerConn {
@@ -164,6 +219,14 @@ func (b *BidiStream[Req, Res]) Receive() (*Req, error) {
 	return &req, nil
 }
 
+// ReceivedFlags returns the custom envelope flags (see [CustomEnvelopeFlags])
+// of the message returned by the most recent call to Receive. It returns zero
+// if the message didn't have any custom flags, if the stream doesn't use the
+// Connect protocol, or if the stream is wrapped by an interceptor.
+func (b *BidiStream[Req, Res]) ReceivedFlags() uint8 {
+	return receivedFlagsOf(b.conn) & CustomEnvelopeFlags
+}
+
 // ResponseHeader returns the response headers. Headers are sent with the first
 // call to Send.
 //
@@ -174,7 +237,10 @@ func (b *BidiStream[Req, Res]) ResponseHeader() http.Header {
 }
 
 // ResponseTrailer returns the response trailers. Handlers may write to the
-// response trailers at any time before returning.
+// response trailers at any time before returning. With the Connect protocol,
+// trailers are sent as the metadata of the final EndStream message, so they're
+// a good place for values only known at the end of the stream (for example,
+// pagination cursors or result counts).
 //
 // Trailers beginning with "Connect-" and "Grpc-" are reserved for use by the
 // Connect and gRPC protocols. Applications shouldn't write them.
@@ -182,8 +248,20 @@ func (b *BidiStream[Req, Res]) ResponseTrailer() http.Header {
 	return b.conn.ResponseTrailer()
 }
 
+// ResponseCompression returns the name of the compression algorithm negotiated
+// for response messages, or "identity" if responses won't be compressed.
+// Messages smaller than the configured [WithCompressMinBytes] threshold are
+// always sent uncompressed.
+// Interceptors defined outside this package hide the negotiated algorithm, so
+// if one wraps the stream's conn, ResponseCompression always reports
+// "identity".
+func (b *BidiStream[Req, Res]) ResponseCompression() string {
+	return responseCompressionOf(b.conn)
+}
+
 // Send a message to the client. The first call to Send also sends the response
-// headers.
+// headers. Like [ServerStream.Send], each message is flushed to the network
+// before Send returns.
 func (b *BidiStream[Req, Res]) Send(msg *Res) error {
 	if msg == nil {
 		return b.conn.Send(nil)
@@ -191,8 +269,84 @@ func (b *BidiStream[Req, Res]) Send(msg *Res) error {
 	return b.conn.Send(msg)
 }
 
+// SendWithFlags is like Send, but it also sets custom envelope flags on the
+// message, which clients can read with [BidiStreamForClient.ReceivedFlags].
+// The flags may only use the bits in [CustomEnvelopeFlags]. SendWithFlags
+// returns an error if the stream doesn't use the Connect protocol, or if the
+// stream is wrapped by an interceptor.
+func (b *BidiStream[Req, Res]) SendWithFlags(msg *Res, flags uint8) error {
+	if msg == nil {
+		return sendWithCustomFlags(b.conn, nil, flags)
+	}
+	return sendWithCustomFlags(b.conn, msg, flags)
+}
+
 // Conn exposes the underlying StreamingHandlerConn. This may be useful if
 // you'd prefer to wrap the connection in a different high-level API.
 func (b *BidiStream[Req, Res]) Conn() StreamingHandlerConn {
 	return b.conn
 }
+
+// BidiChannels adapts a [BidiStream] to Go channels, so handlers can range
+// over incoming messages instead of calling Receive in a loop:
+//
+//	requests, send, wait := connect.BidiChannels(ctx, stream)
+//	for req := range requests {
+//		if err := send(respond(req)); err != nil {
+//			return err
+//		}
+//	}
+//	return wait()
+//
+// A goroutine receives messages from the client and delivers them on the
+// requests channel, which is unbuffered. The channel is closed when the client
+// finishes sending, when receiving fails, or when ctx is done. The wait
+// function blocks until the channel is closed and then returns the error that
+// closed it: nil if the client closed its side of the stream, the receive
+// error if receiving failed, or a [CodeCanceled] or [CodeDeadlineExceeded]
+// error if ctx was done first. The send function sends a message to the
+// client, failing fast if ctx is done. It's safe to call while the goroutine
+// is receiving, but like [BidiStream.Send] it must not be called concurrently
+// with itself.
+//
+// The adapter doesn't change the stream's behavior on the wire. Handlers that
+// return before the requests channel is closed should return an error or
+// cancel ctx, so the receiving goroutine exits promptly.
+func BidiChannels[Req, Res any](
+	ctx context.Context,
+	stream *BidiStream[Req, Res],
+) (requests <-chan *Req, send func(*Res) error, wait func() error) {
+	received := make(chan *Req)
+	done := make(chan struct{})
+	var receiveErr error
+	go func() {
+		defer close(done)
+		defer close(received)
+		for {
+			msg, err := stream.Receive()
+			if errors.Is(err, io.EOF) {
+				return
+			} else if err != nil {
+				receiveErr = err
+				return
+			}
+			select {
+			case received <- msg:
+			case <-ctx.Done():
+				receiveErr = wrapIfContextError(ctx.Err())
+				return
+			}
+		}
+	}()
+	send = func(msg *Res) error {
+		if err := ctx.Err(); err != nil {
+			return wrapIfContextError(err)
+		}
+		return stream.Send(msg)
+	}
+	wait = func() error {
+		<-done
+		return receiveErr
+	}
+	return received, send, wait
+}
@@ -0,0 +1,91 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect/internal/assert"
+)
+
+func TestSendTimeoutConn(t *testing.T) {
+	t.Parallel()
+	newConn := func(sendErr error) (*sendTimeoutConn, *recordingDeadlineSetter) {
+		setter := &recordingDeadlineSetter{}
+		return &sendTimeoutConn{
+			StreamingHandlerConn: &fakeSendConn{err: sendErr},
+			setter:               setter,
+			timeout:              time.Second,
+			serverDeadline:       time.Unix(1234, 0),
+		}, setter
+	}
+	t.Run("restores_server_deadline", func(t *testing.T) {
+		t.Parallel()
+		conn, setter := newConn(nil)
+		assert.Nil(t, conn.Send(nil))
+		assert.Equal(t, len(setter.deadlines), 2)
+		assert.False(t, setter.deadlines[0].IsZero())
+		assert.Equal(t, setter.deadlines[1], time.Unix(1234, 0))
+	})
+	t.Run("deadline_exceeded", func(t *testing.T) {
+		t.Parallel()
+		conn, _ := newConn(fmt.Errorf("write: %w", os.ErrDeadlineExceeded))
+		err := conn.Send(nil)
+		assert.Equal(t, CodeOf(err), CodeDeadlineExceeded)
+		assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	})
+	t.Run("other_errors", func(t *testing.T) {
+		t.Parallel()
+		conn, _ := newConn(NewError(CodeCanceled, context.Canceled))
+		conn.timeout = 0 // every send is "slow"
+		assert.Equal(t, CodeOf(conn.Send(nil)), CodeCanceled)
+	})
+}
+
+func TestServerWriteDeadline(t *testing.T) {
+	t.Parallel()
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.True(t, serverWriteDeadline(request).IsZero())
+	server := &http.Server{WriteTimeout: time.Minute}
+	ctx := context.WithValue(request.Context(), http.ServerContextKey, server)
+	deadline := serverWriteDeadline(request.WithContext(ctx))
+	assert.True(t, deadline.After(time.Now().Add(59*time.Second)))
+}
+
+type recordingDeadlineSetter struct {
+	deadlines []time.Time
+}
+
+func (s *recordingDeadlineSetter) SetWriteDeadline(deadline time.Time) error {
+	s.deadlines = append(s.deadlines, deadline)
+	return nil
+}
+
+type fakeSendConn struct {
+	StreamingHandlerConn
+
+	err error
+}
+
+func (c *fakeSendConn) Send(any) error {
+	return c.err
+}
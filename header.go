@@ -16,7 +16,9 @@ package connect
 
 import (
 	"encoding/base64"
+	"fmt"
 	"net/http"
+	"strings"
 )
 
 // EncodeBinaryHeader base64-encodes the data. It always emits unpadded values.
@@ -31,7 +33,8 @@ func EncodeBinaryHeader(data []byte) string {
 // DecodeBinaryHeader base64-decodes the data. It can decode padded or unpadded
 // values. Following usual HTTP semantics, multiple base64-encoded values may
 // be joined with a comma. When receiving such comma-separated values, split
-// them with [strings.Split] before calling DecodeBinaryHeader.
+// them with [strings.Split] before calling DecodeBinaryHeader, or use
+// [DecodeBinaryHeaderValues] to decode them all at once.
 //
 // Binary headers sent using the Connect, gRPC, and gRPC-Web protocols have
 // keys ending in "-Bin".
@@ -45,12 +48,78 @@ func DecodeBinaryHeader(data string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(data)
 }
 
+// DecodeBinaryHeaderValues decodes every value of a binary header. Values may
+// be sent as repeated header fields, joined with commas, or both, so
+// DecodeBinaryHeaderValues looks at all fields for the key and splits each one
+// on commas. It returns the decoded values in order, or nil if the header
+// isn't present. The key is canonicalized, as with [http.Header.Values].
+//
+// Binary headers sent using the Connect, gRPC, and gRPC-Web protocols have
+// keys ending in "-Bin".
+func DecodeBinaryHeaderValues(header http.Header, key string) ([][]byte, error) {
+	var decoded [][]byte
+	for _, field := range header.Values(key) {
+		for _, value := range strings.Split(field, ",") {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			data, err := DecodeBinaryHeader(value)
+			if err != nil {
+				return nil, fmt.Errorf("decode %s: %w", http.CanonicalHeaderKey(key), err)
+			}
+			decoded = append(decoded, data)
+		}
+	}
+	return decoded, nil
+}
+
 func mergeHeaders(into, from http.Header) {
 	for k, vals := range from {
 		into[k] = append(into[k], vals...)
 	}
 }
 
+// echoHeaders appends the request headers whose names start with prefix to
+// the response headers, skipping any that mustn't cross a hop or that are
+// owned by the RPC protocol.
+func echoHeaders(into, from http.Header, prefix string) {
+	prefix = strings.ToLower(prefix)
+	connectionTokens := make(map[string]struct{})
+	for _, value := range from["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			connectionTokens[http.CanonicalHeaderKey(strings.TrimSpace(token))] = struct{}{}
+		}
+	}
+	for key, vals := range from {
+		if !strings.HasPrefix(strings.ToLower(key), prefix) || !isEchoableHeader(key) {
+			continue
+		}
+		if _, ok := connectionTokens[key]; ok {
+			continue
+		}
+		into[key] = append(into[key], vals...)
+	}
+}
+
+// isEchoableHeader reports whether a canonical request header may be copied
+// into the response. Hop-by-hop headers, credentials, and protocol headers
+// are excluded.
+func isEchoableHeader(key string) bool {
+	switch key {
+	case "Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate",
+		"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+		"Authorization", "Cookie", "Set-Cookie", headerHost:
+		return false
+	}
+	for _, reserved := range []string{"Content-", "Accept-", "Grpc-", "Connect-"} {
+		if strings.HasPrefix(key, reserved) {
+			return false
+		}
+	}
+	return true
+}
+
 // getHeaderCanonical is a shortcut for Header.Get() which
 // bypasses the CanonicalMIMEHeaderKey operation when we
 // know the key is already in canonical form.
@@ -39,6 +39,26 @@ func TestBinaryEncodingQuick(t *testing.T) {
 	}
 }
 
+func TestDecodeBinaryHeaderValues(t *testing.T) {
+	t.Parallel()
+	header := http.Header{}
+	header.Add("Trace-Bin", EncodeBinaryHeader([]byte("one")))
+	header.Add("Trace-Bin", EncodeBinaryHeader([]byte("two"))+", "+EncodeBinaryHeader([]byte("three")))
+	header.Add("Trace-Bin", "")
+	values, err := DecodeBinaryHeaderValues(header, "trace-bin")
+	assert.Nil(t, err)
+	assert.Equal(t, values, [][]byte{[]byte("one"), []byte("two"), []byte("three")})
+
+	values, err = DecodeBinaryHeaderValues(header, "Missing-Bin")
+	assert.Nil(t, err)
+	assert.Zero(t, len(values))
+
+	header.Add("Trace-Bin", "!!!")
+	_, err = DecodeBinaryHeaderValues(header, "Trace-Bin")
+	assert.NotNil(t, err)
+	assert.Match(t, err.Error(), "^decode Trace-Bin: ")
+}
+
 func TestHeaderMerge(t *testing.T) {
 	t.Parallel()
 	header := http.Header{
@@ -56,3 +76,35 @@ func TestHeaderMerge(t *testing.T) {
 	}
 	assert.Equal(t, header, expect)
 }
+
+func TestEchoHeaders(t *testing.T) {
+	t.Parallel()
+	header := http.Header{
+		"X-Debug-Trace": []string{"existing"},
+	}
+	echoHeaders(header, http.Header{
+		"X-Debug-Trace":   []string{"abc"},
+		"X-Debug-User":    []string{"alice", "bob"},
+		"X-Debug-Hop":     []string{"private"},
+		"X-Other":         []string{"ignored"},
+		"Connection":      []string{"keep-alive, x-debug-hop"},
+		"Authorization":   []string{"Bearer secret"},
+		"Content-Type":    []string{"application/proto"},
+		"Grpc-Timeout":    []string{"1S"},
+		"Accept-Encoding": []string{"gzip"},
+	}, "x-debug-")
+	expect := http.Header{
+		"X-Debug-Trace": []string{"existing", "abc"},
+		"X-Debug-User":  []string{"alice", "bob"},
+	}
+	assert.Equal(t, header, expect)
+
+	header = http.Header{}
+	echoHeaders(header, http.Header{
+		"Cookie":                  []string{"session=secret"},
+		"Te":                      []string{"trailers"},
+		"Connect-Accept-Encoding": []string{"gzip"},
+		"X-Request-Id":            []string{"123"},
+	}, "")
+	assert.Equal(t, header, http.Header{"X-Request-Id": []string{"123"}})
+}
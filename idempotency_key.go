@@ -0,0 +1,60 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"net/http"
+)
+
+// headerIdempotencyKey carries the key set with WithIdempotencyKey.
+const headerIdempotencyKey = "Idempotency-Key"
+
+// WithIdempotencyKey returns a copy of ctx that attaches an idempotency key to
+// calls made with it, so servers that deduplicate requests can apply each
+// call's side effects at most once:
+//
+//	ctx = connect.WithIdempotencyKey(ctx, orderID)
+//	res, err := client.PlaceOrder(ctx, req)
+//
+// The key is sent in the Idempotency-Key request header, replacing any value
+// set on the request. If the key is empty, each call made with the context
+// generates its own random key instead. Because it's carried by the context,
+// the key also applies to calls made by generated clients.
+//
+// The header is set once per call, so every attempt of a call sends the same
+// key: requests replayed by the HTTP transport (see [WithMaxCallAttempts]),
+// requests retried with a fallback protocol, and requests resent by
+// interceptors that call the next function again. Separate calls get different
+// keys only if the key is empty, so derive a new context for each logical
+// operation when supplying keys.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+type idempotencyKeyContextKey struct{}
+
+// setIdempotencyKey sets the Idempotency-Key header for a new call if the
+// context carries a key from WithIdempotencyKey.
+func setIdempotencyKey(ctx context.Context, header http.Header) {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	if !ok {
+		return
+	}
+	if key == "" {
+		key = newUUID()
+	}
+	header.Set(headerIdempotencyKey, key)
+}
@@ -0,0 +1,114 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestWithIdempotencyKey(t *testing.T) {
+	t.Parallel()
+	const uuidPattern = `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		defer func() { keys = nil }()
+		return keys
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			mu.Lock()
+			defer mu.Unlock()
+			keys = append(keys, request.Header().Get("Idempotency-Key"))
+			return connect.NewResponse(&pingv1.PingResponse{}), nil
+		},
+		countUp: func(_ context.Context, request *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+			stream.ResponseHeader().Set("Received-Key", request.Header().Get("Idempotency-Key"))
+			return nil
+		},
+		cumSum: func(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			stream.ResponseHeader().Set("Received-Key", stream.RequestHeader().Get("Idempotency-Key"))
+			return nil
+		},
+	}))
+	server := memhttptest.NewServer(t, mux)
+	// The transport sends every unary request twice, like a transport replaying
+	// a request after a lost connection.
+	client := pingv1connect.NewPingServiceClient(
+		&http.Client{Transport: replayingRoundTripper{server.Transport()}},
+		server.URL(),
+	)
+
+	t.Run("supplied", func(t *testing.T) {
+		ctx := connect.WithIdempotencyKey(context.Background(), "order-42")
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		request.Header().Set("Idempotency-Key", "overridden")
+		_, err := client.Ping(ctx, request)
+		assert.Nil(t, err)
+		assert.Equal(t, received(), []string{"order-42", "order-42"})
+	})
+	t.Run("generated", func(t *testing.T) {
+		ctx := connect.WithIdempotencyKey(context.Background(), "")
+		for i := 0; i < 2; i++ {
+			_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+		}
+		got := received()
+		assert.Equal(t, len(got), 4)
+		assert.Match(t, got[0], uuidPattern)
+		// Attempts of the same call share a key, but separate calls don't.
+		assert.Equal(t, got[1], got[0])
+		assert.Equal(t, got[3], got[2])
+		assert.NotEqual(t, got[2], got[0])
+	})
+	t.Run("unset", func(t *testing.T) {
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, received(), []string{"", ""})
+	})
+	t.Run("streams", func(t *testing.T) {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		ctx := connect.WithIdempotencyKey(context.Background(), "order-42")
+		serverStream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		assert.False(t, serverStream.Receive())
+		assert.Nil(t, serverStream.Err())
+		assert.Equal(t, serverStream.ResponseHeader().Get("Received-Key"), "order-42")
+		assert.Nil(t, serverStream.Close())
+
+		bidiStream := client.CumSum(ctx)
+		assert.Nil(t, bidiStream.CloseRequest())
+		_, err = bidiStream.Receive()
+		assert.True(t, errors.Is(err, io.EOF))
+		assert.Equal(t, bidiStream.ResponseHeader().Get("Received-Key"), "order-42")
+		assert.Nil(t, bidiStream.CloseResponse())
+	})
+}
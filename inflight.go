@@ -0,0 +1,113 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// An InFlightTracker counts the RPCs that handlers are currently serving.
+// Readiness probes can use the counts to report load, and servers shutting
+// down gracefully can use Wait to drain in-flight calls before exiting.
+// Attach a tracker to handlers with [WithInFlightTracker]; passing the same
+// tracker to several handlers (or to a generated NewXServiceHandler
+// constructor) counts the RPCs of all of them together.
+//
+// Counting is cheap: starting and finishing an RPC each update two atomic
+// counters. The zero value isn't usable; construct trackers with
+// [NewInFlightTracker].
+type InFlightTracker struct {
+	unary     atomic.Int64
+	streaming atomic.Int64
+	total     atomic.Int64
+
+	mu   sync.Mutex
+	idle chan struct{} // closed when total drops to zero; nil if nobody's waiting
+}
+
+// NewInFlightTracker constructs an InFlightTracker.
+func NewInFlightTracker() *InFlightTracker {
+	return &InFlightTracker{}
+}
+
+// Unary returns the number of unary RPCs in flight.
+func (t *InFlightTracker) Unary() int {
+	return int(t.unary.Load())
+}
+
+// Streaming returns the number of client, server, and bidirectional streaming
+// RPCs in flight.
+func (t *InFlightTracker) Streaming() int {
+	return int(t.streaming.Load())
+}
+
+// InFlight returns the total number of RPCs in flight.
+func (t *InFlightTracker) InFlight() int {
+	return int(t.total.Load())
+}
+
+// Wait blocks until no RPCs are in flight or the context is done, in which
+// case it returns the context's error. It doesn't stop new RPCs from
+// starting, so during graceful shutdown, call it after the server stops
+// accepting requests (for example, after starting [http.Server.Shutdown]).
+func (t *InFlightTracker) Wait(ctx context.Context) error {
+	if t.total.Load() == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+	// The last RPC may have finished before we created the channel.
+	if t.total.Load() == 0 {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *InFlightTracker) start(streamType StreamType) {
+	if streamType == StreamTypeUnary {
+		t.unary.Add(1)
+	} else {
+		t.streaming.Add(1)
+	}
+	t.total.Add(1)
+}
+
+func (t *InFlightTracker) finish(streamType StreamType) {
+	if streamType == StreamTypeUnary {
+		t.unary.Add(-1)
+	} else {
+		t.streaming.Add(-1)
+	}
+	if t.total.Add(-1) != 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
@@ -41,6 +41,19 @@ type StreamingHandlerFunc func(context.Context, StreamingHandlerConn) error
 // else.
 //
 // The returned functions must be safe to call concurrently.
+//
+// Interceptors that hold resources for the duration of an RPC, like rate-limit
+// tokens or tracing spans, can rely on a well-defined lifecycle. Unary RPCs and
+// streaming handlers end when the wrapped function returns, so cleanup deferred
+// in an interceptor always runs, even if an interceptor further down the chain
+// or the implementation itself fails or panics. On the client, a streaming RPC
+// ends when CloseResponse is called on the [StreamingClientConn] returned by
+// the wrapped function, so interceptors should release resources there. The
+// generated clients call CloseResponse on every error path of
+// CallServerStream and CallClientStream's CloseAndReceive, and whenever the
+// caller closes a stream; callers must always close server and bidirectional
+// streams. Since CloseResponse may be called more than once, wrappers should
+// make their cleanup idempotent, for example with a [sync.Once].
 type Interceptor interface {
 	WrapUnary(UnaryFunc) UnaryFunc
 	WrapStreamingClient(StreamingClientFunc) StreamingClientFunc
@@ -64,6 +77,48 @@ func (f UnaryInterceptorFunc) WrapStreamingHandler(next StreamingHandlerFunc) St
 	return next
 }
 
+// StreamingClientInterceptorFunc is a simple Interceptor implementation that
+// only wraps streaming RPCs on the client side. It has no effect on unary RPCs
+// or on handlers.
+type StreamingClientInterceptorFunc func(StreamingClientFunc) StreamingClientFunc
+
+// WrapUnary implements [Interceptor] with a no-op.
+func (f StreamingClientInterceptorFunc) WrapUnary(next UnaryFunc) UnaryFunc {
+	return next
+}
+
+// WrapStreamingClient implements [Interceptor] by applying the interceptor
+// function.
+func (f StreamingClientInterceptorFunc) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return f(next)
+}
+
+// WrapStreamingHandler implements [Interceptor] with a no-op.
+func (f StreamingClientInterceptorFunc) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return next
+}
+
+// StreamingHandlerInterceptorFunc is a simple Interceptor implementation that
+// only wraps streaming RPCs on the handler side. It has no effect on unary
+// RPCs or on clients.
+type StreamingHandlerInterceptorFunc func(StreamingHandlerFunc) StreamingHandlerFunc
+
+// WrapUnary implements [Interceptor] with a no-op.
+func (f StreamingHandlerInterceptorFunc) WrapUnary(next UnaryFunc) UnaryFunc {
+	return next
+}
+
+// WrapStreamingClient implements [Interceptor] with a no-op.
+func (f StreamingHandlerInterceptorFunc) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler implements [Interceptor] by applying the interceptor
+// function.
+func (f StreamingHandlerInterceptorFunc) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return f(next)
+}
+
 // A chain composes multiple interceptors into one.
 type chain struct {
 	interceptors []Interceptor
@@ -103,3 +158,40 @@ func (c *chain) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandler
 	}
 	return next
 }
+
+// A conditionalInterceptor applies an interceptor only to calls whose Spec
+// matches.
+type conditionalInterceptor struct {
+	match       func(Spec) bool
+	interceptor Interceptor
+}
+
+func (c *conditionalInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	wrapped := c.interceptor.WrapUnary(next)
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if c.match(request.Spec()) {
+			return wrapped(ctx, request)
+		}
+		return next(ctx, request)
+	}
+}
+
+func (c *conditionalInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	wrapped := c.interceptor.WrapStreamingClient(next)
+	return func(ctx context.Context, spec Spec) StreamingClientConn {
+		if c.match(spec) {
+			return wrapped(ctx, spec)
+		}
+		return next(ctx, spec)
+	}
+}
+
+func (c *conditionalInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	wrapped := c.interceptor.WrapStreamingHandler(next)
+	return func(ctx context.Context, conn StreamingHandlerConn) error {
+		if c.match(conn.Spec()) {
+			return wrapped(ctx, conn)
+		}
+		return next(ctx, conn)
+	}
+}
@@ -16,8 +16,11 @@ package connect_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"testing"
 
@@ -189,6 +192,91 @@ func TestEmptyUnaryInterceptorFunc(t *testing.T) {
 	assert.Nil(t, countUpStream.Close())
 }
 
+func TestStreamingInterceptorFuncs(t *testing.T) {
+	t.Parallel()
+	var clientCalls, handlerCalls atomic.Int32
+	clientInterceptor := connect.StreamingClientInterceptorFunc(func(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+		return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+			assert.True(t, spec.IsClient)
+			clientCalls.Add(1)
+			return next(ctx, spec)
+		}
+	})
+	handlerInterceptor := connect.StreamingHandlerInterceptorFunc(func(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+		return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+			assert.False(t, conn.Spec().IsClient)
+			handlerCalls.Add(1)
+			return next(ctx, conn)
+		}
+	})
+	// Install both interceptors on both sides: each should only take effect on
+	// its own side, and never for unary RPCs.
+	interceptors := connect.WithInterceptors(clientInterceptor, handlerInterceptor)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, interceptors))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), interceptors)
+
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.Equal(t, clientCalls.Load(), 0)
+	assert.Equal(t, handlerCalls.Load(), 0)
+
+	countUpStream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+	assert.Nil(t, err)
+	for countUpStream.Receive() {
+		assert.NotNil(t, countUpStream.Msg())
+	}
+	assert.Nil(t, countUpStream.Close())
+	assert.Equal(t, clientCalls.Load(), 1)
+	assert.Equal(t, handlerCalls.Load(), 1)
+}
+
+func TestConditionalInterceptor(t *testing.T) {
+	t.Parallel()
+	var clientCalls, handlerCalls atomic.Int32
+	counter := func(calls *atomic.Int32) connect.Interceptor {
+		return &callCounter{calls: calls}
+	}
+	isStreaming := func(spec connect.Spec) bool { return spec.StreamType != connect.StreamTypeUnary }
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.ForProcedures(counter(&handlerCalls), pingv1connect.PingServicePingProcedure),
+		connect.WithConditionalInterceptor(isStreaming, counter(&handlerCalls)),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.ForProcedures(counter(&clientCalls), pingv1connect.PingServicePingProcedure),
+		connect.WithConditionalInterceptor(isStreaming, counter(&clientCalls)),
+	)
+
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.Equal(t, clientCalls.Load(), 1)
+	assert.Equal(t, handlerCalls.Load(), 1)
+
+	// Fail is neither listed nor streaming.
+	_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeInternal)}))
+	assert.NotNil(t, err)
+	assert.Equal(t, clientCalls.Load(), 1)
+	assert.Equal(t, handlerCalls.Load(), 1)
+
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+	assert.Nil(t, err)
+	for stream.Receive() {
+		assert.NotNil(t, stream.Msg())
+	}
+	assert.Nil(t, stream.Close())
+	assert.Equal(t, clientCalls.Load(), 2)
+	assert.Equal(t, handlerCalls.Load(), 2)
+
+	// A nil match must not silently drop the interceptor.
+	assert.Panics(t, func() { connect.WithConditionalInterceptor(nil, counter(&clientCalls)) })
+}
+
 func TestInterceptorFuncAccessingHTTPMethod(t *testing.T) {
 	t.Parallel()
 	clientChecker := &httpMethodChecker{client: true}
@@ -235,12 +323,216 @@ func TestInterceptorFuncAccessingHTTPMethod(t *testing.T) {
 	assert.Equal(t, int32(2), handlerChecker.count.Load())
 }
 
+func TestInterceptorFuncAccessingCodec(t *testing.T) {
+	t.Parallel()
+	// Records the request and response codecs seen by a unary interceptor.
+	newRecorder := func(codecs *[2]string) connect.UnaryInterceptorFunc {
+		return func(next connect.UnaryFunc) connect.UnaryFunc {
+			return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+				codecs[0] = request.Codec()
+				response, err := next(ctx, request)
+				if err != nil {
+					return nil, err
+				}
+				codecs[1] = response.Codec()
+				return response, nil
+			}
+		}
+	}
+	for _, testCase := range []struct {
+		name  string
+		opts  []connect.ClientOption
+		codec string
+	}{
+		{name: "connect_proto", codec: "proto"},
+		{name: "connect_json", opts: []connect.ClientOption{connect.WithProtoJSON()}, codec: "json"},
+		{name: "grpc_proto", opts: []connect.ClientOption{connect.WithGRPC()}, codec: "proto"},
+		{name: "grpcweb_json", opts: []connect.ClientOption{connect.WithGRPCWeb(), connect.WithProtoJSON()}, codec: "json"},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			var handlerCodecs, clientCodecs [2]string
+			var streamCodec string
+			mux := http.NewServeMux()
+			mux.Handle(pingv1connect.NewPingServiceHandler(
+				&pluggablePingServer{
+					ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+						return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+					},
+					countUp: func(_ context.Context, request *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+						streamCodec = request.Codec()
+						return stream.Send(&pingv1.CountUpResponse{Number: 1})
+					},
+				},
+				connect.WithInterceptors(newRecorder(&handlerCodecs)),
+			))
+			server := memhttptest.NewServer(t, mux)
+			opts := append([]connect.ClientOption{connect.WithInterceptors(newRecorder(&clientCodecs))}, testCase.opts...)
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...)
+
+			request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+			assert.Equal(t, request.Codec(), "")
+			response, err := client.Ping(context.Background(), request)
+			assert.Nil(t, err)
+			assert.Equal(t, request.Codec(), testCase.codec)
+			assert.Equal(t, response.Codec(), testCase.codec)
+			assert.Equal(t, handlerCodecs, [2]string{testCase.codec, testCase.codec})
+			assert.Equal(t, clientCodecs, [2]string{testCase.codec, testCase.codec})
+
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+			assert.Nil(t, err)
+			for stream.Receive() {
+				assert.NotNil(t, stream.Msg())
+			}
+			assert.Nil(t, stream.Err())
+			assert.Nil(t, stream.Close())
+			assert.Equal(t, streamCodec, testCase.codec)
+		})
+	}
+}
+
 // headerInterceptor makes it easier to write interceptors that inspect or
 // mutate HTTP headers. It applies the same logic to unary and streaming
 // procedures, wrapping the send or receive side of the stream as appropriate.
 //
 // It's useful as a testing harness to make sure that we're chaining
 // interceptors in the correct order.
+func TestStreamingValidationAbortsUpload(t *testing.T) {
+	t.Parallel()
+	var received atomic.Int32
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			sum: func(_ context.Context, stream *connect.ClientStream[pingv1.SumRequest]) (*connect.Response[pingv1.SumResponse], error) {
+				var sum int64
+				for stream.Receive() {
+					received.Add(1)
+					sum += stream.Msg().GetNumber()
+				}
+				if err := stream.Err(); err != nil {
+					return nil, err
+				}
+				return connect.NewResponse(&pingv1.SumResponse{Sum: sum}), nil
+			},
+		},
+		connect.WithInterceptors(validatingInterceptor{}),
+	))
+	server := memhttptest.NewServer(t, mux)
+	for _, protocol := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{name: "connect"},
+		{name: "grpc", opts: []connect.ClientOption{connect.WithGRPC()}},
+		{name: "grpcweb", opts: []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		t.Run(protocol.name, func(t *testing.T) {
+			received.Store(0)
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opts...)
+			stream := client.Sum(context.Background())
+			assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+			assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: -1}))
+			// The server rejects the stream without waiting for the client to
+			// finish uploading, so the response arrives before CloseRequest.
+			conn, err := stream.Conn()
+			assert.Nil(t, err)
+			assert.NotZero(t, conn.ResponseHeader().Get("Content-Type"))
+			// Depending on when the client notices that the server has stopped
+			// reading, the third message may or may not be written.
+			if err := stream.Send(&pingv1.SumRequest{Number: 3}); err != nil {
+				assert.ErrorIs(t, err, io.EOF)
+			}
+			_, err = stream.CloseAndReceive()
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeInvalidArgument)
+			assert.Equal(t, connectErr.Message(), "number must not be negative")
+			assert.Equal(t, received.Load(), 1)
+		})
+	}
+}
+
+func TestInterceptorTeardown(t *testing.T) {
+	t.Parallel()
+	errInner := errors.New("inner interceptor failed")
+	t.Run("handler", func(t *testing.T) {
+		t.Parallel()
+		var cleanups atomic.Int32
+		outer := connect.StreamingHandlerInterceptorFunc(func(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+			return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+				defer cleanups.Add(1)
+				return next(ctx, conn)
+			}
+		})
+		inner := connect.StreamingHandlerInterceptorFunc(func(connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+			return func(context.Context, connect.StreamingHandlerConn) error {
+				return connect.NewError(connect.CodeResourceExhausted, errInner)
+			}
+		})
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithInterceptors(outer, inner)))
+		server := memhttptest.NewServer(t, mux)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeResourceExhausted)
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, cleanups.Load(), 1)
+	})
+	t.Run("client", func(t *testing.T) {
+		t.Parallel()
+		var cleanups atomic.Int32
+		outer := connect.StreamingClientInterceptorFunc(func(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+			return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+				return &cleanupClientConn{StreamingClientConn: next(ctx, spec), cleanup: func() { cleanups.Add(1) }}
+			}
+		})
+		inner := connect.StreamingClientInterceptorFunc(func(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+			return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+				return &failingCloseRequestClientConn{StreamingClientConn: next(ctx, spec), err: errInner}
+			}
+		})
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+		server := memhttptest.NewServer(t, mux)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithInterceptors(outer, inner))
+		_, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.ErrorIs(t, err, errInner)
+		assert.Equal(t, cleanups.Load(), 1)
+		sum := client.Sum(context.Background())
+		_, err = sum.CloseAndReceive()
+		assert.ErrorIs(t, err, errInner)
+		assert.Equal(t, cleanups.Load(), 2)
+	})
+}
+
+// cleanupClientConn runs a cleanup function once, when the response side of
+// the stream is closed.
+type cleanupClientConn struct {
+	connect.StreamingClientConn
+
+	cleanup func()
+	once    sync.Once
+}
+
+func (c *cleanupClientConn) CloseResponse() error {
+	defer c.once.Do(c.cleanup)
+	return c.StreamingClientConn.CloseResponse()
+}
+
+type failingCloseRequestClientConn struct {
+	connect.StreamingClientConn
+
+	err error
+}
+
+func (c *failingCloseRequestClientConn) CloseRequest() error {
+	_ = c.StreamingClientConn.CloseRequest()
+	return c.err
+}
+
 type headerInterceptor struct {
 	counter               *atomic.Int32
 	inspectRequestHeader  func(connect.Spec, http.Header)
@@ -390,3 +682,61 @@ func (h *httpMethodChecker) WrapStreamingHandler(handlerFunc connect.StreamingHa
 		return handlerFunc(ctx, conn)
 	}
 }
+
+// callCounter counts the RPCs it intercepts.
+// validatingInterceptor rejects streamed SumRequests with negative numbers as
+// soon as the handler receives them.
+type validatingInterceptor struct{}
+
+func (validatingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return next
+}
+
+func (validatingInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (validatingInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		return next(ctx, &validatingHandlerConn{StreamingHandlerConn: conn})
+	}
+}
+
+type validatingHandlerConn struct {
+	connect.StreamingHandlerConn
+}
+
+func (c *validatingHandlerConn) Receive(msg any) error {
+	if err := c.StreamingHandlerConn.Receive(msg); err != nil {
+		return err
+	}
+	if req, ok := msg.(*pingv1.SumRequest); ok && req.GetNumber() < 0 {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("number must not be negative"))
+	}
+	return nil
+}
+
+type callCounter struct {
+	calls *atomic.Int32
+}
+
+func (c *callCounter) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+		c.calls.Add(1)
+		return next(ctx, request)
+	}
+}
+
+func (c *callCounter) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		c.calls.Add(1)
+		return next(ctx, spec)
+	}
+}
+
+func (c *callCounter) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		c.calls.Add(1)
+		return next(ctx, conn)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// A Logger writes leveled log entries. Each entry has a short, constant
+// message followed by alternating keys and values, like "code", "ok". Loggers
+// decide which levels to keep, so verbose debug entries can be filtered out
+// rather than always printed. The standard library's *slog.Logger implements
+// Logger, and most structured logging libraries are easy to adapt.
+type Logger interface {
+	Debug(msg string, keyvals ...any)
+	Info(msg string, keyvals ...any)
+	Warn(msg string, keyvals ...any)
+}
+
+// stdLogger writes info and warning entries to a standard library logger as
+// "msg: key=value ...". The standard library can't filter by level, so it
+// discards debug entries.
+type stdLogger struct {
+	logger *log.Logger
+}
+
+var _ Logger = stdLogger{}
+
+func (stdLogger) Debug(string, ...any) {}
+
+func (l stdLogger) Info(msg string, keyvals ...any) {
+	l.logger.Print(formatLogEntry(msg, keyvals))
+}
+
+func (l stdLogger) Warn(msg string, keyvals ...any) {
+	l.logger.Print(formatLogEntry(msg, keyvals))
+}
+
+func formatLogEntry(msg string, keyvals []any) string {
+	if len(keyvals) == 0 {
+		return msg
+	}
+	var entry strings.Builder
+	entry.WriteString(msg)
+	entry.WriteString(":")
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&entry, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&entry, " %v", keyvals[i])
+		}
+	}
+	return entry.String()
+}
@@ -0,0 +1,33 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"connectrpc.com/connect/internal/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	t.Parallel()
+	var buffer bytes.Buffer
+	logger := stdLogger{logger: log.New(&buffer, "", 0)}
+	logger.Debug("dropped", "key", "value")
+	logger.Info("started")
+	logger.Warn("slow RPC", "code", "ok", "odd")
+	assert.Equal(t, buffer.String(), "started\nslow RPC: code=ok odd\n")
+}
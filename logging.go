@@ -0,0 +1,278 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A LoggingOption configures [NewLoggingInterceptor].
+type LoggingOption interface {
+	applyToLogging(*loggingInterceptor)
+}
+
+// WithLogMessages makes the logging interceptor log each message sent or
+// received, at debug level. Entries include the direction and the message's
+// type, but not its contents unless [WithLogPayloads] is also used.
+func WithLogMessages() LoggingOption {
+	return &logMessagesOption{}
+}
+
+// WithLogPayloads makes the logging interceptor include the contents of each
+// message in its debug entries, and implies [WithLogMessages]. Payloads often
+// contain personal or secret data, so use this option with care, typically
+// only during local development.
+func WithLogPayloads() LoggingOption {
+	return &logPayloadsOption{}
+}
+
+// NewLoggingInterceptor returns an interceptor that logs the lifecycle of
+// every RPC to the logger, for both clients and handlers and for all stream
+// types. Each RPC logs an info entry when it starts and another when it
+// finishes, with the resulting [Code] (or "ok"), the number of messages sent
+// and received, and the duration. With [WithLogMessages], each message in
+// between is logged at debug level. Every entry has the procedure, protocol,
+// stream type, and side (client or handler) as key-value pairs:
+//
+//	rpc finished procedure=/acme.foo.v1.FooService/Bar protocol=connect stream=server side=handler code=ok sent=3 received=1 duration=1.2ms
+//
+// Message payloads are never logged by default. On clients, streaming RPCs are
+// finished when the caller closes the response side of the stream. If the
+// logger is nil, the interceptor writes info entries to the standard
+// library's default logger and discards debug entries.
+func NewLoggingInterceptor(logger Logger, options ...LoggingOption) Interceptor {
+	if logger == nil {
+		logger = stdLogger{logger: log.Default()}
+	}
+	interceptor := &loggingInterceptor{logger: logger}
+	for _, opt := range options {
+		opt.applyToLogging(interceptor)
+	}
+	return interceptor
+}
+
+type loggingInterceptor struct {
+	logger      Logger
+	logMessages bool
+	logPayloads bool
+}
+
+func (i *loggingInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		rpc := i.start(request.Spec(), request.Peer())
+		rpc.message(directionSend(rpc.spec, true), request.Any())
+		response, err := next(ctx, request)
+		if err == nil && response != nil {
+			rpc.message(directionSend(rpc.spec, false), response.Any())
+		}
+		rpc.finish(err)
+		return response, err
+	}
+}
+
+func (i *loggingInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return func(ctx context.Context, spec Spec) StreamingClientConn {
+		conn := next(ctx, spec)
+		return &loggingStreamingClientConn{
+			StreamingClientConn: conn,
+			rpc:                 i.start(spec, conn.Peer()),
+		}
+	}
+}
+
+func (i *loggingInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return func(ctx context.Context, conn StreamingHandlerConn) error {
+		rpc := i.start(conn.Spec(), conn.Peer())
+		err := next(ctx, &loggingStreamingHandlerConn{StreamingHandlerConn: conn, rpc: rpc})
+		rpc.finish(err)
+		return err
+	}
+}
+
+func (i *loggingInterceptor) start(spec Spec, peer Peer) *loggedRPC {
+	side := "handler"
+	if spec.IsClient {
+		side = "client"
+	}
+	rpc := &loggedRPC{
+		interceptor: i,
+		spec:        spec,
+		tags: []any{
+			"procedure", spec.Procedure,
+			"protocol", peer.Protocol,
+			"stream", spec.StreamType.String(),
+			"side", side,
+		},
+		start: time.Now(),
+	}
+	i.logger.Info("rpc started", rpc.tags...)
+	return rpc
+}
+
+// directionSend reports whether a request (or response, if isRequest is
+// false) is sent, rather than received, from the point of view of spec.
+func directionSend(spec Spec, isRequest bool) bool {
+	return spec.IsClient == isRequest
+}
+
+// loggedRPC is the state of a single RPC. Send and Receive may be called
+// concurrently on streams, so the counters are atomic.
+type loggedRPC struct {
+	interceptor *loggingInterceptor
+	spec        Spec
+	tags        []any // key-value pairs common to every entry
+	start       time.Time
+	sent        atomic.Int64
+	received    atomic.Int64
+}
+
+func (r *loggedRPC) message(send bool, msg any) {
+	direction := "receive"
+	if send {
+		r.sent.Add(1)
+		direction = "send"
+	} else {
+		r.received.Add(1)
+	}
+	if !r.interceptor.logMessages {
+		return
+	}
+	typeName := fmt.Sprintf("%T", msg)
+	if protoMsg, ok := msg.(proto.Message); ok {
+		typeName = string(protoMsg.ProtoReflect().Descriptor().FullName())
+	}
+	keyvals := r.withTags("direction", direction, "type", typeName)
+	if r.interceptor.logPayloads {
+		keyvals = append(keyvals, "payload", msg)
+	}
+	r.interceptor.logger.Debug("rpc message", keyvals...)
+}
+
+func (r *loggedRPC) finish(err error) {
+	r.interceptor.logger.Info("rpc finished", r.withTags(
+		"code", codeOrOK(err),
+		"sent", r.sent.Load(),
+		"received", r.received.Load(),
+		"duration", time.Since(r.start),
+	)...)
+}
+
+// withTags returns the RPC's tags followed by the key-value pairs.
+func (r *loggedRPC) withTags(keyvals ...any) []any {
+	return append(append(make([]any, 0, len(r.tags)+len(keyvals)+2), r.tags...), keyvals...)
+}
+
+type loggingStreamingClientConn struct {
+	StreamingClientConn
+
+	rpc  *loggedRPC
+	once sync.Once
+
+	mu  sync.Mutex
+	err error // first error other than io.EOF
+}
+
+func (c *loggingStreamingClientConn) getResponseCompression() string {
+	return responseCompressionOf(c.StreamingClientConn)
+}
+
+func (c *loggingStreamingClientConn) Send(msg any) error {
+	err := c.StreamingClientConn.Send(msg)
+	if err != nil {
+		c.setErr(err)
+		return err
+	}
+	c.rpc.message(true, msg)
+	return nil
+}
+
+func (c *loggingStreamingClientConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	if err != nil {
+		c.setErr(err)
+		return err
+	}
+	c.rpc.message(false, msg)
+	return nil
+}
+
+func (c *loggingStreamingClientConn) CloseResponse() error {
+	err := c.StreamingClientConn.CloseResponse()
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.rpc.finish(c.err)
+	})
+	return err
+}
+
+func (c *loggingStreamingClientConn) setErr(err error) {
+	if errors.Is(err, io.EOF) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+type loggingStreamingHandlerConn struct {
+	StreamingHandlerConn
+
+	rpc *loggedRPC
+}
+
+func (c *loggingStreamingHandlerConn) getResponseCompression() string {
+	return responseCompressionOf(c.StreamingHandlerConn)
+}
+
+func (c *loggingStreamingHandlerConn) Send(msg any) error {
+	if err := c.StreamingHandlerConn.Send(msg); err != nil {
+		return err
+	}
+	c.rpc.message(true, msg)
+	return nil
+}
+
+func (c *loggingStreamingHandlerConn) Receive(msg any) error {
+	if err := c.StreamingHandlerConn.Receive(msg); err != nil {
+		return err
+	}
+	c.rpc.message(false, msg)
+	return nil
+}
+
+type logMessagesOption struct{}
+
+func (o *logMessagesOption) applyToLogging(interceptor *loggingInterceptor) {
+	interceptor.logMessages = true
+}
+
+type logPayloadsOption struct{}
+
+func (o *logPayloadsOption) applyToLogging(interceptor *loggingInterceptor) {
+	interceptor.logMessages = true
+	interceptor.logPayloads = true
+}
@@ -0,0 +1,122 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestLoggingInterceptor(t *testing.T) {
+	t.Parallel()
+	handlerLog, clientLog := make(chan string, 100), make(chan string, 100)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithInterceptors(connect.NewLoggingInterceptor(channelLogger(handlerLog))),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithGRPC(),
+		connect.WithInterceptors(connect.NewLoggingInterceptor(channelLogger(clientLog), connect.WithLogMessages())),
+	)
+	ctx := context.Background()
+	// Durations vary from run to run.
+	duration := regexp.MustCompile(`duration=\S+`)
+	lines := func(logs chan string) []string {
+		var got []string
+		for len(logs) > 0 {
+			got = append(got, duration.ReplaceAllString(<-logs, "duration=X"))
+		}
+		return got
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{Number: 42, Text: "secret"}))
+		assert.Nil(t, err)
+		const tags = "procedure=/connect.ping.v1.PingService/Ping protocol=grpc stream=unary"
+		assert.Equal(t, lines(clientLog), []string{
+			"INFO rpc started " + tags + " side=client",
+			"DEBUG rpc message " + tags + " side=client direction=send type=connect.ping.v1.PingRequest",
+			"DEBUG rpc message " + tags + " side=client direction=receive type=connect.ping.v1.PingResponse",
+			"INFO rpc finished " + tags + " side=client code=ok sent=1 received=1 duration=X",
+		})
+		assert.Equal(t, lines(handlerLog), []string{
+			"INFO rpc started " + tags + " side=handler",
+			"INFO rpc finished " + tags + " side=handler code=ok sent=1 received=1 duration=X",
+		})
+	})
+	t.Run("bidi", func(t *testing.T) {
+		stream := client.CumSum(ctx)
+		for i := int64(1); i <= 2; i++ {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: i}))
+			_, err := stream.Receive()
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, stream.CloseRequest())
+		_, err := stream.Receive()
+		assert.True(t, errors.Is(err, io.EOF))
+		assert.Nil(t, stream.CloseResponse())
+		const tags = "procedure=/connect.ping.v1.PingService/CumSum protocol=grpc stream=bidi"
+		assert.Equal(t, lines(clientLog), []string{
+			"INFO rpc started " + tags + " side=client",
+			"DEBUG rpc message " + tags + " side=client direction=send type=connect.ping.v1.CumSumRequest",
+			"DEBUG rpc message " + tags + " side=client direction=receive type=connect.ping.v1.CumSumResponse",
+			"DEBUG rpc message " + tags + " side=client direction=send type=connect.ping.v1.CumSumRequest",
+			"DEBUG rpc message " + tags + " side=client direction=receive type=connect.ping.v1.CumSumResponse",
+			"INFO rpc finished " + tags + " side=client code=ok sent=2 received=2 duration=X",
+		})
+		assert.Equal(t, lines(handlerLog), []string{
+			"INFO rpc started " + tags + " side=handler",
+			"INFO rpc finished " + tags + " side=handler code=ok sent=2 received=2 duration=X",
+		})
+	})
+	t.Run("error", func(t *testing.T) {
+		_, err := client.Fail(ctx, connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+		const tags = "procedure=/connect.ping.v1.PingService/Fail protocol=grpc stream=unary"
+		assert.Equal(t, lines(handlerLog), []string{
+			"INFO rpc started " + tags + " side=handler",
+			"INFO rpc finished " + tags + " side=handler code=resource_exhausted sent=0 received=1 duration=X",
+		})
+		lines(clientLog)
+	})
+	t.Run("payloads", func(t *testing.T) {
+		payloadLog := make(chan string, 100)
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithInterceptors(connect.NewLoggingInterceptor(channelLogger(payloadLog), connect.WithLogPayloads())),
+		)
+		_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{Text: "secret"}))
+		assert.Nil(t, err)
+		assert.True(t, strings.Contains(strings.Join(lines(payloadLog), "\n"), `text:"secret"`))
+		// Without the option, payloads are never logged.
+		assert.False(t, strings.Contains(strings.Join(lines(handlerLog), "\n"), "secret"))
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Mux routes requests to Connect service handlers by path prefix and sends
+// all other requests to a fallback handler. It's a small convenience for
+// servers that mount RPC services alongside plain HTTP endpoints.
+//
+// Mux passes the original [http.ResponseWriter] through untouched, so it works
+// with HTTP/2, h2c, and streaming RPCs exactly like the handlers it wraps.
+// Register all handlers before serving requests; Handle isn't safe to call
+// concurrently with ServeHTTP.
+type Mux struct {
+	handlers map[string]http.Handler
+	fallback http.Handler
+}
+
+// NewMux constructs a Mux. Requests that don't match any registered service
+// are sent to the fallback handler. If the fallback is nil, unmatched requests
+// get a 404 Not Found response.
+func NewMux(fallback http.Handler) *Mux {
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+	return &Mux{
+		handlers: make(map[string]http.Handler),
+		fallback: fallback,
+	}
+}
+
+// Handle registers the handler for a path prefix. Its signature matches the
+// return values of generated service handler constructors, so they can be
+// passed directly:
+//
+//	mux.Handle(pingv1connect.NewPingServiceHandler(&pingServer{}))
+//
+// The path must begin and end with a slash. If more than one registered prefix
+// matches a request, the longest one wins. Handle panics if the path is invalid
+// or already registered.
+func (m *Mux) Handle(path string, handler http.Handler) {
+	if !strings.HasPrefix(path, "/") || !strings.HasSuffix(path, "/") {
+		panic(fmt.Sprintf("connect: mux path %q must begin and end with a slash", path))
+	}
+	if handler == nil {
+		panic(fmt.Sprintf("connect: nil handler for mux path %q", path))
+	}
+	if _, ok := m.handlers[path]; ok {
+		panic(fmt.Sprintf("connect: multiple registrations for mux path %q", path))
+	}
+	m.handlers[path] = handler
+}
+
+// ServeHTTP implements [http.Handler].
+func (m *Mux) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	m.handlerFor(request.URL.Path).ServeHTTP(responseWriter, request)
+}
+
+func (m *Mux) handlerFor(path string) http.Handler {
+	// Try each prefix ending in a slash, from longest to shortest. Generated
+	// handlers use "/package.Service/", so the first lookup usually hits.
+	for end := strings.LastIndexByte(path, '/'); end >= 0; end = strings.LastIndexByte(path[:end], '/') {
+		if handler, ok := m.handlers[path[:end+1]]; ok {
+			return handler
+		}
+	}
+	return m.fallback
+}
+
+// MountPath serves a service under a path prefix, such as "/api/v1". It wraps
+// the return values of generated service handler constructors:
+//
+//	mux.Handle(connect.MountPath("/api/v1")(
+//		pingv1connect.NewPingServiceHandler(&pingServer{}),
+//	))
+//
+// The returned path is the service's path with the prefix prepended, and the
+// returned handler strips the prefix before routing, so procedure names,
+// [Spec] values, and interceptors see the usual "/package.Service/Method"
+// paths. Clients reach a mounted service by including the prefix in the base
+// URL passed to the generated client constructor, for example
+// "https://api.acme.com/api/v1".
+func MountPath(prefix string) func(path string, handler http.Handler) (string, http.Handler) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		prefix = ""
+	}
+	return func(path string, handler http.Handler) (string, http.Handler) {
+		if prefix == "" {
+			return path, handler
+		}
+		return prefix + path, http.StripPrefix(prefix, handler)
+	}
+}
+
+// RewriteProcedures returns a handler that rewrites each request's URL path
+// before passing the request to handler. It's useful behind gateways that
+// rewrite paths, or to accept legacy or aliased paths for existing
+// procedures. The rewrite function receives the incoming path and returns the
+// canonical one, typically a procedure like "/acme.foo.v1.FooService/Bar";
+// paths it doesn't recognize should be returned unchanged.
+//
+// Rewriting happens before any routing or protocol detection, so wrap the
+// whole mux (or [Mux]) rather than an individual service handler:
+//
+//	handler := connect.RewriteProcedures(mux, func(path string) string {
+//		if path == "/legacy/Ping" {
+//			return pingv1connect.PingServicePingProcedure
+//		}
+//		return path
+//	})
+func RewriteProcedures(handler http.Handler, rewrite func(path string) string) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		path := rewrite(request.URL.Path)
+		if path == request.URL.Path {
+			handler.ServeHTTP(responseWriter, request)
+			return
+		}
+		// Like http.StripPrefix, shallow-copy the request rather than mutating
+		// the caller's.
+		rewritten := new(http.Request)
+		*rewritten = *request
+		rewritten.URL = new(url.URL)
+		*rewritten.URL = *request.URL
+		rewritten.URL.Path = path
+		rewritten.URL.RawPath = ""
+		handler.ServeHTTP(responseWriter, rewritten)
+	})
+}
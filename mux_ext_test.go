@@ -0,0 +1,180 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestMux(t *testing.T) {
+	t.Parallel()
+	mux := connect.NewMux(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, mux)
+	ctx := context.Background()
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		res, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err)
+		assert.Equal(t, res.Msg.GetNumber(), 42)
+	})
+	t.Run("bidi", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), connect.WithGRPC())
+		stream := client.CumSum(ctx)
+		for i := int64(1); i <= 3; i++ {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: i}))
+			res, err := stream.Receive()
+			assert.Nil(t, err)
+			assert.Equal(t, res.GetSum(), i*(i+1)/2)
+		}
+		assert.Nil(t, stream.CloseRequest())
+		_, err := stream.Receive()
+		assert.True(t, errors.Is(err, io.EOF))
+		assert.Nil(t, stream.CloseResponse())
+	})
+	t.Run("fallback", func(t *testing.T) {
+		t.Parallel()
+		for path, want := range map[string]int{
+			"/healthz":                          http.StatusOK,
+			"/unknown":                          http.StatusNotFound,
+			"/connect.ping.v1.OtherService/Foo": http.StatusNotFound,
+		} {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL()+path, http.NoBody)
+			assert.Nil(t, err)
+			res, err := server.Client().Do(req)
+			assert.Nil(t, err)
+			assert.Equal(t, res.StatusCode, want, assert.Sprintf("path %s", path))
+			assert.Nil(t, res.Body.Close())
+		}
+	})
+}
+
+func TestMuxLongestPrefix(t *testing.T) {
+	t.Parallel()
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, name)
+		})
+	}
+	mux := connect.NewMux(nil)
+	mux.Handle("/api/", named("api"))
+	mux.Handle("/api/acme.v1.FooService/", named("foo"))
+	server := memhttptest.NewServer(t, mux)
+	for path, want := range map[string]string{
+		"/api/acme.v1.FooService/Bar": "foo",
+		"/api/acme.v1.BarService/Baz": "api",
+		"/other":                      "404 page not found\n",
+	} {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL()+path, http.NoBody)
+		assert.Nil(t, err)
+		res, err := server.Client().Do(req)
+		assert.Nil(t, err)
+		body, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		assert.Equal(t, string(body), want, assert.Sprintf("path %s", path))
+	}
+	assert.Panics(t, func() { mux.Handle("/api/", named("dup")) })
+	assert.Panics(t, func() { mux.Handle("no-slashes", named("bad")) })
+}
+
+func TestMountPath(t *testing.T) {
+	t.Parallel()
+	var procedures []string
+	var mu sync.Mutex
+	recordProcedure := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+			mu.Lock()
+			procedures = append(procedures, request.Spec().Procedure)
+			mu.Unlock()
+			return next(ctx, request)
+		}
+	})
+	path, handler := connect.MountPath("/api/v1/")(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithInterceptors(recordProcedure),
+	))
+	assert.Equal(t, path, "/api/v1/connect.ping.v1.PingService/")
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	server := memhttptest.NewServer(t, mux)
+	ctx := context.Background()
+
+	for _, opts := range [][]connect.ClientOption{nil, {connect.WithGRPC()}} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL()+"/api/v1", opts...)
+		res, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err)
+		assert.Equal(t, res.Msg.GetNumber(), 42)
+	}
+	mu.Lock()
+	assert.Equal(t, procedures, []string{pingv1connect.PingServicePingProcedure, pingv1connect.PingServicePingProcedure})
+	mu.Unlock()
+
+	// Without the prefix, the service isn't reachable.
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+	_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+
+	// An empty prefix leaves the handler untouched.
+	path, _ = connect.MountPath("/")(pingv1connect.NewPingServiceHandler(pingServer{}))
+	assert.Equal(t, path, "/connect.ping.v1.PingService/")
+}
+
+func TestRewriteProcedures(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	handler := connect.RewriteProcedures(mux, func(path string) string {
+		if path == "/legacy/Ping" {
+			return pingv1connect.PingServicePingProcedure
+		}
+		return path
+	})
+	server := memhttptest.NewServer(t, handler)
+	ctx := context.Background()
+
+	for _, url := range []string{
+		server.URL() + "/legacy/Ping",
+		server.URL() + pingv1connect.PingServicePingProcedure,
+	} {
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](server.Client(), url)
+		res, err := client.CallUnary(ctx, connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err, assert.Sprintf("url %s", url))
+		assert.Equal(t, res.Msg.GetNumber(), 42)
+	}
+	client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](server.Client(), server.URL()+"/legacy/Other")
+	_, err := client.CallUnary(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+}
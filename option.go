@@ -17,8 +17,13 @@ package connect
 import (
 	"compress/gzip"
 	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // A ClientOption configures a [Client].
@@ -71,13 +76,42 @@ func WithGRPCWeb() ClientOption {
 	return &grpcOption{web: true}
 }
 
+// WithGRPCWebText configures clients to use the gRPC-Web protocol in text
+// mode, which base64-encodes request and response bodies and uses the
+// application/grpc-web-text content type. Text mode is less efficient than
+// [WithGRPCWeb], but some proxies and browser environments can only handle
+// text bodies. Handlers support text mode whenever they support gRPC-Web.
+func WithGRPCWebText() ClientOption {
+	return &grpcOption{web: true, text: true}
+}
+
+// WithProtocolFallback configures the client to try each of the protocols in
+// order, falling back to the next one when the server doesn't support the
+// current one. Valid protocols are [ProtocolConnect], [ProtocolGRPC], and
+// [ProtocolGRPCWeb]. The first protocol replaces the one chosen with
+// [WithGRPC] or [WithGRPCWeb]. For example, to call servers that might only
+// speak either gRPC or Connect:
+//
+//	connect.WithProtocolFallback(connect.ProtocolGRPC, connect.ProtocolConnect)
+//
+// The client only falls back when the server rejects a request with an HTTP
+// 415 Unsupported Media Type, which servers send before reading the request
+// body, so no procedure ever runs twice. Application errors and network
+// failures are returned as usual. Each unary call starts with the first
+// protocol and passes through the interceptors once per protocol tried.
+// Streaming calls always use the first protocol, since their messages can't be
+// replayed.
+func WithProtocolFallback(protocols ...string) ClientOption {
+	return &protocolFallbackOption{protocols: protocols}
+}
+
 // WithProtoJSON configures a client to send JSON-encoded data instead of
 // binary Protobuf. It uses the standard Protobuf JSON mapping as implemented
 // by [google.golang.org/protobuf/encoding/protojson]: fields are named using
 // lowerCamelCase, zero values are omitted, missing required fields are errors,
 // enums are emitted as strings, etc.
 func WithProtoJSON() ClientOption {
-	return WithCodec(&protoJSONCodec{codecNameJSON})
+	return WithCodec(&protoJSONCodec{name: codecNameJSON})
 }
 
 // WithSendCompression configures the client to use the specified algorithm to
@@ -100,6 +134,130 @@ func WithSendGzip() ClientOption {
 	return WithSendCompression(compressionGzip)
 }
 
+// WithTimeoutEncoder customizes how the client sends the time remaining until
+// the context's deadline. By default, Connect clients send the
+// Connect-Timeout-Ms header and gRPC and gRPC-Web clients send the
+// Grpc-Timeout header. A custom encoder replaces this default, which lets
+// clients work with gateways that expect a non-standard header. For example,
+// an encoder may set X-Request-Timeout-Ms to the number of milliseconds
+// remaining.
+//
+// The encoder is only called for calls with a deadline. If the deadline has
+// already passed, the encoder receives a zero duration rather than a negative
+// one. Handlers always use the standard headers.
+func WithTimeoutEncoder(encode func(timeout time.Duration, header http.Header)) ClientOption {
+	return &timeoutEncoderOption{encode: encode}
+}
+
+// WithLenientDecompression configures the client to decompress gzipped
+// responses that are mislabeled as uncompressed. Some misbehaving proxies
+// gzip response bodies while leaving their Content-Encoding as identity,
+// which otherwise makes responses fail to unmarshal. With this option, the
+// client sniffs uncompressed messages for the gzip magic number and
+// decompresses them transparently. The [WithReadMaxBytes] limit applies to
+// the decompressed messages.
+//
+// This is a workaround for broken peers, so it's never enabled by default.
+// It has no effect if gzip support has been removed with
+// [WithAcceptCompression].
+func WithLenientDecompression() ClientOption {
+	return &lenientDecompressionOption{}
+}
+
+// WithMaxCallAttempts limits how many times the HTTP transport may send each
+// unary request for procedures that aren't marked as idempotent (see
+// [WithIdempotency]), including the first attempt. Use a limit of 1 to prevent
+// the transport from ever replaying these requests.
+//
+// Connect never retries RPCs itself, but net/http and golang.org/x/net/http2
+// transports may transparently replay a request (for example, after the
+// server closes an idle connection or refuses a stream), and HTTP redirects
+// replay requests too. To replay a request body, they call the request's
+// GetBody function, which Connect sets for unary requests. This option makes
+// GetBody fail once the limit is reached, so the transport returns an error
+// instead of silently sending the request again. Streaming requests can't be
+// replayed after any of the body has been sent, so this option doesn't affect
+// them.
+//
+// By default, there's no limit.
+func WithMaxCallAttempts(attempts int) ClientOption {
+	return &maxCallAttemptsOption{attempts: attempts}
+}
+
+// WithoutResponseDraining stops clients from reading the rest of the response
+// body when an RPC's response is closed early. By default, closing a
+// response, whether explicitly or after an error, reads and discards up to
+// 4MiB of any unread response data before closing the body.
+//
+// Draining lets HTTP/1.1 connections return to the pool for reuse, and it
+// gives the server a chance to deliver trailers. If the server keeps sending
+// after the client has lost interest (for example, a long server stream that
+// continues after an error), draining wastes bandwidth and delays the return
+// of CloseResponse. With this option, the client closes the response body
+// immediately: HTTP/1.1 connections are closed rather than reused, HTTP/2
+// streams are reset, and trailers that haven't been received yet are lost.
+// Responses that the client reads to the end aren't affected.
+//
+// This is an expert-level performance knob; most clients should not use it.
+func WithoutResponseDraining() ClientOption {
+	return &withoutResponseDrainingOption{}
+}
+
+// WithRequireHTTP2 makes streaming calls fail with [CodeUnavailable] if the
+// server's response arrives over HTTP/1.x. Streaming RPCs over HTTP/1.1 are
+// half-duplex at best, so a proxy that silently downgrades the connection can
+// make a stream hang; this option turns the downgrade into an immediate, clear
+// error instead. Unary calls work well over HTTP/1.1, so they're unaffected.
+//
+// Clients must still be configured to use HTTP/2, for example with
+// golang.org/x/net/http2 or [http.Transport.ForceAttemptHTTP2].
+func WithRequireHTTP2() ClientOption {
+	return &requireHTTP2Option{}
+}
+
+// WithDisableKeepAlives makes the client close each HTTP/1.1 connection once
+// its call completes, rather than returning it to the transport's pool for
+// reuse. It's the per-client equivalent of [http.Transport.DisableKeepAlives]:
+// [HTTPClient] is an interface, so Connect can't reconfigure the transport, and
+// this option instead marks each request with [http.Request.Close]. HTTP/2
+// multiplexes many calls over each connection, so HTTP/2 clients should
+// configure connection lifetimes on the transport instead.
+//
+// Disabling keep-alives adds a connection handshake to every call, so it's
+// mostly useful for debugging or for spreading load behind connection-level
+// load balancers.
+func WithDisableKeepAlives() ClientOption {
+	return &disableKeepAlivesOption{}
+}
+
+// WithConnectionObserver reports which connection each call used. The observe
+// function receives the call's context and [Spec] along with the
+// [httptrace.GotConnInfo] from the transport, whose Reused, WasIdle, and
+// IdleTime fields show whether the call reused a pooled connection. It's
+// called synchronously by the transport, possibly more than once per call if
+// the transport retries the request, so it should return quickly.
+//
+// The observer is installed with [httptrace.WithClientTrace], so it composes
+// with any trace already attached to the call's context. Transports that
+// don't support [httptrace] never call it.
+func WithConnectionObserver(observe func(context.Context, Spec, httptrace.GotConnInfo)) ClientOption {
+	return &connectionObserverOption{observe: observe}
+}
+
+// WithRequestRecorder captures the HTTP request the client sends for each
+// RPC, including the headers and the exact bytes of the request body, and
+// passes it to record. Recordings can be replayed against a local handler
+// with [ReplayRequest], which turns a failing RPC into a deterministic test
+// case. The recorder runs once the request body has been sent (for streaming
+// RPCs, after the client closes the request), possibly on another goroutine.
+//
+// Recordings may contain sensitive data. The values of the Authorization,
+// Proxy-Authorization, and Cookie headers, along with any headers named in
+// redact, are replaced with "REDACTED". Message contents are never redacted.
+func WithRequestRecorder(record func(RecordedRequest), redact ...string) ClientOption {
+	return &requestRecorderOption{record: record, redact: redact}
+}
+
 // A HandlerOption configures a [Handler].
 //
 // In addition to any options grouped in the documentation below, remember that
@@ -119,6 +277,16 @@ type HandlerOption interface {
 // a previously-registered compression algorithm, use WithCompression with nil
 // decompressor and compressor constructors.
 //
+// Connect resets pooled compressors and decompressors between messages, so
+// algorithms with preset dictionaries, like zstd and DEFLATE, can use one
+// dictionary for every message in every stream: construct them with the
+// dictionary, and make sure that Reset keeps it. Dictionaries dramatically
+// shrink small, repetitive messages, but Connect doesn't negotiate them, so
+// both peers must be configured with the same dictionary out of band.
+// Register dictionary-based compression under a distinct name (for example,
+// "zstd-telemetry-v1") so that it's never confused with the plain algorithm,
+// and pair it with [WithAcceptCompression] on clients.
+//
 // Calling WithCompression with an empty name is a no-op.
 func WithCompression(
 	name string,
@@ -131,6 +299,59 @@ func WithCompression(
 	}
 }
 
+// WithHandlerAcceptCompression limits the compression algorithms the handler
+// advertises to clients in the Accept-Encoding family of headers. The handler
+// only compresses responses with advertised algorithms, so operators can stop
+// compressing responses (for example, to debug a misbehaving compressor)
+// without unregistering it. Call WithHandlerAcceptCompression with no names to
+// stop advertising and using compression for responses.
+//
+// Names must be registered with [WithCompression]; unregistered names are
+// ignored. Clients may still send requests compressed with registered but
+// unadvertised algorithms, and the handler decompresses them as usual.
+//
+// By default, handlers advertise all registered compression algorithms.
+func WithHandlerAcceptCompression(names ...string) HandlerOption {
+	return &handlerAcceptCompressionOption{names: names}
+}
+
+// WithCompressionSelector registers a function that chooses each response's
+// compression algorithm, overriding the handler's usual negotiation. For
+// example, a selector may stop compressing responses while the server is under
+// CPU pressure. The function runs once per RPC, before any interceptors. It
+// receives the algorithms that both the client and the handler support (see
+// [WithHandlerAcceptCompression]), most preferred first, and returns one of
+// them or "identity". If it returns anything else, the response isn't
+// compressed.
+//
+// Responses smaller than the [WithCompressMinBytes] threshold are never
+// compressed, regardless of the selector.
+func WithCompressionSelector(selector func(ctx context.Context, spec Spec, accepted []string) string) HandlerOption {
+	return &compressionSelectorOption{selector: selector}
+}
+
+// WithLogger sets the logger the handler uses for operational warnings, like
+// those enabled by [WithSlowRequestThreshold]. By default, handlers write
+// warnings to the standard library's default logger. Handlers never log on the
+// hot path of fast, successful RPCs.
+func WithLogger(logger Logger) HandlerOption {
+	return &loggerOption{logger: logger}
+}
+
+// WithSlowRequestThreshold configures the handler to log a warning for every
+// RPC that takes longer than the threshold, including the procedure, the
+// resulting [Code] (or "ok"), and the duration. Durations cover the
+// interceptors, the procedure's implementation, and writing the end of the
+// response; for streaming RPCs, that's the lifetime of the whole stream. It's
+// a lightweight alternative to full metrics for catching outliers: fast RPCs
+// only pay for reading the clock twice.
+//
+// Warnings go to the logger configured with [WithLogger]. By default, or if
+// the threshold isn't positive, handlers don't log slow RPCs.
+func WithSlowRequestThreshold(threshold time.Duration) HandlerOption {
+	return &slowRequestThresholdOption{threshold: threshold}
+}
+
 // WithHandlerOptions composes multiple HandlerOptions into one.
 func WithHandlerOptions(options ...HandlerOption) HandlerOption {
 	return &handlerOptionsOption{options}
@@ -166,6 +387,361 @@ func WithRequireConnectProtocolHeader() HandlerOption {
 	return &requireConnectProtocolHeaderOption{}
 }
 
+// WithOnlyProtocol locks the handler to a single protocol: [ProtocolConnect],
+// [ProtocolGRPC], or [ProtocolGRPCWeb]. By default, handlers detect the
+// protocol from each request and serve all three. Locking a handler is useful
+// when each protocol is mounted on its own path with different middleware, for
+// example with gRPC on the canonical path and Connect under a "/connect"
+// prefix:
+//
+//	mux.Handle(pingv1connect.NewPingServiceHandler(svc, connect.WithOnlyProtocol(connect.ProtocolGRPC)))
+//	path, handler := pingv1connect.NewPingServiceHandler(svc, connect.WithOnlyProtocol(connect.ProtocolConnect))
+//	mux.Handle("/connect"+path, http.StripPrefix("/connect", handler))
+//
+// Requests using any other protocol are rejected with an HTTP 415 Unsupported
+// Media Type, and the Accept-Post header lists only the locked protocol's
+// Content-Types. Server-sent events (see [WithServerSentEvents]) share
+// Connect's request encoding, so they're only served by handlers locked to
+// the Connect protocol.
+//
+// WithOnlyProtocol panics if the protocol isn't one of the three above.
+func WithOnlyProtocol(protocol string) HandlerOption {
+	switch protocol {
+	case ProtocolConnect, ProtocolGRPC, ProtocolGRPCWeb:
+		return &onlyProtocolOption{protocol: protocol}
+	}
+	panic(fmt.Sprintf("connect: can't lock handler to unknown protocol %q", protocol))
+}
+
+// WithStrictJSON configures the handler's JSON codecs to reject messages with
+// unknown fields. By default, unknown fields are discarded so that clients and
+// servers aren't forced to always use exactly the same version of the schema.
+// With strict JSON, requests with unknown fields fail with
+// [CodeInvalidArgument], and the error message includes the name of the
+// offending field. This catches typos in hand-written JSON early, at the cost
+// of breaking clients that send fields the handler doesn't know about yet.
+//
+// This option only affects the default JSON codecs. It has no effect on binary
+// Protobuf or on custom codecs registered with [WithCodec].
+func WithStrictJSON() HandlerOption {
+	return &strictJSONOption{}
+}
+
+// WithJSONTimeFormat changes how the JSON codecs represent the
+// google.protobuf.Timestamp and google.protobuf.Duration well-known types,
+// both when marshaling and when unmarshaling. With [JSONTimeFormatNumeric],
+// timestamps are numbers of milliseconds since the Unix epoch and durations
+// are numbers of seconds, which some legacy clients expect. Unmarshaling also
+// accepts the canonical representation. No other types are affected, and
+// values inside google.protobuf.Any are left in the canonical format.
+//
+// The numeric format diverges from the canonical Protobuf JSON mapping, so
+// other Protobuf JSON implementations can't read it: use it only to
+// interoperate with clients or servers that require it. This option only
+// affects the default JSON codecs; it has no effect on binary Protobuf or on
+// custom codecs registered with [WithCodec].
+func WithJSONTimeFormat(format JSONTimeFormat) Option {
+	return &jsonTimeFormatOption{format: format}
+}
+
+// WithTypeResolver looks up Protobuf message types with the given resolver
+// rather than the global registry. It's useful for plugins and other programs
+// that load schemas dynamically, so the types they use aren't compiled in or
+// registered globally.
+//
+// The JSON codecs use the resolver for google.protobuf.Any fields, both when
+// marshaling and when unmarshaling. Clients also use it in
+// [ErrorDetail.Value] for error details received from the server. If the
+// resolver doesn't implement [protoregistry.ExtensionTypeResolver],
+// extensions are still resolved with the global registry. This option only
+// affects the default JSON codecs; it has no effect on custom codecs
+// registered with [WithCodec].
+func WithTypeResolver(resolver protoregistry.MessageTypeResolver) Option {
+	return &typeResolverOption{resolver: resolver}
+}
+
+// WithServerSentEvents lets browsers consume server streaming procedures with
+// EventSource. When enabled, the handler also accepts HTTP GET requests whose
+// Accept header includes text/event-stream, and it responds with a stream of
+// server-sent events.
+//
+// As with Connect's unary GET requests, the request message is sent in the
+// "message" query parameter. It's encoded with the codec named by the
+// "encoding" query parameter, which defaults to "json", and it's
+// base64-encoded if the "base64" query parameter is "1". Each response
+// message is sent as an unnamed event whose data is the message encoded with
+// the handler's JSON codec. If the procedure succeeds, the stream ends with an
+// "end" event whose data is a JSON object with the response trailers under
+// the "metadata" key. If it fails, the stream ends with an "error" event whose
+// data is the error in the Connect protocol's JSON form, including its code.
+// Note that EventSource reconnects automatically when the stream ends, so
+// browser clients should close the EventSource when they see either event.
+//
+// This option only affects server streaming procedures. EventSource can't set
+// headers, so server-sent events don't support timeouts or request
+// compression.
+func WithServerSentEvents() HandlerOption {
+	return &serverSentEventsOption{}
+}
+
+// WithRequireTLS configures the handler to reject requests that didn't arrive
+// over TLS, including HTTP/1.1 cleartext and h2c requests. Rejected requests
+// fail with [CodePermissionDenied]. This guards against deployments that
+// accidentally expose a cleartext listener.
+//
+// Use [WithConditionalHandlerOptions] to require TLS for only some
+// procedures. If TLS is terminated by a proxy, use [WithRequireTLSBehindProxy]
+// instead.
+func WithRequireTLS() HandlerOption {
+	return &requireTLSOption{}
+}
+
+// WithRequireTLSBehindProxy is like [WithRequireTLS], but it also accepts
+// cleartext requests forwarded by a trusted proxy that terminated TLS. A
+// cleartext request is accepted only if the trusted function returns true
+// and the request's X-Forwarded-Proto header is "https". The trusted function
+// typically checks the request's RemoteAddr against the proxy's addresses:
+// clients can set X-Forwarded-Proto themselves, so the header alone proves
+// nothing.
+func WithRequireTLSBehindProxy(trusted func(*http.Request) bool) HandlerOption {
+	return &requireTLSOption{trustedProxy: trusted}
+}
+
+// WithResponseHeaderFunc registers a function that may modify the response
+// headers of every RPC served by the handler. It's a convenient way to set
+// headers like Cache-Control or X-Content-Type-Options centrally, without
+// editing each procedure's implementation.
+//
+// The function runs once per RPC, before any interceptors or the procedure's
+// implementation, so interceptors and implementations see (and may override)
+// the headers it sets. Headers added to a unary [Response] are appended to the
+// existing values rather than replacing them. Because the function runs before
+// the first message is sent, it applies equally to unary and streaming
+// responses, including errors. Repeated WithResponseHeaderFunc options run in
+// order.
+func WithResponseHeaderFunc(modify func(ctx context.Context, spec Spec, header http.Header)) HandlerOption {
+	return &responseHeaderFuncOption{modify: modify}
+}
+
+// WithEchoHeaders copies every request header whose name starts with prefix
+// (compared case-insensitively) into the response headers. It's useful for
+// debugging proxies and gateways, which often echo headers like X-Debug-*
+// back to the caller.
+//
+// Like [WithResponseHeaderFunc], echoing happens before the first message is
+// sent, so it applies to unary and streaming responses, including errors.
+// Hop-by-hop headers, credentials like Authorization and Cookie, and headers
+// that belong to the RPC protocol itself (Content-*, Grpc-*, Connect-*, and
+// so on) are never echoed, even if they match the prefix.
+func WithEchoHeaders(prefix string) HandlerOption {
+	return &responseHeaderFuncOption{modify: func(ctx context.Context, _ Spec, header http.Header) {
+		if request := HTTPRequest(ctx); request != nil {
+			echoHeaders(header, request.Header, prefix)
+		}
+	}}
+}
+
+// WithContextValues registers a function that decorates the context of every
+// RPC served by the handler, typically by adding request-scoped values like
+// database handles or feature-flag clients. It's a lighter-weight alternative
+// to wrapping the whole handler in net/http middleware.
+//
+// The function runs once per RPC, with the raw [http.Request], before any
+// interceptors or the procedure's implementation, so they all see the values
+// it adds. It must return a context derived from the one it's given. Repeated
+// WithContextValues options run in order, each receiving the context returned
+// by the previous one.
+func WithContextValues(decorate func(ctx context.Context, request *http.Request) context.Context) HandlerOption {
+	return &contextValuesOption{decorate: decorate}
+}
+
+// WithMaxStreamsPerConn limits the number of concurrent RPCs each client
+// connection may have open across all the handlers configured with the
+// returned option. Over HTTP/2, every RPC (unary or streaming) is a separate
+// stream, so this stops a single client from monopolizing the server with
+// thousands of streams on one connection, even if the HTTP/2 server's
+// MaxConcurrentStreams setting is generous. RPCs over the limit fail with
+// [CodeResourceExhausted] before interceptors or the procedure's
+// implementation run.
+//
+// Handlers can't see the underlying connection, so they identify connections
+// by the request's RemoteAddr. Behind a proxy or load balancer, many clients
+// may share one connection to the server, and so share one limit. To limit
+// whole services rather than individual procedures, pass the same option to
+// every handler (generated NewXServiceHandler constructors do this).
+//
+// By default, or if the limit isn't positive, there's no limit.
+func WithMaxStreamsPerConn(limit int) HandlerOption {
+	if limit <= 0 {
+		return &maxStreamsPerConnOption{}
+	}
+	return &maxStreamsPerConnOption{limiter: newConnStreamLimiter(limit)}
+}
+
+// WithInFlightTracker counts the RPCs the handler is serving with the given
+// tracker, from the moment the handler accepts the request until it has
+// finished writing the response. Requests the handler rejects before calling
+// the implementation (for example, with an unsupported HTTP method or content
+// type) aren't counted. Pass the same tracker to several handlers to count
+// their RPCs together. By default, handlers don't count RPCs.
+func WithInFlightTracker(tracker *InFlightTracker) HandlerOption {
+	return &inFlightTrackerOption{tracker: tracker}
+}
+
+// WithAllowDryRun lets clients ask the handler for a dry run by sending the
+// Connect-Dry-Run: 1 request header. Dry runs decode the request messages and
+// pass through all interceptors, so tools can check that requests are
+// well-formed and authorized, but they never call the procedure's
+// implementation. Instead, unary and client streaming RPCs respond with an
+// empty message, and server and bidirectional streaming RPCs respond without
+// any messages. Every dry-run response carries the Connect-Dry-Run: 1 response
+// header, so clients can tell it apart from a real one.
+//
+// Since interceptors see dry runs as ordinary RPCs, they may still have side
+// effects of their own. By default, handlers ignore the Connect-Dry-Run header
+// and always call the implementation.
+func WithAllowDryRun() HandlerOption {
+	return &allowDryRunOption{}
+}
+
+// WithSendTimeout limits how long each message sent by the handler may take
+// to write. If a single Send can't complete within the timeout, usually
+// because a slow client isn't reading the response and flow control has
+// stalled the stream, Send returns an error with [CodeDeadlineExceeded] and
+// the stream is aborted. This sheds slow consumers without tying up a handler
+// goroutine indefinitely. Unlike the RPC's deadline, the timeout applies to
+// each write separately, so long-lived streams to clients that keep up are
+// unaffected.
+//
+// The timeout relies on the http.ResponseWriter supporting write deadlines,
+// as the net/http and golang.org/x/net/http2 servers do. While a Send is in
+// progress, its deadline replaces the server's WriteTimeout, which applies
+// again once the Send returns. By default, or if the timeout isn't positive,
+// Send may block for as long as the RPC lasts.
+func WithSendTimeout(timeout time.Duration) HandlerOption {
+	return &sendTimeoutOption{timeout: timeout}
+}
+
+// WithStreamHeartbeat makes server and bidirectional streaming handlers send
+// a heartbeat whenever they haven't sent a message for the interval. Proxies
+// and load balancers often drop streams that stay silent longer than their
+// idle timeout; heartbeats keep long-running streams, such as analytical
+// queries with long gaps between results, alive without sending placeholder
+// messages. Clients discard heartbeats, so they're never returned from
+// Receive.
+//
+// Heartbeats are an extension to the Connect protocol: each one is an empty
+// envelope with a flag that isn't part of the protocol specification.
+// Handlers only send them to clients that advertise support with the
+// Connect-Accept-Heartbeat request header, which clients from this package
+// send on server and bidirectional streams when they're constructed with
+// [WithAcceptHeartbeats]. Other Connect clients, and all gRPC and gRPC-Web
+// clients, don't receive heartbeats; for gRPC, configure
+// HTTP/2 keepalive pings on the server instead. Some intermediaries only reset
+// their idle timers on HTTP/2 data, which heartbeats are, but not on pings.
+//
+// Heartbeats only start once the handler has sent its first message, which
+// also sends the response headers, so they never interfere with handlers
+// setting headers. By default, or if the interval isn't positive, handlers
+// don't send heartbeats.
+func WithStreamHeartbeat(interval time.Duration) HandlerOption {
+	return &streamHeartbeatOption{interval: interval}
+}
+
+// WithAcceptHeartbeats makes clients ask handlers for heartbeats on server and
+// bidirectional streams, by sending the Connect-Accept-Heartbeat request
+// header. Handlers constructed with [WithStreamHeartbeat] then keep idle
+// streams alive; clients discard the heartbeats, so they're never returned
+// from Receive. The header isn't part of the Connect protocol, so clients
+// don't send it by default, and it has no effect with the gRPC and gRPC-Web
+// protocols.
+func WithAcceptHeartbeats() ClientOption {
+	return &acceptHeartbeatsOption{}
+}
+
+// WithMaxMessageAge makes clients drop server-streamed messages that are
+// older than the given age by the time they're read. It's a best-effort
+// freshness filter for real-time feeds: when a slow client falls behind, the
+// messages buffered in the meantime are silently skipped rather than returned
+// from Receive, so the application only sees recent data. Dropped messages
+// aren't errors.
+//
+// Message ages are an extension to the Connect protocol, so they only work
+// between clients and handlers from this package. The client asks for them
+// with the Connect-Accept-Timestamp request header, and the handler then
+// precedes each message with an envelope holding the time it was sent, using
+// a flag that isn't part of the protocol specification. Other handlers
+// ignore the header, and the option has no effect with the gRPC and gRPC-Web
+// protocols, so every message is delivered. Ages are computed with the
+// client's and server's wall clocks, so they're only as accurate as the clocks
+// are synchronized. By default, or if the age isn't positive, clients don't
+// drop any messages.
+func WithMaxMessageAge(age time.Duration) ClientOption {
+	return &maxMessageAgeOption{age: age}
+}
+
+// WithDebugErrors lets handlers send the stack traces attached by
+// [NewInternalError] to clients as google.rpc.DebugInfo error details. By
+// default, handlers remove these details from errors before sending them, so
+// that production servers never leak their internals. DebugInfo details that
+// handlers attach themselves with [NewErrorDetail] are always sent. Enable
+// this option only in development or other non-production environments.
+func WithDebugErrors() HandlerOption {
+	return &debugErrorsOption{}
+}
+
+// WithErrorRedactor lets handlers rewrite errors just before they're sent to
+// the client, which centralizes policies like "don't leak internal details".
+// The redactor runs after all interceptors, for every protocol, and receives
+// the error as the client would see it: errors that aren't [*Error]s have
+// [CodeUnknown], and context errors have [CodeCanceled] or
+// [CodeDeadlineExceeded]. It returns the error to send instead, or nil to send
+// the error unchanged.
+//
+// Redactors typically replace the message for some codes while keeping the
+// code. To keep the original error's details and metadata, copy them to the
+// replacement with [Error.AddDetail] and [Error.Meta]. The redactor only
+// affects what's sent on the network: the handler's own logging, interceptors,
+// and callbacks see the original error. Errors created with
+// [NewNotModifiedError] aren't errors from the client's point of view, so they
+// skip the redactor.
+//
+// By default, errors aren't redacted.
+func WithErrorRedactor(redact func(*Error) *Error) HandlerOption {
+	return &errorRedactorOption{redact: redact}
+}
+
+// WithETags adds automatic HTTP caching validators to responses for unary
+// procedures called with Connect's HTTP GET requests (see [WithHTTPGet] and
+// [WithIdempotency]). The handler sets the ETag response header to a hash of
+// the response message's deterministic Protobuf encoding, unless the
+// implementation has already set one. If the request's If-None-Match header
+// matches the ETag, the handler sends an HTTP 304 Not Modified response
+// without a body instead of the response message.
+//
+// Clients that send If-None-Match receive an error from the RPC when the
+// response hasn't changed. Check for it with [IsNotModifiedError] and reuse
+// the cached response; the error's metadata includes the ETag. Note that the
+// implementation still runs for every request: ETags save bandwidth, not
+// computation. POST requests, streaming procedures, and the gRPC protocols
+// are unaffected.
+func WithETags() HandlerOption {
+	return &etagsOption{}
+}
+
+// WithGRPCWebTrailerMode controls where the handler sends gRPC-Web trailing
+// metadata, which includes the RPC's status. It's an interoperability knob for
+// browser clients that only look for the status in one place: some only read
+// the trailer frame at the end of the body, while others only read the HTTP
+// headers of trailers-only responses. It doesn't affect the gRPC or Connect
+// protocols.
+//
+// By default, handlers use [GRPCWebTrailerModeDefault], which follows the
+// gRPC-Web specification.
+func WithGRPCWebTrailerMode(mode GRPCWebTrailerMode) HandlerOption {
+	return &grpcWebTrailerModeOption{mode: mode}
+}
+
 // WithConditionalHandlerOptions allows procedures in the same service to have
 // different configurations: for example, one procedure may need a much larger
 // WithReadMaxBytes setting than the others.
@@ -237,6 +813,12 @@ func WithCodec(codec Codec) Option {
 // The default minimum is zero. Setting a minimum compression threshold may
 // improve overall performance, because the CPU cost of compressing very small
 // messages usually isn't worth the small reduction in network I/O.
+//
+// On clients, pair this option with [WithSendGzip] (or [WithSendCompression])
+// to compress only large requests. Connect unary requests below the threshold
+// are sent without a Content-Encoding header. Streaming and gRPC requests
+// always announce the compression algorithm in their headers, but only mark
+// individual messages as compressed if they meet the threshold.
 func WithCompressMinBytes(min int) Option {
 	return &compressMinBytesOption{Min: min}
 }
@@ -258,6 +840,53 @@ func WithReadMaxBytes(max int) Option {
 	return &readMaxBytesOption{Max: max}
 }
 
+// WithMaxDecompressionRatio limits how much compressed messages sent by the
+// other party may expand, which defends against decompression bombs: small
+// messages that are absurdly compressible and stay under the
+// [WithReadMaxBytes] limit on the wire, but still decompress to far more data
+// than any legitimate message. Messages that decompress to more than ratio
+// times their compressed size fail with [CodeResourceExhausted] as soon as
+// they pass the limit, without decompressing the rest.
+//
+// The limit applies to each message, for every compression algorithm,
+// including ones registered with [WithCompression]. Ordinary Protobuf and JSON
+// messages rarely compress better than 10:1, but messages with long runs of
+// repeated data can, so choose a generous ratio. Setting the ratio to zero
+// removes the limit, which is the default for both clients and handlers.
+func WithMaxDecompressionRatio(ratio float64) Option {
+	return &maxDecompressionRatioOption{Ratio: ratio}
+}
+
+// WithMaxStreamFrames limits the number of messages the other party may send
+// on a single stream. For handlers, it limits the number of messages in the
+// request stream; for clients, it limits the number of messages in the
+// response stream. Streams that exceed the limit fail with
+// [CodeResourceExhausted].
+//
+// Setting WithMaxStreamFrames to zero allows any number of messages, which is
+// the default for both clients and handlers. The limit has no effect on unary
+// RPCs using the Connect protocol, which don't use framing.
+func WithMaxStreamFrames(max int) Option {
+	return &maxStreamFramesOption{Max: max}
+}
+
+// WithMaxEmptyStreamFrames limits the number of consecutive empty messages the
+// other party may send on a single stream, guarding against peers that flood a
+// stream with zero-length frames. Each non-empty message resets the count.
+// Streams that exceed the limit fail with [CodeResourceExhausted].
+//
+// Empty frames are valid: they're how zero-value messages (for example,
+// google.protobuf.Empty) are encoded, so choose a limit with the procedure's
+// schema in mind. Clients also count the heartbeats sent by handlers using
+// [WithStreamHeartbeat] as empty messages, so the limit should allow for the
+// longest expected gap between real messages.
+//
+// Setting WithMaxEmptyStreamFrames to zero allows any number of empty
+// messages, which is the default for both clients and handlers.
+func WithMaxEmptyStreamFrames(max int) Option {
+	return &maxEmptyStreamFramesOption{Max: max}
+}
+
 // WithSendMaxBytes prevents sending messages too large for the client/handler
 // to handle without significant performance overhead. For handlers, WithSendMaxBytes
 // limits the size of a message that the handler can respond with. For clients,
@@ -284,6 +913,20 @@ func WithIdempotency(idempotencyLevel IdempotencyLevel) Option {
 	return &idempotencyOption{idempotencyLevel: idempotencyLevel}
 }
 
+// WithConnectGetQueryParams renames the query parameters used by Connect
+// unary GET requests, which may help when a CDN or caching proxy normalizes
+// cache keys by parameter name. Fields left empty keep the names from the
+// Connect protocol specification. Handlers that use server-sent events (see
+// [WithServerSentEvents]) read the request message with the same names.
+//
+// Clients and handlers must agree on the names, so pass the same option to
+// both. Clients and handlers that don't use this option, including those in
+// other Connect implementations, can't interoperate with ones that do when
+// GET requests are in use. POST requests are unaffected.
+func WithConnectGetQueryParams(names ConnectGetParamNames) Option {
+	return &connectGetQueryParamsOption{names: names}
+}
+
 // WithHTTPGet allows Connect-protocol clients to use HTTP GET requests for
 // side-effect free unary RPC calls. Typically, the service schema indicates
 // which procedures are idempotent (see [WithIdempotency] for an example
@@ -351,6 +994,40 @@ func WithInterceptors(interceptors ...Interceptor) Option {
 	return &interceptorsOption{interceptors}
 }
 
+// WithConditionalInterceptor adds an interceptor that only applies to calls
+// whose Spec matches, which keeps per-procedure logic (like authorization
+// rules) out of blanket interceptors. The match function is called once per
+// call. Calls that don't match skip the interceptor entirely, but still pass
+// through the rest of the interceptor chain.
+//
+// Like [WithInterceptors], repeated options are applied in order. A nil
+// interceptor is ignored, but WithConditionalInterceptor panics if match is
+// nil: silently skipping an interceptor that enforces authorization would
+// fail open.
+func WithConditionalInterceptor(match func(Spec) bool, interceptor Interceptor) Option {
+	if match == nil {
+		panic("connect: WithConditionalInterceptor requires a non-nil match function")
+	}
+	if interceptor == nil {
+		return WithInterceptors()
+	}
+	return WithInterceptors(&conditionalInterceptor{match: match, interceptor: interceptor})
+}
+
+// ForProcedures adds an interceptor that only applies to the named
+// procedures. Procedure names have the same form as [Spec.Procedure]: for
+// example, "/acme.foo.v1.FooService/Bar". See [WithConditionalInterceptor].
+func ForProcedures(interceptor Interceptor, procedures ...string) Option {
+	names := make(map[string]struct{}, len(procedures))
+	for _, procedure := range procedures {
+		names[procedure] = struct{}{}
+	}
+	return WithConditionalInterceptor(func(spec Spec) bool {
+		_, ok := names[spec.Procedure]
+		return ok
+	}, interceptor)
+}
+
 // WithOptions composes multiple Options into one.
 func WithOptions(options ...Option) Option {
 	return &optionsOption{options}
@@ -419,6 +1096,39 @@ func (o *codecOption) applyToHandler(config *handlerConfig) {
 	config.Codecs[o.Codec.Name()] = o.Codec
 }
 
+type handlerAcceptCompressionOption struct {
+	names []string
+}
+
+func (o *handlerAcceptCompressionOption) applyToHandler(config *handlerConfig) {
+	// A non-nil, empty slice advertises nothing.
+	config.AcceptCompressionNames = append([]string{}, o.names...)
+}
+
+type loggerOption struct {
+	logger Logger
+}
+
+func (o *loggerOption) applyToHandler(config *handlerConfig) {
+	config.Logger = o.logger
+}
+
+type slowRequestThresholdOption struct {
+	threshold time.Duration
+}
+
+func (o *slowRequestThresholdOption) applyToHandler(config *handlerConfig) {
+	config.SlowRequestThreshold = o.threshold
+}
+
+type compressionSelectorOption struct {
+	selector func(context.Context, Spec, []string) string
+}
+
+func (o *compressionSelectorOption) applyToHandler(config *handlerConfig) {
+	config.CompressionSelector = o.selector
+}
+
 type compressionOption struct {
 	Name            string
 	CompressionPool *compressionPool
@@ -476,6 +1186,42 @@ func (o *readMaxBytesOption) applyToHandler(config *handlerConfig) {
 	config.ReadMaxBytes = o.Max
 }
 
+type maxDecompressionRatioOption struct {
+	Ratio float64
+}
+
+func (o *maxDecompressionRatioOption) applyToClient(config *clientConfig) {
+	config.MaxDecompressionRatio = o.Ratio
+}
+
+func (o *maxDecompressionRatioOption) applyToHandler(config *handlerConfig) {
+	config.MaxDecompressionRatio = o.Ratio
+}
+
+type maxStreamFramesOption struct {
+	Max int
+}
+
+func (o *maxStreamFramesOption) applyToClient(config *clientConfig) {
+	config.ReadMaxFrames = o.Max
+}
+
+func (o *maxStreamFramesOption) applyToHandler(config *handlerConfig) {
+	config.ReadMaxFrames = o.Max
+}
+
+type maxEmptyStreamFramesOption struct {
+	Max int
+}
+
+func (o *maxEmptyStreamFramesOption) applyToClient(config *clientConfig) {
+	config.ReadMaxEmptyFrames = o.Max
+}
+
+func (o *maxEmptyStreamFramesOption) applyToHandler(config *handlerConfig) {
+	config.ReadMaxEmptyFrames = o.Max
+}
+
 type sendMaxBytesOption struct {
 	Max int
 }
@@ -504,6 +1250,192 @@ func (o *requireConnectProtocolHeaderOption) applyToHandler(config *handlerConfi
 	config.RequireConnectProtocolHeader = true
 }
 
+type debugErrorsOption struct{}
+
+func (o *debugErrorsOption) applyToHandler(config *handlerConfig) {
+	config.DebugErrors = true
+}
+
+type onlyProtocolOption struct {
+	protocol string
+}
+
+func (o *onlyProtocolOption) applyToHandler(config *handlerConfig) {
+	config.HandleConnect = o.protocol == ProtocolConnect
+	config.HandleGRPC = o.protocol == ProtocolGRPC
+	config.HandleGRPCWeb = o.protocol == ProtocolGRPCWeb
+}
+
+type responseHeaderFuncOption struct {
+	modify func(context.Context, Spec, http.Header)
+}
+
+func (o *responseHeaderFuncOption) applyToHandler(config *handlerConfig) {
+	if o.modify == nil {
+		return
+	}
+	previous := config.ResponseHeaderFunc
+	if previous == nil {
+		config.ResponseHeaderFunc = o.modify
+		return
+	}
+	config.ResponseHeaderFunc = func(ctx context.Context, spec Spec, header http.Header) {
+		previous(ctx, spec, header)
+		o.modify(ctx, spec, header)
+	}
+}
+
+type contextValuesOption struct {
+	decorate func(context.Context, *http.Request) context.Context
+}
+
+func (o *contextValuesOption) applyToHandler(config *handlerConfig) {
+	if o.decorate == nil {
+		return
+	}
+	previous := config.ContextValues
+	if previous == nil {
+		config.ContextValues = o.decorate
+		return
+	}
+	config.ContextValues = func(ctx context.Context, request *http.Request) context.Context {
+		return o.decorate(previous(ctx, request), request)
+	}
+}
+
+type maxStreamsPerConnOption struct {
+	limiter *connStreamLimiter // shared by all handlers using this option
+}
+
+func (o *maxStreamsPerConnOption) applyToHandler(config *handlerConfig) {
+	config.ConnStreamLimiter = o.limiter
+}
+
+type inFlightTrackerOption struct {
+	tracker *InFlightTracker
+}
+
+func (o *inFlightTrackerOption) applyToHandler(config *handlerConfig) {
+	config.InFlightTracker = o.tracker
+}
+
+type streamHeartbeatOption struct {
+	interval time.Duration
+}
+
+func (o *streamHeartbeatOption) applyToHandler(config *handlerConfig) {
+	config.StreamHeartbeat = o.interval
+}
+
+type acceptHeartbeatsOption struct{}
+
+func (o *acceptHeartbeatsOption) applyToClient(config *clientConfig) {
+	config.AcceptHeartbeats = true
+}
+
+type maxMessageAgeOption struct {
+	age time.Duration
+}
+
+func (o *maxMessageAgeOption) applyToClient(config *clientConfig) {
+	config.MaxMessageAge = o.age
+}
+
+type sendTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (o *sendTimeoutOption) applyToHandler(config *handlerConfig) {
+	config.SendTimeout = o.timeout
+}
+
+type errorRedactorOption struct {
+	redact func(*Error) *Error
+}
+
+func (o *errorRedactorOption) applyToHandler(config *handlerConfig) {
+	config.ErrorRedactor = o.redact
+}
+
+type allowDryRunOption struct{}
+
+func (o *allowDryRunOption) applyToHandler(config *handlerConfig) {
+	config.AllowDryRun = true
+}
+
+type etagsOption struct{}
+
+func (o *etagsOption) applyToHandler(config *handlerConfig) {
+	config.ETags = true
+}
+
+type grpcWebTrailerModeOption struct {
+	mode GRPCWebTrailerMode
+}
+
+func (o *grpcWebTrailerModeOption) applyToHandler(config *handlerConfig) {
+	config.GRPCWebTrailerMode = o.mode
+}
+
+type serverSentEventsOption struct{}
+
+func (o *serverSentEventsOption) applyToHandler(config *handlerConfig) {
+	config.ServerSentEvents = true
+}
+
+type requireTLSOption struct {
+	trustedProxy func(*http.Request) bool
+}
+
+func (o *requireTLSOption) applyToHandler(config *handlerConfig) {
+	config.RequireTLS = true
+	config.TrustedTLSProxy = o.trustedProxy
+}
+
+type strictJSONOption struct{}
+
+func (o *strictJSONOption) applyToHandler(config *handlerConfig) {
+	for name, codec := range config.Codecs {
+		if jsonCodec, ok := codec.(*protoJSONCodec); ok {
+			strict := *jsonCodec
+			strict.disallowUnknown = true
+			config.Codecs[name] = &strict
+		}
+	}
+}
+
+type jsonTimeFormatOption struct {
+	format JSONTimeFormat
+}
+
+func (o *jsonTimeFormatOption) applyToClient(config *clientConfig) {
+	config.JSONTimeFormat = o.format
+}
+
+func (o *jsonTimeFormatOption) applyToHandler(config *handlerConfig) {
+	for name, codec := range config.Codecs {
+		if jsonCodec, ok := codec.(*protoJSONCodec); ok {
+			config.Codecs[name] = jsonCodec.withTimeFormat(o.format)
+		}
+	}
+}
+
+type typeResolverOption struct {
+	resolver protoregistry.MessageTypeResolver
+}
+
+func (o *typeResolverOption) applyToClient(config *clientConfig) {
+	config.TypeResolver = o.resolver
+}
+
+func (o *typeResolverOption) applyToHandler(config *handlerConfig) {
+	for name, codec := range config.Codecs {
+		if jsonCodec, ok := codec.(*protoJSONCodec); ok {
+			config.Codecs[name] = jsonCodec.withResolver(o.resolver)
+		}
+	}
+}
+
 type idempotencyOption struct {
 	idempotencyLevel IdempotencyLevel
 }
@@ -516,12 +1448,53 @@ func (o *idempotencyOption) applyToHandler(config *handlerConfig) {
 	config.IdempotencyLevel = o.idempotencyLevel
 }
 
+type connectGetQueryParamsOption struct {
+	names ConnectGetParamNames
+}
+
+func (o *connectGetQueryParamsOption) applyToClient(config *clientConfig) {
+	config.GetParamNames = o.names
+}
+
+func (o *connectGetQueryParamsOption) applyToHandler(config *handlerConfig) {
+	config.GetParamNames = o.names
+}
+
 type grpcOption struct {
-	web bool
+	web  bool
+	text bool
 }
 
 func (o *grpcOption) applyToClient(config *clientConfig) {
-	config.Protocol = &protocolGRPC{web: o.web}
+	config.Protocol = &protocolGRPC{web: o.web, text: o.text}
+}
+
+type protocolFallbackOption struct {
+	protocols []string
+}
+
+func (o *protocolFallbackOption) applyToClient(config *clientConfig) {
+	config.FallbackProtocols = nil
+	config.FallbackProtocolsErr = nil
+	for i, name := range o.protocols {
+		var p protocol
+		switch name {
+		case ProtocolConnect:
+			p = &protocolConnect{}
+		case ProtocolGRPC:
+			p = &protocolGRPC{web: false}
+		case ProtocolGRPCWeb:
+			p = &protocolGRPC{web: true}
+		default:
+			config.FallbackProtocolsErr = errorf(CodeUnknown, "unknown fallback protocol %q", name)
+			return
+		}
+		if i == 0 {
+			config.Protocol = p
+		} else {
+			config.FallbackProtocols = append(config.FallbackProtocols, p)
+		}
+	}
 }
 
 type enableGet struct{}
@@ -562,6 +1535,71 @@ func (o *getURLMaxBytes) applyToClient(config *clientConfig) {
 	config.GetUseFallback = o.Fallback
 }
 
+type maxCallAttemptsOption struct {
+	attempts int
+}
+
+func (o *maxCallAttemptsOption) applyToClient(config *clientConfig) {
+	config.MaxCallAttempts = o.attempts
+}
+
+type withoutResponseDrainingOption struct{}
+
+func (o *withoutResponseDrainingOption) applyToClient(config *clientConfig) {
+	config.SkipResponseDraining = true
+}
+
+type requireHTTP2Option struct{}
+
+func (o *requireHTTP2Option) applyToClient(config *clientConfig) {
+	config.RequireHTTP2 = true
+}
+
+type disableKeepAlivesOption struct{}
+
+func (o *disableKeepAlivesOption) applyToClient(config *clientConfig) {
+	config.DisableKeepAlives = true
+}
+
+type connectionObserverOption struct {
+	observe func(context.Context, Spec, httptrace.GotConnInfo)
+}
+
+func (o *connectionObserverOption) applyToClient(config *clientConfig) {
+	config.ConnObserver = o.observe
+}
+
+type requestRecorderOption struct {
+	record func(RecordedRequest)
+	redact []string
+}
+
+func (o *requestRecorderOption) applyToClient(config *clientConfig) {
+	config.RequestRecorder = o.record
+	config.RecorderRedactHeaders = map[string]struct{}{
+		"Authorization":       {},
+		"Proxy-Authorization": {},
+		"Cookie":              {},
+	}
+	for _, key := range o.redact {
+		config.RecorderRedactHeaders[http.CanonicalHeaderKey(key)] = struct{}{}
+	}
+}
+
+type lenientDecompressionOption struct{}
+
+func (o *lenientDecompressionOption) applyToClient(config *clientConfig) {
+	config.LenientDecompression = true
+}
+
+type timeoutEncoderOption struct {
+	encode func(time.Duration, http.Header)
+}
+
+func (o *timeoutEncoderOption) applyToClient(config *clientConfig) {
+	config.TimeoutEncoder = o.encode
+}
+
 type interceptorsOption struct {
 	Interceptors []Interceptor
 }
@@ -627,8 +1665,8 @@ func withProtoBinaryCodec() Option {
 
 func withProtoJSONCodecs() HandlerOption {
 	return WithHandlerOptions(
-		WithCodec(&protoJSONCodec{codecNameJSON}),
-		WithCodec(&protoJSONCodec{codecNameJSONCharsetUTF8}),
+		WithCodec(&protoJSONCodec{name: codecNameJSON}),
+		WithCodec(&protoJSONCodec{name: codecNameJSONCharsetUTF8}),
 	)
 }
 
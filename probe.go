@@ -0,0 +1,164 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// probeContentType is deliberately unsupported, so that servers reject the
+// probe and advertise the content types they do support.
+const probeContentType = "application/x-connect-probe"
+
+// probeCompression is deliberately unsupported, so that servers reject the
+// probe and advertise the compression algorithms they do support.
+const probeCompression = "x-connect-probe"
+
+// Capabilities describes what a server supports for a procedure. See
+// [Client.Probe].
+type Capabilities struct {
+	// ContentTypes lists the request content types the server accepts for
+	// every protocol it supports, like "application/proto" and
+	// "application/grpc+json".
+	ContentTypes []string
+	// Compressions lists the names of the compression algorithms the server
+	// accepts, not including identity.
+	Compressions []string
+}
+
+// SupportsCodec reports whether the server accepts messages encoded with the
+// named codec using any protocol.
+func (c *Capabilities) SupportsCodec(name string) bool {
+	for _, contentType := range c.ContentTypes {
+		if strings.HasSuffix(contentType, "/"+name) || strings.HasSuffix(contentType, "+"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsCompression reports whether the server accepts messages compressed
+// with the named algorithm. Every server supports identity.
+func (c *Capabilities) SupportsCompression(name string) bool {
+	if name == compressionIdentity {
+		return true
+	}
+	for _, compression := range c.Compressions {
+		if compression == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Probe asks the server which codecs and compression algorithms it supports
+// for the client's procedure, so that dynamic clients can pick the best ones
+// before sending real requests. It doesn't call the procedure: it sends two
+// empty requests with an unsupported content type and an unsupported
+// compression algorithm, which Connect servers reject before running
+// interceptors or the procedure's implementation, and it reads the
+// capabilities the server advertises in its rejections.
+//
+// Probe always uses the Connect protocol's headers, whatever protocol the
+// client is configured to use, and it doesn't run the client's interceptors.
+// Servers that aren't implemented with connect-go may not advertise their
+// capabilities, in which case Probe returns an error.
+func (c *Client[Req, Res]) Probe(ctx context.Context) (*Capabilities, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	response, err := c.probe(ctx, http.Header{headerContentType: []string{probeContentType}})
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusUnsupportedMediaType {
+		return nil, errorf(
+			connectHTTPToCode(response.StatusCode),
+			"probe: expected HTTP status %d, got %d",
+			http.StatusUnsupportedMediaType, response.StatusCode,
+		)
+	}
+	capabilities := &Capabilities{
+		ContentTypes: splitHeaderValues(response.Header.Get("Accept-Post")),
+	}
+	if len(capabilities.ContentTypes) == 0 {
+		return nil, errorf(CodeUnknown, "probe: server didn't advertise supported content types")
+	}
+	// Any Connect content type will do: the server rejects the compression
+	// before it looks at the body.
+	for _, contentType := range capabilities.ContentTypes {
+		header := http.Header{
+			headerContentType:            []string{contentType},
+			connectHeaderProtocolVersion: []string{connectProtocolVersion},
+		}
+		acceptHeader := connectUnaryHeaderAcceptCompression
+		switch {
+		case strings.HasPrefix(contentType, connectStreamingContentTypePrefix):
+			header[connectStreamingHeaderCompression] = []string{probeCompression}
+			acceptHeader = connectStreamingHeaderAcceptCompression
+		case strings.HasPrefix(contentType, grpcContentTypeDefault),
+			!strings.HasPrefix(contentType, connectUnaryContentTypePrefix):
+			continue
+		default:
+			header[connectUnaryHeaderCompression] = []string{probeCompression}
+		}
+		response, err := c.probe(ctx, header)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range splitHeaderValues(response.Header.Get(acceptHeader)) {
+			if name != compressionIdentity {
+				capabilities.Compressions = append(capabilities.Compressions, name)
+			}
+		}
+		break
+	}
+	return capabilities, nil
+}
+
+// probe sends an empty POST request with the given headers to the client's
+// URL. The response body has already been drained and closed.
+func (c *Client[Req, Res]) probe(ctx context.Context, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.URL.String(), http.NoBody)
+	if err != nil {
+		return nil, errorf(CodeInternal, "probe: %w", err)
+	}
+	request.Header = header
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		err = wrapIfContextError(err)
+		if _, ok := asError(err); !ok {
+			err = NewError(CodeUnavailable, err)
+		}
+		return nil, err
+	}
+	_, _ = discard(response.Body)
+	_ = response.Body.Close()
+	return response, nil
+}
+
+// splitHeaderValues splits a comma-separated header value, keeping any
+// parameters (like "; charset=utf-8") with their values.
+func splitHeaderValues(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
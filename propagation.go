@@ -0,0 +1,152 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// A PropagationField describes a single context value carried in a request
+// header by [NewContextPropagationInterceptor].
+type PropagationField struct {
+	// Key is the context key holding the value, as passed to
+	// [context.WithValue]. It must not be nil.
+	Key any
+	// Header is the name of the request header carrying the value. It must be
+	// a valid HTTP header name, and it must not begin with "Connect-" or
+	// "Grpc-", which are reserved for the protocols.
+	Header string
+	// Encode converts the context value to a header value. If nil, values are
+	// formatted with [fmt.Sprint].
+	Encode func(any) string
+	// Decode parses a header value back into the value stored in the context.
+	// If nil, the header value is stored as a string.
+	Decode func(string) (any, error)
+}
+
+// NewContextPropagationInterceptor returns an interceptor that carries values
+// like tenant IDs and locales from the client's context to the handler's
+// context, using a request header for each field.
+//
+// When used with a client, the interceptor sets a header for each field whose
+// key has a non-nil value in the context. Headers that are already set are
+// left as-is. When used with a handler, the interceptor decodes each header
+// the client sent and adds the value to the context before calling the
+// implementation; missing headers leave the context unchanged. If a header
+// can't be decoded, the RPC fails with [CodeInvalidArgument].
+//
+// Because the same context is typically used for outbound calls made while
+// handling a request, using this interceptor on both handlers and clients
+// propagates values through a chain of services.
+//
+// NewContextPropagationInterceptor panics if a field has a nil key or an
+// invalid header name.
+func NewContextPropagationInterceptor(fields []PropagationField) Interceptor {
+	fields = append([]PropagationField(nil), fields...)
+	for _, field := range fields {
+		if field.Key == nil {
+			panic(fmt.Sprintf("connect: nil context key for propagated header %q", field.Header))
+		}
+		if !httpguts.ValidHeaderFieldName(field.Header) {
+			panic(fmt.Sprintf("connect: invalid propagated header name %q", field.Header))
+		}
+		if isProtocolHeader(field.Header) {
+			panic(fmt.Sprintf("connect: propagated header %q uses a reserved prefix", field.Header))
+		}
+	}
+	return &contextPropagationInterceptor{fields: fields}
+}
+
+type contextPropagationInterceptor struct {
+	fields []PropagationField
+}
+
+func (i *contextPropagationInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if request.Spec().IsClient {
+			i.inject(ctx, request.Header())
+			return next(ctx, request)
+		}
+		ctx, err := i.extract(ctx, request.Header())
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, request)
+	}
+}
+
+func (i *contextPropagationInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return func(ctx context.Context, spec Spec) StreamingClientConn {
+		conn := next(ctx, spec)
+		// Headers are sent lazily, so it's safe to modify them here.
+		i.inject(ctx, conn.RequestHeader())
+		return conn
+	}
+}
+
+func (i *contextPropagationInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return func(ctx context.Context, conn StreamingHandlerConn) error {
+		ctx, err := i.extract(ctx, conn.RequestHeader())
+		if err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+func (i *contextPropagationInterceptor) inject(ctx context.Context, header http.Header) {
+	for _, field := range i.fields {
+		value := ctx.Value(field.Key)
+		if value == nil || header.Get(field.Header) != "" {
+			continue
+		}
+		if field.Encode != nil {
+			header.Set(field.Header, field.Encode(value))
+		} else {
+			header.Set(field.Header, fmt.Sprint(value))
+		}
+	}
+}
+
+func (i *contextPropagationInterceptor) extract(ctx context.Context, header http.Header) (context.Context, error) {
+	for _, field := range i.fields {
+		raw := header.Get(field.Header)
+		if raw == "" {
+			continue
+		}
+		var value any = raw
+		if field.Decode != nil {
+			decoded, err := field.Decode(raw)
+			if err != nil {
+				return ctx, errorf(CodeInvalidArgument, "invalid %s header: %w", field.Header, err)
+			}
+			value = decoded
+		}
+		ctx = context.WithValue(ctx, field.Key, value)
+	}
+	return ctx, nil
+}
+
+// isProtocolHeader reports whether the header name uses one of the prefixes
+// reserved for the Connect and gRPC protocols.
+func isProtocolHeader(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(lower, "connect-") || strings.HasPrefix(lower, "grpc-")
+}
@@ -0,0 +1,124 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestContextPropagationInterceptor(t *testing.T) {
+	t.Parallel()
+	type tenantKey struct{}
+	type priorityKey struct{}
+	fields := []connect.PropagationField{
+		{Key: tenantKey{}, Header: "Tenant"},
+		{
+			Key:    priorityKey{},
+			Header: "Priority",
+			Encode: func(value any) string { return strconv.Itoa(value.(int)) }, //nolint:forcetypeassert
+			Decode: func(header string) (any, error) { return strconv.Atoi(header) },
+		},
+	}
+	// The handler reports the values it finds in its context.
+	describe := func(ctx context.Context) string {
+		return fmt.Sprintf("tenant=%v priority=%v", ctx.Value(tenantKey{}), ctx.Value(priorityKey{}))
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Text: describe(ctx)}), nil
+			},
+			countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				stream.ResponseHeader().Set("Context-Values", describe(ctx))
+				return stream.Send(&pingv1.CountUpResponse{Number: 1})
+			},
+		},
+		connect.WithInterceptors(connect.NewContextPropagationInterceptor(fields)),
+	))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithInterceptors(connect.NewContextPropagationInterceptor(fields)),
+	)
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	ctx = context.WithValue(ctx, priorityKey{}, 7)
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		response, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetText(), "tenant=acme priority=7")
+	})
+	t.Run("streaming", func(t *testing.T) {
+		t.Parallel()
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		for stream.Receive() {
+			assert.NotNil(t, stream.Msg())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, stream.ResponseHeader().Get("Context-Values"), "tenant=acme priority=7")
+	})
+	t.Run("missing_values", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+		response, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetText(), "tenant=acme priority=<nil>")
+	})
+	t.Run("explicit_header", func(t *testing.T) {
+		t.Parallel()
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		request.Header().Set("Tenant", "globex")
+		response, err := client.Ping(ctx, request)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.GetText(), "tenant=globex priority=7")
+	})
+	t.Run("invalid_header", func(t *testing.T) {
+		t.Parallel()
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		request.Header().Set("Priority", "high")
+		_, err := client.Ping(context.Background(), request)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+	})
+	t.Run("invalid_fields", func(t *testing.T) {
+		t.Parallel()
+		for _, field := range []connect.PropagationField{
+			{Header: "Tenant"},
+			{Key: tenantKey{}, Header: ""},
+			{Key: tenantKey{}, Header: "Tenant Id"},
+			{Key: tenantKey{}, Header: "Grpc-Tenant"},
+			{Key: tenantKey{}, Header: "connect-tenant"},
+		} {
+			field := field
+			assert.Panics(t, func() {
+				connect.NewContextPropagationInterceptor([]connect.PropagationField{field})
+			}, assert.Sprintf("header %q", field.Header))
+		}
+	})
+}
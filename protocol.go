@@ -21,17 +21,23 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"sort"
 	"strings"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // The names of the Connect, gRPC, and gRPC-Web protocols (as exposed by
-// [Peer.Protocol]). Additional protocols may be added in the future.
+// [Peer.Protocol]), along with server-sent events (see
+// [WithServerSentEvents]). Additional protocols may be added in the future.
 const (
 	ProtocolConnect = "connect"
 	ProtocolGRPC    = "grpc"
 	ProtocolGRPCWeb = "grpcweb"
+	ProtocolSSE     = "sse"
 )
 
 const (
@@ -41,6 +47,9 @@ const (
 	headerHost            = "Host"
 	headerUserAgent       = "User-Agent"
 	headerTrailer         = "Trailer"
+	headerForwardedProto  = "X-Forwarded-Proto"
+	headerRetryAfter      = "Retry-After"
+	headerDryRun          = "Connect-Dry-Run"
 
 	discardLimit = 1024 * 1024 * 4 // 4MiB
 )
@@ -61,7 +70,18 @@ var errNoTimeout = errors.New("no timeout")
 //
 // We don't have any short-term plans to export this interface; it's just here
 // to separate the protocol-specific portions of connect from the
-// protocol-agnostic plumbing.
+// protocol-agnostic plumbing. Exporting it would also mean exporting the
+// params structs, handlerConnCloser, and the buffer and compression pools,
+// and freezing all of them. Protocols that only differ on the server side can
+// implement protocolHandler alone, as the server-sent events handler does.
+//
+// On the server, a Handler routes each request as follows: it picks the
+// protocolHandlers whose Methods include the request's HTTP method, asks each
+// in turn whether CanHandlePayload, and uses the first that can. It then calls
+// SetTimeout and NewConn, and runs the interceptors and implementation with
+// the returned conn. NewConn is responsible for the protocol's framing and for
+// encoding errors on the wire; if it returns false, it must already have
+// written a response to the client.
 type protocol interface {
 	NewHandler(*protocolHandlerParams) protocolHandler
 	NewClient(*protocolClientParams) (protocolClient, error)
@@ -76,12 +96,20 @@ type protocolHandlerParams struct {
 	Spec                         Spec
 	Codecs                       readOnlyCodecs
 	CompressionPools             readOnlyCompressionPools
+	AcceptCompression            readOnlyCompressionPools
 	CompressMinBytes             int
 	BufferPool                   *bufferPool
 	ReadMaxBytes                 int
+	MaxDecompressionRatio        float64
+	ReadMaxFrames                int
+	ReadMaxEmptyFrames           int
 	SendMaxBytes                 int
 	RequireConnectProtocolHeader bool
 	IdempotencyLevel             IdempotencyLevel
+	CompressionSelector          func(context.Context, Spec, []string) string
+	GRPCWebTrailerMode           GRPCWebTrailerMode
+	GetParamNames                ConnectGetParamNames
+	StreamHeartbeat              time.Duration
 }
 
 // Handler is the server side of a protocol. HTTP handlers typically support
@@ -116,18 +144,37 @@ type protocolHandler interface {
 // Protocol implementations should take care to use the supplied Spec rather
 // than constructing their own, since new fields may have been added.
 type protocolClientParams struct {
-	CompressionName  string
-	CompressionPools readOnlyCompressionPools
-	Codec            Codec
-	CompressMinBytes int
-	HTTPClient       HTTPClient
-	URL              *url.URL
-	BufferPool       *bufferPool
-	ReadMaxBytes     int
-	SendMaxBytes     int
-	EnableGet        bool
-	GetURLMaxBytes   int
-	GetUseFallback   bool
+	CompressionName       string
+	CompressionPools      readOnlyCompressionPools
+	Codec                 Codec
+	CompressMinBytes      int
+	HTTPClient            HTTPClient
+	URL                   *url.URL
+	BufferPool            *bufferPool
+	ReadMaxBytes          int
+	MaxDecompressionRatio float64
+	ReadMaxFrames         int
+	ReadMaxEmptyFrames    int
+	SendMaxBytes          int
+	EnableGet             bool
+	GetURLMaxBytes        int
+	GetUseFallback        bool
+	GetParamNames         ConnectGetParamNames
+	TimeoutEncoder        func(time.Duration, http.Header)
+	LenientGzip           bool
+	MaxCallAttempts       int
+	SkipDrain             bool
+	RequireHTTP2          bool
+	DisableKeepAlives     bool
+	ConnObserver          func(context.Context, Spec, httptrace.GotConnInfo)
+	MaxMessageAge         time.Duration
+	AcceptHeartbeats      bool
+	// TypeResolver, if non-nil, resolves the types of error details received
+	// from the server.
+	TypeResolver protoregistry.MessageTypeResolver
+	// Now is the time source for encoding timeouts. If nil, clients use
+	// time.Now. It's a seam for tests that need deterministic deadlines.
+	Now func() time.Time
 	// The gRPC family of protocols always needs access to a Protobuf codec to
 	// marshal and unmarshal errors.
 	Protobuf Codec
@@ -167,16 +214,40 @@ type streamingClientConn interface {
 type errorTranslatingHandlerConnCloser struct {
 	handlerConnCloser
 
+	ctx      context.Context
 	toWire   func(error) error
 	fromWire func(error) error
 }
 
 func (hc *errorTranslatingHandlerConnCloser) Send(msg any) error {
-	return hc.fromWire(hc.handlerConnCloser.Send(msg))
+	return hc.sendWithFlags(msg, 0)
+}
+
+func (hc *errorTranslatingHandlerConnCloser) sendWithFlags(msg any, flags uint8) error {
+	// If the client has gone away, writes fail with confusing I/O errors (for
+	// example, broken pipes). Fail fast with the context's error instead.
+	if err := hc.ctx.Err(); err != nil {
+		return hc.contextError(err)
+	}
+	if err := sendWithFlags(hc.handlerConnCloser, msg, flags); err != nil {
+		if ctxErr := hc.ctx.Err(); ctxErr != nil {
+			return hc.contextError(ctxErr)
+		}
+		return hc.fromWire(err)
+	}
+	return nil
 }
 
 func (hc *errorTranslatingHandlerConnCloser) Receive(msg any) error {
-	return hc.fromWire(hc.handlerConnCloser.Receive(msg))
+	if err := hc.handlerConnCloser.Receive(msg); err != nil {
+		// Reads interrupted by the RPC's deadline fail with I/O errors, so
+		// prefer the context's error.
+		if ctxErr := hc.ctx.Err(); ctxErr != nil && !errors.Is(err, io.EOF) {
+			return hc.contextError(ctxErr)
+		}
+		return hc.fromWire(err)
+	}
+	return nil
 }
 
 func (hc *errorTranslatingHandlerConnCloser) Close(err error) error {
@@ -184,6 +255,16 @@ func (hc *errorTranslatingHandlerConnCloser) Close(err error) error {
 	return hc.fromWire(closeErr)
 }
 
+// contextError codes the error from a done request context. If the context
+// was canceled with an *Error as its cause, that error is used instead, so
+// that whatever canceled the RPC can choose the code sent to the client.
+func (hc *errorTranslatingHandlerConnCloser) contextError(ctxErr error) error {
+	if causeErr := contextCauseError(hc.ctx); causeErr != nil {
+		return causeErr
+	}
+	return hc.fromWire(ctxErr)
+}
+
 func (hc *errorTranslatingHandlerConnCloser) getHTTPMethod() string {
 	if methoder, ok := hc.handlerConnCloser.(interface{ getHTTPMethod() string }); ok {
 		return methoder.getHTTPMethod()
@@ -191,6 +272,18 @@ func (hc *errorTranslatingHandlerConnCloser) getHTTPMethod() string {
 	return http.MethodPost
 }
 
+func (hc *errorTranslatingHandlerConnCloser) getResponseCompression() string {
+	return responseCompressionOf(hc.handlerConnCloser)
+}
+
+func (hc *errorTranslatingHandlerConnCloser) getCodecName() string {
+	return codecNameOf(hc.handlerConnCloser)
+}
+
+func (hc *errorTranslatingHandlerConnCloser) receivedFlags() uint8 {
+	return receivedFlagsOf(hc.handlerConnCloser)
+}
+
 // errorTranslatingClientConn wraps a StreamingClientConn to make sure that we always
 // return coded errors from clients.
 //
@@ -199,48 +292,259 @@ type errorTranslatingClientConn struct {
 	streamingClientConn
 
 	fromWire func(error) error
+	resolver protoResolver
 }
 
 func (cc *errorTranslatingClientConn) Send(msg any) error {
-	return cc.fromWire(cc.streamingClientConn.Send(msg))
+	return cc.translate(cc.streamingClientConn.Send(msg))
+}
+
+func (cc *errorTranslatingClientConn) sendWithFlags(msg any, flags uint8) error {
+	return cc.translate(sendWithFlags(cc.streamingClientConn, msg, flags))
 }
 
 func (cc *errorTranslatingClientConn) Receive(msg any) error {
-	return cc.fromWire(cc.streamingClientConn.Receive(msg))
+	err := cc.translate(cc.streamingClientConn.Receive(msg))
+	if connectErr, ok := asError(err); ok && connectErr.wireErr && connectErr.httpStatus == 0 {
+		connectErr.httpStatus = responseStatusOf(cc.streamingClientConn)
+	}
+	return err
 }
 
 func (cc *errorTranslatingClientConn) CloseRequest() error {
-	return cc.fromWire(cc.streamingClientConn.CloseRequest())
+	return cc.translate(cc.streamingClientConn.CloseRequest())
 }
 
 func (cc *errorTranslatingClientConn) CloseResponse() error {
-	return cc.fromWire(cc.streamingClientConn.CloseResponse())
+	return cc.translate(cc.streamingClientConn.CloseResponse())
+}
+
+// translate codes the error and attaches the client's type resolver, if any,
+// to the error's details.
+func (cc *errorTranslatingClientConn) translate(err error) error {
+	err = cc.fromWire(err)
+	if cc.resolver == nil {
+		return err
+	}
+	if connectErr, ok := asError(err); ok {
+		for _, detail := range connectErr.details {
+			detail.resolver = cc.resolver
+		}
+	}
+	return err
 }
 
 func (cc *errorTranslatingClientConn) onRequestSend(fn func(*http.Request)) {
 	cc.streamingClientConn.onRequestSend(fn)
 }
 
+func (cc *errorTranslatingClientConn) getResponseCompression() string {
+	return responseCompressionOf(cc.streamingClientConn)
+}
+
+func (cc *errorTranslatingClientConn) getResponseStatus() int {
+	return responseStatusOf(cc.streamingClientConn)
+}
+
+func (cc *errorTranslatingClientConn) getCodecName() string {
+	return codecNameOf(cc.streamingClientConn)
+}
+
+func (cc *errorTranslatingClientConn) receivedFlags() uint8 {
+	return receivedFlagsOf(cc.streamingClientConn)
+}
+
+// lenientGzipPool returns the gzip compression pool if the client opted into
+// WithLenientDecompression, and nil otherwise.
+func (p *protocolClientParams) lenientGzipPool() *compressionPool {
+	if !p.LenientGzip {
+		return nil
+	}
+	return p.CompressionPools.Get(compressionGzip)
+}
+
+// newDuplexHTTPCall constructs a duplexHTTPCall for the RPC, applying any
+// limit on transport-level replays configured with WithMaxCallAttempts and
+// honoring WithoutResponseDraining, WithRequireHTTP2, WithDisableKeepAlives,
+// and WithConnectionObserver.
+func (p *protocolClientParams) newDuplexHTTPCall(ctx context.Context, spec Spec, header http.Header) *duplexHTTPCall {
+	if p.ConnObserver != nil {
+		callCtx := ctx
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				p.ConnObserver(callCtx, spec, info)
+			},
+		})
+	}
+	duplexCall := newDuplexHTTPCall(ctx, p.HTTPClient, p.URL, spec, header)
+	if p.MaxCallAttempts > 0 && spec.IdempotencyLevel == IdempotencyUnknown {
+		duplexCall.SetMaxAttempts(p.MaxCallAttempts)
+	}
+	if p.SkipDrain {
+		duplexCall.DisableResponseDraining()
+	}
+	if p.RequireHTTP2 {
+		duplexCall.RequireHTTP2()
+	}
+	if p.DisableKeepAlives {
+		duplexCall.DisableKeepAlives()
+	}
+	return duplexCall
+}
+
+// sendCompression returns the name of the compression algorithm for this
+// call's requests: the override from WithCallSendCompression, if any, or the
+// client's default. WriteRequestHeader announces the default, so if the
+// override differs and headerKey isn't empty, sendCompression also updates
+// that header.
+func (p *protocolClientParams) sendCompression(ctx context.Context, header http.Header, headerKey string) string {
+	name, ok := callSendCompression(ctx)
+	if !ok || name == p.CompressionName {
+		return p.CompressionName
+	}
+	if headerKey != "" {
+		if name == "" || name == compressionIdentity {
+			delHeaderCanonical(header, headerKey)
+		} else {
+			header[headerKey] = []string{name}
+		}
+	}
+	return name
+}
+
+// now returns the current time according to the configured time source.
+func (p *protocolClientParams) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// encodeTimeout sets a header with the time remaining until the context's
+// deadline, if any, measured from the time returned by now. If the client
+// didn't configure a custom encoder with WithTimeoutEncoder, it uses the
+// protocol's standard encoding.
+func encodeTimeout(
+	ctx context.Context,
+	header http.Header,
+	now func() time.Time,
+	custom func(time.Duration, http.Header),
+	standard func(time.Duration, http.Header),
+) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	timeout := deadline.Sub(now())
+	if custom == nil {
+		standard(timeout, header)
+		return
+	}
+	if timeout < 0 {
+		timeout = 0
+	}
+	custom(timeout, header)
+}
+
 // wrapHandlerConnWithCodedErrors ensures that we (1) automatically code
-// context-related errors correctly when writing them to the network, and (2)
-// return *Errors from all exported APIs.
-func wrapHandlerConnWithCodedErrors(conn handlerConnCloser) handlerConnCloser {
+// context-related errors correctly when writing them to the network, (2)
+// return *Errors from all exported APIs, and (3) fail sends with CodeCanceled
+// or CodeDeadlineExceeded once the request context is done, unless it was
+// canceled with an *Error cause.
+func wrapHandlerConnWithCodedErrors(ctx context.Context, conn handlerConnCloser) handlerConnCloser {
 	return &errorTranslatingHandlerConnCloser{
 		handlerConnCloser: conn,
+		ctx:               ctx,
 		toWire:            wrapIfContextError,
 		fromWire:          wrapIfUncoded,
 	}
 }
 
 // wrapClientConnWithCodedErrors ensures that we always return *Errors from
-// public APIs.
-func wrapClientConnWithCodedErrors(conn streamingClientConn) streamingClientConn {
+// public APIs, and that their details use the client's type resolver.
+func wrapClientConnWithCodedErrors(conn streamingClientConn, resolver protoregistry.MessageTypeResolver) streamingClientConn {
 	return &errorTranslatingClientConn{
 		streamingClientConn: conn,
 		fromWire:            wrapIfUncoded,
+		resolver:            newProtoResolver(resolver),
 	}
 }
 
+// responseCompressionOf returns the name of the compression algorithm
+// negotiated for the response body of a client or handler conn. The built-in
+// conn wrappers forward it, but conns that don't expose this information (for
+// example, conns wrapped by third-party interceptors) report identity.
+func responseCompressionOf(conn any) string {
+	if compressioner, ok := conn.(interface{ getResponseCompression() string }); ok {
+		return compressioner.getResponseCompression()
+	}
+	return compressionIdentity
+}
+
+// codecNameOf returns the name of the codec used by a client or handler conn.
+// Conns that don't expose this information (for example, conns wrapped by
+// interceptors) report the empty string.
+func codecNameOf(conn any) string {
+	if codecNamer, ok := conn.(interface{ getCodecName() string }); ok {
+		return codecNamer.getCodecName()
+	}
+	return ""
+}
+
+// sendWithCustomFlags sends a message with application-supplied envelope
+// flags, which may only use the bits in CustomEnvelopeFlags.
+func sendWithCustomFlags(conn interface{ Send(any) error }, msg any, flags uint8) error {
+	if flags&^CustomEnvelopeFlags != 0 {
+		return errorf(CodeInternal, "envelope flags %08b use bits reserved by the protocol", flags)
+	}
+	return sendWithFlags(conn, msg, flags)
+}
+
+// sendWithFlags sends a message with envelope flags, without checking them
+// against CustomEnvelopeFlags. Only Connect streaming conns support envelope
+// flags: other conns, including conns wrapped by interceptors, return an error
+// unless the flags are zero.
+func sendWithFlags(conn interface{ Send(any) error }, msg any, flags uint8) error {
+	if flags == 0 {
+		return conn.Send(msg)
+	}
+	sender, ok := conn.(interface{ sendWithFlags(any, uint8) error })
+	if !ok {
+		return errorf(CodeUnimplemented, "custom envelope flags are only supported by Connect streams")
+	}
+	return sender.sendWithFlags(msg, flags)
+}
+
+// supportsEnvelopeFlags reports whether a conn can send and receive custom
+// envelope flags. Conns wrapped by interceptors and conns using the gRPC or
+// gRPC-Web protocols can't.
+func supportsEnvelopeFlags(conn interface{ Peer() Peer }) bool {
+	_, ok := conn.(interface{ sendWithFlags(any, uint8) error })
+	return ok && conn.Peer().Protocol == ProtocolConnect
+}
+
+// receivedFlagsOf returns the envelope flags of the last message a conn
+// received, other than the compression flag. Besides the CustomEnvelopeFlags,
+// they may include the credit flag, so mask them before returning them to
+// applications. Conns that don't support custom flags (for example, conns
+// wrapped by interceptors) report zero.
+func receivedFlagsOf(conn any) uint8 {
+	if receiver, ok := conn.(interface{ receivedFlags() uint8 }); ok {
+		return receiver.receivedFlags()
+	}
+	return 0
+}
+
+// responseStatusOf returns the HTTP status code of a client conn's response.
+// Conns that don't expose this information (for example, conns wrapped by
+// interceptors) and conns that never received a response report zero.
+func responseStatusOf(conn any) int {
+	if statuser, ok := conn.(interface{ getResponseStatus() int }); ok {
+		return statuser.getResponseStatus()
+	}
+	return 0
+}
+
 func mappedMethodHandlers(handlers []protocolHandler) map[string][]protocolHandler {
 	methodHandlers := make(map[string][]protocolHandler)
 	for _, handler := range handlers {
@@ -297,9 +601,11 @@ func discard(reader io.Reader) (int64, error) {
 
 // negotiateCompression determines and validates the request compression and
 // response compression using the available compressors and protocol-specific
-// Content-Encoding and Accept-Encoding headers.
+// Content-Encoding and Accept-Encoding headers. Requests may use any available
+// compressor, but responses only use the advertised ones.
 func negotiateCompression( //nolint:nonamedreturns
 	availableCompressors readOnlyCompressionPools,
+	advertisedCompressors readOnlyCompressionPools,
 	sent, accept string,
 ) (requestCompression, responseCompression string, clientVisibleErr *Error) {
 	requestCompression = compressionIdentity
@@ -324,12 +630,15 @@ func negotiateCompression( //nolint:nonamedreturns
 	// Support asymmetric compression. This logic follows
 	// https://github.com/grpc/grpc/blob/master/doc/compression.md and common
 	// sense.
-	responseCompression = requestCompression
+	responseCompression = compressionIdentity
+	if advertisedCompressors.Contains(requestCompression) {
+		responseCompression = requestCompression
+	}
 	// If we're not already planning to compress the response, check whether the
 	// client requested a compression algorithm we support.
 	if responseCompression == compressionIdentity && accept != "" {
 		for _, name := range strings.FieldsFunc(accept, isCommaOrSpace) {
-			if availableCompressors.Contains(name) {
+			if advertisedCompressors.Contains(name) {
 				// We found a mutually supported compression algorithm. Unlike standard
 				// HTTP, there's no preference weighting, so can bail out immediately.
 				responseCompression = name
@@ -340,6 +649,35 @@ func negotiateCompression( //nolint:nonamedreturns
 	return requestCompression, responseCompression, nil
 }
 
+// selectResponseCompression lets the handler's compression selector, if any,
+// override the negotiated response compression. The selector chooses from the
+// algorithms that both the client and the handler support; anything else
+// falls back to identity.
+func (p *protocolHandlerParams) selectResponseCompression(
+	ctx context.Context,
+	requestCompression, accept, negotiated string,
+) string {
+	if p.CompressionSelector == nil {
+		return negotiated
+	}
+	var accepted []string
+	if requestCompression != compressionIdentity && p.AcceptCompression.Contains(requestCompression) {
+		accepted = append(accepted, requestCompression)
+	}
+	for _, name := range strings.FieldsFunc(accept, isCommaOrSpace) {
+		if name != requestCompression && p.AcceptCompression.Contains(name) {
+			accepted = append(accepted, name)
+		}
+	}
+	selected := p.CompressionSelector(ctx, p.Spec, accepted)
+	for _, name := range accepted {
+		if name == selected {
+			return selected
+		}
+	}
+	return compressionIdentity
+}
+
 // checkServerStreamsCanFlush ensures that bidi and server streaming handlers
 // have received an http.ResponseWriter that implements http.Flusher, since
 // they must flush data after sending each message.
@@ -381,6 +719,24 @@ func canonicalizeContentType(contentType string) string {
 	return canonicalizeContentTypeSlow(contentType)
 }
 
+// contentTypeWithoutParams strips the parameters from a canonical Content-Type.
+// It reports false if there are no parameters to strip, or if the Content-Type
+// specifies a charset other than UTF-8: since all our text-based codecs use
+// UTF-8, ignoring another charset would silently corrupt the payload.
+func contentTypeWithoutParams(contentType string) (string, bool) {
+	if !strings.Contains(contentType, ";") {
+		return "", false
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || len(params) == 0 {
+		return "", false
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return "", false
+	}
+	return mediaType, true
+}
+
 func canonicalizeContentTypeSlow(contentType string) string {
 	base, params, err := mime.ParseMediaType(contentType)
 	if err != nil {
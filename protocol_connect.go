@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/protobuf/types/known/anypb"
@@ -41,10 +43,32 @@ const (
 	connectStreamingHeaderAcceptCompression = "Connect-Accept-Encoding"
 	connectHeaderTimeout                    = "Connect-Timeout-Ms"
 	connectHeaderProtocolVersion            = "Connect-Protocol-Version"
+	connectHeaderAcceptHeartbeat            = "Connect-Accept-Heartbeat"
+	connectHeaderAcceptTimestamp            = "Connect-Accept-Timestamp"
+	connectHeaderCreditWindow               = "Connect-Credit-Window"
 	connectProtocolVersion                  = "1"
 	headerVary                              = "Vary"
 
 	connectFlagEnvelopeEndStream = 0b00000010
+	// connectFlagEnvelopeHeartbeat marks an empty envelope that only keeps the
+	// stream alive. It's an extension to the Connect protocol, so handlers only
+	// send heartbeats to clients that advertise support with the
+	// Connect-Accept-Heartbeat request header.
+	connectFlagEnvelopeHeartbeat = 0b10000000
+	// connectFlagEnvelopeTimestamp marks an envelope holding the time, in
+	// big-endian nanoseconds since the Unix epoch, at which the handler sent the
+	// next message. It's also an extension to the Connect protocol: handlers only
+	// send timestamps to clients that ask for them with the
+	// Connect-Accept-Timestamp request header.
+	connectFlagEnvelopeTimestamp = 0b01000000
+	// connectFlagEnvelopeCredit marks an empty message that grants the peer
+	// more credit. It's set only by CreditControlledBidi and only when the peer
+	// advertised its window with the Connect-Credit-Window header. It isn't one
+	// of the CustomEnvelopeFlags, so applications can't forge credit grants.
+	connectFlagEnvelopeCredit = 0b00100000
+	// connectMessageFlags are the flags that may accompany a Connect streaming
+	// message in addition to the compression flag.
+	connectMessageFlags = CustomEnvelopeFlags | connectFlagEnvelopeCredit
 
 	connectUnaryContentTypePrefix     = "application/"
 	connectUnaryContentTypeJSON       = connectUnaryContentTypePrefix + "json"
@@ -58,6 +82,60 @@ const (
 	connectUnaryConnectQueryValue         = "v" + connectProtocolVersion
 )
 
+// CustomEnvelopeFlags are the bits of the envelope flag byte that
+// applications may set on Connect streaming messages for their own
+// out-of-band signaling, like marking control messages. Streams like
+// [BidiStream] set them with SendWithFlags and read them back with
+// ReceivedFlags.
+//
+// The other bits are reserved. The Connect protocol uses 0b00000001 for
+// compressed messages and 0b00000010 for the end of the stream, and this
+// package uses 0b00100000, 0b01000000, and 0b10000000 for its credit, message
+// timestamp, and heartbeat extensions (see [CreditControlledBidi],
+// [WithMaxMessageAge], and [WithStreamHeartbeat]). Custom flags aren't part of
+// the Connect protocol, so only send them to peers using this package that
+// expect them: other implementations may reject the messages. The gRPC and
+// gRPC-Web protocols don't support custom flags.
+const CustomEnvelopeFlags uint8 = 0b00011100
+
+// connectSupportedProtocolVersions lists the values of the
+// Connect-Protocol-Version header that handlers accept; requests with any
+// other version are rejected rather than risk misinterpreting them. As the
+// protocol evolves, add new versions here. Clients always send
+// connectProtocolVersion. GET requests carry the same versions in a query
+// parameter, prefixed with "v".
+var connectSupportedProtocolVersions = []string{connectProtocolVersion}
+
+// ConnectGetParamNames are the names of the query parameters used by Connect
+// unary GET requests. Empty fields use the names from the Connect protocol
+// specification. See [WithConnectGetQueryParams].
+type ConnectGetParamNames struct {
+	Message     string // defaults to "message"
+	Encoding    string // defaults to "encoding"
+	Base64      string // defaults to "base64"
+	Compression string // defaults to "compression"
+	Connect     string // defaults to "connect"
+}
+
+func (n ConnectGetParamNames) withDefaults() ConnectGetParamNames {
+	if n.Message == "" {
+		n.Message = connectUnaryMessageQueryParameter
+	}
+	if n.Encoding == "" {
+		n.Encoding = connectUnaryEncodingQueryParameter
+	}
+	if n.Base64 == "" {
+		n.Base64 = connectUnaryBase64QueryParameter
+	}
+	if n.Compression == "" {
+		n.Compression = connectUnaryCompressionQueryParameter
+	}
+	if n.Connect == "" {
+		n.Connect = connectUnaryConnectQueryParameter
+	}
+	return n
+}
+
 // defaultConnectUserAgent returns a User-Agent string similar to those used in gRPC.
 var defaultConnectUserAgent = fmt.Sprintf("connect-go/%s (%s)", Version, runtime.Version())
 
@@ -133,7 +211,7 @@ func (*connectHandler) SetTimeout(request *http.Request) (context.Context, conte
 func (h *connectHandler) CanHandlePayload(request *http.Request, contentType string) bool {
 	if request.Method == http.MethodGet {
 		query := request.URL.Query()
-		codecName := query.Get(connectUnaryEncodingQueryParameter)
+		codecName := query.Get(h.GetParamNames.Encoding)
 		contentType = connectContentTypeFromCodecName(
 			h.Spec.StreamType,
 			codecName,
@@ -153,7 +231,7 @@ func (h *connectHandler) NewConn(
 	var contentEncoding, acceptEncoding string
 	if h.Spec.StreamType == StreamTypeUnary {
 		if request.Method == http.MethodGet {
-			contentEncoding = query.Get(connectUnaryCompressionQueryParameter)
+			contentEncoding = query.Get(h.GetParamNames.Compression)
 		} else {
 			contentEncoding = getHeaderCanonical(request.Header, connectUnaryHeaderCompression)
 		}
@@ -164,41 +242,45 @@ func (h *connectHandler) NewConn(
 	}
 	requestCompression, responseCompression, failed := negotiateCompression(
 		h.CompressionPools,
+		h.AcceptCompression,
 		contentEncoding,
 		acceptEncoding,
 	)
+	if failed == nil {
+		responseCompression = h.selectResponseCompression(request.Context(), requestCompression, acceptEncoding, responseCompression)
+	}
 	if failed == nil {
 		failed = checkServerStreamsCanFlush(h.Spec, responseWriter)
 	}
 	if failed == nil && request.Method == http.MethodGet {
-		version := query.Get(connectUnaryConnectQueryParameter)
+		version := query.Get(h.GetParamNames.Connect)
 		if version == "" && h.RequireConnectProtocolHeader {
-			failed = errorf(CodeInvalidArgument, "missing required query parameter: set %s to %q", connectUnaryConnectQueryParameter, connectUnaryConnectQueryValue)
-		} else if version != "" && version != connectUnaryConnectQueryValue {
-			failed = errorf(CodeInvalidArgument, "%s must be %q: got %q", connectUnaryConnectQueryParameter, connectUnaryConnectQueryValue, version)
+			failed = errorf(CodeInvalidArgument, "missing required query parameter: set %s to %q", h.GetParamNames.Connect, connectUnaryConnectQueryValue)
+		} else if version != "" && !connectQueryVersionSupported(version) {
+			failed = errorf(CodeInvalidArgument, "unsupported %s query parameter %q: must be %s", h.GetParamNames.Connect, version, connectSupportedVersionsList("v"))
 		}
 	}
 	if failed == nil && request.Method == http.MethodPost {
 		version := getHeaderCanonical(request.Header, connectHeaderProtocolVersion)
 		if version == "" && h.RequireConnectProtocolHeader {
 			failed = errorf(CodeInvalidArgument, "missing required header: set %s to %q", connectHeaderProtocolVersion, connectProtocolVersion)
-		} else if version != "" && version != connectProtocolVersion {
-			failed = errorf(CodeInvalidArgument, "%s must be %q: got %q", connectHeaderProtocolVersion, connectProtocolVersion, version)
+		} else if version != "" && !connectProtocolVersionSupported(version) {
+			failed = errorf(CodeInvalidArgument, "unsupported %s %q: must be %s", connectHeaderProtocolVersion, version, connectSupportedVersionsList(""))
 		}
 	}
 
 	var requestBody io.ReadCloser
 	var contentType, codecName string
 	if request.Method == http.MethodGet {
-		if failed == nil && !query.Has(connectUnaryEncodingQueryParameter) {
-			failed = errorf(CodeInvalidArgument, "missing %s parameter", connectUnaryEncodingQueryParameter)
-		} else if failed == nil && !query.Has(connectUnaryMessageQueryParameter) {
-			failed = errorf(CodeInvalidArgument, "missing %s parameter", connectUnaryMessageQueryParameter)
+		if failed == nil && !query.Has(h.GetParamNames.Encoding) {
+			failed = errorf(CodeInvalidArgument, "missing %s parameter", h.GetParamNames.Encoding)
+		} else if failed == nil && !query.Has(h.GetParamNames.Message) {
+			failed = errorf(CodeInvalidArgument, "missing %s parameter", h.GetParamNames.Message)
 		}
-		msg := query.Get(connectUnaryMessageQueryParameter)
-		msgReader := queryValueReader(msg, query.Get(connectUnaryBase64QueryParameter) == "1")
+		msg := query.Get(h.GetParamNames.Message)
+		msgReader := queryValueReader(msg, query.Get(h.GetParamNames.Base64) == "1")
 		requestBody = io.NopCloser(msgReader)
-		codecName = query.Get(connectUnaryEncodingQueryParameter)
+		codecName = query.Get(h.GetParamNames.Encoding)
 		contentType = connectContentTypeFromCodecName(
 			h.Spec.StreamType,
 			codecName,
@@ -239,13 +321,14 @@ func (h *connectHandler) NewConn(
 			header[connectStreamingHeaderCompression] = []string{responseCompression}
 		}
 	}
-	header[acceptCompressionHeader] = []string{h.CompressionPools.CommaSeparatedNames()}
+	header[acceptCompressionHeader] = []string{h.AcceptCompression.CommaSeparatedNames()}
 
 	var conn handlerConnCloser
 	peer := Peer{
 		Addr:     request.RemoteAddr,
 		Protocol: ProtocolConnect,
 		Query:    query,
+		TLS:      request.TLS,
 	}
 	if h.Spec.StreamType == StreamTypeUnary {
 		conn = &connectUnaryHandlerConn{
@@ -269,6 +352,7 @@ func (h *connectHandler) NewConn(
 				compressionPool: h.CompressionPools.Get(requestCompression),
 				bufferPool:      h.BufferPool,
 				readMaxBytes:    h.ReadMaxBytes,
+				maxRatio:        h.MaxDecompressionRatio,
 			},
 			responseTrailer: make(http.Header),
 		}
@@ -295,12 +379,28 @@ func (h *connectHandler) NewConn(
 					compressionPool: h.CompressionPools.Get(requestCompression),
 					bufferPool:      h.BufferPool,
 					readMaxBytes:    h.ReadMaxBytes,
+					maxRatio:        h.MaxDecompressionRatio,
+					maxFrames:       h.ReadMaxFrames,
+					maxEmptyFrames:  h.ReadMaxEmptyFrames,
+					customFlags:     connectMessageFlags,
 				},
 			},
 			responseTrailer: make(http.Header),
+			compression:     responseCompression,
+		}
+		if streamingConn, ok := conn.(*connectStreamingHandlerConn); ok &&
+			h.Spec.StreamType&StreamTypeServer != 0 {
+			if failed == nil &&
+				h.StreamHeartbeat > 0 &&
+				getHeaderCanonical(request.Header, connectHeaderAcceptHeartbeat) == "1" {
+				streamingConn.heartbeatInterval = h.StreamHeartbeat
+			}
+			if getHeaderCanonical(request.Header, connectHeaderAcceptTimestamp) == "1" {
+				streamingConn.stampMessages = true
+			}
 		}
 	}
-	conn = wrapHandlerConnWithCodedErrors(conn)
+	conn = wrapHandlerConnWithCodedErrors(request.Context(), conn)
 
 	if failed != nil {
 		// Negotiation failed, so we can't establish a stream.
@@ -327,6 +427,14 @@ func (c *connectClient) WriteRequestHeader(streamType StreamType, header http.He
 		header[headerUserAgent] = []string{defaultConnectUserAgent}
 	}
 	header[connectHeaderProtocolVersion] = []string{connectProtocolVersion}
+	if streamType&StreamTypeServer != 0 {
+		if c.AcceptHeartbeats {
+			header[connectHeaderAcceptHeartbeat] = []string{"1"}
+		}
+		if c.MaxMessageAge > 0 {
+			header[connectHeaderAcceptTimestamp] = []string{"1"}
+		}
+	}
 	header[headerContentType] = []string{
 		connectContentTypeFromCodecName(streamType, c.Codec.Name()),
 	}
@@ -355,16 +463,15 @@ func (c *connectClient) NewConn(
 	spec Spec,
 	header http.Header,
 ) streamingClientConn {
-	if deadline, ok := ctx.Deadline(); ok {
-		millis := int64(time.Until(deadline) / time.Millisecond)
-		if millis > 0 {
-			encoded := strconv.FormatInt(millis, 10 /* base */)
-			if len(encoded) <= 10 {
-				header[connectHeaderTimeout] = []string{encoded}
-			} // else effectively unbounded
-		}
+	encodeTimeout(ctx, header, c.now, c.TimeoutEncoder, connectEncodeTimeout)
+	var compressionName string
+	if spec.StreamType == StreamTypeUnary {
+		// Unary requests set Content-Encoding only if they're compressed.
+		compressionName = c.sendCompression(ctx, header, "")
+	} else {
+		compressionName = c.sendCompression(ctx, header, connectStreamingHeaderCompression)
 	}
-	duplexCall := newDuplexHTTPCall(ctx, c.HTTPClient, c.URL, spec, header)
+	duplexCall := c.newDuplexHTTPCall(ctx, spec, header)
 	var conn streamingClientConn
 	if spec.StreamType == StreamTypeUnary {
 		unaryConn := &connectUnaryClientConn{
@@ -378,18 +485,20 @@ func (c *connectClient) NewConn(
 					sender:           duplexCall,
 					codec:            c.Codec,
 					compressMinBytes: c.CompressMinBytes,
-					compressionName:  c.CompressionName,
-					compressionPool:  c.CompressionPools.Get(c.CompressionName),
+					compressionName:  compressionName,
+					compressionPool:  c.CompressionPools.Get(compressionName),
 					bufferPool:       c.BufferPool,
 					header:           duplexCall.Header(),
 					sendMaxBytes:     c.SendMaxBytes,
 				},
 			},
 			unmarshaler: connectUnaryUnmarshaler{
-				reader:       duplexCall,
-				codec:        c.Codec,
-				bufferPool:   c.BufferPool,
-				readMaxBytes: c.ReadMaxBytes,
+				reader:          duplexCall,
+				codec:           c.Codec,
+				bufferPool:      c.BufferPool,
+				readMaxBytes:    c.ReadMaxBytes,
+				maxRatio:        c.MaxDecompressionRatio,
+				lenientGzipPool: c.lenientGzipPool(),
 			},
 			responseHeader:  make(http.Header),
 			responseTrailer: make(http.Header),
@@ -398,6 +507,7 @@ func (c *connectClient) NewConn(
 			unaryConn.marshaler.enableGet = c.EnableGet
 			unaryConn.marshaler.getURLMaxBytes = c.GetURLMaxBytes
 			unaryConn.marshaler.getUseFallback = c.GetUseFallback
+			unaryConn.marshaler.getParamNames = c.GetParamNames
 			unaryConn.marshaler.duplexCall = duplexCall
 			if stableCodec, ok := c.Codec.(stableCodec); ok {
 				unaryConn.marshaler.stableCodec = stableCodec
@@ -418,17 +528,25 @@ func (c *connectClient) NewConn(
 					sender:           duplexCall,
 					codec:            c.Codec,
 					compressMinBytes: c.CompressMinBytes,
-					compressionPool:  c.CompressionPools.Get(c.CompressionName),
+					compressionPool:  c.CompressionPools.Get(compressionName),
 					bufferPool:       c.BufferPool,
 					sendMaxBytes:     c.SendMaxBytes,
 				},
 			},
 			unmarshaler: connectStreamingUnmarshaler{
 				envelopeReader: envelopeReader{
-					reader:       duplexCall,
-					codec:        c.Codec,
-					bufferPool:   c.BufferPool,
-					readMaxBytes: c.ReadMaxBytes,
+					reader:          duplexCall,
+					codec:           c.Codec,
+					bufferPool:      c.BufferPool,
+					readMaxBytes:    c.ReadMaxBytes,
+					maxRatio:        c.MaxDecompressionRatio,
+					maxFrames:       c.ReadMaxFrames,
+					maxEmptyFrames:  c.ReadMaxEmptyFrames,
+					heartbeatFlags:  connectFlagEnvelopeHeartbeat,
+					timestampFlags:  connectFlagEnvelopeTimestamp,
+					maxMessageAge:   c.MaxMessageAge,
+					customFlags:     connectMessageFlags,
+					lenientGzipPool: c.lenientGzipPool(),
 				},
 			},
 			responseHeader:  make(http.Header),
@@ -437,7 +555,7 @@ func (c *connectClient) NewConn(
 		conn = streamingConn
 		duplexCall.SetValidateResponse(streamingConn.validateResponse)
 	}
-	return wrapClientConnWithCodedErrors(conn)
+	return wrapClientConnWithCodedErrors(conn, c.TypeResolver)
 }
 
 type connectUnaryClientConn struct {
@@ -450,6 +568,7 @@ type connectUnaryClientConn struct {
 	unmarshaler      connectUnaryUnmarshaler
 	responseHeader   http.Header
 	responseTrailer  http.Header
+	compression      string
 }
 
 func (cc *connectUnaryClientConn) Spec() Spec {
@@ -503,6 +622,20 @@ func (cc *connectUnaryClientConn) onRequestSend(fn func(*http.Request)) {
 	cc.duplexCall.onRequestSend = fn
 }
 
+func (cc *connectUnaryClientConn) getResponseCompression() string {
+	_ = cc.duplexCall.BlockUntilResponseReady()
+	return compressionNameOrIdentity(cc.compression)
+}
+
+func (cc *connectUnaryClientConn) getResponseStatus() int {
+	status, _ := cc.duplexCall.ResponseStatusCode()
+	return status
+}
+
+func (cc *connectUnaryClientConn) getCodecName() string {
+	return cc.marshaler.codec.Name()
+}
+
 func (cc *connectUnaryClientConn) validateResponse(response *http.Response) *Error {
 	for k, v := range response.Header {
 		if !strings.HasPrefix(k, connectUnaryTrailerPrefix) {
@@ -535,10 +668,19 @@ func (cc *connectUnaryClientConn) validateResponse(response *http.Response) *Err
 		}
 		var wireErr connectWireError
 		if err := unmarshaler.UnmarshalFunc(&wireErr, json.Unmarshal); err != nil {
-			return NewError(
+			statusErr := NewError(
 				connectHTTPToCode(response.StatusCode),
 				errors.New(response.Status),
 			)
+			statusErr.setHTTPResponse(response)
+			return statusErr
+		}
+		if wireErr.Code == 0 {
+			// The body is valid JSON but doesn't include a code. Per the
+			// Connect specification, infer one from the HTTP status. When the
+			// body does include a code, it always takes precedence: proxies
+			// may have rewritten the status.
+			wireErr.Code = connectHTTPToCode(response.StatusCode)
 		}
 		serverErr := wireErr.asError()
 		if serverErr == nil {
@@ -546,9 +688,11 @@ func (cc *connectUnaryClientConn) validateResponse(response *http.Response) *Err
 		}
 		serverErr.meta = cc.responseHeader.Clone()
 		mergeHeaders(serverErr.meta, cc.responseTrailer)
+		serverErr.setHTTPResponse(response)
 		return serverErr
 	}
 	cc.unmarshaler.compressionPool = cc.compressionPools.Get(compression)
+	cc.compression = compression
 	return nil
 }
 
@@ -563,6 +707,7 @@ type connectStreamingClientConn struct {
 	unmarshaler      connectStreamingUnmarshaler
 	responseHeader   http.Header
 	responseTrailer  http.Header
+	compression      string
 }
 
 func (cc *connectStreamingClientConn) Spec() Spec {
@@ -574,12 +719,20 @@ func (cc *connectStreamingClientConn) Peer() Peer {
 }
 
 func (cc *connectStreamingClientConn) Send(msg any) error {
-	if err := cc.marshaler.Marshal(msg); err != nil {
+	return cc.sendWithFlags(msg, 0)
+}
+
+func (cc *connectStreamingClientConn) sendWithFlags(msg any, flags uint8) error {
+	if err := cc.marshaler.MarshalWithFlags(msg, flags); err != nil {
 		return err
 	}
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+func (cc *connectStreamingClientConn) receivedFlags() uint8 {
+	return cc.unmarshaler.lastFlags
+}
+
 func (cc *connectStreamingClientConn) RequestHeader() http.Header {
 	return cc.duplexCall.Header()
 }
@@ -639,9 +792,25 @@ func (cc *connectStreamingClientConn) onRequestSend(fn func(*http.Request)) {
 	cc.duplexCall.onRequestSend = fn
 }
 
+func (cc *connectStreamingClientConn) getResponseCompression() string {
+	_ = cc.duplexCall.BlockUntilResponseReady()
+	return compressionNameOrIdentity(cc.compression)
+}
+
+func (cc *connectStreamingClientConn) getResponseStatus() int {
+	status, _ := cc.duplexCall.ResponseStatusCode()
+	return status
+}
+
+func (cc *connectStreamingClientConn) getCodecName() string {
+	return cc.codec.Name()
+}
+
 func (cc *connectStreamingClientConn) validateResponse(response *http.Response) *Error {
 	if response.StatusCode != http.StatusOK {
-		return errorf(connectHTTPToCode(response.StatusCode), "HTTP status %v", response.Status)
+		statusErr := errorf(connectHTTPToCode(response.StatusCode), "HTTP status %v", response.Status)
+		statusErr.setHTTPResponse(response)
+		return statusErr
 	}
 	compression := getHeaderCanonical(response.Header, connectStreamingHeaderCompression)
 	if compression != "" &&
@@ -655,6 +824,7 @@ func (cc *connectStreamingClientConn) validateResponse(response *http.Response)
 		)
 	}
 	cc.unmarshaler.compressionPool = cc.compressionPools.Get(compression)
+	cc.compression = compression
 	mergeHeaders(cc.responseHeader, response.Header)
 	return nil
 }
@@ -738,6 +908,14 @@ func (hc *connectUnaryHandlerConn) getHTTPMethod() string {
 	return hc.request.Method
 }
 
+func (hc *connectUnaryHandlerConn) getResponseCompression() string {
+	return compressionNameOrIdentity(hc.marshaler.compressionName)
+}
+
+func (hc *connectUnaryHandlerConn) getCodecName() string {
+	return hc.marshaler.codec.Name()
+}
+
 func (hc *connectUnaryHandlerConn) writeResponseHeader(err error) {
 	header := hc.responseWriter.Header()
 	if hc.request.Method == http.MethodGet {
@@ -764,6 +942,12 @@ type connectStreamingHandlerConn struct {
 	marshaler       connectStreamingMarshaler
 	unmarshaler     connectStreamingUnmarshaler
 	responseTrailer http.Header
+	compression     string
+	// If positive, heartbeats start after the first message is sent. See
+	// WithStreamHeartbeat.
+	heartbeatInterval time.Duration
+	heartbeat         *connectHeartbeat // nil until heartbeats start
+	stampMessages     bool              // see WithMaxMessageAge
 }
 
 func (hc *connectStreamingHandlerConn) Spec() Spec {
@@ -788,13 +972,47 @@ func (hc *connectStreamingHandlerConn) RequestHeader() http.Header {
 }
 
 func (hc *connectStreamingHandlerConn) Send(msg any) error {
+	return hc.sendWithFlags(msg, 0)
+}
+
+func (hc *connectStreamingHandlerConn) sendWithFlags(msg any, flags uint8) error {
+	if err := hc.write(msg, flags); err != nil {
+		return err
+	}
+	if hc.heartbeat == nil && hc.heartbeatInterval > 0 {
+		// The first message has sent and flushed the response headers, so the
+		// heartbeat goroutine can't race with the handler mutating them.
+		hc.startHeartbeat(hc.heartbeatInterval)
+	}
+	return nil
+}
+
+func (hc *connectStreamingHandlerConn) write(msg any, flags uint8) error {
+	if hc.heartbeat != nil {
+		hc.heartbeat.mu.Lock()
+		defer hc.heartbeat.mu.Unlock()
+		hc.heartbeat.lastWrite = time.Now()
+	}
 	defer flushResponseWriter(hc.responseWriter)
-	if err := hc.marshaler.Marshal(msg); err != nil {
+	if hc.stampMessages {
+		sentAt := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+		if err := hc.marshaler.write(&envelope{
+			Data:  bytes.NewBuffer(sentAt),
+			Flags: connectFlagEnvelopeTimestamp,
+		}); err != nil {
+			return err
+		}
+	}
+	if err := hc.marshaler.MarshalWithFlags(msg, flags); err != nil {
 		return err
 	}
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+func (hc *connectStreamingHandlerConn) receivedFlags() uint8 {
+	return hc.unmarshaler.lastFlags
+}
+
 func (hc *connectStreamingHandlerConn) ResponseHeader() http.Header {
 	return hc.responseWriter.Header()
 }
@@ -803,7 +1021,18 @@ func (hc *connectStreamingHandlerConn) ResponseTrailer() http.Header {
 	return hc.responseTrailer
 }
 
+func (hc *connectStreamingHandlerConn) getResponseCompression() string {
+	return compressionNameOrIdentity(hc.compression)
+}
+
+func (hc *connectStreamingHandlerConn) getCodecName() string {
+	return hc.marshaler.codec.Name()
+}
+
 func (hc *connectStreamingHandlerConn) Close(err error) error {
+	if hc.heartbeat != nil {
+		hc.heartbeat.stop()
+	}
 	defer flushResponseWriter(hc.responseWriter)
 	if err := hc.marshaler.MarshalEndStream(err, hc.responseTrailer); err != nil {
 		_ = hc.request.Body.Close()
@@ -824,6 +1053,63 @@ func (hc *connectStreamingHandlerConn) Close(err error) error {
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+// startHeartbeat starts a goroutine that sends a heartbeat envelope whenever
+// the handler hasn't sent anything for the interval. Close stops it.
+func (hc *connectStreamingHandlerConn) startHeartbeat(interval time.Duration) {
+	heartbeat := &connectHeartbeat{
+		lastWrite: time.Now(),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	hc.heartbeat = heartbeat
+	go func() {
+		defer close(heartbeat.stopped)
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-heartbeat.done:
+				return
+			case <-timer.C:
+			}
+			heartbeat.mu.Lock()
+			if idle := time.Since(heartbeat.lastWrite); idle < interval {
+				heartbeat.mu.Unlock()
+				timer.Reset(interval - idle)
+				continue
+			}
+			// Heartbeats are always empty and never compressed, so write the
+			// envelope directly. If the write fails, the handler's next Send fails
+			// too, so there's no need to report the error here.
+			_ = hc.marshaler.write(&envelope{
+				Data:  &bytes.Buffer{},
+				Flags: connectFlagEnvelopeHeartbeat,
+			})
+			flushResponseWriter(hc.responseWriter)
+			heartbeat.lastWrite = time.Now()
+			heartbeat.mu.Unlock()
+			timer.Reset(interval)
+		}
+	}()
+}
+
+// connectHeartbeat is the state shared between a streaming handler conn and
+// its heartbeat goroutine. See WithStreamHeartbeat.
+type connectHeartbeat struct {
+	mu        sync.Mutex // serializes writes to the response
+	lastWrite time.Time
+	stopOnce  sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// stop stops the heartbeat goroutine and waits for it to exit, so the caller
+// may write to the response without holding the lock.
+func (h *connectHeartbeat) stop() {
+	h.stopOnce.Do(func() { close(h.done) })
+	<-h.stopped
+}
+
 type connectStreamingMarshaler struct {
 	envelopeWriter
 }
@@ -955,6 +1241,7 @@ type connectUnaryRequestMarshaler struct {
 	enableGet      bool
 	getURLMaxBytes int
 	getUseFallback bool
+	getParamNames  ConnectGetParamNames
 	stableCodec    stableCodec
 	duplexCall     *duplexHTTPCall
 }
@@ -1031,16 +1318,16 @@ func (m *connectUnaryRequestMarshaler) marshalWithGet(message any) *Error {
 func (m *connectUnaryRequestMarshaler) buildGetURL(data []byte, compressed bool) *url.URL {
 	url := *m.duplexCall.URL()
 	query := url.Query()
-	query.Set(connectUnaryConnectQueryParameter, connectUnaryConnectQueryValue)
-	query.Set(connectUnaryEncodingQueryParameter, m.codec.Name())
+	query.Set(m.getParamNames.Connect, connectUnaryConnectQueryValue)
+	query.Set(m.getParamNames.Encoding, m.codec.Name())
 	if m.stableCodec.IsBinary() || compressed {
-		query.Set(connectUnaryMessageQueryParameter, encodeBinaryQueryValue(data))
-		query.Set(connectUnaryBase64QueryParameter, "1")
+		query.Set(m.getParamNames.Message, encodeBinaryQueryValue(data))
+		query.Set(m.getParamNames.Base64, "1")
 	} else {
-		query.Set(connectUnaryMessageQueryParameter, string(data))
+		query.Set(m.getParamNames.Message, string(data))
 	}
 	if compressed {
-		query.Set(connectUnaryCompressionQueryParameter, m.compressionName)
+		query.Set(m.getParamNames.Compression, m.compressionName)
 	}
 	url.RawQuery = query.Encode()
 	return &url
@@ -1060,9 +1347,11 @@ type connectUnaryUnmarshaler struct {
 	reader          io.Reader
 	codec           Codec
 	compressionPool *compressionPool
+	lenientGzipPool *compressionPool // see WithLenientDecompression
 	bufferPool      *bufferPool
 	alreadyRead     bool
 	readMaxBytes    int
+	maxRatio        float64 // see WithMaxDecompressionRatio
 }
 
 func (u *connectUnaryUnmarshaler) Unmarshal(message any) *Error {
@@ -1100,10 +1389,14 @@ func (u *connectUnaryUnmarshaler) UnmarshalFunc(message any, unmarshal func([]by
 		}
 		return errorf(CodeResourceExhausted, "message size %d is larger than configured max %d", bytesRead+discardedBytes, u.readMaxBytes)
 	}
-	if data.Len() > 0 && u.compressionPool != nil {
+	compressionPool := u.compressionPool
+	if compressionPool == nil && u.lenientGzipPool != nil && isGzipped(data.Bytes()) {
+		compressionPool = u.lenientGzipPool
+	}
+	if data.Len() > 0 && compressionPool != nil {
 		decompressed := u.bufferPool.Get()
 		defer u.bufferPool.Put(decompressed)
-		if err := u.compressionPool.Decompress(decompressed, data, int64(u.readMaxBytes)); err != nil {
+		if err := compressionPool.Decompress(decompressed, data, int64(u.readMaxBytes), u.maxRatio); err != nil {
 			return err
 		}
 		data = decompressed
@@ -1211,6 +1504,25 @@ type connectEndStreamMessage struct {
 	Trailer http.Header       `json:"metadata,omitempty"`
 }
 
+// connectEncodeTimeout sets the Connect-Timeout-Ms header. Timeouts under a
+// millisecond are rounded up to one millisecond. Expired timeouts are omitted,
+// as are timeouts too large to fit in the header.
+func connectEncodeTimeout(timeout time.Duration, header http.Header) {
+	if timeout <= 0 {
+		return
+	}
+	millis := int64(timeout / time.Millisecond)
+	if millis == 0 {
+		// Round sub-millisecond timeouts up rather than omitting the header,
+		// which would tell the server that there's no deadline at all.
+		millis = 1
+	}
+	encoded := strconv.FormatInt(millis, 10 /* base */)
+	if len(encoded) <= 10 {
+		header[connectHeaderTimeout] = []string{encoded}
+	} // else effectively unbounded
+}
+
 func connectCodeToHTTP(code Code) int {
 	// Return literals rather than named constants from the HTTP package to make
 	// it easier to compare this function to the Connect specification.
@@ -1285,6 +1597,36 @@ func connectHTTPToCode(httpCode int) Code {
 	}
 }
 
+// connectProtocolVersionSupported reports whether handlers accept the given
+// Connect-Protocol-Version header value.
+func connectProtocolVersionSupported(version string) bool {
+	for _, supported := range connectSupportedProtocolVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// connectQueryVersionSupported is like connectProtocolVersionSupported, but
+// for the "v"-prefixed version in a GET request's query parameters.
+func connectQueryVersionSupported(version string) bool {
+	return strings.HasPrefix(version, "v") && connectProtocolVersionSupported(version[1:])
+}
+
+// connectSupportedVersionsList formats the supported versions for error
+// messages, for example `"1"` or `one of "1", "2"`.
+func connectSupportedVersionsList(prefix string) string {
+	quoted := make([]string, len(connectSupportedProtocolVersions))
+	for i, version := range connectSupportedProtocolVersions {
+		quoted[i] = strconv.Quote(prefix + version)
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return "one of " + strings.Join(quoted, ", ")
+}
+
 func connectCodecFromContentType(streamType StreamType, contentType string) string {
 	if streamType == StreamTypeUnary {
 		return strings.TrimPrefix(contentType, connectUnaryContentTypePrefix)
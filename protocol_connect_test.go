@@ -93,3 +93,43 @@ func TestConnectEndOfResponseCanonicalTrailers(t *testing.T) {
 	assert.Equal(t, unmarshaler.Trailer().Values("Mixed-Canonical"), []string{"b", "b"})
 	assert.Equal(t, unmarshaler.Trailer().Values("Canonical-Header"), []string{"c"})
 }
+
+func TestConnectEndStreamMetadata(t *testing.T) {
+	t.Parallel()
+	buffer := bytes.Buffer{}
+	bufferPool := newBufferPool()
+	marshaler := connectStreamingMarshaler{
+		envelopeWriter: envelopeWriter{
+			sender:     writeSender{writer: &buffer},
+			bufferPool: bufferPool,
+		},
+	}
+	trailer := make(http.Header)
+	trailer.Set("Next-Page-Cursor", "abc")
+	trailer.Add("Result-Count", "42")
+	assert.Nil(t, marshaler.MarshalEndStream(nil /* err */, trailer))
+
+	unmarshaler := connectStreamingUnmarshaler{
+		envelopeReader: envelopeReader{
+			reader:     bytes.NewReader(buffer.Bytes()),
+			bufferPool: bufferPool,
+		},
+	}
+	err := unmarshaler.Unmarshal(nil) // parameter won't be used
+	assert.ErrorIs(t, err, errSpecialEnvelope)
+	assert.Nil(t, unmarshaler.EndStreamError())
+	assert.Equal(t, unmarshaler.Trailer().Get("Next-Page-Cursor"), "abc")
+	assert.Equal(t, unmarshaler.Trailer().Get("Result-Count"), "42")
+
+	// The raw frame should carry the trailers in the metadata field, without an
+	// error.
+	data := buffer.Bytes()[5:] // skip the envelope prefix
+	var end struct {
+		Error    json.RawMessage     `json:"error"`
+		Metadata map[string][]string `json:"metadata"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &end))
+	assert.Zero(t, len(end.Error))
+	assert.Equal(t, end.Metadata["Next-Page-Cursor"], []string{"abc"})
+	assert.Equal(t, end.Metadata["Result-Count"], []string{"42"})
+}
@@ -16,7 +16,9 @@ package connect
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -41,10 +43,12 @@ const (
 
 	grpcFlagEnvelopeTrailer = 0b10000000
 
-	grpcContentTypeDefault    = "application/grpc"
-	grpcWebContentTypeDefault = "application/grpc-web"
-	grpcContentTypePrefix     = grpcContentTypeDefault + "+"
-	grpcWebContentTypePrefix  = grpcWebContentTypeDefault + "+"
+	grpcContentTypeDefault        = "application/grpc"
+	grpcWebContentTypeDefault     = "application/grpc-web"
+	grpcWebTextContentTypeDefault = "application/grpc-web-text"
+	grpcContentTypePrefix         = grpcContentTypeDefault + "+"
+	grpcWebContentTypePrefix      = grpcWebContentTypeDefault + "+"
+	grpcWebTextContentTypePrefix  = grpcWebTextContentTypeDefault + "+"
 
 	headerXUserAgent = "X-User-Agent"
 
@@ -69,8 +73,31 @@ var (
 	}
 )
 
+// GRPCWebTrailerMode selects where gRPC-Web handlers send trailing metadata,
+// including the RPC's status. See [WithGRPCWebTrailerMode].
+type GRPCWebTrailerMode int
+
+const (
+	// GRPCWebTrailerModeDefault follows the gRPC-Web specification and
+	// Envoy's behavior: responses with messages send trailing metadata in the
+	// body's trailer frame, and trailers-only responses (usually errors) send
+	// it as HTTP headers instead.
+	GRPCWebTrailerModeDefault GRPCWebTrailerMode = iota
+	// GRPCWebTrailerModeFrame always sends trailing metadata in the body's
+	// trailer frame, even for trailers-only responses. It's for clients that
+	// never look for the status in the HTTP headers.
+	GRPCWebTrailerModeFrame
+	// GRPCWebTrailerModeDuplicate sends the trailing metadata of trailers-only
+	// responses both as HTTP headers and in the body's trailer frame, so that
+	// clients find the status wherever they look. Responses with messages have
+	// already sent their headers, so their trailing metadata is only in the
+	// trailer frame.
+	GRPCWebTrailerModeDuplicate
+)
+
 type protocolGRPC struct {
-	web bool
+	web  bool
+	text bool // base64-encoded gRPC-Web bodies, only used by clients
 }
 
 // NewHandler implements protocol, so it must return an interface.
@@ -82,9 +109,15 @@ func (g *protocolGRPC) NewHandler(params *protocolHandlerParams) protocolHandler
 	contentTypes := make(map[string]struct{})
 	for _, name := range params.Codecs.Names() {
 		contentTypes[canonicalizeContentType(prefix+name)] = struct{}{}
+		if g.web {
+			contentTypes[canonicalizeContentType(grpcWebTextContentTypePrefix+name)] = struct{}{}
+		}
 	}
 	if params.Codecs.Get(codecNameProto) != nil {
 		contentTypes[bare] = struct{}{}
+		if g.web {
+			contentTypes[grpcWebTextContentTypeDefault] = struct{}{}
+		}
 	}
 	return &grpcHandler{
 		protocolHandlerParams: *params,
@@ -102,6 +135,7 @@ func (g *protocolGRPC) NewClient(params *protocolClientParams) (protocolClient,
 	return &grpcClient{
 		protocolClientParams: *params,
 		web:                  g.web,
+		text:                 g.web && g.text,
 		peer:                 peer,
 	}, nil
 }
@@ -148,9 +182,18 @@ func (g *grpcHandler) NewConn(
 	// send the error to the client later on.
 	requestCompression, responseCompression, failed := negotiateCompression(
 		g.CompressionPools,
+		g.AcceptCompression,
 		getHeaderCanonical(request.Header, grpcHeaderCompression),
 		getHeaderCanonical(request.Header, grpcHeaderAcceptCompression),
 	)
+	if failed == nil {
+		responseCompression = g.selectResponseCompression(
+			request.Context(),
+			requestCompression,
+			getHeaderCanonical(request.Header, grpcHeaderAcceptCompression),
+			responseCompression,
+		)
+	}
 	if failed == nil {
 		failed = checkServerStreamsCanFlush(g.Spec, responseWriter)
 	}
@@ -164,29 +207,41 @@ func (g *grpcHandler) NewConn(
 	// skip the normalization in Header.Set.
 	header := responseWriter.Header()
 	header[headerContentType] = []string{getHeaderCanonical(request.Header, headerContentType)}
-	header[grpcHeaderAcceptCompression] = []string{g.CompressionPools.CommaSeparatedNames()}
+	header[grpcHeaderAcceptCompression] = []string{g.AcceptCompression.CommaSeparatedNames()}
 	if responseCompression != compressionIdentity {
 		header[grpcHeaderCompression] = []string{responseCompression}
 	}
 
-	codecName := grpcCodecFromContentType(g.web, getHeaderCanonical(request.Header, headerContentType))
+	contentType := getHeaderCanonical(request.Header, headerContentType)
+	codecName := grpcCodecFromContentType(g.web, contentType)
 	codec := g.Codecs.Get(codecName) // handler.go guarantees this is not nil
 	protocolName := ProtocolGRPC
 	if g.web {
 		protocolName = ProtocolGRPCWeb
 	}
-	conn := wrapHandlerConnWithCodedErrors(&grpcHandlerConn{
+	var sender messageSender = writeSender{writer: responseWriter}
+	var body io.Reader = request.Body
+	if g.web && isGRPCWebTextContentType(contentType) {
+		// Text mode only changes how the body is encoded, so the response
+		// echoes the request's content type and everything else is unchanged.
+		sender = &grpcWebTextSender{sender: sender, bufferPool: g.BufferPool}
+		body = &grpcWebTextReader{reader: request.Body}
+	}
+	conn := wrapHandlerConnWithCodedErrors(request.Context(), &grpcHandlerConn{
 		spec: g.Spec,
 		peer: Peer{
 			Addr:     request.RemoteAddr,
 			Protocol: protocolName,
+			TLS:      request.TLS,
 		},
-		web:        g.web,
-		bufferPool: g.BufferPool,
-		protobuf:   g.Codecs.Protobuf(), // for errors
+		web:         g.web,
+		trailerMode: g.GRPCWebTrailerMode,
+		bufferPool:  g.BufferPool,
+		protobuf:    g.Codecs.Protobuf(), // for errors
+		compression: responseCompression,
 		marshaler: grpcMarshaler{
 			envelopeWriter: envelopeWriter{
-				sender:           writeSender{writer: responseWriter},
+				sender:           sender,
 				compressionPool:  g.CompressionPools.Get(responseCompression),
 				codec:            codec,
 				compressMinBytes: g.CompressMinBytes,
@@ -200,11 +255,14 @@ func (g *grpcHandler) NewConn(
 		request:         request,
 		unmarshaler: grpcUnmarshaler{
 			envelopeReader: envelopeReader{
-				reader:          request.Body,
+				reader:          body,
 				codec:           codec,
 				compressionPool: g.CompressionPools.Get(requestCompression),
 				bufferPool:      g.BufferPool,
 				readMaxBytes:    g.ReadMaxBytes,
+				maxRatio:        g.MaxDecompressionRatio,
+				maxFrames:       g.ReadMaxFrames,
+				maxEmptyFrames:  g.ReadMaxEmptyFrames,
 			},
 			web: g.web,
 		},
@@ -221,6 +279,7 @@ type grpcClient struct {
 	protocolClientParams
 
 	web  bool
+	text bool
 	peer Peer
 }
 
@@ -241,7 +300,11 @@ func (g *grpcClient) WriteRequestHeader(_ StreamType, header http.Header) {
 		// both.
 		header[headerXUserAgent] = []string{defaultGrpcUserAgent}
 	}
-	header[headerContentType] = []string{grpcContentTypeFromCodecName(g.web, g.Codec.Name())}
+	if g.text {
+		header[headerContentType] = []string{grpcWebTextContentTypePrefix + g.Codec.Name()}
+	} else {
+		header[headerContentType] = []string{grpcContentTypeFromCodecName(g.web, g.Codec.Name())}
+	}
 	// gRPC handles compression on a per-message basis, so we don't want to
 	// compress the whole stream. By default, http.Client will ask the server
 	// to gzip the stream if we don't set Accept-Encoding.
@@ -264,17 +327,11 @@ func (g *grpcClient) NewConn(
 	spec Spec,
 	header http.Header,
 ) streamingClientConn {
-	if deadline, ok := ctx.Deadline(); ok {
-		encodedDeadline := grpcEncodeTimeout(time.Until(deadline))
-		header[grpcHeaderTimeout] = []string{encodedDeadline}
-	}
-	duplexCall := newDuplexHTTPCall(
-		ctx,
-		g.HTTPClient,
-		g.URL,
-		spec,
-		header,
-	)
+	encodeTimeout(ctx, header, g.now, g.TimeoutEncoder, func(timeout time.Duration, header http.Header) {
+		header[grpcHeaderTimeout] = []string{grpcEncodeTimeout(timeout)}
+	})
+	compressionName := g.sendCompression(ctx, header, grpcHeaderCompression)
+	duplexCall := g.newDuplexHTTPCall(ctx, spec, header)
 	conn := &grpcClientConn{
 		spec:             spec,
 		peer:             g.Peer(),
@@ -285,7 +342,7 @@ func (g *grpcClient) NewConn(
 		marshaler: grpcMarshaler{
 			envelopeWriter: envelopeWriter{
 				sender:           duplexCall,
-				compressionPool:  g.CompressionPools.Get(g.CompressionName),
+				compressionPool:  g.CompressionPools.Get(compressionName),
 				codec:            g.Codec,
 				compressMinBytes: g.CompressMinBytes,
 				bufferPool:       g.BufferPool,
@@ -294,16 +351,24 @@ func (g *grpcClient) NewConn(
 		},
 		unmarshaler: grpcUnmarshaler{
 			envelopeReader: envelopeReader{
-				reader:       duplexCall,
-				codec:        g.Codec,
-				bufferPool:   g.BufferPool,
-				readMaxBytes: g.ReadMaxBytes,
+				reader:          duplexCall,
+				codec:           g.Codec,
+				bufferPool:      g.BufferPool,
+				readMaxBytes:    g.ReadMaxBytes,
+				maxRatio:        g.MaxDecompressionRatio,
+				maxFrames:       g.ReadMaxFrames,
+				maxEmptyFrames:  g.ReadMaxEmptyFrames,
+				lenientGzipPool: g.lenientGzipPool(),
 			},
 		},
 		responseHeader:  make(http.Header),
 		responseTrailer: make(http.Header),
 	}
 	duplexCall.SetValidateResponse(conn.validateResponse)
+	if g.text {
+		conn.marshaler.sender = &grpcWebTextSender{sender: duplexCall, bufferPool: g.BufferPool}
+		conn.unmarshaler.envelopeReader.reader = &grpcWebTextReader{reader: duplexCall}
+	}
 	if g.web {
 		conn.unmarshaler.web = true
 		conn.readTrailers = func(unmarshaler *grpcUnmarshaler, _ *duplexHTTPCall) http.Header {
@@ -316,7 +381,7 @@ func (g *grpcClient) NewConn(
 			return call.ResponseTrailer()
 		}
 	}
-	return wrapClientConnWithCodedErrors(conn)
+	return wrapClientConnWithCodedErrors(conn, g.TypeResolver)
 }
 
 // grpcClientConn works for both gRPC and gRPC-Web.
@@ -332,6 +397,7 @@ type grpcClientConn struct {
 	responseHeader   http.Header
 	responseTrailer  http.Header
 	readTrailers     func(*grpcUnmarshaler, *duplexHTTPCall) http.Header
+	compression      string
 }
 
 func (cc *grpcClientConn) Spec() Spec {
@@ -416,6 +482,20 @@ func (cc *grpcClientConn) onRequestSend(fn func(*http.Request)) {
 	cc.duplexCall.onRequestSend = fn
 }
 
+func (cc *grpcClientConn) getResponseCompression() string {
+	_ = cc.duplexCall.BlockUntilResponseReady()
+	return compressionNameOrIdentity(cc.compression)
+}
+
+func (cc *grpcClientConn) getResponseStatus() int {
+	status, _ := cc.duplexCall.ResponseStatusCode()
+	return status
+}
+
+func (cc *grpcClientConn) getCodecName() string {
+	return cc.marshaler.codec.Name()
+}
+
 func (cc *grpcClientConn) validateResponse(response *http.Response) *Error {
 	if err := grpcValidateResponse(
 		response,
@@ -428,6 +508,7 @@ func (cc *grpcClientConn) validateResponse(response *http.Response) *Error {
 	}
 	compression := getHeaderCanonical(response.Header, grpcHeaderCompression)
 	cc.unmarshaler.envelopeReader.compressionPool = cc.compressionPools.Get(compression)
+	cc.compression = compression
 	return nil
 }
 
@@ -435,6 +516,7 @@ type grpcHandlerConn struct {
 	spec            Spec
 	peer            Peer
 	web             bool
+	trailerMode     GRPCWebTrailerMode
 	bufferPool      *bufferPool
 	protobuf        Codec // for errors
 	marshaler       grpcMarshaler
@@ -444,6 +526,7 @@ type grpcHandlerConn struct {
 	wroteToBody     bool
 	request         *http.Request
 	unmarshaler     grpcUnmarshaler
+	compression     string
 }
 
 func (hc *grpcHandlerConn) Spec() Spec {
@@ -485,6 +568,14 @@ func (hc *grpcHandlerConn) ResponseTrailer() http.Header {
 	return hc.responseTrailer
 }
 
+func (hc *grpcHandlerConn) getResponseCompression() string {
+	return compressionNameOrIdentity(hc.compression)
+}
+
+func (hc *grpcHandlerConn) getCodecName() string {
+	return hc.marshaler.codec.Name()
+}
+
 func (hc *grpcHandlerConn) Close(err error) (retErr error) {
 	defer func() {
 		// We don't want to copy unread portions of the body to /dev/null here: if
@@ -512,7 +603,7 @@ func (hc *grpcHandlerConn) Close(err error) (retErr error) {
 	)
 	mergeHeaders(mergedTrailers, hc.responseTrailer)
 	grpcErrorToTrailer(mergedTrailers, hc.protobuf, err)
-	if hc.web && !hc.wroteToBody {
+	if hc.web && !hc.wroteToBody && hc.trailerMode != GRPCWebTrailerModeFrame {
 		// We're using gRPC-Web and we haven't yet written to the body. Since we're
 		// not sending any response messages, the gRPC specification calls this a
 		// "trailers-only" response. Under those circumstances, the gRPC-Web spec
@@ -521,7 +612,9 @@ func (hc *grpcHandlerConn) Close(err error) (retErr error) {
 		// so we emulate Envoy's behavior and put the trailing metadata in the HTTP
 		// headers.
 		mergeHeaders(hc.responseWriter.Header(), mergedTrailers)
-		return nil
+		if hc.trailerMode != GRPCWebTrailerModeDuplicate {
+			return nil
+		}
 	}
 	if hc.web {
 		// We're using gRPC-Web and we've already sent the headers, so we write
@@ -651,7 +744,9 @@ func grpcValidateResponse(
 	protobuf Codec,
 ) *Error {
 	if response.StatusCode != http.StatusOK {
-		return errorf(grpcHTTPToCode(response.StatusCode), "HTTP status %v", response.Status)
+		statusErr := errorf(grpcHTTPToCode(response.StatusCode), "HTTP status %v", response.Status)
+		statusErr.setHTTPResponse(response)
+		return statusErr
 	}
 	if compression := getHeaderCanonical(response.Header, grpcHeaderCompression); compression != "" &&
 		compression != compressionIdentity &&
@@ -831,6 +926,10 @@ func grpcTimeoutUnitLookup(unit byte) (time.Duration, error) {
 }
 
 func grpcCodecFromContentType(web bool, contentType string) string {
+	if web && isGRPCWebTextContentType(contentType) {
+		// Text mode uses the same codecs as binary gRPC-Web.
+		contentType = grpcWebContentTypeDefault + strings.TrimPrefix(contentType, grpcWebTextContentTypeDefault)
+	}
 	if (!web && contentType == grpcContentTypeDefault) || (web && contentType == grpcWebContentTypeDefault) {
 		// implicitly protobuf
 		return codecNameProto
@@ -849,6 +948,11 @@ func grpcContentTypeFromCodecName(web bool, name string) string {
 	return grpcContentTypePrefix + name
 }
 
+func isGRPCWebTextContentType(contentType string) bool {
+	return contentType == grpcWebTextContentTypeDefault ||
+		strings.HasPrefix(contentType, grpcWebTextContentTypePrefix)
+}
+
 func grpcErrorToTrailer(trailer http.Header, protobuf Codec, err error) {
 	if err == nil {
 		setHeaderCanonical(trailer, grpcHeaderStatus, "0") // zero is the gRPC OK status
@@ -857,7 +961,13 @@ func grpcErrorToTrailer(trailer http.Header, protobuf Codec, err error) {
 	}
 	status := grpcStatusFromError(err)
 	code := strconv.Itoa(int(status.GetCode()))
-	bin, binErr := protobuf.Marshal(status)
+	var bin []byte
+	var binErr error
+	if connectErr, ok := asError(err); ok && connectErr.rawStatus != nil {
+		bin = connectErr.rawStatus // see NewErrorWithRawDetails
+	} else {
+		bin, binErr = protobuf.Marshal(status)
+	}
 	if binErr != nil {
 		setHeaderCanonical(
 			trailer,
@@ -995,3 +1105,93 @@ func validateHex(input string) error {
 	}
 	return nil
 }
+
+// grpcWebTextSender base64-encodes each payload before sending it, as required
+// by gRPC-Web's text mode. Every payload is padded separately, so the body is a
+// concatenation of complete base64 chunks.
+type grpcWebTextSender struct {
+	sender     messageSender
+	bufferPool *bufferPool
+}
+
+func (s *grpcWebTextSender) Send(payload messagePayload) (int64, error) {
+	if payload.Len() == 0 {
+		// Let the underlying sender handle requests used to send headers.
+		return s.sender.Send(payload)
+	}
+	raw := s.bufferPool.Get()
+	defer s.bufferPool.Put(raw)
+	if _, err := payload.WriteTo(raw); err != nil {
+		return 0, err
+	}
+	encoded := s.bufferPool.Get()
+	defer s.bufferPool.Put(encoded)
+	encoded.Grow(base64.StdEncoding.EncodedLen(raw.Len()))
+	encoder := base64.NewEncoder(base64.StdEncoding, encoded)
+	_, _ = encoder.Write(raw.Bytes()) // writes to a bytes.Buffer can't fail
+	_ = encoder.Close()
+	return s.sender.Send(bytes.NewReader(encoded.Bytes()))
+}
+
+// grpcWebTextReader decodes a base64-encoded gRPC-Web body. Senders may pad
+// each chunk they write, so unlike base64.NewDecoder, it accepts padding in
+// the middle of the stream.
+type grpcWebTextReader struct {
+	reader  io.Reader
+	encoded []byte // undecoded input, always less than one quantum between reads
+	buf     []byte // backing array for decoded
+	decoded []byte // decoded output not yet returned
+	err     error
+}
+
+func (r *grpcWebTextReader) Read(data []byte) (int, error) {
+	for len(r.decoded) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	n := copy(data, r.decoded)
+	r.decoded = r.decoded[n:]
+	return n, nil
+}
+
+// fill reads more of the body and decodes all the complete quanta it has.
+func (r *grpcWebTextReader) fill() {
+	const chunkSize = 4096 // a multiple of 4, so whole quanta are decoded
+	buffered := len(r.encoded)
+	if cap(r.encoded) < chunkSize {
+		r.encoded = append(make([]byte, 0, chunkSize), r.encoded...)
+	}
+	n, err := r.reader.Read(r.encoded[buffered:chunkSize])
+	r.encoded = r.encoded[:buffered+n]
+	complete := len(r.encoded) - len(r.encoded)%4
+	if r.buf == nil {
+		r.buf = make([]byte, 0, base64.StdEncoding.DecodedLen(chunkSize))
+	}
+	decoded := r.buf[:0]
+	for start := 0; start < complete; {
+		// A padded quantum ends a chunk, so decode each chunk separately.
+		end := start + 4
+		for end < complete && r.encoded[end-1] != '=' {
+			end += 4
+		}
+		decodedLen, decodeErr := base64.StdEncoding.Decode(decoded[len(decoded):cap(decoded)], r.encoded[start:end])
+		if decodeErr != nil {
+			r.decoded = decoded
+			r.err = errorf(CodeInvalidArgument, "protocol error: invalid gRPC-Web text body: %w", decodeErr)
+			return
+		}
+		decoded = decoded[:len(decoded)+decodedLen]
+		start = end
+	}
+	r.decoded = decoded
+	r.encoded = r.encoded[:copy(r.encoded, r.encoded[complete:])]
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF) && len(r.encoded) > 0:
+		r.err = errorf(CodeInvalidArgument, "protocol error: gRPC-Web text body ends with a partial base64 quantum: %w", io.ErrUnexpectedEOF)
+	default:
+		r.err = err
+	}
+}
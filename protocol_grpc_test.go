@@ -15,18 +15,26 @@
 package connect
 
 import (
+	"encoding/base64"
 	"errors"
+	"io"
 	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"testing/quick"
 	"time"
 	"unicode/utf8"
 
 	"connectrpc.com/connect/internal/assert"
+	statusv1 "connectrpc.com/connect/internal/gen/connectext/grpc/status/v1"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func TestGRPCHandlerSender(t *testing.T) {
@@ -195,6 +203,76 @@ func TestGRPCWebTrailerMarshalling(t *testing.T) {
 	marshalled := responseWriter.Body.String()
 	assert.Equal(t, marshalled, "grpc-message: Foo\r\ngrpc-status: 0\r\nuser-provided: bar\r\n")
 }
+
+func TestGRPCWebTextReader(t *testing.T) {
+	t.Parallel()
+	chunks := []string{"a", "bc", "defghi", "", strings.Repeat("j", 5000)}
+	var encoded, want strings.Builder
+	for _, chunk := range chunks {
+		encoded.WriteString(base64.StdEncoding.EncodeToString([]byte(chunk)))
+		want.WriteString(chunk)
+	}
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		for _, reader := range []io.Reader{
+			strings.NewReader(encoded.String()),
+			iotest.OneByteReader(strings.NewReader(encoded.String())),
+		} {
+			got, err := io.ReadAll(&grpcWebTextReader{reader: reader})
+			assert.Nil(t, err)
+			assert.Equal(t, string(got), want.String())
+		}
+	})
+	t.Run("truncated", func(t *testing.T) {
+		t.Parallel()
+		truncated := encoded.String()[:encoded.Len()-1]
+		_, err := io.ReadAll(&grpcWebTextReader{reader: strings.NewReader(truncated)})
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := io.ReadAll(&grpcWebTextReader{reader: strings.NewReader("ab!d")})
+		assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	})
+}
+
+func TestGRPCErrorToTrailerRawStatus(t *testing.T) {
+	t.Parallel()
+	detail, err := anypb.New(durationpb.New(time.Second))
+	assert.Nil(t, err)
+	raw, err := proto.Marshal(&statusv1.Status{
+		Code:    int32(CodeNotFound),
+		Message: "not found",
+		Details: []*anypb.Any{detail},
+	})
+	assert.Nil(t, err)
+	// An unknown field, which a re-encoded status would lose.
+	raw = protowire.AppendTag(raw, 99, protowire.VarintType)
+	raw = protowire.AppendVarint(raw, 1)
+
+	connectErr := NewErrorWithRawDetails(CodeNotFound, "not found", raw)
+	assert.Equal(t, len(connectErr.Details()), 1)
+	connectErr.AddDetail(&ErrorDetail{pb: detail}) // not sent to gRPC clients
+	trailer := make(http.Header)
+	grpcErrorToTrailer(trailer, &protoBinaryCodec{}, connectErr)
+	assert.Equal(t, trailer.Get("Grpc-Status"), "5")
+	assert.Equal(t, trailer.Get("Grpc-Message"), "not found")
+	bin, err := DecodeBinaryHeader(trailer.Get("Grpc-Status-Details-Bin"))
+	assert.Nil(t, err)
+	assert.Equal(t, bin, raw)
+
+	// Without raw details, the status is built from the error as usual.
+	trailer = make(http.Header)
+	grpcErrorToTrailer(trailer, &protoBinaryCodec{}, NewError(CodeNotFound, errors.New("not found")))
+	bin, err = DecodeBinaryHeader(trailer.Get("Grpc-Status-Details-Bin"))
+	assert.Nil(t, err)
+	var status statusv1.Status
+	assert.Nil(t, proto.Unmarshal(bin, &status))
+	assert.Equal(t, status.GetCode(), int32(CodeNotFound))
+	assert.Equal(t, len(status.GetDetails()), 0)
+}
+
 func BenchmarkGRPCPercentEncoding(b *testing.B) {
 	input := "Hello, 世界"
 	want := "Hello, %E4%B8%96%E7%95%8C"
@@ -0,0 +1,254 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	sseContentType = "text/event-stream"
+	sseEventError  = "error"
+	sseEventEnd    = "end"
+)
+
+// sseHandler serves server streaming procedures as server-sent events, so
+// that browsers can consume them with EventSource. It's enabled with
+// WithServerSentEvents.
+//
+// Requests are HTTP GETs with an Accept header that includes
+// text/event-stream. Like Connect unary GET requests, the request message is
+// encoded in the "message" query parameter, using the codec named by the
+// "encoding" query parameter (JSON by default) and, if the "base64" query
+// parameter is "1", base64-encoded. Each response message is sent as a
+// JSON-encoded data event. The stream ends with either an "end" event, whose
+// data are the response trailers in the same form as the metadata of a
+// Connect end-of-stream message, or an "error" event, whose data is a
+// JSON-encoded Connect error.
+type sseHandler struct {
+	protocolHandlerParams
+}
+
+func newSSEHandler(params *protocolHandlerParams) *sseHandler {
+	return &sseHandler{protocolHandlerParams: *params}
+}
+
+func (h *sseHandler) Methods() map[string]struct{} {
+	return map[string]struct{}{http.MethodGet: {}}
+}
+
+func (h *sseHandler) ContentTypes() map[string]struct{} {
+	// Requests don't have a body, so there's no request Content-Type to
+	// advertise in Accept-Post.
+	return nil
+}
+
+func (*sseHandler) SetTimeout(request *http.Request) (context.Context, context.CancelFunc, error) {
+	// EventSource can't set headers, so there's no way to send a timeout.
+	return request.Context(), nil, nil
+}
+
+func (h *sseHandler) CanHandlePayload(request *http.Request, _ string) bool {
+	for _, accept := range request.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), sseContentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *sseHandler) NewConn(
+	responseWriter http.ResponseWriter,
+	request *http.Request,
+) (handlerConnCloser, bool) {
+	query := request.URL.Query()
+	failed := checkServerStreamsCanFlush(h.Spec, responseWriter)
+	codecName := query.Get(h.GetParamNames.Encoding)
+	if codecName == "" {
+		codecName = codecNameJSON
+	}
+	requestCodec := h.Codecs.Get(codecName)
+	if failed == nil && requestCodec == nil {
+		failed = errorf(CodeInvalidArgument, "invalid message encoding: %q", codecName)
+	}
+	responseCodec := h.Codecs.Get(codecNameJSON)
+	if failed == nil && responseCodec == nil {
+		failed = errorf(CodeInternal, "server-sent events require a %q codec", codecNameJSON)
+	}
+
+	header := responseWriter.Header()
+	header[headerContentType] = []string{sseContentType}
+	header["Cache-Control"] = []string{"no-cache"}
+	conn := wrapHandlerConnWithCodedErrors(request.Context(), &sseHandlerConn{
+		spec: h.Spec,
+		peer: Peer{
+			Addr:     request.RemoteAddr,
+			Protocol: ProtocolSSE,
+			Query:    query,
+			TLS:      request.TLS,
+		},
+		request:         request,
+		responseWriter:  responseWriter,
+		responseTrailer: make(http.Header),
+		requestCodec:    requestCodec,
+		responseCodec:   responseCodec,
+		message: queryValueReader(
+			query.Get(h.GetParamNames.Message),
+			query.Get(h.GetParamNames.Base64) == "1",
+		),
+		bufferPool:   h.BufferPool,
+		readMaxBytes: h.ReadMaxBytes,
+		sendMaxBytes: h.SendMaxBytes,
+	})
+	if failed != nil {
+		// Negotiation failed, so we can't establish a stream.
+		_ = conn.Close(failed)
+		return nil, false
+	}
+	return conn, true
+}
+
+type sseHandlerConn struct {
+	spec            Spec
+	peer            Peer
+	request         *http.Request
+	responseWriter  http.ResponseWriter
+	responseTrailer http.Header
+	requestCodec    Codec
+	responseCodec   Codec
+	message         io.Reader
+	bufferPool      *bufferPool
+	readMaxBytes    int
+	sendMaxBytes    int
+	received        bool
+}
+
+func (hc *sseHandlerConn) Spec() Spec {
+	return hc.spec
+}
+
+func (hc *sseHandlerConn) Peer() Peer {
+	return hc.peer
+}
+
+func (hc *sseHandlerConn) Receive(msg any) error {
+	if hc.received {
+		return NewError(CodeInternal, io.EOF)
+	}
+	hc.received = true
+	data := hc.bufferPool.Get()
+	defer hc.bufferPool.Put(data)
+	if _, err := data.ReadFrom(hc.message); err != nil {
+		return errorf(CodeInvalidArgument, "read message: %w", err)
+	}
+	if hc.readMaxBytes > 0 && data.Len() > hc.readMaxBytes {
+		return errorf(CodeResourceExhausted, "message size %d is larger than configured max %d", data.Len(), hc.readMaxBytes)
+	}
+	if err := hc.requestCodec.Unmarshal(data.Bytes(), msg); err != nil {
+		return errorf(CodeInvalidArgument, "unmarshal message: %w", err)
+	}
+	return nil
+}
+
+func (hc *sseHandlerConn) RequestHeader() http.Header {
+	return hc.request.Header
+}
+
+func (hc *sseHandlerConn) Send(msg any) error {
+	data, err := hc.responseCodec.Marshal(msg)
+	if err != nil {
+		return errorf(CodeInternal, "marshal message: %w", err)
+	}
+	if hc.sendMaxBytes > 0 && len(data) > hc.sendMaxBytes {
+		return errorf(CodeResourceExhausted, "message size %d exceeds sendMaxBytes %d", len(data), hc.sendMaxBytes)
+	}
+	return hc.writeEvent("", data)
+}
+
+func (hc *sseHandlerConn) ResponseHeader() http.Header {
+	return hc.responseWriter.Header()
+}
+
+func (hc *sseHandlerConn) ResponseTrailer() http.Header {
+	return hc.responseTrailer
+}
+
+func (hc *sseHandlerConn) Close(err error) error {
+	if err != nil {
+		data, marshalErr := json.Marshal(newConnectWireError(err))
+		if marshalErr != nil {
+			_ = hc.request.Body.Close()
+			return errorf(CodeInternal, "marshal error: %w", marshalErr)
+		}
+		if writeErr := hc.writeEvent(sseEventError, data); writeErr != nil {
+			_ = hc.request.Body.Close()
+			return writeErr
+		}
+		return hc.request.Body.Close()
+	}
+	data, marshalErr := json.Marshal(&connectEndStreamMessage{Trailer: hc.responseTrailer})
+	if marshalErr != nil {
+		_ = hc.request.Body.Close()
+		return errorf(CodeInternal, "marshal end stream: %w", marshalErr)
+	}
+	if writeErr := hc.writeEvent(sseEventEnd, data); writeErr != nil {
+		_ = hc.request.Body.Close()
+		return writeErr
+	}
+	return hc.request.Body.Close()
+}
+
+func (hc *sseHandlerConn) getHTTPMethod() string {
+	return hc.request.Method
+}
+
+func (hc *sseHandlerConn) getCodecName() string {
+	return hc.requestCodec.Name()
+}
+
+// writeEvent writes and flushes a single event. Data containing newlines is
+// split across multiple data lines, which EventSource joins back together.
+func (hc *sseHandlerConn) writeEvent(event string, data []byte) error {
+	buffer := hc.bufferPool.Get()
+	defer hc.bufferPool.Put(buffer)
+	if event != "" {
+		buffer.WriteString("event: ")
+		buffer.WriteString(event)
+		buffer.WriteByte('\n')
+	}
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		buffer.WriteString("data: ")
+		buffer.Write(bytes.TrimSuffix(line, []byte{'\r'}))
+		buffer.WriteByte('\n')
+	}
+	buffer.WriteByte('\n')
+	if _, err := hc.responseWriter.Write(buffer.Bytes()); err != nil {
+		err = wrapIfContextError(err)
+		if connectErr, ok := asError(err); ok {
+			return connectErr
+		}
+		return errorf(CodeUnknown, "write event: %w", err)
+	}
+	flushResponseWriter(hc.responseWriter)
+	return nil
+}
@@ -15,7 +15,10 @@
 package connect
 
 import (
+	"context"
+	"net/http"
 	"testing"
+	"time"
 
 	"connectrpc.com/connect/internal/assert"
 )
@@ -41,6 +44,27 @@ func TestCanonicalizeContentType(t *testing.T) {
 	}
 }
 
+func TestContentTypeWithoutParams(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		arg    string
+		want   string
+		wantOK bool
+	}{
+		{arg: "application/json", wantOK: false},
+		{arg: "application/json; charset=utf-8", want: "application/json", wantOK: true},
+		{arg: "application/json; charset=UTF-8; foo=bar", want: "application/json", wantOK: true},
+		{arg: "application/proto; boundary=fooBar", want: "application/proto", wantOK: true},
+		{arg: "application/json; charset=shift-jis", wantOK: false},
+		{arg: "application/json; =", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := contentTypeWithoutParams(tt.arg)
+		assert.Equal(t, ok, tt.wantOK, assert.Sprintf("arg %q", tt.arg))
+		assert.Equal(t, got, tt.want, assert.Sprintf("arg %q", tt.arg))
+	}
+}
+
 func BenchmarkCanonicalizeContentType(b *testing.B) {
 	b.Run("simple", func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
@@ -63,3 +87,40 @@ func BenchmarkCanonicalizeContentType(b *testing.B) {
 		b.ReportAllocs()
 	})
 }
+
+func TestEncodeTimeoutBoundaries(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+	grpcEncoder := func(timeout time.Duration, header http.Header) {
+		header[grpcHeaderTimeout] = []string{grpcEncodeTimeout(timeout)}
+	}
+	encode := func(t *testing.T, deadline time.Time, custom func(time.Duration, http.Header), standard func(time.Duration, http.Header)) http.Header {
+		t.Helper()
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		t.Cleanup(cancel)
+		header := make(http.Header)
+		encodeTimeout(ctx, header, clock, custom, standard)
+		return header
+	}
+	t.Run("just_before_deadline", func(t *testing.T) {
+		t.Parallel()
+		deadline := now.Add(time.Nanosecond)
+		assert.Equal(t, encode(t, deadline, nil, connectEncodeTimeout).Get(connectHeaderTimeout), "1")
+		assert.Equal(t, encode(t, deadline, nil, grpcEncoder).Get(grpcHeaderTimeout), "1n")
+		var got time.Duration
+		encode(t, deadline, func(timeout time.Duration, _ http.Header) { got = timeout }, nil)
+		assert.Equal(t, got, time.Nanosecond)
+	})
+	for _, deadline := range []time.Time{now, now.Add(-time.Nanosecond)} {
+		deadline := deadline
+		t.Run("deadline_"+now.Sub(deadline).String()+"_ago", func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, len(encode(t, deadline, nil, connectEncodeTimeout)), 0)
+			assert.Equal(t, encode(t, deadline, nil, grpcEncoder).Get(grpcHeaderTimeout), "0n")
+			got := time.Duration(-1)
+			encode(t, deadline, func(timeout time.Duration, _ http.Header) { got = timeout }, nil)
+			assert.Equal(t, got, 0)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// retryInfoTypeName is the fully-qualified name of the google.rpc.RetryInfo
+// error detail. We construct it by hand to avoid depending on genproto;
+// clients that have the message registered can resolve it as usual with
+// [ErrorDetail.Value].
+const retryInfoTypeName = "google.rpc.RetryInfo"
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// A Limiter decides whether to admit an RPC. Allow reports whether a call
+// identified by the key may proceed and, if not, how long the caller should
+// wait before retrying. A zero or negative duration means that the limiter
+// can't provide a useful estimate.
+//
+// Limiters are typically token buckets keyed by client identity. For example,
+// a Limiter may keep one [golang.org/x/time/rate.Limiter] per key. Limiters
+// must be safe to call concurrently.
+type Limiter interface {
+	Allow(key string) (ok bool, retryAfter time.Duration)
+}
+
+// NewRateLimitInterceptor returns a handler interceptor that rejects RPCs
+// once a client exceeds its rate limit. The key function identifies the
+// client, typically by inspecting an API key or authorization header, and the
+// limiter decides whether to admit the call.
+//
+// Rejected calls fail with [CodeResourceExhausted]. If the limiter returns a
+// positive retry delay, the error includes a google.rpc.RetryInfo detail so
+// that clients know when to try again. Unary RPCs are checked before the
+// handler runs, and streaming RPCs are checked once, before any messages are
+// received. The interceptor has no effect on clients.
+func NewRateLimitInterceptor(limiter Limiter, keyFunc func(context.Context, Spec, http.Header) string) Interceptor {
+	return &rateLimitInterceptor{
+		limiter: limiter,
+		keyFunc: keyFunc,
+	}
+}
+
+// NewGlobalRateLimitInterceptor returns a handler interceptor that caps the
+// total rate of calls to expensive procedures, regardless of which client
+// makes them. It's a simple admission-control layer that protects shared
+// backends. The limits map procedures (like "/acme.foo.v1.FooService/Bar") to
+// the maximum number of calls per second; each procedure may also burst up to
+// one second's worth of calls. Procedures that aren't in the map are
+// unlimited, and a procedure with a non-positive limit rejects every call.
+//
+// Like [NewRateLimitInterceptor], rejected calls fail with
+// [CodeResourceExhausted] and a google.rpc.RetryInfo detail. Each procedure
+// has its own token bucket, so calls to different procedures never contend.
+func NewGlobalRateLimitInterceptor(limits map[string]float64) Interceptor {
+	limiter := &procedureLimiter{buckets: make(map[string]*tokenBucket, len(limits))}
+	for procedure, limit := range limits {
+		limiter.buckets[procedure] = newTokenBucket(limit, time.Now())
+	}
+	return NewRateLimitInterceptor(limiter, func(_ context.Context, spec Spec, _ http.Header) string {
+		return spec.Procedure
+	})
+}
+
+type rateLimitInterceptor struct {
+	limiter Limiter
+	keyFunc func(context.Context, Spec, http.Header) string
+}
+
+func (i *rateLimitInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if request.Spec().IsClient {
+			return next(ctx, request)
+		}
+		if err := i.allow(ctx, request.Spec(), request.Header()); err != nil {
+			return nil, err
+		}
+		return next(ctx, request)
+	}
+}
+
+func (i *rateLimitInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return next
+}
+
+func (i *rateLimitInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return func(ctx context.Context, conn StreamingHandlerConn) error {
+		if err := i.allow(ctx, conn.Spec(), conn.RequestHeader()); err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+func (i *rateLimitInterceptor) allow(ctx context.Context, spec Spec, header http.Header) *Error {
+	ok, retryAfter := i.limiter.Allow(i.keyFunc(ctx, spec, header))
+	if ok {
+		return nil
+	}
+	err := NewError(CodeResourceExhausted, errRateLimited)
+	if retryAfter > 0 {
+		if detail, detailErr := newRetryInfoDetail(retryAfter); detailErr == nil {
+			err.AddDetail(detail)
+		}
+	}
+	return err
+}
+
+// procedureLimiter is a Limiter keyed by procedure. The map is never
+// modified after construction, so lookups don't need a lock.
+type procedureLimiter struct {
+	buckets map[string]*tokenBucket
+}
+
+func (l *procedureLimiter) Allow(procedure string) (bool, time.Duration) {
+	bucket, ok := l.buckets[procedure]
+	if !ok {
+		return true, 0
+	}
+	return bucket.take(time.Now())
+}
+
+// tokenBucket refills at rate tokens per second, up to a burst of one second's
+// worth of tokens (but at least one).
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	if b.rate <= 0 {
+		return false, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// newRetryInfoDetail returns a google.rpc.RetryInfo error detail with the
+// supplied retry delay.
+func newRetryInfoDetail(delay time.Duration) (*ErrorDetail, error) {
+	durationBytes, err := proto.Marshal(durationpb.New(delay))
+	if err != nil {
+		return nil, err
+	}
+	// RetryInfo has a single field: google.protobuf.Duration retry_delay = 1.
+	value := protowire.AppendTag(nil, 1, protowire.BytesType)
+	value = protowire.AppendBytes(value, durationBytes)
+	return NewErrorDetail(&anypb.Any{
+		TypeUrl: defaultAnyResolverPrefix + retryInfoTypeName,
+		Value:   value,
+	})
+}
@@ -0,0 +1,171 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRateLimitInterceptor(t *testing.T) {
+	t.Parallel()
+	limiter := &countingLimiter{allowed: 1, retryAfter: 3 * time.Second}
+	interceptor := connect.NewRateLimitInterceptor(
+		limiter,
+		func(_ context.Context, _ connect.Spec, header http.Header) string {
+			return header.Get("Api-Key")
+		},
+	)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithInterceptors(interceptor)))
+	server := memhttptest.NewServer(t, mux)
+
+	assertRateLimited := func(t *testing.T, err error) {
+		t.Helper()
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeResourceExhausted)
+		assert.Equal(t, len(connectErr.Details()), 1)
+		detail := connectErr.Details()[0]
+		assert.Equal(t, detail.Type(), "google.rpc.RetryInfo")
+		// Decode RetryInfo by hand, since we don't depend on genproto.
+		num, typ, n := protowire.ConsumeTag(detail.Bytes())
+		assert.Equal(t, num, 1)
+		assert.Equal(t, typ, protowire.BytesType)
+		durationBytes, m := protowire.ConsumeBytes(detail.Bytes()[n:])
+		assert.True(t, m > 0)
+		var delay durationpb.Duration
+		assert.Nil(t, proto.Unmarshal(durationBytes, &delay))
+		assert.Equal(t, delay.AsDuration(), 3*time.Second)
+	}
+	protocols := []struct {
+		name string
+		opt  connect.ClientOption
+	}{
+		{name: "connect", opt: connect.WithProtoJSON()},
+		{name: "grpc", opt: connect.WithGRPC()},
+		{name: "grpcweb", opt: connect.WithGRPCWeb()},
+	}
+	for _, protocol := range protocols {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL(), protocol.opt)
+		key := protocol.name
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		request.Header().Set("Api-Key", key)
+		_, err := client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+
+		request = connect.NewRequest(&pingv1.PingRequest{})
+		request.Header().Set("Api-Key", key)
+		_, err = client.Ping(context.Background(), request)
+		assertRateLimited(t, err)
+
+		streamRequest := connect.NewRequest(&pingv1.CountUpRequest{Number: 1})
+		streamRequest.Header().Set("Api-Key", key)
+		stream, err := client.CountUp(context.Background(), streamRequest)
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assertRateLimited(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	}
+	t.Run("no_retry_delay", func(t *testing.T) {
+		t.Parallel()
+		interceptor := connect.NewRateLimitInterceptor(
+			&countingLimiter{},
+			func(context.Context, connect.Spec, http.Header) string { return "" },
+		)
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithInterceptors(interceptor)))
+		server := memhttptest.NewServer(t, mux)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Zero(t, len(connectErr.Details()))
+	})
+}
+
+func TestGlobalRateLimitInterceptor(t *testing.T) {
+	t.Parallel()
+	interceptor := connect.NewGlobalRateLimitInterceptor(map[string]float64{
+		pingv1connect.PingServicePingProcedure: 1,
+		pingv1connect.PingServiceSumProcedure:  0,
+	})
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithInterceptors(interceptor)))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	var connectErr *connect.Error
+	assert.True(t, errors.As(err, &connectErr))
+	assert.Equal(t, len(connectErr.Details()), 1)
+	assert.Equal(t, connectErr.Details()[0].Type(), "google.rpc.RetryInfo")
+
+	// Procedures that aren't in the map are unlimited.
+	for i := 0; i < 10; i++ {
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+		assert.Nil(t, err)
+		for stream.Receive() {
+			assert.NotNil(t, stream.Msg())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	}
+
+	// A non-positive limit rejects every call.
+	stream := client.Sum(context.Background())
+	assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+	_, err = stream.CloseAndReceive()
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+}
+
+// countingLimiter admits a fixed number of calls per key.
+type countingLimiter struct {
+	allowed    int
+	retryAfter time.Duration
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (l *countingLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.calls == nil {
+		l.calls = make(map[string]int)
+	}
+	l.calls[key]++
+	if l.calls[key] <= l.allowed {
+		return true, 0
+	}
+	return false, l.retryAfter
+}
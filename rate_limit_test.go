@@ -0,0 +1,55 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"testing"
+	"time"
+
+	"connectrpc.com/connect/internal/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+	start := time.Unix(0, 0)
+	bucket := newTokenBucket(2, start)
+	// The bucket starts full, with one second's worth of tokens.
+	for i := 0; i < 2; i++ {
+		ok, _ := bucket.take(start)
+		assert.True(t, ok)
+	}
+	ok, retryAfter := bucket.take(start)
+	assert.False(t, ok)
+	assert.Equal(t, retryAfter, 500*time.Millisecond)
+	// Tokens refill at the configured rate.
+	ok, _ = bucket.take(start.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+	// Refills never exceed the burst.
+	later := start.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		ok, _ := bucket.take(later)
+		assert.True(t, ok)
+	}
+	ok, _ = bucket.take(later)
+	assert.False(t, ok)
+
+	// Fractional rates still allow a burst of one.
+	slow := newTokenBucket(0.5, start)
+	ok, _ = slow.take(start)
+	assert.True(t, ok)
+	ok, retryAfter = slow.take(start)
+	assert.False(t, ok)
+	assert.Equal(t, retryAfter, 2*time.Second)
+}
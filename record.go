@@ -0,0 +1,208 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// redactedHeaderValue replaces the values of redacted headers in recordings.
+const redactedHeaderValue = "REDACTED"
+
+// RecordedRequest is the HTTP request sent by a client for a single RPC, as
+// captured by [WithRequestRecorder]. The headers identify the protocol,
+// codec, and compression, and the body holds the exact bytes the client sent,
+// so replaying a recording with [ReplayRequest] reproduces the original call.
+type RecordedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// ReplayRequest sends a recorded request to a handler, typically a local
+// [Handler] or a mux of handlers, and returns the handler's response. It's a
+// way to turn an RPC captured with [WithRequestRecorder] into a deterministic
+// test case. The response's body and trailers are fully buffered.
+//
+// Replayed requests are always presented to the handler as HTTP/2, so that
+// bidirectional streaming procedures work. Since recordings hold the whole
+// request body, handlers see all of the client's messages at once.
+func ReplayRequest(handler http.Handler, recorded RecordedRequest) (*http.Response, error) {
+	request, err := http.NewRequest(recorded.Method, recorded.URL, bytes.NewReader(recorded.Body))
+	if err != nil {
+		return nil, fmt.Errorf("replay request: %w", err)
+	}
+	request.Header = recorded.Header.Clone()
+	if request.Header == nil {
+		request.Header = make(http.Header)
+	}
+	request.Proto, request.ProtoMajor, request.ProtoMinor = "HTTP/2.0", 2, 0
+	writer := &replayResponseWriter{header: make(http.Header)}
+	handler.ServeHTTP(writer, request)
+	return writer.result(request), nil
+}
+
+// recordingHTTPClient wraps an HTTPClient to capture every request it sends.
+// See WithRequestRecorder.
+type recordingHTTPClient struct {
+	HTTPClient
+
+	record func(RecordedRequest)
+	redact map[string]struct{} // canonical header keys
+}
+
+func (c *recordingHTTPClient) Do(request *http.Request) (*http.Response, error) {
+	header := request.Header.Clone()
+	for key := range header {
+		if _, ok := c.redact[key]; ok {
+			header[key] = []string{redactedHeaderValue}
+		}
+	}
+	recorder := &requestRecorder{
+		record: c.record,
+		recorded: RecordedRequest{
+			Method: request.Method,
+			URL:    request.URL.String(),
+			Header: header,
+		},
+	}
+	if request.Body == nil || request.Body == http.NoBody {
+		recorder.finish()
+		return c.HTTPClient.Do(request)
+	}
+	// Don't modify the caller's request.
+	recording := *request
+	recording.Body = &recordingBody{ReadCloser: request.Body, recorder: recorder}
+	return c.HTTPClient.Do(&recording)
+}
+
+// requestRecorder accumulates a request body as the transport reads it and
+// hands the recording to the user once the body is exhausted or closed.
+type requestRecorder struct {
+	record   func(RecordedRequest)
+	recorded RecordedRequest
+
+	mu   sync.Mutex
+	body bytes.Buffer
+	once sync.Once
+}
+
+func (r *requestRecorder) write(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.body.Write(data)
+}
+
+func (r *requestRecorder) finish() {
+	r.once.Do(func() {
+		r.mu.Lock()
+		if r.body.Len() > 0 {
+			r.recorded.Body = append([]byte(nil), r.body.Bytes()...)
+		}
+		r.mu.Unlock()
+		r.record(r.recorded)
+	})
+}
+
+type recordingBody struct {
+	io.ReadCloser
+
+	recorder *requestRecorder
+}
+
+func (b *recordingBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	b.recorder.write(data[:n])
+	if err != nil {
+		b.recorder.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.recorder.finish()
+	return err
+}
+
+// replayResponseWriter buffers a handler's response for ReplayRequest.
+type replayResponseWriter struct {
+	header      http.Header
+	wroteHeader bool
+	status      int
+	sentHeader  http.Header // snapshot taken when the header was written
+	body        bytes.Buffer
+}
+
+func (w *replayResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *replayResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	w.sentHeader = w.header.Clone()
+}
+
+func (w *replayResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *replayResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *replayResponseWriter) result(request *http.Request) *http.Response {
+	w.WriteHeader(http.StatusOK)
+	// Like net/http, support both trailers declared in the Trailer header and
+	// trailers set with http.TrailerPrefix.
+	trailer := make(http.Header)
+	for _, declared := range w.sentHeader.Values(headerTrailer) {
+		for _, key := range strings.Split(declared, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			if values, ok := w.header[key]; ok {
+				trailer[key] = values
+			}
+		}
+	}
+	for key, values := range w.header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(key, http.TrailerPrefix))] = values
+		}
+	}
+	header := w.sentHeader
+	delHeaderCanonical(header, headerTrailer)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(w.body.Bytes())),
+		ContentLength: int64(w.body.Len()),
+		Trailer:       trailer,
+		Request:       request,
+	}
+}
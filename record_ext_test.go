@@ -0,0 +1,153 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRequestRecorder(t *testing.T) {
+	t.Parallel()
+	newHandler := func() http.Handler {
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				if request.Msg.GetText() == "fail" {
+					return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("oops"))
+				}
+				return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.GetNumber()}), nil
+			},
+			cumSum: func(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+				var sum int64
+				for {
+					request, err := stream.Receive()
+					if errors.Is(err, io.EOF) {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					sum += request.GetNumber()
+					if err := stream.Send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+						return err
+					}
+				}
+			},
+		}))
+		return mux
+	}
+	server := memhttptest.NewServer(t, newHandler())
+	newClient := func(t *testing.T, opts ...connect.ClientOption) (pingv1connect.PingServiceClient, <-chan connect.RecordedRequest) {
+		t.Helper()
+		recordings := make(chan connect.RecordedRequest, 1)
+		opts = append(opts, connect.WithRequestRecorder(
+			func(recorded connect.RecordedRequest) { recordings <- recorded },
+			"x-secret",
+		))
+		return pingv1connect.NewPingServiceClient(server.Client(), server.URL(), opts...), recordings
+	}
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		client, recordings := newClient(
+			t,
+			connect.WithProtoJSON(),
+			connect.WithAcceptCompression("gzip", nil, nil),
+		)
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+		request.Header().Set("Authorization", "Bearer secret")
+		request.Header().Set("X-Secret", "hunter2")
+		request.Header().Set("X-Debug", "visible")
+		_, err := client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+		recorded := <-recordings
+		assert.Equal(t, recorded.Method, http.MethodPost)
+		assert.Equal(t, recorded.URL, server.URL()+pingv1connect.PingServicePingProcedure)
+		assert.Equal(t, recorded.Header.Get("Authorization"), "REDACTED")
+		assert.Equal(t, recorded.Header.Get("X-Secret"), "REDACTED")
+		assert.Equal(t, recorded.Header.Get("X-Debug"), "visible")
+		assert.Equal(t, recorded.Header.Get("Content-Type"), "application/json")
+		var sent pingv1.PingRequest
+		assert.Nil(t, protojson.Unmarshal(recorded.Body, &sent))
+		assert.Equal(t, sent.GetNumber(), 42)
+
+		response, err := connect.ReplayRequest(newHandler(), recorded)
+		assert.Nil(t, err)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		body, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		var replayed pingv1.PingResponse
+		assert.Nil(t, protojson.Unmarshal(body, &replayed))
+		assert.Equal(t, replayed.GetNumber(), 42)
+	})
+	t.Run("unary_error", func(t *testing.T) {
+		t.Parallel()
+		client, recordings := newClient(t, connect.WithSendGzip())
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "fail"}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeFailedPrecondition)
+		recorded := <-recordings
+		assert.Equal(t, recorded.Header.Get("Content-Encoding"), "gzip")
+		response, err := connect.ReplayRequest(newHandler(), recorded)
+		assert.Nil(t, err)
+		assert.Equal(t, response.StatusCode, http.StatusPreconditionFailed)
+	})
+	t.Run("bidi_grpc", func(t *testing.T) {
+		t.Parallel()
+		client, recordings := newClient(
+			t,
+			connect.WithGRPC(),
+			connect.WithAcceptCompression("gzip", nil, nil),
+		)
+		stream := client.CumSum(context.Background())
+		for _, number := range []int64{1, 2, 3} {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: number}))
+			_, err := stream.Receive()
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, stream.CloseRequest())
+		_, err := stream.Receive()
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Nil(t, stream.CloseResponse())
+		recorded := <-recordings
+		assert.Equal(t, recorded.Header.Get("Content-Type"), "application/grpc+proto")
+
+		response, err := connect.ReplayRequest(newHandler(), recorded)
+		assert.Nil(t, err)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Trailer.Get("Grpc-Status"), "0")
+		body, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		var sums []int64
+		for len(body) >= 5 {
+			size := int(binary.BigEndian.Uint32(body[1:5]))
+			var msg pingv1.CumSumResponse
+			assert.Nil(t, proto.Unmarshal(body[5:5+size], &msg))
+			sums = append(sums, msg.GetSum())
+			body = body[5+size:]
+		}
+		assert.Equal(t, sums, []int64{1, 3, 6})
+	})
+}
@@ -0,0 +1,176 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+// defaultRequestIDHeader is the header used to carry request IDs unless
+// overridden with [WithRequestIDHeader].
+const defaultRequestIDHeader = "Request-Id"
+
+// A RequestIDOption configures the interceptor returned by
+// [NewRequestIDInterceptor].
+type RequestIDOption interface {
+	applyToRequestID(*requestIDConfig)
+}
+
+// WithRequestIDHeader changes the name of the header used to carry request
+// IDs. By default, the interceptor uses "Request-Id".
+func WithRequestIDHeader(name string) RequestIDOption {
+	return &requestIDHeaderOption{name: name}
+}
+
+// WithRequestIDGenerator changes the function used to generate new request
+// IDs. By default, the interceptor generates random (version 4) UUIDs. The
+// generator must be safe to call concurrently.
+func WithRequestIDGenerator(generate func() string) RequestIDOption {
+	return &requestIDGeneratorOption{generate: generate}
+}
+
+// NewRequestIDInterceptor returns an interceptor that attaches a request ID to
+// each RPC, giving clients and handlers a cheap way to correlate logs across
+// services.
+//
+// When used with a client, the interceptor sends a request ID header with each
+// call. If the header is already set, it's left as-is. Otherwise, the
+// interceptor propagates the ID from the context (see [RequestID]) or
+// generates a new one. When used with a handler, the interceptor reads the ID
+// from the request headers, generating one if the client didn't send it. The
+// ID is available to the handler and subsequent interceptors via [RequestID],
+// and it's echoed back to the client as a response trailer.
+//
+// Because the same context is typically used for outbound calls made while
+// handling a request, using this interceptor on both handlers and clients
+// propagates request IDs through a chain of services.
+func NewRequestIDInterceptor(options ...RequestIDOption) Interceptor {
+	config := requestIDConfig{
+		Header:   defaultRequestIDHeader,
+		Generate: newUUID,
+	}
+	for _, opt := range options {
+		opt.applyToRequestID(&config)
+	}
+	return &requestIDInterceptor{config: config}
+}
+
+// RequestID returns the request ID associated with the context by the
+// interceptor returned by [NewRequestIDInterceptor]. If the context doesn't
+// carry a request ID, RequestID returns an empty string.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+type requestIDContextKey struct{}
+
+type requestIDConfig struct {
+	Header   string
+	Generate func() string
+}
+
+type requestIDHeaderOption struct {
+	name string
+}
+
+func (o *requestIDHeaderOption) applyToRequestID(config *requestIDConfig) {
+	if o.name != "" {
+		config.Header = o.name
+	}
+}
+
+type requestIDGeneratorOption struct {
+	generate func() string
+}
+
+func (o *requestIDGeneratorOption) applyToRequestID(config *requestIDConfig) {
+	if o.generate != nil {
+		config.Generate = o.generate
+	}
+}
+
+type requestIDInterceptor struct {
+	config requestIDConfig
+}
+
+func (i *requestIDInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if request.Spec().IsClient {
+			if request.Header().Get(i.config.Header) == "" {
+				request.Header().Set(i.config.Header, i.outboundID(ctx))
+			}
+			return next(ctx, request)
+		}
+		requestID := i.inboundID(request.Header().Get(i.config.Header))
+		response, err := next(context.WithValue(ctx, requestIDContextKey{}, requestID), request)
+		if err != nil {
+			if connectErr, ok := asError(err); ok {
+				connectErr.Meta().Set(i.config.Header, requestID)
+			}
+			return nil, err
+		}
+		response.Trailer().Set(i.config.Header, requestID)
+		return response, nil
+	}
+}
+
+func (i *requestIDInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return func(ctx context.Context, spec Spec) StreamingClientConn {
+		conn := next(ctx, spec)
+		// Headers are sent lazily, so it's safe to modify them here.
+		if conn.RequestHeader().Get(i.config.Header) == "" {
+			conn.RequestHeader().Set(i.config.Header, i.outboundID(ctx))
+		}
+		return conn
+	}
+}
+
+func (i *requestIDInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return func(ctx context.Context, conn StreamingHandlerConn) error {
+		requestID := i.inboundID(conn.RequestHeader().Get(i.config.Header))
+		conn.ResponseTrailer().Set(i.config.Header, requestID)
+		return next(context.WithValue(ctx, requestIDContextKey{}, requestID), conn)
+	}
+}
+
+func (i *requestIDInterceptor) outboundID(ctx context.Context) string {
+	if requestID := RequestID(ctx); requestID != "" {
+		return requestID
+	}
+	return i.config.Generate()
+}
+
+func (i *requestIDInterceptor) inboundID(requestID string) string {
+	if requestID != "" {
+		return requestID
+	}
+	return i.config.Generate()
+}
+
+// newUUID returns a random, version 4 UUID in its canonical string form.
+func newUUID() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		// crypto/rand only fails if the operating system's entropy source is
+		// unavailable, in which case there's not much else we can do.
+		panic(fmt.Sprintf("connect: generate request ID: %v", err)) //nolint:forbidigo
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40 // version 4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
@@ -0,0 +1,131 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestRequestIDInterceptor(t *testing.T) {
+	t.Parallel()
+	const uuidPattern = `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`
+	// The handler echoes the request ID it sees in the context as a response
+	// header, so we can compare it to the trailer set by the interceptor.
+	newServer := func(t *testing.T, opts ...connect.RequestIDOption) *memhttp.Server {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(
+			&pluggablePingServer{
+				ping: func(ctx context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+					if request.Msg.GetText() == "fail" {
+						return nil, connect.NewError(connect.CodeInternal, errors.New("oops"))
+					}
+					response := connect.NewResponse(&pingv1.PingResponse{})
+					response.Header().Set("Context-Request-Id", connect.RequestID(ctx))
+					return response, nil
+				},
+				countUp: func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+					stream.ResponseHeader().Set("Context-Request-Id", connect.RequestID(ctx))
+					return stream.Send(&pingv1.CountUpResponse{Number: 1})
+				},
+			},
+			connect.WithInterceptors(connect.NewRequestIDInterceptor(opts...)),
+		))
+		return memhttptest.NewServer(t, mux)
+	}
+	t.Run("generated_by_client", func(t *testing.T) {
+		t.Parallel()
+		server := newServer(t)
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithInterceptors(connect.NewRequestIDInterceptor()),
+		)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		requestID := response.Header().Get("Context-Request-Id")
+		assert.Match(t, requestID, uuidPattern)
+		assert.Equal(t, response.Trailer().Get("Request-Id"), requestID)
+
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+		assert.Nil(t, err)
+		for stream.Receive() {
+			assert.NotNil(t, stream.Msg())
+		}
+		assert.Nil(t, stream.Err())
+		streamID := stream.ResponseHeader().Get("Context-Request-Id")
+		assert.Match(t, streamID, uuidPattern)
+		assert.NotEqual(t, streamID, requestID)
+		assert.Equal(t, stream.ResponseTrailer().Get("Request-Id"), streamID)
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("preserves_existing", func(t *testing.T) {
+		t.Parallel()
+		server := newServer(t)
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithInterceptors(connect.NewRequestIDInterceptor()),
+		)
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		request.Header().Set("Request-Id", "abc")
+		response, err := client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Header().Get("Context-Request-Id"), "abc")
+		assert.Equal(t, response.Trailer().Get("Request-Id"), "abc")
+	})
+	t.Run("generated_by_handler", func(t *testing.T) {
+		t.Parallel()
+		server := newServer(t)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL())
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		requestID := response.Header().Get("Context-Request-Id")
+		assert.Match(t, requestID, uuidPattern)
+		assert.Equal(t, response.Trailer().Get("Request-Id"), requestID)
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "fail"}))
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Match(t, connectErr.Meta().Get("Request-Id"), uuidPattern)
+	})
+	t.Run("custom", func(t *testing.T) {
+		t.Parallel()
+		opts := []connect.RequestIDOption{
+			connect.WithRequestIDHeader("Correlation-Id"),
+			connect.WithRequestIDGenerator(func() string { return "static" }),
+		}
+		server := newServer(t, opts...)
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL(),
+			connect.WithInterceptors(connect.NewRequestIDInterceptor(opts...)),
+		)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Header().Get("Context-Request-Id"), "static")
+		assert.Equal(t, response.Trailer().Get("Correlation-Id"), "static")
+		assert.Zero(t, response.Trailer().Get("Request-Id"))
+	})
+}
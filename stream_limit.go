@@ -0,0 +1,103 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"reflect"
+)
+
+// NewClientStreamLimitInterceptor returns a client interceptor that protects
+// callers from servers that send endless streams. Once a server streaming or
+// bidirectional streaming call has received maxMessages messages, Receive
+// fails with [CodeResourceExhausted] if the server sends another one, even if
+// the server would have ended the stream afterwards. The extra message is
+// discarded rather than unmarshaled into the caller's message. Callers should
+// then close the stream as usual. A non-positive limit allows any number of
+// messages.
+//
+// Because it's an interceptor, the limit can differ from client to client and
+// can be combined with other interceptors that inspect messages. To enforce a
+// limit at the protocol level instead, see [WithMaxStreamFrames]. The
+// interceptor has no effect on unary and client streaming calls, or on
+// handlers.
+func NewClientStreamLimitInterceptor(maxMessages int) Interceptor {
+	return &clientStreamLimitInterceptor{max: maxMessages}
+}
+
+type clientStreamLimitInterceptor struct {
+	max int
+}
+
+func (i *clientStreamLimitInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return next
+}
+
+func (i *clientStreamLimitInterceptor) WrapStreamingClient(next StreamingClientFunc) StreamingClientFunc {
+	return func(ctx context.Context, spec Spec) StreamingClientConn {
+		conn := next(ctx, spec)
+		if i.max <= 0 || spec.StreamType&StreamTypeServer == 0 {
+			return conn
+		}
+		return &limitedStreamingClientConn{StreamingClientConn: conn, max: i.max}
+	}
+}
+
+func (i *clientStreamLimitInterceptor) WrapStreamingHandler(next StreamingHandlerFunc) StreamingHandlerFunc {
+	return next
+}
+
+// limitedStreamingClientConn counts received messages. Receive isn't called
+// concurrently with itself, so the count doesn't need synchronization. Once the
+// limit is exceeded, it stops reading from the server.
+type limitedStreamingClientConn struct {
+	StreamingClientConn
+
+	max      int
+	received int
+}
+
+func (c *limitedStreamingClientConn) getResponseCompression() string {
+	return responseCompressionOf(c.StreamingClientConn)
+}
+
+func (c *limitedStreamingClientConn) Receive(msg any) error {
+	if c.received < c.max {
+		if err := c.StreamingClientConn.Receive(msg); err != nil {
+			return err
+		}
+		c.received++
+		return nil
+	}
+	if c.received == c.max {
+		// Check whether the server sends another message without handing it to
+		// the caller.
+		if err := c.StreamingClientConn.Receive(scratchMessage(msg)); err != nil {
+			return err
+		}
+		c.received++
+	}
+	return errorf(CodeResourceExhausted, "server sent more than %d messages", c.max)
+}
+
+// scratchMessage returns a new, empty message of the same type as msg. If msg
+// isn't a non-nil pointer, it's returned unchanged.
+func scratchMessage(msg any) any {
+	value := reflect.ValueOf(msg)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return msg
+	}
+	return reflect.New(value.Type().Elem()).Interface()
+}
@@ -0,0 +1,91 @@
+// Copyright 2021-2024 The Connect Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	connect "connectrpc.com/connect"
+	"connectrpc.com/connect/internal/assert"
+	pingv1 "connectrpc.com/connect/internal/gen/connect/ping/v1"
+	"connectrpc.com/connect/internal/gen/connect/ping/v1/pingv1connect"
+	"connectrpc.com/connect/internal/memhttp/memhttptest"
+)
+
+func TestClientStreamLimitInterceptor(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := memhttptest.NewServer(t, mux)
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL(),
+		connect.WithInterceptors(connect.NewClientStreamLimitInterceptor(3)),
+	)
+	ctx := context.Background()
+
+	t.Run("server_stream_over_limit", func(t *testing.T) {
+		t.Parallel()
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 100}))
+		assert.Nil(t, err)
+		var received int
+		for stream.Receive() {
+			received++
+		}
+		assert.Equal(t, received, 3)
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeResourceExhausted)
+		// The extra message isn't unmarshaled into the caller's message.
+		assert.Equal(t, stream.Msg().GetNumber(), int64(0))
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("server_stream_at_limit", func(t *testing.T) {
+		t.Parallel()
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err)
+		var received int
+		for stream.Receive() {
+			received++
+		}
+		assert.Equal(t, received, 3)
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("bidi_stream", func(t *testing.T) {
+		t.Parallel()
+		stream := client.CumSum(ctx)
+		for i := int64(1); i <= 3; i++ {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: i}))
+			_, err := stream.Receive()
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 4}))
+		_, err := stream.Receive()
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+		assert.False(t, errors.Is(err, io.EOF))
+		assert.Nil(t, stream.CloseRequest())
+		assert.Nil(t, stream.CloseResponse())
+	})
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		for i := 0; i < 5; i++ {
+			_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+		}
+	})
+}